@@ -0,0 +1,214 @@
+package fsq
+
+import "log/slog"
+import "time"
+
+// A task whose action kept running past the queue's abandon timeout (or its own timeout, see AddWithTimeout).
+// Its processing slot was reclaimed and its go routine was left running until the action returns on its own.
+type AbandonedTask struct {
+	ExternalId string
+	ActionName string
+	StartedAt time.Time
+	AbandonedAt time.Time
+	Reason OutcomeReason  //ReasonTimeout for the abandon timeout or the task's timeout, ReasonMaxAge for the max task age
+}
+
+
+// - Abandons tasks whose action runs longer than @timeout, so that an action that never returns can't hold
+// a processing slot forever. At the timeout the task's context is cancelled (see AddContextAction), and if the
+// action still hasn't returned after the abandon grace period (see SetAbandonGrace) the task is abandoned: the
+// go routine running the action is deliberately leaked, but its slot is reclaimed for the next waiting task,
+// and the task is reported to the OnAbandoned handler.
+// - When an abandoned action finally returns, its result is ignored.
+// - A @timeout <= 0 disables abandoning (the default). Only tasks dispatched after the call are affected.
+func (q *FixedSizeQueue) SetAbandonTimeout(timeout time.Duration) {
+	q.changeConfig("", 0, false, "abandonTimeout", func() (string, string, error) {
+		oldValue := q.abandonTimeout.String()
+		q.abandonTimeout = timeout
+		return oldValue, q.abandonTimeout.String(), nil
+	})
+}
+
+
+// - Sets how long an action is given to return once its context was cancelled at the abandon timeout or the
+// max task age, before the task is abandoned. Context actions that give up in time end as they return,
+// actions that ignore the cancellation are abandoned and can be found with OnAbandoned.
+// - A @grace <= 0 abandons the task right away (the default). Only tasks dispatched after the call are affected.
+func (q *FixedSizeQueue) SetAbandonGrace(grace time.Duration) {
+	q.changeConfig("", 0, false, "abandonGrace", func() (string, string, error) {
+		oldValue := q.abandonGrace.String()
+		q.abandonGrace = max(grace, 0)
+		return oldValue, q.abandonGrace.String(), nil
+	})
+}
+
+
+// Sets a handler called whenever a task is abandoned, so operators can find actions that don't return.
+// The handler is called from the go routine of a timer, without holding the queue's lock.
+func (q *FixedSizeQueue) OnAbandoned(handler func(abandoned AbandonedTask)) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.onAbandoned = handler
+}
+
+
+// Returns the abandoned tasks whose action is still running.
+func (q *FixedSizeQueue) AbandonedTasks() []AbandonedTask {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	tasks := make([]AbandonedTask, 0, len(q.abandonedTasks))
+
+	for _, info := range q.abandonedTasks {
+		tasks = append(tasks, info)
+	}
+
+	return tasks
+}
+
+
+// Called by actionWrapper before the action runs, returns nil if tasks are never abandoned. The task is
+// abandoned at the abandon timeout or the max task age, or fails at its own timeout (see AddWithTimeout),
+// whichever comes first. Takes the lock.
+func (q *FixedSizeQueue) startAbandonTimer(task *task) *time.Timer {
+	q.mu.Lock()
+	timeout := q.abandonTimeout
+	grace := q.abandonGrace
+	left, limited := q.ageLeft(task)
+	// the timer may fire once the task was recycled and dispatched again, the run tells them apart
+	run := task.run
+	q.mu.Unlock()
+
+	expire := func() { q.expire(task, run, ReasonTimeout, grace) }
+	if task.timeout > 0 && (timeout <= 0 || task.timeout <= timeout) {
+		timeout = task.timeout
+		expire = func() { q.timeOut(task, run) }
+	}
+
+	if limited && (timeout <= 0 || left < timeout) {
+		timeout = max(left, time.Nanosecond)
+		expire = func() { q.expire(task, run, ReasonMaxAge, grace) }
+	}
+
+	if timeout <= 0 {
+		return nil
+	}
+
+	return time.AfterFunc(timeout, expire)
+}
+
+
+// Returns true if @task is still processing the @run a timer was started for. Called with the lock held.
+func (q *FixedSizeQueue) isCurrentRun(task *task, run uint64) bool {
+	return task.run == run && !task.settled.Load()
+}
+
+
+// Runs in the timer's go routine once @run of @task should end for @reason: cancels the task's context, and
+// abandons the task unless its action returned within @grace. Takes the lock.
+func (q *FixedSizeQueue) expire(task *task, run uint64, reason OutcomeReason, grace time.Duration) {
+	q.mu.Lock()
+	if !q.isCurrentRun(task, run) {
+		q.mu.Unlock()
+		return
+	}
+
+	// context actions are told to give up
+	task.cancel()
+	q.trace(task.externalId, "cancelled", "ran past its time, abandoned unless it returns within %s", grace)
+	q.mu.Unlock()
+
+	if grace <= 0 {
+		q.abandon(task, run, reason)
+		return
+	}
+
+	time.AfterFunc(grace, func() { q.abandon(task, run, reason) })
+}
+
+
+// Runs in the timer's go routine, takes the lock.
+func (q *FixedSizeQueue) abandon(task *task, run uint64, reason OutcomeReason) {
+	q.mu.Lock()
+
+	// the action may have returned at the same time the timer fired, and the task may run again since
+	if task.run != run || !task.settle() {
+		q.mu.Unlock()
+		return
+	}
+
+	q.forgetProcessing(task)
+
+	info := AbandonedTask{
+		ExternalId: task.externalId,
+		ActionName: task.actionName,
+		StartedAt: task.startedAt,
+		AbandonedAt: q.now(),
+		Reason: reason,
+	}
+
+	q.keepAbandoned(task, info.AbandonedAt, reason)
+	q.stats.Abandoned++
+	if reason == ReasonMaxAge {
+		q.trace(task.externalId, "abandoned", "past the max task age of %s", q.maxTaskAge)
+	} else {
+		q.trace(task.externalId, "abandoned", "ran past %s", q.abandonTimeout)
+	}
+	q.countProcessing--
+	q.epoch++
+
+	event := q.taskEvent(task, nil)
+	event.Type = EventAbandoned
+	event.Reason = reason
+	event.At = info.AbandonedAt
+	event.Duration = event.At.Sub(task.startedAt)
+	q.publish(event)
+	task.resolve(event)
+	q.recordAttempt(task, event)
+	q.endTaskSpan(task, event)
+	q.logTask(slog.LevelWarn, "task abandoned", task, nil, slog.Duration("duration", event.Duration), slog.String("reason", reason.String()))
+
+	// the slot is free again
+	q.dispatchWaiting()
+	q.notifyIdle()
+
+	handler := q.onAbandoned
+	done := task.completion(ErrTaskAbandoned)
+	q.mu.Unlock()
+
+	if handler != nil {
+		handler(info)
+	}
+
+	if done != nil {
+		done()
+	}
+}
+
+
+// Keeps @task, whose slot is reclaimed while its action still runs, until the action returns.
+func (q *FixedSizeQueue) keepAbandoned(task *task, at time.Time, reason OutcomeReason) {
+	task.SetStateAbandoned()
+
+	if q.abandonedTasks == nil {
+		q.abandonedTasks = map[int]AbandonedTask{}
+	}
+
+	q.abandonedTasks[task.id] = AbandonedTask{
+		ExternalId: task.externalId,
+		ActionName: task.actionName,
+		StartedAt: task.startedAt,
+		AbandonedAt: at,
+		Reason: reason,
+	}
+}
+
+
+// Called when the action of an abandoned task finally returns, the task can be re-used now.
+func (q *FixedSizeQueue) recycleAbandoned(task *task) {
+	delete(q.abandonedTasks, task.id)
+	task.Clean()
+	*q.readyTaskPool = append(*q.readyTaskPool, task)
+	q.epoch++
+}
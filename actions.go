@@ -0,0 +1,224 @@
+package fsq
+
+import "fmt"
+import "errors"
+import "context"
+import "sort"
+import "strings"
+
+
+// - Registers @action under @name so tasks can be added by name with AddByName. Registered actions
+// can be run in a child process (see SetExecutionMode) since only their name and params have to be
+// passed around.
+// - Registering a name that is already registered replaces its action. Actions can be registered while
+// the queue is running, tasks that were parked because the name was unregistered are dispatched.
+func (q *FixedSizeQueue) RegisterAction(name string, action func(params map[string]interface{}) error) error {
+	if action == nil {
+		return errors.New(fmt.Sprintf("Action %s can't be nil.", name))
+	}
+
+	return q.RegisterContextAction(name, func(ctx context.Context, params map[string]interface{}) error {
+		return action(params)
+	})
+}
+
+
+// Same as RegisterAction, for an action that is handed the context of the task's run (see ContextAction), so
+// it can give up when the task times out, passes its deadline or the queue is stopped.
+func (q *FixedSizeQueue) RegisterContextAction(name string, action ContextAction) error {
+	if len(strings.TrimSpace(name)) == 0 {
+		return errors.New("Action name is not valid, only uses space characters.")
+	}
+
+	if action == nil {
+		return errors.New(fmt.Sprintf("Action %s can't be nil.", name))
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.actions == nil {
+		q.actions = map[string]ContextAction{}
+	}
+
+	q.actions[name] = action
+	q.unpark(name)
+	return nil
+}
+
+
+// - Removes the action registered under @name, which can be done while the queue is running.
+// - Tasks for the action that are already processing are not affected. Waiting tasks for the action
+// are parked when they reach the head of the queue, and dispatched once the action is registered again.
+// - Returns false if no action was registered under @name.
+func (q *FixedSizeQueue) UnregisterAction(name string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if !q.isRegistered(name) {
+		return false
+	}
+
+	delete(q.actions, name)
+	return true
+}
+
+
+// Returns the external ids of the tasks parked because the action registered under @name was removed, because
+// the action is paused after being rate limited (see SetRateLimitPause), or because its health probe reports
+// unhealthy (see SetActionHealthProbe).
+func (q *FixedSizeQueue) ParkedTasks(name string) []string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	ids := []string{}
+
+	for _, task := range q.parked[name] {
+		ids = append(ids, task.externalId)
+	}
+
+	return ids
+}
+
+
+// Returns true if an action is registered under @name.
+func (q *FixedSizeQueue) IsRegistered(name string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.isRegistered(name)
+}
+
+
+func (q *FixedSizeQueue) isRegistered(name string) bool {
+	_, ok := q.actions[name]
+	return ok
+}
+
+
+// - Same as Add, but runs the action registered under @actionName.
+// - The action is looked up when the task runs, not when it is added.
+func (q *FixedSizeQueue) AddByName(actionName string, params map[string]interface{}, id string, opts ...AddOption) error {
+	if !q.IsRegistered(actionName) {
+		return errors.New(fmt.Sprintf("Action %s is not registered.", actionName))
+	}
+
+	options, err := applyAddOptions(opts)
+	if err != nil {
+		return err
+	}
+
+	options.actionName = actionName
+	options.byName = true
+	options.ctxAction = q.registeredAction(actionName)
+	return q.add(context.Background(), nil, params, id, options)
+}
+
+
+// Returns the action of tasks added by name, it runs the action registered under @name when the task runs.
+func (q *FixedSizeQueue) registeredAction(name string) ContextAction {
+	return func(ctx context.Context, params map[string]interface{}) error {
+		return q.runRegistered(ctx, name, params)
+	}
+}
+
+
+// Called with the next waiting task, moves it into parking if its registered action was removed, its
+// action is paused after being rate limited, or is unhealthy. Returns true if the task was parked.
+func (q *FixedSizeQueue) parkIfHeld(next *task) bool {
+	if !q.isHeld(next) {
+		return false
+	}
+
+	q.removeNextWaiting()
+
+	if q.parked == nil {
+		q.parked = map[string][]*task{}
+	}
+
+	q.parked[next.actionName] = append(q.parked[next.actionName], next)
+	q.epoch++
+	q.trace(next.externalId, "parked", "action %s is unregistered, rate limited, warming up or unhealthy", next.actionName)
+	return true
+}
+
+
+// Returns true if @next can't be dispatched because its registered action was removed, its action is
+// paused after being rate limited, is warming up, or its health probe reports unhealthy.
+func (q *FixedSizeQueue) isHeld(next *task) bool {
+	unregistered := next.byName && !q.isRegistered(next.actionName)
+	return unregistered || q.isRateLimited(next.actionName) || q.isWarming(next.actionName) || !q.isActionHealthy(next.actionName)
+}
+
+
+// Returns the number of parked tasks, including the ones released but not dispatched yet. They keep their
+// slot in the queue while parked, see isFull.
+func (q *FixedSizeQueue) countParked() int {
+	count := len(q.unparked)
+	for _, tasks := range q.parked {
+		count += len(tasks)
+	}
+
+	for _, tasks := range q.overBudget {
+		count += len(tasks)
+	}
+
+	return count
+}
+
+
+// Returns the tasks parked for their action by action name, then the ones parked over budget by tenant.
+// Released ones are part of waitingInOrder.
+func (q *FixedSizeQueue) parkedTasks() []*task {
+	parked := []*task{}
+	for _, byKey := range []map[string][]*task{q.parked, q.overBudget} {
+		keys := make([]string, 0, len(byKey))
+		for key := range byKey {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			parked = append(parked, byKey[key]...)
+		}
+	}
+
+	return parked
+}
+
+
+// releases the tasks parked for @name, they are dispatched ahead of the ring buffer
+func (q *FixedSizeQueue) unpark(name string) {
+	tasks, ok := q.parked[name]
+	if ok {
+		delete(q.parked, name)
+		q.unparked = append(q.unparked, tasks...)
+		q.epoch++
+
+		for _, task := range tasks {
+			q.trace(task.externalId, "unparked", "action %s is available again", name)
+		}
+	}
+
+	// in strict FIFO mode, held tasks wait at the head of the queue instead of being parked
+	q.dispatchWaiting()
+}
+
+
+// Runs in the task's go routine, the lock is only held to look up the action.
+func (q *FixedSizeQueue) runRegistered(ctx context.Context, name string, params map[string]interface{}) error {
+	q.mu.Lock()
+	action, ok := q.actions[name]
+	mode := q.executionModes[name]
+	q.mu.Unlock()
+
+	if !ok {
+		return errors.New(fmt.Sprintf("Action %s is not registered.", name))
+	}
+
+	if mode == Subprocess {
+		return q.runIsolated(ctx, name, params)
+	}
+
+	return action(ctx, params)
+}
@@ -0,0 +1,47 @@
+package fsq
+
+import "fmt"
+import "errors"
+
+// The queue has no room for the task, see SetSoftCapacity and AddWait. Also returned by FixedSizeBuffer.TryPut.
+var ErrQueueFull = errors.New("Queue has no capacity at this time. Try later.")
+
+// A task with the same external id is already waiting.
+var ErrDuplicateID = errors.New("Id for task is already waiting to be processed.")
+
+// The external id is empty or only uses space characters.
+var ErrInvalidID = errors.New("Id for task is not valid, only uses space characters.")
+
+// The queue isn't running, tasks are only added once it is started.
+var ErrNotRunning = errors.New("Queue is not running. Try starting and then adding.")
+
+// - The error of a task the queue turned away. Err is one of ErrQueueFull, ErrDuplicateID, ErrInvalidID and
+// ErrNotRunning, so callers can tell them apart with errors.Is, and get the queue and id with errors.As.
+type AddError struct {
+	Queue string  //qualified name
+	Id string  //external id of the task
+	Err error
+}
+
+
+func (e *AddError) Error() string {
+	switch e.Err {
+	case ErrQueueFull:
+		return fmt.Sprintf("FixedSizeQueue %s has no capacity at this time. Try later.", e.Queue)
+	case ErrNotRunning:
+		return fmt.Sprintf("FixedSizeQueue %s is not running. Try starting and then adding.", e.Queue)
+	}
+
+	return e.Err.Error()
+}
+
+
+func (e *AddError) Unwrap() error {
+	return e.Err
+}
+
+
+// Wraps @err, one of the errors above, with the queue and @id.
+func (q *FixedSizeQueue) addError(id string, err error) error {
+	return &AddError{Queue: q.QualifiedName(), Id: id, Err: err}
+}
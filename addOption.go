@@ -0,0 +1,129 @@
+package fsq
+
+import "fmt"
+import "errors"
+import "time"
+
+// Changes how a single task is handled, see Add.
+type AddOption func(o *addOptions) error
+
+
+// Dispatches the task ahead of or after others, see AddWithPriority.
+func WithPriority(priority Priority) AddOption {
+	return func(o *addOptions) error {
+		if priority < PriorityLow || priority > PriorityHigh {
+			return errors.New(fmt.Sprintf("Priority %s is not valid.", priority))
+		}
+
+		o.priority = priority
+		return nil
+	}
+}
+
+
+// Holds the task back until @delay passed, see AddAfter.
+func WithDelay(delay time.Duration) AddOption {
+	return func(o *addOptions) error {
+		o.delay = delay
+		return nil
+	}
+}
+
+
+// Fails the task once its action ran longer than @timeout, see AddWithTimeout.
+func WithTimeout(timeout time.Duration) AddOption {
+	return func(o *addOptions) error {
+		o.timeout = timeout
+		return nil
+	}
+}
+
+
+// - Retries the task up to @retries times after its first run failed, whatever the queue's retry policy allows.
+// The waits between runs still follow the policy's backoff, see SetRetryPolicy.
+// - A @retries of 0 turns retries off for the task. @retries can't be negative.
+func WithRetries(retries int) AddOption {
+	return func(o *addOptions) error {
+		if retries < 0 {
+			return errors.New("Task retries can't be negative.")
+		}
+
+		o.maxAttempts = retries + 1
+		return nil
+	}
+}
+
+
+// Calls @callback once the task ended, with nil if its action succeeded. See AddWithCallbacks.
+func WithCallback(callback func(err error)) AddOption {
+	return func(o *addOptions) error {
+		if callback == nil {
+			return errors.New("Task callback can't be nil.")
+		}
+
+		o.onSuccess = func() { callback(nil) }
+		o.onFailure = callback
+		return nil
+	}
+}
+
+
+// Charges the task @cost units of the queue wide budget and of its tenant's budget, see AddWithCost. @cost can't be negative.
+func WithCost(cost int) AddOption {
+	return func(o *addOptions) error {
+		if cost < 0 {
+			return errors.New("Task cost can't be negative.")
+		}
+
+		o.cost = cost
+		return nil
+	}
+}
+
+
+// Adds the task for @tenant, whose budget (see SetBudget) and fair share it counts against.
+func WithTenant(tenant string) AddOption {
+	return func(o *addOptions) error {
+		o.tenant = tenant
+		return nil
+	}
+}
+
+
+// - The task should be done by @deadline, see AddWithDeadline. The context of a context action expires at the deadline.
+// - A zero @deadline means the task has none.
+func WithDeadline(deadline time.Time) AddOption {
+	return func(o *addOptions) error {
+		o.deadline = deadline
+		return nil
+	}
+}
+
+
+// - Sets *@handle to a handle to the task, to await its end instead of treating the queue as fire and forget.
+// - *@handle is set to nil if the task isn't added.
+func WithHandle(handle **TaskHandle) AddOption {
+	return func(o *addOptions) error {
+		if handle == nil {
+			return errors.New("Task handle can't be nil.")
+		}
+
+		o.handleOut = handle
+		return nil
+	}
+}
+
+
+// Applies @opts to the settings of a task, returns the first option's error.
+func applyAddOptions(opts []AddOption) (addOptions, error) {
+	o := addOptions{}
+
+	for _, opt := range opts {
+		err := opt(&o)
+		if err != nil {
+			return addOptions{}, err
+		}
+	}
+
+	return o, nil
+}
@@ -0,0 +1,370 @@
+package fsq
+
+import "fmt"
+import "errors"
+import "encoding/json"
+import "net/http"
+import "sort"
+import "strings"
+
+// The access level of a caller of the admin API. Each role can do everything the roles below it can.
+type Role int
+
+const (
+	RoleNone Role = iota  //no access
+	RoleViewer  //can read queue views and the config audit log
+	RoleOperator  //can also start and stop queues and cancel tasks
+	RoleAdmin  //can also change the runtime configuration and purge queues
+)
+
+// Identifies the caller of an admin request. Returns the caller's principal (recorded in the config audit
+// log for changes they make) and role.
+type Authorizer func(r *http.Request) (string, Role)
+
+// An http.Handler exposing JSON endpoints to inspect and manage queues:
+//
+//	GET  /queues                                       viewer    views of every queue
+//	GET  /queues/{name}                                viewer    view of a queue, see FixedSizeQueue.SnapshotView
+//	GET  /queues/{name}/config-changes                 viewer    the queue's config audit log
+//	POST /queues/{name}/start                          operator  starts the queue
+//	POST /queues/{name}/stop                           operator  stops the queue
+//	POST /queues/{name}/pause                          operator  pauses dispatching, see FixedSizeQueue.Pause
+//	POST /queues/{name}/resume                         operator  resumes dispatching
+//	POST /queues/{name}/tasks/{id}/cancel              operator  cancels the task, see FixedSizeQueue.Cancel and CancelProcessing
+//	POST /queues/{name}/purge                          admin     cancels every waiting task, see FixedSizeQueue.PurgeWaiting
+//	PUT  /queues/{name}/max-processing                 admin     body: {"value": 4, "version": 7}
+//	PUT  /queues/{name}/size                           admin     body: {"value": 100, "version": 7}
+//	GET  /queues/{name}/schedules                      viewer    the queue's schedules, see FixedSizeQueue.Schedules
+//	GET  /queues/{name}/schedules/{schedule}           viewer    a schedule's status
+//	POST /queues/{name}/schedules/{schedule}/pause     operator  pauses the schedule
+//	POST /queues/{name}/schedules/{schedule}/resume    operator  resumes the schedule
+//	POST /queues/{name}/schedules/{schedule}/trigger   operator  adds a task for the schedule right away
+//
+// Queues are identified by their qualified name, see FixedSizeQueue.QualifiedName.
+// The "version" in the PUT bodies is optional. When given, the change is only made if the queue's
+// configuration is still at that version (see FixedSizeQueue.ConfigVersion), otherwise 409 is returned.
+type AdminHandler struct {
+	queues map[string]*FixedSizeQueue
+	authorize Authorizer
+	mux *http.ServeMux
+}
+
+// the body of the admin PUT endpoints
+type configRequest struct {
+	Value int `json:"value"`
+	Version *uint64 `json:"version,omitempty"`
+}
+
+// the body of the admin PUT endpoints' responses
+type configResponse struct {
+	Version uint64 `json:"version"`
+}
+
+// the body of the cancel endpoint's response
+type cancelResponse struct {
+	ExternalId string `json:"externalId"`
+	Processing bool `json:"processing"`  //the task was processing, it ends once its action returns
+}
+
+// the body of the purge endpoint's response
+type purgeResponse struct {
+	Cancelled []string `json:"cancelled"`  //external ids of the cancelled waiting tasks
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+
+// - Returns an admin handler for @queues.
+// - @authorize decides the principal and role of every request. If nil, every request is denied.
+func NewAdminHandler(authorize Authorizer, queues ...*FixedSizeQueue) *AdminHandler {
+	if authorize == nil {
+		authorize = func(r *http.Request) (string, Role) {
+			return "", RoleNone
+		}
+	}
+
+	h := &AdminHandler{
+		queues: map[string]*FixedSizeQueue{},
+		authorize: authorize,
+		mux: http.NewServeMux(),
+	}
+
+	for _, q := range queues {
+		h.queues[q.QualifiedName()] = q
+	}
+
+	h.mux.HandleFunc("GET /queues", h.requireRole(RoleViewer, h.listQueues))
+	h.mux.HandleFunc("GET /queues/{name}", h.requireQueue(RoleViewer, h.viewQueue))
+	h.mux.HandleFunc("GET /queues/{name}/config-changes", h.requireQueue(RoleViewer, h.configChanges))
+	h.mux.HandleFunc("POST /queues/{name}/start", h.requireQueue(RoleOperator, h.startQueue))
+	h.mux.HandleFunc("POST /queues/{name}/stop", h.requireQueue(RoleOperator, h.stopQueue))
+	h.mux.HandleFunc("POST /queues/{name}/pause", h.requireQueue(RoleOperator, h.pauseQueue))
+	h.mux.HandleFunc("POST /queues/{name}/resume", h.requireQueue(RoleOperator, h.resumeQueue))
+	h.mux.HandleFunc("POST /queues/{name}/tasks/{id}/cancel", h.requireQueue(RoleOperator, h.cancelTask))
+	h.mux.HandleFunc("POST /queues/{name}/purge", h.requireQueue(RoleAdmin, h.purgeQueue))
+	h.mux.HandleFunc("PUT /queues/{name}/max-processing", h.requireQueue(RoleAdmin, h.setMaxProcessing))
+	h.mux.HandleFunc("PUT /queues/{name}/size", h.requireQueue(RoleAdmin, h.resize))
+	h.mux.HandleFunc("GET /queues/{name}/schedules", h.requireQueue(RoleViewer, h.listSchedules))
+	h.mux.HandleFunc("GET /queues/{name}/schedules/{schedule}", h.requireQueue(RoleViewer, h.viewSchedule))
+	h.mux.HandleFunc("POST /queues/{name}/schedules/{schedule}/pause", h.requireQueue(RoleOperator, h.scheduleAction((*FixedSizeQueue).PauseSchedule)))
+	h.mux.HandleFunc("POST /queues/{name}/schedules/{schedule}/resume", h.requireQueue(RoleOperator, h.scheduleAction((*FixedSizeQueue).ResumeSchedule)))
+	h.mux.HandleFunc("POST /queues/{name}/schedules/{schedule}/trigger", h.requireQueue(RoleOperator, h.scheduleAction((*FixedSizeQueue).TriggerSchedule)))
+
+	return h
+}
+
+
+// - Returns an authorizer that looks up the bearer token from the request's Authorization header in @tokens.
+// - The principal of a caller is "token:" followed by the first 4 characters of their token, so the audit
+// log can tell callers apart without recording their secrets.
+func StaticTokens(tokens map[string]Role) Authorizer {
+	return func(r *http.Request) (string, Role) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok {
+			return "", RoleNone
+		}
+
+		role, ok := tokens[token]
+		if !ok {
+			return "", RoleNone
+		}
+
+		prefix := token
+		if len(prefix) > 4 {
+			prefix = prefix[:4]
+		}
+
+		return "token:" + prefix, role
+	}
+}
+
+
+func (h *AdminHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+
+func (r Role) String() string {
+	switch r {
+	case RoleViewer:
+		return "viewer"
+	case RoleOperator:
+		return "operator"
+	case RoleAdmin:
+		return "admin"
+	}
+
+	return "none"
+}
+
+
+// wraps @next so that it is only called for callers with at least @role
+func (h *AdminHandler) requireRole(role Role, next func(w http.ResponseWriter, r *http.Request, principal string)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal, callerRole := h.authorize(r)
+
+		if callerRole == RoleNone {
+			writeJSON(w, http.StatusUnauthorized, errorResponse{Error: "Unauthorized."})
+			return
+		}
+
+		if callerRole < role {
+			errMsg := fmt.Sprintf("Role %s is required, caller has role %s.", role, callerRole)
+			writeJSON(w, http.StatusForbidden, errorResponse{Error: errMsg})
+			return
+		}
+
+		next(w, r, principal)
+	}
+}
+
+
+// same as requireRole, but also looks up the queue named in the path
+func (h *AdminHandler) requireQueue(role Role, next func(w http.ResponseWriter, r *http.Request, principal string, q *FixedSizeQueue)) http.HandlerFunc {
+	return h.requireRole(role, func(w http.ResponseWriter, r *http.Request, principal string) {
+		name := r.PathValue("name")
+
+		q, ok := h.queues[name]
+		if !ok {
+			errMsg := fmt.Sprintf("FixedSizeQueue %s does not exist.", name)
+			writeJSON(w, http.StatusNotFound, errorResponse{Error: errMsg})
+			return
+		}
+
+		next(w, r, principal, q)
+	})
+}
+
+
+func (h *AdminHandler) listQueues(w http.ResponseWriter, r *http.Request, principal string) {
+	names := make([]string, 0, len(h.queues))
+	for name := range h.queues {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	views := []QueueView{}
+	for _, name := range names {
+		views = append(views, h.queues[name].SnapshotView())
+	}
+
+	writeJSON(w, http.StatusOK, views)
+}
+
+
+func (h *AdminHandler) viewQueue(w http.ResponseWriter, r *http.Request, principal string, q *FixedSizeQueue) {
+	writeJSON(w, http.StatusOK, q.SnapshotView())
+}
+
+
+func (h *AdminHandler) configChanges(w http.ResponseWriter, r *http.Request, principal string, q *FixedSizeQueue) {
+	writeJSON(w, http.StatusOK, q.ConfigChanges())
+}
+
+
+func (h *AdminHandler) startQueue(w http.ResponseWriter, r *http.Request, principal string, q *FixedSizeQueue) {
+	q.Start()
+	writeJSON(w, http.StatusOK, q.SnapshotView())
+}
+
+
+func (h *AdminHandler) stopQueue(w http.ResponseWriter, r *http.Request, principal string, q *FixedSizeQueue) {
+	q.Stop()
+	writeJSON(w, http.StatusOK, q.SnapshotView())
+}
+
+
+func (h *AdminHandler) pauseQueue(w http.ResponseWriter, r *http.Request, principal string, q *FixedSizeQueue) {
+	q.As(principal).Pause()
+	writeJSON(w, http.StatusOK, q.SnapshotView())
+}
+
+
+func (h *AdminHandler) resumeQueue(w http.ResponseWriter, r *http.Request, principal string, q *FixedSizeQueue) {
+	q.As(principal).Resume()
+	writeJSON(w, http.StatusOK, q.SnapshotView())
+}
+
+
+// cancels the waiting task with the id in the path, or else the processing ones
+func (h *AdminHandler) cancelTask(w http.ResponseWriter, r *http.Request, principal string, q *FixedSizeQueue) {
+	id := r.PathValue("id")
+
+	err := q.Cancel(id)
+	if err == nil {
+		writeJSON(w, http.StatusOK, cancelResponse{ExternalId: id})
+		return
+	}
+
+	err = q.CancelProcessing(id)
+	if err == nil {
+		writeJSON(w, http.StatusOK, cancelResponse{ExternalId: id, Processing: true})
+		return
+	}
+
+	errMsg := fmt.Sprintf("No task %s is waiting or processing on FixedSizeQueue %s.", id, q.QualifiedName())
+	writeJSON(w, http.StatusNotFound, errorResponse{Error: errMsg})
+}
+
+
+func (h *AdminHandler) purgeQueue(w http.ResponseWriter, r *http.Request, principal string, q *FixedSizeQueue) {
+	writeJSON(w, http.StatusOK, purgeResponse{Cancelled: q.PurgeWaiting()})
+}
+
+
+func (h *AdminHandler) setMaxProcessing(w http.ResponseWriter, r *http.Request, principal string, q *FixedSizeQueue) {
+	h.changeConfig(w, r, q, func(req configRequest) (uint64, error) {
+		version := q.ConfigVersion()
+		if req.Version != nil {
+			version = *req.Version
+		}
+
+		return q.As(principal).CompareAndSetMaxProcessing(version, req.Value)
+	})
+}
+
+
+func (h *AdminHandler) resize(w http.ResponseWriter, r *http.Request, principal string, q *FixedSizeQueue) {
+	h.changeConfig(w, r, q, func(req configRequest) (uint64, error) {
+		version := q.ConfigVersion()
+		if req.Version != nil {
+			version = *req.Version
+		}
+
+		return q.As(principal).CompareAndResize(version, req.Value)
+	})
+}
+
+
+func (h *AdminHandler) listSchedules(w http.ResponseWriter, r *http.Request, principal string, q *FixedSizeQueue) {
+	writeJSON(w, http.StatusOK, q.Schedules())
+}
+
+
+func (h *AdminHandler) viewSchedule(w http.ResponseWriter, r *http.Request, principal string, q *FixedSizeQueue) {
+	h.writeSchedule(w, q, r.PathValue("schedule"), nil)
+}
+
+
+// returns a handler that calls @action with the schedule named in the path, then writes its status
+func (h *AdminHandler) scheduleAction(action func(q *FixedSizeQueue, name string) error) func(w http.ResponseWriter, r *http.Request, principal string, q *FixedSizeQueue) {
+	return func(w http.ResponseWriter, r *http.Request, principal string, q *FixedSizeQueue) {
+		name := r.PathValue("schedule")
+		h.writeSchedule(w, q, name, action(q, name))
+	}
+}
+
+
+// writes the status of the schedule @name, or @err if there is one
+func (h *AdminHandler) writeSchedule(w http.ResponseWriter, q *FixedSizeQueue, name string, err error) {
+	if err == nil {
+		var status ScheduleStatus
+		status, err = q.ScheduleStatus(name)
+		if err == nil {
+			writeJSON(w, http.StatusOK, status)
+			return
+		}
+	}
+
+	if errors.Is(err, ErrNoSchedule) {
+		errMsg := fmt.Sprintf("Schedule %s does not exist on FixedSizeQueue %s.", name, q.QualifiedName())
+		writeJSON(w, http.StatusNotFound, errorResponse{Error: errMsg})
+		return
+	}
+
+	writeJSON(w, http.StatusConflict, errorResponse{Error: err.Error()})
+}
+
+
+// decodes a config request, applies it with @change and writes the result
+func (h *AdminHandler) changeConfig(w http.ResponseWriter, r *http.Request, q *FixedSizeQueue, change func(req configRequest) (uint64, error)) {
+	req := configRequest{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "Request body is not valid JSON."})
+		return
+	}
+
+	version, err := change(req)
+
+	if errors.Is(err, ErrConfigConflict) {
+		writeJSON(w, http.StatusConflict, errorResponse{Error: err.Error()})
+		return
+	}
+
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, configResponse{Version: version})
+}
+
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,40 @@
+package fsq
+
+import "time"
+
+// how many configuration changes a queue keeps in its audit log, older ones are dropped
+const maxConfigChanges = 1000
+
+// A record of a single runtime configuration change.
+type ConfigChange struct {
+	Version uint64  //the configuration version after the change
+	Principal string  //who or what made the change, see FixedSizeQueue.As
+	Setting string  //the setting that changed, e.g. "maxProcessing" or "budget[tenant-a]"
+	Old string  //the value before the change, empty if the setting was not set
+	New string  //the value after the change, empty if the setting was removed
+	At time.Time
+}
+
+
+// - Returns the most recent runtime configuration changes of the queue (up to 1000), oldest first.
+// - Runtime settings (max processing, size, budgets, retry policy, pausing, draining, ...) are recorded.
+// Setters that wire the queue to a dependency or hook (SetNamespace, SetClock, SetLogger, SetHistory,
+// SetResultSink, the health probes, ...) and starting or stopping the queue are not.
+func (q *FixedSizeQueue) ConfigChanges() []ConfigChange {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	changes := make([]ConfigChange, len(q.configChanges))
+	copy(changes, q.configChanges)
+	return changes
+}
+
+
+func (q *FixedSizeQueue) recordConfigChange(change ConfigChange) {
+	if len(q.configChanges) >= maxConfigChanges {
+		copy(q.configChanges, q.configChanges[1:])
+		q.configChanges = q.configChanges[:len(q.configChanges) - 1]
+	}
+
+	q.configChanges = append(q.configChanges, change)
+}
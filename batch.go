@@ -0,0 +1,103 @@
+package fsq
+
+import "fmt"
+import "errors"
+
+// A task submitted with AddAll.
+type TaskSpec struct {
+	ExternalId string
+	Action func(params map[string]interface{}) error  //nil to run the action registered under ActionName, see AddByName
+	ActionName string  //labels the task, see AddNamed
+	Params map[string]interface{}
+	Cost int  //see AddWithCost
+	Tenant string
+	Priority Priority  //see AddWithPriority
+}
+
+
+// - Adds @tasks in order while holding the queue's lock, so no other Add takes a slot in between. Stops at
+// the first task that is rejected, the tasks before it stay added.
+// - Returns how many tasks were accepted, and the error of the rejected task (see AddError).
+func (q *FixedSizeQueue) AddAll(tasks []TaskSpec) (accepted int, err error) {
+	return q.addAll(tasks, false)
+}
+
+
+// - Same as AddAll, but adds either every task of @tasks or none of them: if one is rejected, the tasks added
+// before it are taken out again and 0 is returned with its error. Callers submitting related work don't
+// end up with part of it queued when the queue fills up midway.
+// - None of the tasks is dispatched before all of them were added.
+func (q *FixedSizeQueue) AddAllOrNothing(tasks []TaskSpec) (accepted int, err error) {
+	return q.addAll(tasks, true)
+}
+
+
+func (q *FixedSizeQueue) addAll(tasks []TaskSpec, allOrNothing bool) (int, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	// with all or nothing, the added tasks are held back from dispatch so they can still be taken out
+	q.batching = allOrNothing
+	added := []*task{}
+
+	for i, spec := range tasks {
+		err := q.addSpec(spec)
+		if err != nil {
+			q.stats.Rejected++
+			q.trace(spec.ExternalId, "rejected", "%s", err)
+
+			if !allOrNothing {
+				return i, err
+			}
+
+			for _, task := range added {
+				q.trace(task.externalId, "rolled back", "task %s of the batch was rejected", spec.ExternalId)
+				q.stats.Enqueued--
+				q.removeWaiting(task)
+			}
+
+			q.batching = false
+			q.dispatchWaiting()
+			return 0, err
+		}
+
+		if allOrNothing {
+			added = append(added, q.waitingTasksByExternalId[spec.ExternalId])
+		}
+	}
+
+	q.batching = false
+	q.dispatchWaiting()
+	return len(tasks), nil
+}
+
+
+// Adds the task of @spec. Expects the lock to be held.
+func (q *FixedSizeQueue) addSpec(spec TaskSpec) error {
+	if spec.Cost < 0 {
+		return errors.New("Task cost can't be negative.")
+	}
+
+	if spec.Priority < PriorityLow || spec.Priority > PriorityHigh {
+		return errors.New(fmt.Sprintf("Priority %s is not valid.", spec.Priority))
+	}
+
+	opts := addOptions{
+		actionName: spec.ActionName,
+		cost: spec.Cost,
+		tenant: spec.Tenant,
+		priority: spec.Priority,
+	}
+
+	action := spec.Action
+	if action == nil {
+		if !q.isRegistered(spec.ActionName) {
+			return errors.New(fmt.Sprintf("Action %s is not registered.", spec.ActionName))
+		}
+
+		opts.ctxAction = q.registeredAction(spec.ActionName)
+		opts.byName = true
+	}
+
+	return q.enqueue(action, spec.Params, spec.ExternalId, opts)
+}
@@ -0,0 +1,93 @@
+package fsq
+
+import "time"
+
+// What a schedule does with a fire time that falls inside a blackout.
+type BlackoutPolicy int
+
+const (
+	SkipBlackedOut BlackoutPolicy = iota  //the occurrence is dropped
+	DeferBlackedOut  //the occurrence fires when the blackout ends. Occurrences in the same blackout fire together, as one task.
+)
+
+// A period in which schedules don't fire, from Start up to (not including) End.
+type BlackoutPeriod struct {
+	Start time.Time
+	End time.Time
+	Reason string  //e.g. "New Year's Day", shown in the schedule's status
+}
+
+// A named set of blackout periods, e.g. a holiday calendar, that can be attached to many schedules.
+type BlackoutCalendar struct {
+	Name string
+	Periods []BlackoutPeriod
+}
+
+// What a schedule did with an occurrence inside a blackout, see ScheduleStatus.
+type BlackoutDecision struct {
+	Occurrence time.Time  //the fire time that fell inside the blackout
+	Calendar string
+	Reason string
+	Deferred bool  //false if the occurrence was skipped
+	Until time.Time  //when the deferred occurrence fires
+}
+
+
+// Returns a blackout period covering the calendar day @year-@month-@day in @loc, e.g. for a holiday.
+func BlackoutDay(year int, month time.Month, day int, loc *time.Location, reason string) BlackoutPeriod {
+	start := time.Date(year, month, day, 0, 0, 0, 0, loc)
+	return BlackoutPeriod{Start: start, End: start.AddDate(0, 0, 1), Reason: reason}
+}
+
+
+// Returns the first period of the calendar that covers @t.
+func (c BlackoutCalendar) Covering(t time.Time) (BlackoutPeriod, bool) {
+	for _, p := range c.Periods {
+		if !t.Before(p.Start) && t.Before(p.End) {
+			return p, true
+		}
+	}
+
+	return BlackoutPeriod{}, false
+}
+
+
+// Returns the calendar and period that black out @t, picking the one that ends last when several overlap.
+func (s Schedule) blackedOut(t time.Time) (string, BlackoutPeriod, bool) {
+	var calendar string
+	var period BlackoutPeriod
+	found := false
+
+	for _, c := range s.Blackouts {
+		p, ok := c.Covering(t)
+		if ok && (!found || p.End.After(period.End)) {
+			calendar, period, found = c.Name, p, true
+		}
+	}
+
+	return calendar, period, found
+}
+
+
+// Called by fireSchedule at @fireAt with the lock held. If @fireAt is blacked out, skips or defers
+// @occurrence as the schedule's policy says, arms the schedule again and returns true.
+func (q *FixedSizeQueue) holdForBlackout(job *scheduledJob, occurrence time.Time, fireAt time.Time) bool {
+	calendar, period, ok := job.schedule.blackedOut(fireAt)
+	if !ok {
+		return false
+	}
+
+	decision := &BlackoutDecision{Occurrence: occurrence, Calendar: calendar, Reason: period.Reason}
+	job.lastBlackout = decision
+
+	if job.schedule.Blackout == SkipBlackedOut {
+		q.armSchedule(job, fireAt)
+		return true
+	}
+
+	decision.Deferred = true
+	decision.Until = period.End
+	job.deferred = occurrence
+	q.setScheduleTimer(job, period.End)
+	return true
+}
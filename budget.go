@@ -0,0 +1,374 @@
+package fsq
+
+import "fmt"
+import "errors"
+import "sort"
+import "time"
+
+// What happens to a task when the budget it is charged against is spent for the current interval.
+type BudgetPolicy int
+
+const (
+	BudgetReject BudgetPolicy = iota  //Add returns an error. The cost is charged when the task is added.
+	BudgetDefer  //the task is accepted but parked until the budget refills, tasks of other tenants are dispatched meanwhile. The cost is charged at dispatch.
+)
+
+type budget struct {
+	tenant string
+	limit int
+	interval time.Duration
+	policy BudgetPolicy
+	spent int  //spent in the current interval
+	totalSpent int
+	rejected int
+	deferred int
+	intervalStart time.Time
+}
+
+// A point in time view of a budget and what has been spent against it.
+type BudgetSpend struct {
+	Tenant string  //empty for the queue wide budget
+	Limit int
+	Interval time.Duration
+	Policy BudgetPolicy
+	Spent int  //spent in the current interval
+	TotalSpent int  //spent since the budget was set
+	Rejected int  //number of Adds rejected by the budget
+	Deferred int  //number of times dispatch was held back by the budget
+	IntervalEnds time.Time
+}
+
+
+// - Sets a budget of @limit cost units per @interval for tasks added with AddWithCost.
+// - @tenant: the tenant the budget applies to. An empty tenant sets the queue wide budget, which every
+// task is charged against in addition to its tenant's budget.
+// - Setting a budget for a tenant that already has one replaces it and resets its counters.
+func (q *FixedSizeQueue) SetBudget(tenant string, limit int, interval time.Duration, policy BudgetPolicy) error {
+	return q.As("").SetBudget(tenant, limit, interval, policy)
+}
+
+
+// Removes the budget for @tenant, tasks already held back by it are dispatched.
+func (q *FixedSizeQueue) RemoveBudget(tenant string) {
+	q.As("").RemoveBudget(tenant)
+}
+
+
+// Returns the spend of the budget for @tenant, false if the tenant has no budget.
+func (q *FixedSizeQueue) BudgetSpend(tenant string) (BudgetSpend, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	b, ok := q.budgets[tenant]
+	if !ok {
+		return BudgetSpend{}, false
+	}
+
+	b.refill(q.now())
+	return b.spend(), true
+}
+
+
+// Returns the spend of every budget on the queue, ordered by tenant.
+func (q *FixedSizeQueue) BudgetSpends() []BudgetSpend {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.budgetSpends()
+}
+
+
+func (q *FixedSizeQueue) budgetSpends() []BudgetSpend {
+	now := q.now()
+	spends := make([]BudgetSpend, 0, len(q.budgets))
+
+	for _, b := range q.budgets {
+		b.refill(now)
+		spends = append(spends, b.spend())
+	}
+
+	sort.Slice(spends, func(i, j int) bool {
+		return spends[i].Tenant < spends[j].Tenant
+	})
+
+	return spends
+}
+
+
+// - Adds a task that costs @cost units of the queue wide budget and of @tenant's budget (when those are set).
+// - @cost must not be negative, and a task that costs more than a budget's limit is always rejected
+// since it could never be dispatched.
+// - Same as Add with WithCost and WithTenant.
+func (q *FixedSizeQueue) AddWithCost(action func(params map[string]interface{}) error, params map[string]interface{}, id string, cost int, tenant string) error {
+	return q.Add(action, params, id, WithCost(cost), WithTenant(tenant))
+}
+
+
+func (q *FixedSizeQueue) setBudget(tenant string, limit int, interval time.Duration, policy BudgetPolicy) (string, string, error) {
+	if limit <= 0 {
+		return "", "", errors.New("Budget limit must be greater than 0.")
+	}
+
+	if interval <= 0 {
+		return "", "", errors.New("Budget interval must be greater than 0.")
+	}
+
+	if q.budgets == nil {
+		q.budgets = map[string]*budget{}
+	}
+
+	oldValue := q.budgets[tenant].String()
+	q.budgets[tenant] = &budget{
+		tenant: tenant,
+		limit: limit,
+		interval: interval,
+		policy: policy,
+		intervalStart: q.now(),
+	}
+
+	return oldValue, q.budgets[tenant].String(), nil
+}
+
+
+func (q *FixedSizeQueue) removeBudget(tenant string) (string, string, error) {
+	b, ok := q.budgets[tenant]
+	if !ok {
+		return "", "", errors.New(fmt.Sprintf("Tenant %q has no budget.", tenant))
+	}
+
+	delete(q.budgets, tenant)
+	q.dispatchWaiting()
+	return b.String(), "", nil
+}
+
+
+// the name of the setting for @tenant's budget in the audit log
+func budgetSetting(tenant string) string {
+	return fmt.Sprintf("budget[%s]", tenant)
+}
+
+
+func (p BudgetPolicy) String() string {
+	if p == BudgetDefer {
+		return "defer"
+	}
+
+	return "reject"
+}
+
+
+// Budget policies are written as "reject" or "defer" in JSON, e.g. by the admin API.
+func (p BudgetPolicy) MarshalText() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+
+// formats the budget's configuration, empty for a nil budget
+func (b *budget) String() string {
+	if b == nil {
+		return ""
+	}
+
+	return fmt.Sprintf("%d per %s (%s)", b.limit, b.interval, b.policy)
+}
+
+
+func (b *budget) spend() BudgetSpend {
+	return BudgetSpend{
+		Tenant: b.tenant,
+		Limit: b.limit,
+		Interval: b.interval,
+		Policy: b.policy,
+		Spent: b.spent,
+		TotalSpent: b.totalSpent,
+		Rejected: b.rejected,
+		Deferred: b.deferred,
+		IntervalEnds: b.intervalStart.Add(b.interval),
+	}
+}
+
+
+// starts a new interval (and resets the spend) if the current interval has passed
+func (b *budget) refill(now time.Time) {
+	elapsed := now.Sub(b.intervalStart)
+	if elapsed < b.interval {
+		return
+	}
+
+	b.intervalStart = b.intervalStart.Add(elapsed - elapsed % b.interval)
+	b.spent = 0
+}
+
+
+func (b *budget) fits(cost int) bool {
+	return b.spent + cost <= b.limit
+}
+
+
+func (b *budget) charge(cost int) {
+	b.spent += cost
+	b.totalSpent += cost
+}
+
+
+// returns the budgets (queue wide first) that a task for @tenant is charged against
+func (q *FixedSizeQueue) budgetsFor(tenant string) []*budget {
+	budgets := []*budget{}
+
+	if b, ok := q.budgets[""]; ok {
+		budgets = append(budgets, b)
+	}
+
+	if tenant == "" {
+		return budgets
+	}
+
+	if b, ok := q.budgets[tenant]; ok {
+		budgets = append(budgets, b)
+	}
+
+	return budgets
+}
+
+
+// Called by Add, charges the reject policy budgets for a task or returns an error if one is spent.
+func (q *FixedSizeQueue) admitCost(cost int, tenant string) error {
+	if len(q.budgets) == 0 || cost == 0 {
+		return nil
+	}
+
+	now := q.now()
+	budgets := q.budgetsFor(tenant)
+
+	for _, b := range budgets {
+		b.refill(now)
+
+		if cost > b.limit {
+			return errors.New(fmt.Sprintf("FixedSizeQueue %s task cost %d exceeds the budget limit %d.", q.QualifiedName(), cost, b.limit))
+		}
+
+		if b.policy == BudgetReject && !b.fits(cost) {
+			b.rejected++
+			errMsg := fmt.Sprintf("FixedSizeQueue %s budget for tenant %q is spent for this interval. Try later.", q.QualifiedName(), tenant)
+			return errors.New(errMsg)
+		}
+	}
+
+	for _, b := range budgets {
+		if b.policy == BudgetReject {
+			b.charge(cost)
+		}
+	}
+
+	return nil
+}
+
+
+// Called before dispatching @task, charges the defer policy budgets for the task. Returns false (and
+// schedules a dispatch for when the budget refills) if the task has to be held back.
+func (q *FixedSizeQueue) dispatchCost(task *task) bool {
+	if b := q.spentBudget(task); b != nil {
+		b.deferred++
+		q.dispatchAt(b.intervalStart.Add(b.interval))
+		return false
+	}
+
+	if task.cost == 0 {
+		return true
+	}
+
+	for _, b := range q.budgetsFor(task.tenant) {
+		if b.policy == BudgetDefer {
+			b.charge(task.cost)
+		}
+	}
+
+	return true
+}
+
+
+// returns the defer policy budget that is too spent for @task to be dispatched now, nil if there is none
+func (q *FixedSizeQueue) spentBudget(task *task) *budget {
+	if len(q.budgets) == 0 || task.cost == 0 {
+		return nil
+	}
+
+	now := q.now()
+	for _, b := range q.budgetsFor(task.tenant) {
+		b.refill(now)
+
+		if b.policy == BudgetDefer && !b.fits(task.cost) {
+			return b
+		}
+	}
+
+	return nil
+}
+
+
+// Called with the next waiting task, moves it into parking if a budget it is charged against is spent, so
+// the tasks behind it (e.g. of other tenants) are dispatched meanwhile. Returns true if the task was parked.
+func (q *FixedSizeQueue) parkIfOverBudget(next *task) bool {
+	b := q.spentBudget(next)
+	if b == nil {
+		return false
+	}
+
+	b.deferred++
+	q.dispatchAt(b.intervalStart.Add(b.interval))
+	q.removeNextWaiting()
+
+	if q.overBudget == nil {
+		q.overBudget = map[string][]*task{}
+	}
+
+	q.overBudget[next.tenant] = append(q.overBudget[next.tenant], next)
+	q.epoch++
+	q.trace(next.externalId, "parked", "budget of tenant %q is spent", next.tenant)
+	return true
+}
+
+
+// releases the tasks parked by parkIfOverBudget whose budgets refilled, they are dispatched ahead of the ring buffer
+func (q *FixedSizeQueue) releaseOverBudget() {
+	if len(q.overBudget) == 0 {
+		return
+	}
+
+	tenants := make([]string, 0, len(q.overBudget))
+	for tenant := range q.overBudget {
+		tenants = append(tenants, tenant)
+	}
+	sort.Strings(tenants)
+
+	for _, tenant := range tenants {
+		tasks := q.overBudget[tenant]
+		if q.spentBudget(tasks[0]) != nil {
+			continue
+		}
+
+		delete(q.overBudget, tenant)
+		q.unparked = append(q.unparked, tasks...)
+		q.epoch++
+
+		for _, task := range tasks {
+			q.trace(task.externalId, "unparked", "budget of tenant %q refilled", tenant)
+		}
+	}
+}
+
+
+// Makes sure waiting tasks are dispatched again no later than @t.
+func (q *FixedSizeQueue) dispatchAt(t time.Time) {
+	// tasks of several tenants may be held back, the earliest refill wins
+	if q.budgetTimer != nil && q.budgetWakeAt.After(q.now()) && !t.Before(q.budgetWakeAt) {
+		return
+	}
+
+	if q.budgetTimer != nil {
+		q.budgetTimer.Stop()
+	}
+
+	q.budgetWakeAt = t
+	q.budgetTimer = q.afterFunc(t.Sub(q.now()), q.wake)
+}
@@ -0,0 +1,172 @@
+package fsq
+
+import "sync"
+
+// What FixedSizeBuffer needs from the buffer it holds, implemented by ringBuffer, lifoBuffer and priorityBuffer.
+type buffer[T any] interface {
+	Enqueue(item T) error  //fails if there is no space for the item
+	Dequeue() T  //the zero value of T when empty
+	Peek() T  //same as Dequeue, without removing the item
+	PeekTail() T  //the newest, least important item, without removing it
+	Remove(match func(item T) bool) (T, bool)  //removes the first matching item, keeping the order of the others
+	Len() int
+	Cap() int
+	Tasks() []T  //in the order they would be dequeued
+}
+
+// - A bounded FIFO of arbitrary payloads, for when only the buffer semantics of the queue are wanted:
+// no actions and no dispatching, just O(1) Put and Get on a fixed size ring buffer (the same one
+// FixedSizeQueue uses).
+// - Safe for concurrent use.
+type FixedSizeBuffer[T any] struct {
+	mu sync.Mutex
+	notFull *sync.Cond  //broadcast when an item is taken out, wakes up blocked Puts
+	items buffer[T]
+}
+
+
+// @size: the max number of items in the buffer. Defaults to 1 if size of <= 0 is passed in
+func NewFixedSizeBuffer[T any](size int) *FixedSizeBuffer[T] {
+	if size <= 0 {
+		size = 1
+	}
+
+	return newFixedSizeBuffer[T](newRingBuffer[T](size))
+}
+
+
+// - Same as NewFixedSizeBuffer, but Get takes out the newest item first (a stack), e.g. for workloads where
+// only the latest state matters.
+func NewLIFOBuffer[T any](size int) *FixedSizeBuffer[T] {
+	if size <= 0 {
+		size = 1
+	}
+
+	return newFixedSizeBuffer[T](newLIFOBuffer[T](size))
+}
+
+
+// - Returns a buffer with @levels priority levels of @sizePerLevel items each. Level 0 is the highest priority.
+// - @level returns the priority level of an item, levels out of range are clamped. Items of the same level
+// are taken out in FIFO order.
+// - @weights: when empty, Get takes items strictly by level, a level only gets a turn once the levels above
+// it are empty. Otherwise there must be one weight per level, and per round each level gets up to its weight
+// in items, so that lower levels are never starved. e.g. weights 3, 1 take 3 high priority items for every low priority item.
+// - Put and TryPut fail (or wait) when the item's level is full, even if other levels have space.
+func NewPriorityBuffer[T any](levels int, sizePerLevel int, level func(item T) int, weights ...int) (*FixedSizeBuffer[T], error) {
+	if sizePerLevel <= 0 {
+		sizePerLevel = 1
+	}
+
+	pb, err := newPriorityBuffer[T](levels, sizePerLevel, level, weights...)
+	if err != nil {
+		return nil, err
+	}
+
+	return newFixedSizeBuffer[T](pb), nil
+}
+
+
+func newFixedSizeBuffer[T any](items buffer[T]) *FixedSizeBuffer[T] {
+	b := &FixedSizeBuffer[T]{
+		items: items,
+	}
+	b.notFull = sync.NewCond(&b.mu)
+
+	return b
+}
+
+
+// Adds @item to the buffer, or returns ErrQueueFull right away if the buffer is full (the same overflow
+// behavior as FixedSizeQueue.Add).
+func (b *FixedSizeBuffer[T]) TryPut(item T) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	err := b.items.Enqueue(item)
+	if err != nil {
+		return ErrQueueFull
+	}
+
+	return nil
+}
+
+
+// Adds @item to the buffer, waiting for an item to be taken out first if the buffer is full.
+func (b *FixedSizeBuffer[T]) Put(item T) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for b.items.Enqueue(item) != nil {
+		b.notFull.Wait()
+	}
+}
+
+
+// Takes the next item out of the buffer, the oldest one unless the buffer is a LIFO or priority buffer.
+// Returns false (and the zero value of T) if the buffer is empty.
+func (b *FixedSizeBuffer[T]) Get() (T, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.items.Len() == 0 {
+		var zero T
+		return zero, false
+	}
+
+	item := b.items.Dequeue()
+	// with priority levels, the space may not be the one a particular Put waits for, so wake them all
+	b.notFull.Broadcast()
+	return item, true
+}
+
+
+// Returns the item Get would take out next without removing it. Returns false if the buffer is empty.
+func (b *FixedSizeBuffer[T]) Peek() (T, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.items.Peek(), b.items.Len() > 0
+}
+
+
+// - Returns the newest item in the buffer without removing it. Returns false if the buffer is empty.
+// - For a priority buffer, returns the newest item of the lowest priority level that has items.
+// - For a LIFO buffer, returns the oldest item, the one Get takes out last.
+func (b *FixedSizeBuffer[T]) PeekTail() (T, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.items.PeekTail(), b.items.Len() > 0
+}
+
+
+// - Removes the first item @match returns true for (in the order Get would take them out) and returns it.
+// Returns false if no item matches.
+// - The order of the other items is kept, e.g. to cancel or expire an item that is still buffered.
+func (b *FixedSizeBuffer[T]) Remove(match func(item T) bool) (T, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	item, ok := b.items.Remove(match)
+	if ok {
+		b.notFull.Broadcast()
+	}
+
+	return item, ok
+}
+
+
+// Returns the number of items in the buffer.
+func (b *FixedSizeBuffer[T]) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.items.Len()
+}
+
+
+// Returns the max number of items in the buffer.
+func (b *FixedSizeBuffer[T]) Cap() int {
+	return b.items.Cap()
+}
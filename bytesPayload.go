@@ -0,0 +1,26 @@
+package fsq
+
+import "context"
+
+// An action whose input is a serialized payload (e.g. JSON or protobuf) instead of a params map, see AddBytes.
+type BytesAction func(ctx context.Context, payload []byte) error
+
+// Shared by the tasks that have no params of their own, so they don't each allocate a map. Never written to.
+var noParams = map[string]interface{}{}
+
+
+// - Same as Add, but the task carries @payload instead of a params map, and @action gets it together with
+// the task's context (see ContextAction). Saves building a map for data that is already serialized.
+// - @payload isn't copied, it is handed to @action as is. Don't modify it after adding the task.
+// - The task has no params, so params features (templates, triggers' params, Freeze's params) see an empty map.
+func (q *FixedSizeQueue) AddBytes(action BytesAction, payload []byte, id string) error {
+	if action == nil {
+		return q.add(context.Background(), nil, nil, id, addOptions{})
+	}
+
+	ctxAction := func(ctx context.Context, params map[string]interface{}) error {
+		return action(ctx, payload)
+	}
+
+	return q.add(context.Background(), nil, noParams, id, addOptions{ctxAction: ctxAction})
+}
@@ -0,0 +1,36 @@
+package fsq
+
+import "errors"
+import "context"
+
+// The error @onFailure is called with when a task was abandoned, see AddWithCallbacks.
+var ErrTaskAbandoned = errors.New("Task was abandoned while processing.")
+
+
+// - Same as Add, but the caller is told how the task ended: @onSuccess is called once its action returned
+// nil, @onFailure once it returned an error. Either may be nil.
+// - @onFailure is also called with ErrTaskAbandoned when the task is abandoned (see SetAbandonTimeout), and
+// with ErrTaskTooOld when it is dropped past the max task age (see SetMaxTaskAge). A task ends only once,
+// so exactly one of the callbacks is called.
+// - Callbacks are called without holding the queue's lock, so they may add tasks. They run in the
+// go routine of the task, of the timer that abandoned it, or in one of their own for dropped tasks.
+func (q *FixedSizeQueue) AddWithCallbacks(action func(params map[string]interface{}) error, params map[string]interface{}, id string, onSuccess func(), onFailure func(err error)) error {
+	return q.add(context.Background(), action, params, id, addOptions{onSuccess: onSuccess, onFailure: onFailure})
+}
+
+
+// Returns the callback telling the caller @task ended with @err, nil if it has none. Call it once the lock
+// is released.
+func (t *task) completion(err error) func() {
+	onSuccess, onFailure := t.onSuccess, t.onFailure
+
+	if err == nil && onSuccess != nil {
+		return onSuccess
+	}
+
+	if err != nil && onFailure != nil {
+		return func() { onFailure(err) }
+	}
+
+	return nil
+}
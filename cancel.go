@@ -0,0 +1,145 @@
+package fsq
+
+import "errors"
+
+// The error of the EventFailed event of a waiting task withdrawn by Cancel.
+var ErrTaskCancelled = errors.New("Task was cancelled before it was dispatched.")
+
+// Returned by Cancel when no task with the external id is waiting.
+var ErrTaskNotWaiting = errors.New("No task with that external id is waiting.")
+
+// Returned by CancelProcessing when no task with the external id is processing.
+var ErrTaskNotProcessing = errors.New("No task with that external id is processing.")
+
+
+// - Withdraws the waiting task added with @externalId, so it is never run: it is taken out of the queue, its
+// slot is freed, and it ends with an EventFailed event whose error is ErrTaskCancelled (ReasonCancelled).
+// Its failure callback is called before Cancel returns (see AddWithCallbacks).
+// - Works for parked, delayed and overflow tasks and tasks waiting for a retry too. Returns ErrTaskNotWaiting
+// if no task with @externalId is waiting, e.g. because it is processing or finished.
+func (q *FixedSizeQueue) Cancel(externalId string) error {
+	q.mu.Lock()
+
+	task, ok := q.waitingTasksByExternalId[externalId]
+	if !ok {
+		q.mu.Unlock()
+		return ErrTaskNotWaiting
+	}
+
+	q.stats.Cancelled++
+	q.trace(externalId, "cancelled", "withdrawn while waiting")
+	done := q.withdraw(task, ErrTaskCancelled, ReasonCancelled)
+	q.mu.Unlock()
+
+	if done != nil {
+		done()
+	}
+
+	return nil
+}
+
+
+// - Withdraws every waiting task like Cancel, parked, delayed and overflow ones included. Processing tasks keep
+// running and the queue keeps taking new tasks.
+// - Returns the external ids of the cancelled tasks in dispatch order, parked ones last.
+func (q *FixedSizeQueue) PurgeWaiting() []string {
+	q.mu.Lock()
+
+	// withdrawing frees slots, which would release delayed and over budget tasks of the snapshot
+	q.purging = true
+	waiting := append(q.waitingInOrder(), q.parkedTasks()...)
+
+	purged := make([]string, 0, len(waiting))
+	callbacks := []func(){}
+
+	for _, task := range waiting {
+		purged = append(purged, task.externalId)
+		q.stats.Cancelled++
+		q.trace(task.externalId, "cancelled", "the waiting tasks were purged")
+
+		if done := q.withdraw(task, ErrTaskCancelled, ReasonCancelled); done != nil {
+			callbacks = append(callbacks, done)
+		}
+	}
+
+	q.purging = false
+	q.dispatchWaiting()
+	q.mu.Unlock()
+
+	for _, done := range callbacks {
+		done()
+	}
+
+	return purged
+}
+
+
+// - Cancels the context of the processing task added with @externalId (see AddContextAction), so an action that
+// watches its context can abort early. The task ends as its action returns, with ReasonCancelled if it returns the
+// context's error, and isn't retried (see SetRetryPolicy).
+// - Plain actions have no context and run to completion. Returns ErrTaskNotProcessing if no task with @externalId
+// is processing. Several processing tasks with @externalId (ids are only unique among waiting tasks) are all cancelled.
+func (q *FixedSizeQueue) CancelProcessing(externalId string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	tasks := q.processingByExternalId[externalId]
+	if len(tasks) == 0 {
+		return ErrTaskNotProcessing
+	}
+
+	for _, task := range tasks {
+		q.trace(externalId, "cancelling", "ran %s", q.now().Sub(task.startedAt))
+		task.cancel()
+	}
+
+	return nil
+}
+
+
+// Marks the dispatched @task as processing, see CancelProcessing.
+func (q *FixedSizeQueue) rememberProcessing(dispatched *task) {
+	if q.processingByExternalId == nil {
+		q.processingByExternalId = map[string][]*task{}
+	}
+
+	q.processingByExternalId[dispatched.externalId] = append(q.processingByExternalId[dispatched.externalId], dispatched)
+}
+
+
+// Called once @task settled: its action returned, it timed out or it was abandoned.
+func (q *FixedSizeQueue) forgetProcessing(settled *task) {
+	tasks := q.processingByExternalId[settled.externalId]
+
+	for i, task := range tasks {
+		if task == settled {
+			tasks = append(tasks[:i:i], tasks[i + 1:]...)
+			break
+		}
+	}
+
+	if len(tasks) == 0 {
+		delete(q.processingByExternalId, settled.externalId)
+	} else {
+		q.processingByExternalId[settled.externalId] = tasks
+	}
+}
+
+
+// Ends the waiting @task with @err without running it and takes it out of the queue. Returns its completion
+// callback, to be called once the lock is released.
+func (q *FixedSizeQueue) withdraw(task *task, err error, reason OutcomeReason) func() {
+	event := q.taskEvent(task, err)
+	event.Reason = reason
+	q.publish(event)
+	task.resolve(event)
+
+	if q.results != nil {
+		q.results.push(event)
+	}
+
+	done := task.completion(err)
+	task.releasePayload()
+	q.removeWaiting(task)
+	return done
+}
@@ -0,0 +1,165 @@
+package fsq
+
+import "sort"
+import "sync"
+import "time"
+
+// - The time source of a queue, see SetClock.
+// - Readings must be monotonic: a reading is never before an earlier one, even if the wall clock is
+// changed (e.g. by NTP). time.Now is, since its readings carry the monotonic clock.
+type Clock interface {
+	Now() time.Time
+}
+
+// - A Clock that also runs timers, so they fire when the clock reaches their time instead of in real time.
+// - The queue schedules its budget refills (see SetBudget) with its clock when the clock is a TimerClock.
+type TimerClock interface {
+	Clock
+	// Calls @f in its own go routine once @d has passed on the clock.
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// A timer started by TimerClock.AfterFunc. *time.Timer is one.
+type Timer interface {
+	// Keeps the timer from firing. Returns false if it already fired or was stopped.
+	Stop() bool
+}
+
+type realClock struct{}
+
+// A TimerClock for tests that only moves when told to.
+type FakeClock struct {
+	mu sync.Mutex
+	now time.Time
+	timers []*fakeTimer  //not yet fired nor stopped
+}
+
+type fakeTimer struct {
+	clock *FakeClock
+	at time.Time
+	f func()
+}
+
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+
+// Returns a fake clock that reads @start until it is advanced.
+func NewFakeClock(start time.Time) *FakeClock {
+	return &FakeClock{now: start}
+}
+
+
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+
+// Moves the clock forward by @d. Negative durations are ignored, so the clock stays monotonic.
+func (c *FakeClock) Advance(d time.Duration) {
+	if d <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+
+	due := []*fakeTimer{}
+	pending := []*fakeTimer{}
+	for _, timer := range c.timers {
+		if timer.at.After(c.now) {
+			pending = append(pending, timer)
+		} else {
+			due = append(due, timer)
+		}
+	}
+	c.timers = pending
+	c.mu.Unlock()
+
+	// the timers fire in the order of their times, each in its own go routine like a time.Timer's func
+	sort.SliceStable(due, func(i, j int) bool {
+		return due[i].at.Before(due[j].at)
+	})
+	for _, timer := range due {
+		go timer.f()
+	}
+}
+
+
+// Calls @f in its own go routine once the clock was advanced by @d. A @d <= 0 calls it right away.
+func (c *FakeClock) AfterFunc(d time.Duration, f func()) Timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	timer := &fakeTimer{clock: c, at: c.now.Add(d), f: f}
+	if d <= 0 {
+		go f()
+		return timer
+	}
+
+	c.timers = append(c.timers, timer)
+	return timer
+}
+
+
+func (t *fakeTimer) Stop() bool {
+	c := t.clock
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i, timer := range c.timers {
+		if timer == t {
+			c.timers = append(c.timers[:i], c.timers[i + 1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
+
+// - Sets the time source the queue reads for timestamps and durations: enqueue and dispatch times, budget
+// intervals, maintenance windows, audit log entries and views. A nil @clock restores the real clock.
+// - Budget refills are scheduled with @clock when it is a TimerClock (as FakeClock is), other timers (e.g. the
+// abandon timeout) still fire in real time.
+func (q *FixedSizeQueue) SetClock(clock Clock) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if clock == nil {
+		clock = realClock{}
+	}
+
+	q.clock = clock
+}
+
+
+func (q *FixedSizeQueue) now() time.Time {
+	return q.clock.Now()
+}
+
+
+// calls @f after @d on the queue's clock if it runs timers, in real time otherwise
+func (q *FixedSizeQueue) afterFunc(d time.Duration, f func()) Timer {
+	if clock, ok := q.clock.(TimerClock); ok {
+		return clock.AfterFunc(d, f)
+	}
+
+	return time.AfterFunc(d, f)
+}
+
+
+// Returns the time passed since @t, never negative.
+func (q *FixedSizeQueue) since(t time.Time) time.Duration {
+	elapsed := q.now().Sub(t)
+	if elapsed < 0 {
+		return 0
+	}
+
+	return elapsed
+}
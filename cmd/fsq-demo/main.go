@@ -0,0 +1,74 @@
+// - fsq-demo serves the example application of the fsqdemo package over HTTP, see fsqdemo for its endpoints.
+// On SIGINT or SIGTERM it stops taking requests, drains its queues and prints how each shutdown went.
+//
+//	go run ./cmd/fsq-demo -addr :8080 -results results.jsonl -admin-token secret
+//	curl -X POST 'localhost:8080/tasks/email?id=welcome-1' -d '{"to": "ada@example.com", "subject": "Welcome"}'
+//	curl localhost:8080/tasks/welcome-1
+//	curl localhost:8080/stats
+//	curl -H 'Authorization: Bearer secret' localhost:8080/admin/queues
+
+package main
+
+import "fmt"
+import "errors"
+import "context"
+import "flag"
+import "net/http"
+import "os"
+import "os/signal"
+import "syscall"
+import "time"
+
+import "github.com/brybott/go_fsq"
+import "github.com/brybott/go_fsq/fsqdemo"
+
+func main() {
+	addr := flag.String("addr", ":8080", "address to serve on")
+	results := flag.String("results", "", "file the outcome of every task is appended to, none if empty")
+	adminToken := flag.String("admin-token", "", "bearer token with the admin role on /admin, the admin API is closed if empty")
+	work := flag.Duration("work", 200 * time.Millisecond, "how long the demo actions take")
+	drain := flag.Duration("drain", 10 * time.Second, "how long shutdown waits for the queues to drain")
+	flag.Parse()
+
+	config := fsqdemo.Config{ResultsPath: *results, Work: *work}
+	if *adminToken != "" {
+		config.AdminTokens = map[string]fsq.Role{*adminToken: fsq.RoleAdmin}
+	}
+
+	demo, err := fsqdemo.New(config)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	demo.Start()
+	server := &http.Server{Addr: *addr, Handler: demo.Handler()}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go func() {
+		err := server.ListenAndServe()
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}()
+
+	fmt.Printf("fsq-demo serving on %s\n", *addr)
+	<-ctx.Done()
+
+	drainCtx, cancel := context.WithTimeout(context.Background(), *drain)
+	defer cancel()
+
+	// no new tasks come in while the queues drain
+	server.Shutdown(drainCtx)
+
+	for _, report := range demo.Shutdown(drainCtx) {
+		if report.Drained {
+			fmt.Printf("%s drained in %s\n", report.Queue, report.Took)
+		} else {
+			fmt.Printf("%s didn't drain: %d waiting, %d processing (%s)\n", report.Queue, report.Waiting, report.Processing, report.Err)
+		}
+	}
+}
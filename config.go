@@ -0,0 +1,202 @@
+package fsq
+
+import "fmt"
+import "errors"
+import "strconv"
+import "time"
+
+// Returned by the CompareAndSet methods when the queue's configuration was changed since the caller read
+// ConfigVersion. The caller should read the configuration again and decide if its change still applies.
+var ErrConfigConflict = errors.New("Queue configuration was changed since it was read, try again.")
+
+// Makes runtime configuration changes to a queue on behalf of a principal, which is recorded in the
+// queue's change-audit log (see ConfigChanges). Get one with FixedSizeQueue.As.
+type ConfigEditor struct {
+	q *FixedSizeQueue
+	principal string
+}
+
+
+// - Returns the version of the queue's runtime configuration (max processing, capacity, budgets, ...).
+// Every change to the configuration increases the version.
+// - Pass the version to the CompareAndSet methods so that concurrent changes can't silently clobber each other.
+func (q *FixedSizeQueue) ConfigVersion() uint64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.configVersion
+}
+
+
+// - Returns an editor that makes configuration changes on behalf of @principal (a user, service or
+// automation), so the audit log records who made each change.
+// - The configuration methods on the queue itself record an empty principal.
+func (q *FixedSizeQueue) As(principal string) ConfigEditor {
+	return ConfigEditor{q: q, principal: principal}
+}
+
+
+// - Sets the max number of tasks processed at once. Raising it dispatches more waiting tasks right away,
+// lowering it takes effect as processing tasks finish.
+// - Stops following GOMAXPROCS if the queue was created with InitWithProcs.
+func (q *FixedSizeQueue) SetMaxProcessing(maxProcessing int) error {
+	return q.As("").SetMaxProcessing(maxProcessing)
+}
+
+
+// Same as SetMaxProcessing, but fails with ErrConfigConflict if the configuration is no longer at @version.
+// Returns the new version.
+func (q *FixedSizeQueue) CompareAndSetMaxProcessing(version uint64, maxProcessing int) (uint64, error) {
+	return q.As("").CompareAndSetMaxProcessing(version, maxProcessing)
+}
+
+
+// - Changes the max number of waiting tasks the queue holds.
+// - Shrinking below the number of tasks that are currently waiting is refused with an error.
+func (q *FixedSizeQueue) Resize(size int) error {
+	return q.As("").Resize(size)
+}
+
+
+// Same as Resize, but fails with ErrConfigConflict if the configuration is no longer at @version.
+// Returns the new version.
+func (q *FixedSizeQueue) CompareAndResize(version uint64, size int) (uint64, error) {
+	return q.As("").CompareAndResize(version, size)
+}
+
+
+// Same as SetBudget, but fails with ErrConfigConflict if the configuration is no longer at @version.
+// Returns the new version.
+func (q *FixedSizeQueue) CompareAndSetBudget(version uint64, tenant string, limit int, interval time.Duration, policy BudgetPolicy) (uint64, error) {
+	return q.As("").CompareAndSetBudget(version, tenant, limit, interval, policy)
+}
+
+
+// See FixedSizeQueue.SetMaxProcessing
+func (e ConfigEditor) SetMaxProcessing(maxProcessing int) error {
+	_, err := e.q.changeConfig(e.principal, 0, false, "maxProcessing", func() (string, string, error) {
+		return e.q.setMaxProcessing(maxProcessing)
+	})
+	return err
+}
+
+
+// See FixedSizeQueue.CompareAndSetMaxProcessing
+func (e ConfigEditor) CompareAndSetMaxProcessing(version uint64, maxProcessing int) (uint64, error) {
+	return e.q.changeConfig(e.principal, version, true, "maxProcessing", func() (string, string, error) {
+		return e.q.setMaxProcessing(maxProcessing)
+	})
+}
+
+
+// See FixedSizeQueue.Resize
+func (e ConfigEditor) Resize(size int) error {
+	_, err := e.q.changeConfig(e.principal, 0, false, "size", func() (string, string, error) {
+		return e.q.resize(size)
+	})
+	return err
+}
+
+
+// See FixedSizeQueue.CompareAndResize
+func (e ConfigEditor) CompareAndResize(version uint64, size int) (uint64, error) {
+	return e.q.changeConfig(e.principal, version, true, "size", func() (string, string, error) {
+		return e.q.resize(size)
+	})
+}
+
+
+// See FixedSizeQueue.SetBudget
+func (e ConfigEditor) SetBudget(tenant string, limit int, interval time.Duration, policy BudgetPolicy) error {
+	_, err := e.q.changeConfig(e.principal, 0, false, budgetSetting(tenant), func() (string, string, error) {
+		return e.q.setBudget(tenant, limit, interval, policy)
+	})
+	return err
+}
+
+
+// See FixedSizeQueue.CompareAndSetBudget
+func (e ConfigEditor) CompareAndSetBudget(version uint64, tenant string, limit int, interval time.Duration, policy BudgetPolicy) (uint64, error) {
+	return e.q.changeConfig(e.principal, version, true, budgetSetting(tenant), func() (string, string, error) {
+		return e.q.setBudget(tenant, limit, interval, policy)
+	})
+}
+
+
+// See FixedSizeQueue.RemoveBudget
+func (e ConfigEditor) RemoveBudget(tenant string) {
+	e.q.changeConfig(e.principal, 0, false, budgetSetting(tenant), func() (string, string, error) {
+		return e.q.removeBudget(tenant)
+	})
+}
+
+
+// - Applies @change to the configuration, records it in the audit log and increases the version if it succeeds.
+// When @compare is true, the change is only applied if the configuration is still at @version.
+// - @change returns the old and new value of @setting, formatted for the audit log.
+// - Takes the lock, @change is called while holding it.
+func (q *FixedSizeQueue) changeConfig(principal string, version uint64, compare bool, setting string, change func() (string, string, error)) (uint64, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if compare && version != q.configVersion {
+		return q.configVersion, ErrConfigConflict
+	}
+
+	oldValue, newValue, err := change()
+	if err != nil {
+		return q.configVersion, err
+	}
+
+	q.configVersion++
+	q.recordConfigChange(ConfigChange{
+		Version: q.configVersion,
+		Principal: principal,
+		Setting: setting,
+		Old: oldValue,
+		New: newValue,
+		At: q.now(),
+	})
+
+	return q.configVersion, nil
+}
+
+
+func (q *FixedSizeQueue) setMaxProcessing(maxProcessing int) (string, string, error) {
+	if maxProcessing < 0 {
+		return "", "", errors.New("Max processing can't be negative.")
+	}
+
+	oldValue := q.currentMaxProcessing()
+	q.procsMultiplier = 0
+	q.maxProcessing = maxProcessing
+	q.dispatchWaiting()
+	return strconv.Itoa(oldValue), strconv.Itoa(maxProcessing), nil
+}
+
+
+func (q *FixedSizeQueue) resize(size int) (string, string, error) {
+	if size <= 0 {
+		return "", "", errors.New("Queue size must be greater than 0.")
+	}
+
+	// delayed and parked tasks keep their slot too
+	if held := q.items.Len() + len(q.delayed) + q.countParked(); held > size {
+		return "", "", errors.New(fmt.Sprintf("FixedSizeQueue %s can't shrink to %d, %d tasks are waiting.", q.QualifiedName(), size, held))
+	}
+
+	oldValue := q.capacity()
+	for i, level := range q.levels {
+		// levels that were never used stay empty
+		if level.MaxSize > 0 {
+			q.levels[i] = level.resized(size)
+		}
+	}
+	q.orderItems()
+
+	// a larger queue has room for the overflow
+	q.refillFromOverflow()
+	q.notifyRoom()
+
+	return strconv.Itoa(oldValue), strconv.Itoa(size), nil
+}
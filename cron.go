@@ -0,0 +1,222 @@
+package fsq
+
+import "fmt"
+import "errors"
+import "strconv"
+import "strings"
+import "time"
+
+// the shorthands ParseSchedule accepts in place of the five fields
+var cronShorthands = map[string]string{
+	"@yearly": "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly": "0 0 1 * *",
+	"@weekly": "0 0 * * 0",
+	"@daily": "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly": "0 * * * *",
+}
+
+var cronMonthNames = []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}
+var cronDayNames = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// a field of a cron expression and the values it accepts
+type cronField struct {
+	name string
+	min int
+	max int
+	names []string  //names of the values from min on, e.g. "jan" for 1
+}
+
+
+// - Reads a schedule from a cron expression: the five fields "minute hour day-of-month month day-of-week"
+// (e.g. "*/15 9-17 * * mon-fri"), one of the shorthands @yearly, @monthly, @weekly, @daily, @midnight and
+// @hourly, or "@every <duration>" for an interval (e.g. "@every 90s", see Schedule.Every).
+// - Fields take *, values, ranges (a-b), steps (*/n, a-b/n) and lists of those separated by commas. Months
+// and days of the week may be given by their first three letters, Sunday is 0 or 7. As in cron, a day
+// matching either the day of the month or the day of the week fires when both are restricted.
+// - The schedule fires in time.Local, set its Location for another time zone.
+func ParseSchedule(spec string) (Schedule, error) {
+	spec = strings.TrimSpace(spec)
+
+	if every, ok := strings.CutPrefix(spec, "@every "); ok {
+		interval, err := time.ParseDuration(strings.TrimSpace(every))
+		if err != nil || interval <= 0 {
+			return Schedule{}, errors.New(fmt.Sprintf("Cron expression %q needs a positive duration after @every.", spec))
+		}
+
+		return Schedule{Every: interval}, nil
+	}
+
+	expanded := spec
+	if strings.HasPrefix(spec, "@") {
+		var ok bool
+		expanded, ok = cronShorthands[strings.ToLower(spec)]
+		if !ok {
+			return Schedule{}, errors.New(fmt.Sprintf("Cron expression %q is not a known shorthand.", spec))
+		}
+	}
+
+	fields := strings.Fields(expanded)
+	if len(fields) != 5 {
+		return Schedule{}, errors.New(fmt.Sprintf("Cron expression %q must have 5 fields, it has %d.", spec, len(fields)))
+	}
+
+	minutes, err := parseCronField(spec, fields[0], cronField{name: "minute", min: 0, max: 59})
+	if err != nil {
+		return Schedule{}, err
+	}
+
+	hours, err := parseCronField(spec, fields[1], cronField{name: "hour", min: 0, max: 23})
+	if err != nil {
+		return Schedule{}, err
+	}
+
+	monthDays, err := parseCronField(spec, fields[2], cronField{name: "day of the month", min: 1, max: 31})
+	if err != nil {
+		return Schedule{}, err
+	}
+
+	months, err := parseCronField(spec, fields[3], cronField{name: "month", min: 1, max: 12, names: cronMonthNames})
+	if err != nil {
+		return Schedule{}, err
+	}
+
+	// 7 is Sunday too
+	weekdays, err := parseCronField(spec, fields[4], cronField{name: "day of the week", min: 0, max: 7, names: cronDayNames})
+	if err != nil {
+		return Schedule{}, err
+	}
+
+	schedule := Schedule{}
+
+	for _, hour := range hours {
+		for _, minute := range minutes {
+			schedule.Times = append(schedule.Times, time.Duration(hour) * time.Hour + time.Duration(minute) * time.Minute)
+		}
+	}
+
+	// a field covering every value doesn't restrict the days
+	if len(monthDays) < 31 {
+		schedule.MonthDays = monthDays
+	}
+
+	if len(months) < 12 {
+		for _, month := range months {
+			schedule.Months = append(schedule.Months, time.Month(month))
+		}
+	}
+
+	days := map[time.Weekday]bool{}
+	for _, day := range weekdays {
+		days[time.Weekday(day % 7)] = true
+	}
+
+	if len(days) < 7 {
+		for day := time.Sunday; day <= time.Saturday; day++ {
+			if days[day] {
+				schedule.Days = append(schedule.Days, day)
+			}
+		}
+	}
+
+	return schedule, nil
+}
+
+
+// - Same as AddSchedule, for a schedule written as a cron expression, see ParseSchedule.
+// - Returns an error if @spec can't be read.
+func (q *FixedSizeQueue) AddCron(name string, spec string, action func(params map[string]interface{}) error, params map[string]interface{}) error {
+	schedule, err := ParseSchedule(spec)
+	if err != nil {
+		return err
+	}
+
+	return q.AddSchedule(name, schedule, action, params)
+}
+
+
+// Returns the values of @text, a field of the cron expression @spec, sorted and without duplicates.
+func parseCronField(spec string, text string, field cronField) ([]int, error) {
+	selected := make([]bool, field.max + 1)
+
+	for _, part := range strings.Split(text, ",") {
+		first, last, step, err := parseCronRange(part, field)
+		if err != nil {
+			errMsg := fmt.Sprintf("Cron expression %q has an invalid %s %q: %s", spec, field.name, part, err)
+			return nil, errors.New(errMsg)
+		}
+
+		for value := first; value <= last; value += step {
+			selected[value] = true
+		}
+	}
+
+	values := []int{}
+	for value, ok := range selected {
+		if ok {
+			values = append(values, value)
+		}
+	}
+
+	return values, nil
+}
+
+
+// Reads a single part of a field (*, a, a-b, with an optional /step), returns its bounds and step.
+func parseCronRange(part string, field cronField) (int, int, int, error) {
+	rangeText, stepText, hasStep := strings.Cut(part, "/")
+
+	step := 1
+	if hasStep {
+		var err error
+		step, err = strconv.Atoi(stepText)
+		if err != nil || step < 1 {
+			return 0, 0, 0, errors.New("the step must be a positive number.")
+		}
+	}
+
+	if rangeText == "*" {
+		return field.min, field.max, step, nil
+	}
+
+	firstText, lastText, isRange := strings.Cut(rangeText, "-")
+
+	first, err := parseCronValue(firstText, field)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	last := first
+	if isRange {
+		last, err = parseCronValue(lastText, field)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+	} else if hasStep {
+		// a/n runs from a to the end of the field
+		last = field.max
+	}
+
+	if last < first {
+		return 0, 0, 0, errors.New("the range ends before it starts.")
+	}
+
+	return first, last, step, nil
+}
+
+
+func parseCronValue(text string, field cronField) (int, error) {
+	for i, name := range field.names {
+		if strings.EqualFold(text, name) {
+			return field.min + i, nil
+		}
+	}
+
+	value, err := strconv.Atoi(text)
+	if err != nil || value < field.min || value > field.max {
+		return 0, errors.New(fmt.Sprintf("values must be from %d to %d.", field.min, field.max))
+	}
+
+	return value, nil
+}
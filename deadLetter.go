@@ -0,0 +1,205 @@
+package fsq
+
+import "fmt"
+import "errors"
+import "strconv"
+import "time"
+
+// Returned for an external id that isn't among the queue's dead letters.
+var ErrNoDeadLetter = errors.New("No dead letter has that external id.")
+
+// A task that failed for good and was kept by the queue, see SetDeadLetters.
+type DeadLetter struct {
+	ExternalId string
+	ActionName string
+	Tenant string
+	Cost int
+	Priority Priority
+	Params map[string]interface{}  //a copy of the params the task was added with, sensitive ones redacted (see AddRedaction)
+	Err error  //the error of the task's last run
+	Reason OutcomeReason  //how the task's last run ended
+	Attempts int  //runs of the task's action, more than 1 if it was retried (see SetRetryPolicy)
+	EnqueuedAt time.Time  //when the task was first added
+	FailedAt time.Time  //when the task's last run failed
+	Requeueable bool  //false for tasks whose payload was read (see AddReader), they can't be requeued
+}
+
+// a dead letter and what it takes to add its task again
+type deadLetter struct {
+	letter DeadLetter
+	action func(params map[string]interface{}) error
+	ctxAction ContextAction
+	byName bool
+}
+
+
+// - Keeps up to @max tasks that failed for good, with their params, error and timestamps, so they can be
+// inspected, requeued (see RequeueDeadLetter) or purged (see PurgeDeadLetters) instead of being lost. Once
+// @max are kept, the oldest one is dropped for a new one.
+// - A task fails for good when its action returned an error on its last run, after any retries (see
+// SetRetryPolicy). Its EventFailed event has ReasonDLQ, the DeadLetter keeps the reason of the run.
+// - A task failing again under the external id of a dead letter replaces it.
+// - Of a batch task that failed in part, only the failed items are kept, see PartialFailure.
+// - A @max <= 0 stops keeping failed tasks (the default), lowering @max drops the oldest dead letters over it.
+func (q *FixedSizeQueue) SetDeadLetters(max int) {
+	if max < 0 {
+		max = 0
+	}
+
+	q.changeConfig("", 0, false, "deadLetters", func() (string, string, error) {
+		oldValue := strconv.Itoa(q.maxDeadLetters)
+		q.maxDeadLetters = max
+		q.trimDeadLetters()
+		return oldValue, strconv.Itoa(q.maxDeadLetters), nil
+	})
+}
+
+
+// Returns the queue's dead letters, oldest first. Their params are copies with the sensitive params redacted,
+// RequeueDeadLetter still adds the tasks with their params as they were.
+func (q *FixedSizeQueue) DeadLetters() []DeadLetter {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	letters := make([]DeadLetter, 0, len(q.deadLetters))
+	for _, dead := range q.deadLetters {
+		letter := dead.letter
+		letter.Params = q.redact(letter.ActionName, letter.Params)
+		letters = append(letters, letter)
+	}
+
+	return letters
+}
+
+
+// - Adds the task of the dead letter with @externalId again, with its action, params, cost and priority, and
+// removes the dead letter once it was added. The task's attempts start over, it has no deadline.
+// - Returns ErrNoDeadLetter if there is no such dead letter, or the error of adding the task (e.g. if the queue
+// is full), then the dead letter is kept.
+func (q *FixedSizeQueue) RequeueDeadLetter(externalId string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	i := q.findDeadLetter(externalId)
+	if i < 0 {
+		return ErrNoDeadLetter
+	}
+
+	dead := q.deadLetters[i]
+	if !dead.letter.Requeueable {
+		return errors.New(fmt.Sprintf("Task %s read its payload and can't be requeued.", externalId))
+	}
+
+	err := q.enqueue(dead.action, dead.letter.Params, externalId, addOptions{
+		cost: dead.letter.Cost,
+		tenant: dead.letter.Tenant,
+		actionName: dead.letter.ActionName,
+		byName: dead.byName,
+		ctxAction: dead.ctxAction,
+		priority: dead.letter.Priority,
+	})
+	if err != nil {
+		q.stats.Rejected++
+		q.trace(externalId, "rejected", "%s", err)
+		return err
+	}
+
+	// tasks dispatched by the add finish in their own go routines, so the dead letters didn't change meanwhile
+	q.deadLetters = append(q.deadLetters[:i:i], q.deadLetters[i + 1:]...)
+	return nil
+}
+
+
+// Removes the dead letter with @externalId, returns false if there is none.
+func (q *FixedSizeQueue) RemoveDeadLetter(externalId string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	i := q.findDeadLetter(externalId)
+	if i < 0 {
+		return false
+	}
+
+	q.deadLetters = append(q.deadLetters[:i:i], q.deadLetters[i + 1:]...)
+	return true
+}
+
+
+// Removes every dead letter, returns how many were removed.
+func (q *FixedSizeQueue) PurgeDeadLetters() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	purged := len(q.deadLetters)
+	q.deadLetters = nil
+	return purged
+}
+
+
+// - Called by finish with the event of @task failing for good. Keeps the task as a dead letter if the queue
+// keeps them, returns false if it doesn't.
+func (q *FixedSizeQueue) deadLetter(task *task, event Event) bool {
+	if event.Type != EventFailed {
+		return false
+	}
+
+	return q.keepDeadLetter(task, task.externalId, task.params, event)
+}
+
+
+// Keeps @task as a dead letter under @externalId with @params, returns false if the queue doesn't keep dead letters.
+func (q *FixedSizeQueue) keepDeadLetter(task *task, externalId string, params map[string]interface{}, event Event) bool {
+	if q.maxDeadLetters <= 0 {
+		return false
+	}
+
+	if i := q.findDeadLetter(externalId); i >= 0 {
+		q.deadLetters = append(q.deadLetters[:i:i], q.deadLetters[i + 1:]...)
+	}
+
+	q.deadLetters = append(q.deadLetters, &deadLetter{
+		letter: DeadLetter{
+			ExternalId: externalId,
+			ActionName: task.actionName,
+			Tenant: task.tenant,
+			Cost: task.cost,
+			Priority: task.priority,
+			Params: copyParams(params),
+			Err: event.Err,
+			Reason: event.Reason,
+			Attempts: task.attempt,
+			EnqueuedAt: task.enqueuedAt,
+			FailedAt: event.At,
+			Requeueable: !task.oneShot,
+		},
+		action: task.action,
+		ctxAction: task.ctxAction,
+		byName: task.byName,
+	})
+
+	q.trimDeadLetters()
+	q.stats.DeadLettered++
+	q.trace(task.externalId, "dead lettered", "as %s, %d dead letters kept", externalId, len(q.deadLetters))
+	return true
+}
+
+
+// drops the oldest dead letters over the limit
+func (q *FixedSizeQueue) trimDeadLetters() {
+	over := len(q.deadLetters) - q.maxDeadLetters
+	if over > 0 {
+		q.deadLetters = append([]*deadLetter{}, q.deadLetters[over:]...)
+	}
+}
+
+
+// Returns the index of the dead letter with @externalId, -1 if there is none.
+func (q *FixedSizeQueue) findDeadLetter(externalId string) int {
+	for i, dead := range q.deadLetters {
+		if dead.letter.ExternalId == externalId {
+			return i
+		}
+	}
+
+	return -1
+}
@@ -0,0 +1,42 @@
+package fsq
+
+import "context"
+import "time"
+
+// An action that is handed a context, which carries the task's deadline (if it has one).
+type ContextAction func(ctx context.Context, params map[string]interface{}) error
+
+
+// - Adds a task that should be done by @deadline. The context passed to @action expires at the deadline,
+// so downstream calls made with it naturally respect the time the task has left.
+// - A task dispatched after its deadline still runs, with a context that has already expired.
+// - Same as AddContextAction with WithDeadline.
+func (q *FixedSizeQueue) AddWithDeadline(action ContextAction, params map[string]interface{}, id string, deadline time.Time) error {
+	return q.AddContextAction(action, params, id, WithDeadline(deadline))
+}
+
+
+// Returns the context the task's action runs with, called when the task is dispatched.
+func (q *FixedSizeQueue) executionContext(task *task) (context.Context, context.CancelFunc) {
+	ctx := q.baseContext()
+
+	if q.env != nil {
+		ctx = context.WithValue(ctx, envKey{}, q.env)
+	}
+
+	ctx = context.WithValue(ctx, resultKey{}, task.result)
+	ctx = q.startTaskSpans(ctx, task)
+	ctx, cancelTimeout := withTaskTimeout(ctx, task)
+
+	var cancel context.CancelFunc
+	if task.deadline.IsZero() {
+		ctx, cancel = context.WithCancel(ctx)
+	} else {
+		ctx, cancel = context.WithDeadline(ctx, task.deadline)
+	}
+
+	return ctx, func() {
+		cancel()
+		cancelTimeout()
+	}
+}
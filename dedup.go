@@ -0,0 +1,119 @@
+package fsq
+
+import "fmt"
+import "errors"
+import "hash/fnv"
+import "strconv"
+
+// Returns the dedup key of a task being added, see SetDedupKey. An empty key means the task is never a duplicate.
+type DedupKeyFunc func(externalId string, actionName string, params map[string]interface{}) string
+
+
+// - Sets what makes a task a duplicate of a waiting one. By default only a waiting task with the same
+// external id is, with @keyFunc a waiting task with the same key is too, e.g. one for the same customer
+// under a different id. Duplicates are rejected by Add.
+// - External ids stay unique among waiting tasks whatever the key func.
+// - Keys of the tasks already waiting are computed right away. A nil @keyFunc goes back to the default.
+func (q *FixedSizeQueue) SetDedupKey(keyFunc DedupKeyFunc) {
+	q.changeConfig("", 0, false, "dedupKey", func() (string, string, error) {
+		oldValue := dedupKeyName(q.dedupKey)
+		q.dedupKey = keyFunc
+		q.indexDedupKeys()
+		return oldValue, dedupKeyName(q.dedupKey), nil
+	})
+}
+
+
+// - Returns a key func under which tasks of the same action with the same values of the @params keys are
+// duplicates, whatever their external ids. Missing params count as nil.
+// - The values are hashed as they are formatted by fmt, so maps and structs compare by content.
+func DedupByParams(params ...string) DedupKeyFunc {
+	return func(externalId string, actionName string, taskParams map[string]interface{}) string {
+		hash := fnv.New64a()
+		fmt.Fprintf(hash, "%q", actionName)
+
+		for _, key := range params {
+			fmt.Fprintf(hash, "|%q=%#v", key, taskParams[key])
+		}
+
+		return strconv.FormatUint(hash.Sum64(), 16)
+	}
+}
+
+
+// names @keyFunc for the audit log
+func dedupKeyName(keyFunc DedupKeyFunc) string {
+	if keyFunc == nil {
+		return "externalId"
+	}
+
+	return "custom"
+}
+
+
+// Called by Add once the id was checked, returns the dedup key of the task or an error if it is a duplicate.
+func (q *FixedSizeQueue) admitDedupKey(id string, actionName string, params map[string]interface{}) (string, error) {
+	if q.dedupKey == nil {
+		return "", nil
+	}
+
+	key := q.dedupKey(id, actionName, params)
+	if key == "" {
+		return "", nil
+	}
+
+	duplicate, ok := q.waitingTasksByDedupKey[key]
+	if ok {
+		errMsg := fmt.Sprintf("Task is a duplicate of task %s, which is waiting to be processed.", duplicate.externalId)
+		return "", errors.New(errMsg)
+	}
+
+	return key, nil
+}
+
+
+// rebuilds the index of waiting tasks by dedup key, the first waiting task wins a key
+func (q *FixedSizeQueue) indexDedupKeys() {
+	q.waitingTasksByDedupKey = nil
+	for _, task := range q.waitingTasksByExternalId {
+		task.SetDedupKey("")
+	}
+
+	if q.dedupKey == nil {
+		return
+	}
+
+	q.waitingTasksByDedupKey = map[string]*task{}
+
+	waiting := append(q.waitingInOrder(), q.parkedTasks()...)
+
+	for _, task := range waiting {
+		key := q.dedupKey(task.externalId, task.actionName, task.params)
+		if _, ok := q.waitingTasksByDedupKey[key]; key == "" || ok {
+			continue
+		}
+
+		task.SetDedupKey(key)
+		q.waitingTasksByDedupKey[key] = task
+	}
+}
+
+
+// Marks @task as waiting: tasks with its id or dedup key are duplicates now.
+func (q *FixedSizeQueue) rememberWaiting(task *task) {
+	q.waitingTasksByExternalId[task.externalId] = task
+
+	if task.dedupKey != "" {
+		q.waitingTasksByDedupKey[task.dedupKey] = task
+	}
+}
+
+
+// Called once @task stopped waiting.
+func (q *FixedSizeQueue) forgetWaiting(task *task) {
+	delete(q.waitingTasksByExternalId, task.externalId)
+
+	if task.dedupKey != "" {
+		delete(q.waitingTasksByDedupKey, task.dedupKey)
+	}
+}
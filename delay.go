@@ -0,0 +1,129 @@
+package fsq
+
+import "context"
+import "sort"
+import "time"
+
+// delayed tasks are checked at least this often, so tasks added with AddAt follow changes of the wall clock
+const maxDelayCheckInterval = time.Minute
+
+
+// - Same as Add, but the task only becomes eligible to be dispatched once @delay passed. Until then it is
+// held outside the dispatch order, and tasks added after it may overtake it.
+// - A delayed task takes up its slot in the queue from the start, so capacity and dedup rules apply as
+// they do to any waiting task. Delayed tasks are never accepted into the overflow (see SetSoftCapacity).
+// - The delay is measured with the queue's clock, see SetClock. A @delay <= 0 is the same as Add.
+func (q *FixedSizeQueue) AddAfter(action func(params map[string]interface{}) error, params map[string]interface{}, id string, delay time.Duration) error {
+	return q.add(context.Background(), action, params, id, addOptions{delay: delay})
+}
+
+
+// - Same as AddAfter, but the task only becomes eligible to be dispatched once the wall clock reached @when,
+// e.g. to defer work to off peak hours. A @when that already passed is the same as Add.
+// - @when is compared with the wall clock readings of the queue's clock (see SetClock), not its monotonic
+// ones, so the task follows changes of the wall clock (e.g. by NTP), within a minute.
+func (q *FixedSizeQueue) AddAt(action func(params map[string]interface{}) error, params map[string]interface{}, id string, when time.Time) error {
+	// drops the monotonic reading, if any
+	return q.add(context.Background(), action, params, id, addOptions{notBefore: when.Round(0)})
+}
+
+
+// Returns true if the waiting tasks, the delayed ones and the parked ones take up every slot of the queue.
+func (q *FixedSizeQueue) isFull() bool {
+	return q.items.Len() + len(q.delayed) + q.countParked() >= q.capacity()
+}
+
+
+// Returns the max number of waiting tasks, not counting the overflow.
+func (q *FixedSizeQueue) capacity() int {
+	return q.levels[normalLevel].MaxSize
+}
+
+
+// Holds @task back until its notBefore time, called by Add once the task was set up.
+func (q *FixedSizeQueue) enqueueDelayed(task *task) {
+	i := sort.Search(len(q.delayed), func(i int) bool { return q.delayed[i].notBefore.After(task.notBefore) })
+	q.delayed = append(q.delayed, nil)
+	copy(q.delayed[i + 1:], q.delayed[i:])
+	q.delayed[i] = task
+
+	q.trace(task.externalId, "delayed", "until %s", task.notBefore.Format(time.RFC3339Nano))
+	q.armDelayTimer()
+}
+
+
+// Moves the delayed tasks whose time came into the queue, called before every dispatch.
+func (q *FixedSizeQueue) releaseDelayed() {
+	if len(q.delayed) == 0 {
+		return
+	}
+
+	now := q.now()
+	released := 0
+
+	for _, task := range q.delayed {
+		if task.notBefore.After(now) {
+			break
+		}
+
+		// the slot was kept for the task, unless the queue was resized meanwhile
+		if q.items.Len() >= q.capacity() {
+			q.overflow = append(q.overflow, task)
+			q.notePressure(task.externalId)
+		} else {
+			q.enqueueWaiting(task)
+		}
+
+		q.trace(task.externalId, "due", "held back for %s", now.Sub(task.enqueuedAt))
+		released++
+	}
+
+	if released == 0 {
+		return
+	}
+
+	q.delayed = q.delayed[released:]
+	q.epoch++
+	q.armDelayTimer()
+}
+
+
+// Takes @waitingTask out of the delayed tasks, returns false if it isn't there.
+func (q *FixedSizeQueue) removeDelayed(waitingTask *task) bool {
+	for i, task := range q.delayed {
+		if task == waitingTask {
+			q.delayed = append(q.delayed[:i:i], q.delayed[i + 1:]...)
+			q.armDelayTimer()
+			return true
+		}
+	}
+
+	return false
+}
+
+
+// Makes sure the queue wakes up when the next delayed task is due.
+func (q *FixedSizeQueue) armDelayTimer() {
+	if q.delayTimer != nil {
+		q.delayTimer.Stop()
+		q.delayTimer = nil
+	}
+
+	if len(q.delayed) == 0 {
+		return
+	}
+
+	wait := min(max(q.delayed[0].notBefore.Sub(q.now()), 0), maxDelayCheckInterval)
+	q.delayTimer = time.AfterFunc(wait, q.wakeDelayed)
+}
+
+
+// Runs in the delay timer's go routine, dispatches the delayed tasks that are due. Takes the lock.
+func (q *FixedSizeQueue) wakeDelayed() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	// the tasks may not be due yet if the wall clock was changed
+	q.armDelayTimer()
+	q.dispatchWaiting()
+}
@@ -0,0 +1,82 @@
+package fsq
+
+import "errors"
+import "strconv"
+
+// Returned by Add (and the other Add variants) while the queue is draining, see BeginDrain.
+var ErrDraining = errors.New("Queue is draining and doesn't take new tasks.")
+
+// Where a submitted task stands, see Position.
+type TaskPosition struct {
+	Processing bool  //the task is running
+	Parked bool  //the task waits for its action to be registered again or resume, see AddByName
+	Ahead int  //waiting tasks that are dispatched before it, 0 when processing or parked. Random order and dispatch strategies may still overtake it.
+}
+
+
+// - Puts the queue in draining mode: from now on every Add is rejected with ErrDraining, while the tasks
+// already submitted keep being dispatched and run to completion. Use Position to tell producers where
+// their submitted work stands.
+// - Draining a draining queue does nothing. EndDrain takes new tasks again.
+func (q *FixedSizeQueue) BeginDrain() {
+	q.changeConfig("", 0, false, "draining", func() (string, string, error) {
+		oldValue := strconv.FormatBool(q.draining)
+		q.draining = true
+		q.epoch++
+		return oldValue, "true", nil
+	})
+}
+
+
+// Ends draining mode, Add takes new tasks again.
+func (q *FixedSizeQueue) EndDrain() {
+	q.changeConfig("", 0, false, "draining", func() (string, string, error) {
+		oldValue := strconv.FormatBool(q.draining)
+		q.draining = false
+		q.epoch++
+		return oldValue, "false", nil
+	})
+}
+
+
+// Returns true if the queue is draining, see BeginDrain.
+func (q *FixedSizeQueue) IsDraining() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.draining
+}
+
+
+// Returns where the task added with @externalId stands, false if it isn't waiting or processing (e.g. it
+// finished). With several tasks for the id, the waiting one is reported.
+func (q *FixedSizeQueue) Position(externalId string) (TaskPosition, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if _, ok := q.waitingTasksByExternalId[externalId]; ok {
+		for ahead, task := range q.waitingInOrder() {
+			if task.externalId == externalId {
+				return TaskPosition{Ahead: ahead}, true
+			}
+		}
+
+		return TaskPosition{Parked: true}, true
+	}
+
+	if len(q.processingByExternalId[externalId]) > 0 {
+		return TaskPosition{Processing: true}, true
+	}
+
+	return TaskPosition{}, false
+}
+
+
+// Called by Add, returns ErrDraining if the queue is draining.
+func (q *FixedSizeQueue) admitDraining() error {
+	if q.draining {
+		return ErrDraining
+	}
+
+	return nil
+}
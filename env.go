@@ -0,0 +1,39 @@
+package fsq
+
+import "fmt"
+import "context"
+
+// the context key the queue's environment is stored under
+type envKey struct{}
+
+
+// - Sets the queue's environment: the dependencies its actions need, e.g. a struct holding DB pools and
+// HTTP clients. Context actions (see ContextAction) get it from their context with Env, so they don't have
+// to reach for globals.
+// - Set the environment before adding tasks that need it, tasks dispatched afterwards see the new environment.
+// - The audit log (see ConfigChanges) records the type of the environment, not its content.
+func (q *FixedSizeQueue) SetEnv(env interface{}) {
+	q.changeConfig("", 0, false, "env", func() (string, string, error) {
+		oldValue := envName(q.env)
+		q.env = env
+		return oldValue, envName(env), nil
+	})
+}
+
+
+// names @env for the audit log by its type, empty when there is none
+func envName(env interface{}) string {
+	if env == nil {
+		return ""
+	}
+
+	return fmt.Sprintf("%T", env)
+}
+
+
+// Returns the environment of the queue running the action @ctx was passed to, false if the queue has
+// none or it isn't a @T.
+func Env[T any](ctx context.Context) (T, bool) {
+	env, ok := ctx.Value(envKey{}).(T)
+	return env, ok
+}
@@ -0,0 +1,260 @@
+package fsq
+
+import "fmt"
+import "errors"
+import "context"
+import "sort"
+import "strings"
+import "time"
+
+// How a failed action's error should be treated, see ClassOf.
+type ErrorClass int
+
+const (
+	ClassUnclassified ErrorClass = iota  //a plain error, the queue treats it as it always has
+	ClassRetryable  //a transient failure, running the task again may succeed
+	ClassPermanent  //running the task again won't help
+	ClassRateLimited  //the downstream asked to be called less often, possibly with a retry-after
+	ClassCancelled  //the task was cancelled, by its context or by the action itself
+)
+
+// Runs whose action returned an error, by the error's class. See Stats.Errors.
+type ClassCounts struct {
+	Unclassified int
+	Retryable int
+	Permanent int
+	RateLimited int
+	Cancelled int
+}
+
+// an error wrapped by one of the classification helpers
+type classifiedError struct {
+	err error
+	class ErrorClass
+	retryAfter time.Duration
+}
+
+
+// Marks @err as a transient failure. Returns nil for a nil @err, like the other classification helpers.
+func Retryable(err error) error {
+	return classify(err, ClassRetryable, 0)
+}
+
+
+// Marks @err as a failure that running the task again won't fix.
+func Permanent(err error) error {
+	return classify(err, ClassPermanent, 0)
+}
+
+
+// Marks @err as the downstream rate limiting the action. @retryAfter is how long the downstream asked to
+// wait (e.g. from a Retry-After header), 0 if it didn't say.
+func RateLimited(err error, retryAfter time.Duration) error {
+	return classify(err, ClassRateLimited, retryAfter)
+}
+
+
+// Marks @err as the task being cancelled.
+func Cancelled(err error) error {
+	return classify(err, ClassCancelled, 0)
+}
+
+
+// - Returns the class of @err, as marked by Retryable, Permanent, RateLimited or Cancelled anywhere in its
+// chain (the outermost one wins).
+// - An unmarked PartialFailure takes the class of its items: ClassPermanent if none of them would succeed when
+// run again (every item is Permanent or Cancelled), ClassRetryable if one of them is Retryable or RateLimited.
+// - Unmarked context.Canceled and ErrQueueStopped errors (e.g. from an HTTP request made with the context of a
+// stopped queue's action) are ClassCancelled, other unmarked errors are ClassUnclassified.
+func ClassOf(err error) ErrorClass {
+	var classified *classifiedError
+	if errors.As(err, &classified) {
+		return classified.class
+	}
+
+	if failed, ok := FailedItems(err); ok && len(failed) > 0 {
+		return classOfItems(failed)
+	}
+
+	if errors.Is(err, context.Canceled) || errors.Is(err, ErrQueueStopped) {
+		return ClassCancelled
+	}
+
+	return ClassUnclassified
+}
+
+
+// Returns the retry-after of a RateLimited error, false if @err isn't rate limited or has no retry-after.
+func RetryAfter(err error) (time.Duration, bool) {
+	var classified *classifiedError
+	if !errors.As(err, &classified) || classified.class != ClassRateLimited || classified.retryAfter <= 0 {
+		return 0, false
+	}
+
+	return classified.retryAfter, true
+}
+
+
+func classify(err error, class ErrorClass, retryAfter time.Duration) error {
+	if err == nil {
+		return nil
+	}
+
+	return &classifiedError{err: err, class: class, retryAfter: retryAfter}
+}
+
+
+func (e *classifiedError) Error() string {
+	return e.err.Error()
+}
+
+
+func (e *classifiedError) Unwrap() error {
+	return e.err
+}
+
+
+func (c ErrorClass) String() string {
+	switch c {
+	case ClassRetryable:
+		return "retryable"
+	case ClassPermanent:
+		return "permanent"
+	case ClassRateLimited:
+		return "rate-limited"
+	case ClassCancelled:
+		return "cancelled"
+	}
+
+	return "unclassified"
+}
+
+
+// Counts a run that failed with an error of @class.
+func (c *ClassCounts) count(class ErrorClass) {
+	switch class {
+	case ClassRetryable:
+		c.Retryable++
+	case ClassPermanent:
+		c.Permanent++
+	case ClassRateLimited:
+		c.RateLimited++
+	case ClassCancelled:
+		c.Cancelled++
+	default:
+		c.Unclassified++
+	}
+}
+
+
+// Returns the count of @class.
+func (c ClassCounts) Of(class ErrorClass) int {
+	switch class {
+	case ClassRetryable:
+		return c.Retryable
+	case ClassPermanent:
+		return c.Permanent
+	case ClassRateLimited:
+		return c.RateLimited
+	case ClassCancelled:
+		return c.Cancelled
+	}
+
+	return c.Unclassified
+}
+
+
+// adds the counts of @other
+func (c *ClassCounts) add(other ClassCounts) {
+	c.Unclassified += other.Unclassified
+	c.Retryable += other.Retryable
+	c.Permanent += other.Permanent
+	c.RateLimited += other.RateLimited
+	c.Cancelled += other.Cancelled
+}
+
+
+// Error classes are written as their name in JSON.
+func (c ErrorClass) MarshalText() ([]byte, error) {
+	return []byte(c.String()), nil
+}
+
+
+// - Returned by an action that handles many items at once (e.g. a task whose params hold a batch) when only
+// some of the items failed, so that only those items have to be handled again.
+// - Failed holds the error of each failed item by the item's key (an id the action and its caller agree on).
+// The errors may be classified, e.g. a Permanent item error next to Retryable ones.
+// - When the task's items are in its BatchItemsParam, the items that didn't fail are taken out of the task's
+// params, so a retry (see SetRetryPolicy) only runs the failed items and a dead letter (see SetDeadLetters)
+// only holds them. Failed items that the retry policy won't run again are dead lettered on their own as
+// "<external id>/<item key>" while the others are retried.
+type PartialFailure struct {
+	Failed map[string]error
+}
+
+// The param holding the items of a batch task by item key, as a map[string]interface{}. See PartialFailure.
+const BatchItemsParam = "items"
+
+
+func (e *PartialFailure) Error() string {
+	keys := make([]string, 0, len(e.Failed))
+	for key := range e.Failed {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return fmt.Sprintf("%d items failed: %s", len(keys), strings.Join(keys, ", "))
+}
+
+
+// the class of a PartialFailure with the @failed items, see ClassOf
+func classOfItems(failed map[string]error) ErrorClass {
+	class := ClassPermanent
+
+	for _, err := range failed {
+		switch ClassOf(err) {
+		case ClassRetryable, ClassRateLimited:
+			return ClassRetryable
+		case ClassUnclassified:
+			class = ClassUnclassified
+		}
+	}
+
+	return class
+}
+
+
+// Returns a copy of @params whose batch items (see BatchItemsParam) are only the ones @keep returns true for.
+// Returns false if @params has no batch items.
+func withItems(params map[string]interface{}, keep func(key string) bool) (map[string]interface{}, bool) {
+	items, ok := params[BatchItemsParam].(map[string]interface{})
+	if !ok {
+		return params, false
+	}
+
+	kept := map[string]interface{}{}
+	for key, item := range items {
+		if keep(key) {
+			kept[key] = item
+		}
+	}
+
+	narrowed := make(map[string]interface{}, len(params))
+	for key, value := range params {
+		narrowed[key] = value
+	}
+	narrowed[BatchItemsParam] = kept
+
+	return narrowed, true
+}
+
+
+// Returns the errors of the failed items if @err is (or wraps) a PartialFailure, false otherwise.
+func FailedItems(err error) (map[string]error, bool) {
+	var partial *PartialFailure
+	if !errors.As(err, &partial) {
+		return nil, false
+	}
+
+	return partial.Failed, true
+}
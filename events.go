@@ -0,0 +1,271 @@
+package fsq
+
+import "slices"
+import "strings"
+import "time"
+
+// used when subscribing with a buffer size <= 0
+const defaultEventBuffer = 64
+
+// What happened to a task.
+type EventType int
+
+const (
+	EventCompleted EventType = iota  //the task's action returned nil
+	EventFailed  //the task's action returned an error
+	EventAbandoned  //the task's action ran past the abandon timeout, see SetAbandonTimeout
+	EventStarving  //the task has been waiting longer than the starvation threshold, see SetStarvationThreshold
+	EventPressure  //the queue's pressure level changed, see SetSoftCapacity. ExternalId is the task that caused it, if any.
+	EventRetrying  //the task's action returned an error and the task will run again, see SetRetryPolicy
+)
+
+// What a subscription does with an event when its buffer is full.
+type OverflowPolicy int
+
+const (
+	DropOldest OverflowPolicy = iota  //the oldest buffered event is dropped to make room
+	Disconnect  //the subscription is closed, its channel is closed after the buffered events
+)
+
+// Something that happened to a task on a queue.
+type Event struct {
+	Type EventType
+	Queue string  //the qualified name of the queue
+	ExternalId string
+	ActionName string
+	Tenant string
+	Err error  //the action's error for EventFailed and EventRetrying
+	Reason OutcomeReason  //how the task ended, for EventCompleted, EventFailed and EventAbandoned. How the run ended for EventRetrying.
+	Pressure PressureLevel  //the new pressure level, for EventPressure
+	Class ErrorClass  //the class of Err, see ClassOf
+	FailedItems map[string]error  //when Err is a PartialFailure, the errors of the items that failed
+	Attempt int  //the run of the task the event is about, 1 for the first. 0 for tasks that didn't run.
+	RetryAt time.Time  //when the task runs again, for EventRetrying
+	Duration time.Duration  //how long the action ran, for EventCompleted, EventFailed, EventRetrying and EventAbandoned. 0 for tasks that didn't run.
+	Result interface{}  //what the action reported with SetResult, for EventCompleted and EventFailed. nil when it reported nothing.
+	At time.Time
+}
+
+// - Decides which events a subscription receives, see FixedSizeQueue.SubscribeFiltered.
+// - Empty fields match every event. An event must match every non-empty field.
+type EventFilter struct {
+	Types []EventType
+	Classes []ErrorClass  //only matches failures of these classes
+	Reasons []OutcomeReason  //only matches task outcomes with these reasons
+	ExternalIdPrefix string
+	ActionNames []string
+	Tenants []string
+}
+
+// - A subscriber's stream of a queue's events, see FixedSizeQueue.Subscribe.
+// - Every subscription has its own bounded buffer, so a slow subscriber never holds up the queue or other subscribers.
+type Subscription struct {
+	q *FixedSizeQueue
+	ch chan Event
+	filter EventFilter
+	overflow OverflowPolicy
+	dropped int
+	closed bool
+}
+
+
+// - Subscribes to the queue's task events (completions, failures, abandoned and starving tasks).
+// - Up to @bufferSize events are buffered for the subscriber (64 if <= 0). When the buffer is full,
+// @overflow decides whether the oldest event is dropped or the subscription is disconnected.
+// - Close the subscription when done with it.
+func (q *FixedSizeQueue) Subscribe(bufferSize int, overflow OverflowPolicy) *Subscription {
+	return q.SubscribeFiltered(EventFilter{}, bufferSize, overflow)
+}
+
+
+// - Same as Subscribe, but only events matching @filter are delivered. Events are filtered before they are
+// buffered, so a subscriber that e.g. only wants one tenant's failures isn't flooded by a busy queue and
+// doesn't lose its events to the overflow policy.
+func (q *FixedSizeQueue) SubscribeFiltered(filter EventFilter, bufferSize int, overflow OverflowPolicy) *Subscription {
+	if bufferSize <= 0 {
+		bufferSize = defaultEventBuffer
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	sub := &Subscription{
+		q: q,
+		ch: make(chan Event, bufferSize),
+		filter: filter,
+		overflow: overflow,
+	}
+	q.subscribers = append(q.subscribers, sub)
+
+	return sub
+}
+
+
+// Returns the channel the subscription's events are delivered on. It is closed when the subscription is
+// closed or disconnected.
+func (s *Subscription) Events() <-chan Event {
+	return s.ch
+}
+
+
+// Returns the number of events dropped because the subscription's buffer was full.
+func (s *Subscription) Dropped() int {
+	s.q.mu.Lock()
+	defer s.q.mu.Unlock()
+
+	return s.dropped
+}
+
+
+// Stops the subscription and closes its channel. Closing a subscription more than once does nothing.
+func (s *Subscription) Close() {
+	s.q.mu.Lock()
+	defer s.q.mu.Unlock()
+
+	s.q.unsubscribe(s)
+}
+
+
+func (t EventType) String() string {
+	switch t {
+	case EventCompleted:
+		return "completed"
+	case EventFailed:
+		return "failed"
+	case EventAbandoned:
+		return "abandoned"
+	case EventStarving:
+		return "starving"
+	case EventPressure:
+		return "pressure"
+	case EventRetrying:
+		return "retrying"
+	}
+
+	return "unknown"
+}
+
+
+// Event types are written as their name in JSON.
+func (t EventType) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
+
+
+// returns the event for @task, completed or failed depending on @err
+func (q *FixedSizeQueue) taskEvent(task *task, err error) Event {
+	event := Event{
+		Type: EventCompleted,
+		Queue: q.QualifiedName(),
+		ExternalId: task.externalId,
+		ActionName: task.actionName,
+		Tenant: task.tenant,
+		Reason: reasonOf(err, task.startedAt, task.deadline),
+		Attempt: task.attempt,
+		At: q.now(),
+	}
+
+	if event.Reason == ReasonCancelled && stoppedWhileRunning(task) {
+		event.Reason = ReasonQueueShutdown
+	}
+
+	if err != nil {
+		event.Type = EventFailed
+		event.Err = err
+		event.Class = ClassOf(err)
+		event.FailedItems, _ = FailedItems(err)
+	}
+
+	return event
+}
+
+
+// delivers @event to every subscriber without blocking
+func (q *FixedSizeQueue) publish(event Event) {
+	for _, sub := range q.subscribers {
+		if !sub.filter.matches(event) {
+			continue
+		}
+
+		if !sub.send(event) {
+			q.unsubscribe(sub)
+		}
+	}
+}
+
+
+func (q *FixedSizeQueue) unsubscribe(sub *Subscription) {
+	if sub.closed {
+		return
+	}
+
+	sub.closed = true
+	close(sub.ch)
+
+	for i, s := range q.subscribers {
+		if s == sub {
+			q.subscribers = append(q.subscribers[:i:i], q.subscribers[i + 1:]...)
+			break
+		}
+	}
+}
+
+
+// Sends @event, applying the overflow policy if the buffer is full. Returns false if the subscription
+// has to be disconnected.
+func (s *Subscription) send(event Event) bool {
+	select {
+	case s.ch <- event:
+		return true
+	default:
+	}
+
+	if s.overflow == Disconnect {
+		return false
+	}
+
+	// the subscriber may take events while this runs, so neither step may block
+	select {
+	case <-s.ch:
+		s.dropped++
+	default:
+	}
+
+	select {
+	case s.ch <- event:
+	default:
+		s.dropped++
+	}
+
+	return true
+}
+
+
+
+func (f EventFilter) matches(event Event) bool {
+	if len(f.Types) > 0 && !slices.Contains(f.Types, event.Type) {
+		return false
+	}
+
+	if len(f.Classes) > 0 && (event.Type != EventFailed || !slices.Contains(f.Classes, event.Class)) {
+		return false
+	}
+
+	if len(f.Reasons) > 0 && (event.Type == EventStarving || event.Type == EventPressure || !slices.Contains(f.Reasons, event.Reason)) {
+		return false
+	}
+
+	if !strings.HasPrefix(event.ExternalId, f.ExternalIdPrefix) {
+		return false
+	}
+
+	if len(f.ActionNames) > 0 && !slices.Contains(f.ActionNames, event.ActionName) {
+		return false
+	}
+
+	if len(f.Tenants) > 0 && !slices.Contains(f.Tenants, event.Tenant) {
+		return false
+	}
+
+	return true
+}
\ No newline at end of file
@@ -0,0 +1,121 @@
+package fsq
+
+import "fmt"
+import "errors"
+import "bytes"
+import "context"
+import "os"
+import "os/exec"
+import "strings"
+import "time"
+
+// how much of a command's output is kept in its ExecResult or ExecError
+const maxExecOutput = 4096
+
+// how long a killed command's output is waited for, e.g. when a child process it started still holds it
+const execWaitDelay = time.Second
+
+// The result of an exec action whose command succeeded, see SetResult.
+type ExecResult struct {
+	Command string
+	Output string  //the end of the command's combined stdout and stderr
+}
+
+// Returned by an exec action (see RegisterExecAction) when its command fails.
+type ExecError struct {
+	Command string
+	ExitCode int  //-1 when the command couldn't be started or was killed by a signal
+	Output string  //the end of the command's combined stdout and stderr
+}
+
+
+// - Registers an action under @name that runs @command with @args, turning the queue into a bounded local
+// job runner without custom code.
+// - The task's params may hold "args" (a list of strings appended to @args) and "env" (a map of extra
+// environment variables for the command).
+// - The command runs with the context of the task's run, so it is killed when the task times out, passes its
+// deadline, is abandoned (see SetAbandonTimeout) or the queue is stopped.
+// - Once the command succeeded, its output is the task's result as an ExecResult (see SetResult). The task
+// fails with an ExecError when the command exits with a non-zero code.
+func (q *FixedSizeQueue) RegisterExecAction(name string, command string, args ...string) error {
+	return q.RegisterContextAction(name, func(ctx context.Context, params map[string]interface{}) error {
+		return runCommand(ctx, command, args, params)
+	})
+}
+
+
+func runCommand(ctx context.Context, command string, args []string, params map[string]interface{}) error {
+	extraArgs, err := stringList(params["args"])
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.CommandContext(ctx, command, append(append([]string{}, args...), extraArgs...)...)
+	cmd.WaitDelay = execWaitDelay
+	cmd.Env = os.Environ()
+
+	env, _ := params["env"].(map[string]interface{})
+	for key, value := range env {
+		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%v", key, value))
+	}
+
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	err = cmd.Run()
+	if err == nil {
+		SetResult(ctx, ExecResult{Command: command, Output: tail(output.String(), maxExecOutput)})
+		return nil
+	}
+
+	execErr := &ExecError{Command: command, ExitCode: -1, Output: tail(output.String(), maxExecOutput)}
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		execErr.ExitCode = exitErr.ExitCode()
+	} else if execErr.Output == "" {
+		execErr.Output = err.Error()
+	}
+
+	// a killed command failed because its task ran out of time or was stopped
+	if ctx.Err() != nil {
+		return errors.Join(ctx.Err(), execErr)
+	}
+
+	return execErr
+}
+
+
+func (e *ExecError) Error() string {
+	return fmt.Sprintf("Command %s exited with code %d: %s", e.Command, e.ExitCode, strings.TrimSpace(e.Output))
+}
+
+
+// converts a list param (e.g. []interface{} from JSON) to strings
+func stringList(value interface{}) ([]string, error) {
+	switch list := value.(type) {
+	case nil:
+		return nil, nil
+	case []string:
+		return list, nil
+	case []interface{}:
+		strs := make([]string, 0, len(list))
+		for _, item := range list {
+			strs = append(strs, fmt.Sprint(item))
+		}
+		return strs, nil
+	}
+
+	return nil, errors.New(fmt.Sprintf("Param args must be a list of strings, got %T.", value))
+}
+
+
+// returns the last @max bytes of @s
+func tail(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+
+	return s[len(s) - max:]
+}
@@ -0,0 +1,35 @@
+package fsq
+
+import "fmt"
+import "errors"
+import "expvar"
+import "sync"
+
+// prefixes the expvar names of queues, see PublishExpvar
+const expvarPrefix = "fsq."
+
+// expvar panics when a name is published twice, so checking and publishing happen under this lock
+var expvarMu sync.Mutex
+
+
+// - Publishes the queue's Stats under expvar as "fsq.<qualified name>", so its live counters (waiting,
+// processing, completed, failed, rejected, ...) show at /debug/vars without running Prometheus.
+// - The stats are read whenever the variable is read. expvar can't remove a variable, so a queue stays
+// published for the life of the process.
+// - Returns an error if a variable with the name is already published, e.g. by another queue with the same name.
+func (q *FixedSizeQueue) PublishExpvar() error {
+	name := expvarPrefix + q.QualifiedName()
+
+	expvarMu.Lock()
+	defer expvarMu.Unlock()
+
+	if expvar.Get(name) != nil {
+		return errors.New(fmt.Sprintf("Expvar %s is already published.", name))
+	}
+
+	expvar.Publish(name, expvar.Func(func() any {
+		return q.Stats()
+	}))
+
+	return nil
+}
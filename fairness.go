@@ -0,0 +1,179 @@
+package fsq
+
+import "sort"
+import "time"
+
+// how often a running queue with a starvation threshold checks its waiting tasks
+var starvationCheckInterval = time.Second
+
+// Fairness indicators of a dispatch order or strategy, see FixedSizeQueue.Fairness.
+type FairnessStats struct {
+	Strategy string  //the dispatch order (e.g. "FIFO"), "StrictFIFO", or the type of the dispatch strategy
+	Dispatched int  //tasks dispatched while the strategy was in use
+	MeanWait time.Duration  //mean time dispatched tasks waited, delays (see AddAfter) aside
+	MaxWait time.Duration  //longest time a dispatched task waited
+	TenantDispatches map[string]int  //dispatched tasks by tenant, "" for tasks added without one
+	TenantShares map[string]float64  //each tenant's share of the dispatched tasks, from 0 to 1
+	Starved int  //tasks that waited past the starvation threshold before they were dispatched
+}
+
+// the running totals behind FairnessStats
+type fairness struct {
+	dispatched int
+	totalWait time.Duration
+	maxWait time.Duration
+	tenants map[string]int
+	starved int
+}
+
+
+// - Sets the wait after which a waiting task counts as starving. While the queue is running, waiting
+// tasks are checked every second, and an EventStarving event is published once for every task that
+// waits past @threshold (see Subscribe).
+// - Tasks dispatched after starving are counted in their strategy's FairnessStats.
+// - A @threshold <= 0 turns the check off.
+func (q *FixedSizeQueue) SetStarvationThreshold(threshold time.Duration) {
+	q.changeConfig("", 0, false, "starvationThreshold", func() (string, string, error) {
+		oldValue := q.starvationThreshold.String()
+		q.stopStarvationCheck()
+		q.starvationThreshold = threshold
+
+		if q.isRunning {
+			q.startStarvationCheck()
+		}
+
+		return oldValue, threshold.String(), nil
+	})
+}
+
+
+// - Returns the fairness indicators of every dispatch order or strategy the queue used since it was
+// created, sorted by strategy, so custom strategies can be compared with the built-in ones in production.
+// - Tasks released from parking are counted for the strategy in use when they are dispatched.
+func (q *FixedSizeQueue) Fairness() []FairnessStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	stats := make([]FairnessStats, 0, len(q.fairness))
+
+	for name, f := range q.fairness {
+		s := FairnessStats{
+			Strategy: name,
+			Dispatched: f.dispatched,
+			MaxWait: f.maxWait,
+			TenantDispatches: map[string]int{},
+			TenantShares: map[string]float64{},
+			Starved: f.starved,
+		}
+
+		if f.dispatched > 0 {
+			s.MeanWait = f.totalWait / time.Duration(f.dispatched)
+		}
+
+		for tenant, count := range f.tenants {
+			s.TenantDispatches[tenant] = count
+			s.TenantShares[tenant] = float64(count) / float64(f.dispatched)
+		}
+
+		stats = append(stats, s)
+	}
+
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Strategy < stats[j].Strategy })
+	return stats
+}
+
+
+// names the dispatch order or strategy in use
+func (q *FixedSizeQueue) strategyInUse() string {
+	if q.strictFIFO {
+		return "StrictFIFO"
+	}
+
+	if q.strategy != nil {
+		return strategyName(q.strategy)
+	}
+
+	return q.dispatchOrder.String()
+}
+
+
+// Called when @task is dispatched, after its start time was set.
+func (q *FixedSizeQueue) noteDispatch(task *task) {
+	if q.fairness == nil {
+		q.fairness = map[string]*fairness{}
+	}
+
+	name := q.strategyInUse()
+	f, ok := q.fairness[name]
+	if !ok {
+		f = &fairness{tenants: map[string]int{}}
+		q.fairness[name] = f
+	}
+
+	wait := task.startedAt.Sub(task.eligibleAt())
+	if wait < 0 {
+		wait = 0
+	}
+
+	f.dispatched++
+	f.totalWait += wait
+	f.tenants[task.tenant]++
+
+	if wait > f.maxWait {
+		f.maxWait = wait
+	}
+
+	if q.starvationThreshold > 0 && wait > q.starvationThreshold {
+		f.starved++
+	}
+}
+
+
+func (q *FixedSizeQueue) startStarvationCheck() {
+	if q.starvationThreshold <= 0 || q.starvationStop != nil {
+		return
+	}
+
+	q.starvationStop = make(chan struct{})
+	go runTicker(starvationCheckInterval, q.starvationStop, q.checkStarvation)
+}
+
+
+func (q *FixedSizeQueue) stopStarvationCheck() {
+	if q.starvationStop == nil {
+		return
+	}
+
+	close(q.starvationStop)
+	q.starvationStop = nil
+}
+
+
+// Runs in the ticker's go routine, publishes an EventStarving event for the waiting tasks that started
+// starving since the last check.
+func (q *FixedSizeQueue) checkStarvation() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.starvationThreshold <= 0 {
+		return
+	}
+
+	waiting := append(q.waitingInOrder(), q.parkedTasks()...)
+
+	for _, task := range waiting {
+		if task.starving || q.since(task.eligibleAt()) <= q.starvationThreshold {
+			continue
+		}
+
+		task.starving = true
+		q.publish(Event{
+			Type: EventStarving,
+			Queue: q.QualifiedName(),
+			ExternalId: task.externalId,
+			ActionName: task.actionName,
+			Tenant: task.tenant,
+			At: q.now(),
+		})
+	}
+}
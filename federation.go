@@ -0,0 +1,165 @@
+package fsq
+
+import "fmt"
+import "errors"
+import "encoding/json"
+import "io"
+import "net/http"
+import "strings"
+import "sync"
+import "time"
+
+// used when a federation handler is created without an http.Client
+const defaultFederationTimeout = 10 * time.Second
+
+// An admin API (see AdminHandler) of another process that a FederationHandler aggregates.
+type AdminPeer struct {
+	Name string  //identifies the peer in the federation's paths, e.g. "billing-worker-1"
+	URL string  //base URL the peer's AdminHandler is served at, e.g. "http://10.0.0.5:8080/fsq"
+	Token string  //bearer token sent to the peer, its role on the peer limits what the federation can do there
+}
+
+// The queues of a single peer, as returned by the federation.
+type PeerQueues struct {
+	Peer string `json:"peer"`
+	Queues []QueueView `json:"queues"`
+	Error string `json:"error,omitempty"`  //set when the peer couldn't be reached, in which case Queues is empty
+}
+
+// An http.Handler presenting the admin APIs of many processes as a single pane:
+//
+//	GET /peers/queues                  viewer   the queues of every peer
+//	ANY /peers/{peer}/queues/...       varies   forwarded to the peer's admin API
+//
+// Forwarded requests need the same role from the caller as the peer's endpoint would (GET viewer,
+// POST operator, PUT and purge admin), and are made on the peer with the peer's Token. Config changes made
+// through the federation are audited on the peer under the principal of that token.
+// Peers are reached over the HTTP/JSON admin API, fsq has no gRPC endpoint to federate through.
+type FederationHandler struct {
+	peers map[string]AdminPeer
+	order []string
+	client *http.Client
+	admin *AdminHandler  //only used for its authorization
+	mux *http.ServeMux
+}
+
+
+// - Returns a federation handler for @peers.
+// - @authorize decides the principal and role of every request. If nil, every request is denied.
+// - @client is used to call the peers. If nil, a client with a 10 second timeout is used.
+func NewFederationHandler(authorize Authorizer, client *http.Client, peers ...AdminPeer) *FederationHandler {
+	if client == nil {
+		client = &http.Client{Timeout: defaultFederationTimeout}
+	}
+
+	h := &FederationHandler{
+		peers: map[string]AdminPeer{},
+		client: client,
+		admin: NewAdminHandler(authorize),
+		mux: http.NewServeMux(),
+	}
+
+	for _, peer := range peers {
+		h.peers[peer.Name] = peer
+		h.order = append(h.order, peer.Name)
+	}
+
+	h.mux.HandleFunc("GET /peers/queues", h.admin.requireRole(RoleViewer, h.listQueues))
+	h.mux.HandleFunc("GET /peers/{peer}/queues/", h.admin.requireRole(RoleViewer, h.forward))
+	h.mux.HandleFunc("POST /peers/{peer}/queues/", h.admin.requireRole(RoleOperator, h.forward))
+	h.mux.HandleFunc("POST /peers/{peer}/queues/{name}/purge", h.admin.requireRole(RoleAdmin, h.forward))
+	h.mux.HandleFunc("PUT /peers/{peer}/queues/", h.admin.requireRole(RoleAdmin, h.forward))
+
+	return h
+}
+
+
+func (h *FederationHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+
+// fetches the queues of every peer at once, in the order the peers were given
+func (h *FederationHandler) listQueues(w http.ResponseWriter, r *http.Request, principal string) {
+	results := make([]PeerQueues, len(h.order))
+
+	var wg sync.WaitGroup
+	for i, name := range h.order {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = h.peerQueues(r, h.peers[name])
+		}()
+	}
+	wg.Wait()
+
+	writeJSON(w, http.StatusOK, results)
+}
+
+
+func (h *FederationHandler) peerQueues(r *http.Request, peer AdminPeer) PeerQueues {
+	result := PeerQueues{Peer: peer.Name, Queues: []QueueView{}}
+
+	resp, err := h.call(r, peer, http.MethodGet, "/queues", nil)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		result.Error = fmt.Sprintf("Peer %s responded with status %d.", peer.Name, resp.StatusCode)
+		return result
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(&result.Queues)
+	if err != nil {
+		result.Error = fmt.Sprintf("Peer %s responded with invalid JSON: %s", peer.Name, err)
+	}
+
+	return result
+}
+
+
+// forwards the request to the peer's admin API, and copies its response back
+func (h *FederationHandler) forward(w http.ResponseWriter, r *http.Request, principal string) {
+	name := r.PathValue("peer")
+
+	peer, ok := h.peers[name]
+	if !ok {
+		writeJSON(w, http.StatusNotFound, errorResponse{Error: fmt.Sprintf("Peer %s does not exist.", name)})
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/peers/" + name)
+
+	resp, err := h.call(r, peer, r.Method, path, r.Body)
+	if err != nil {
+		writeJSON(w, http.StatusBadGateway, errorResponse{Error: err.Error()})
+		return
+	}
+	defer resp.Body.Close()
+
+	w.Header().Set("Content-Type", resp.Header.Get("Content-Type"))
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
+
+
+func (h *FederationHandler) call(r *http.Request, peer AdminPeer, method string, path string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(r.Context(), method, strings.TrimSuffix(peer.URL, "/") + path, body)
+	if err != nil {
+		return nil, err
+	}
+
+	if peer.Token != "" {
+		req.Header.Set("Authorization", "Bearer " + peer.Token)
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Peer %s can't be reached: %s", peer.Name, err))
+	}
+
+	return resp, nil
+}
@@ -0,0 +1,101 @@
+package fsq
+
+import "fmt"
+import "errors"
+import "sort"
+import "time"
+
+// A deep, point in time copy of a frozen queue's state for debugging, see Freeze.
+type FrozenState struct {
+	View QueueView
+	Params map[string]map[string]interface{}  //params of the waiting and parked tasks, by external id. Only maps are copied, sensitive params are redacted (see AddRedaction).
+	ConfigVersion uint64
+	ConfigChanges []ConfigChange
+	Schedules []ScheduleStatus
+	RegisteredActions []string  //sorted
+	RateLimitedUntil map[string]time.Time  //paused action names, see SetRateLimitPause
+	TaskCount int  //tasks created by the queue, waiting, processing or pooled
+	PooledTasks int  //tasks ready to be reused
+}
+
+
+// - Pauses the queue for inspection: Add rejects new tasks and waiting tasks aren't dispatched until
+// Thaw is called. Tasks that are already processing run to completion.
+// - Returns a copy of the queue's state taken in the same critical section that froze it, so it can be
+// inspected at leisure. Freezing a frozen queue returns a fresh copy.
+func (q *FixedSizeQueue) Freeze() FrozenState {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.frozen = true
+	q.epoch++
+
+	state := FrozenState{
+		View: q.buildView(),
+		Params: map[string]map[string]interface{}{},
+		ConfigVersion: q.configVersion,
+		ConfigChanges: append([]ConfigChange{}, q.configChanges...),
+		Schedules: []ScheduleStatus{},
+		RegisteredActions: []string{},
+		RateLimitedUntil: map[string]time.Time{},
+		TaskCount: q.taskCount,
+		PooledTasks: len(*q.readyTaskPool),
+	}
+
+	waiting := append(q.waitingInOrder(), q.parkedTasks()...)
+
+	for _, task := range waiting {
+		state.Params[task.externalId] = q.redact(task.actionName, task.params)
+	}
+
+	for _, job := range q.schedules {
+		state.Schedules = append(state.Schedules, job.status())
+	}
+	sort.Slice(state.Schedules, func(i, j int) bool { return state.Schedules[i].Name < state.Schedules[j].Name })
+
+	for name := range q.actions {
+		state.RegisteredActions = append(state.RegisteredActions, name)
+	}
+	sort.Strings(state.RegisteredActions)
+
+	for name, until := range q.rateLimitedUntil {
+		state.RateLimitedUntil[name] = until
+	}
+
+	return state
+}
+
+
+// Resumes a frozen queue, dispatching the tasks that waited while it was frozen. Thawing a queue that
+// isn't frozen does nothing.
+func (q *FixedSizeQueue) Thaw() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if !q.frozen {
+		return
+	}
+
+	q.frozen = false
+	q.epoch++
+	q.dispatchWaiting()
+}
+
+
+// Returns true if the queue is frozen, see Freeze.
+func (q *FixedSizeQueue) IsFrozen() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.frozen
+}
+
+
+// Called by Add, returns an error if the queue is frozen.
+func (q *FixedSizeQueue) admitFrozen() error {
+	if !q.frozen {
+		return nil
+	}
+
+	return errors.New(fmt.Sprintf("FixedSizeQueue %s is frozen. Try later.", q.QualifiedName()))
+}
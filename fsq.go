@@ -14,88 +14,346 @@
 // - To prevent duplication, new tasks cannot be added with the same id as tasks that are waiting in the queue.
 // However, there is no logic to prevent duplicating a task that has already been removed from the queue (processed).
 // 
-// IMPORTANT: Adding to the queue is a fire and forget operation. There is no feedback regarding if a 
-// task has been completed successfully or not.
+// IMPORTANT: Add is a fire and forget operation, there is no feedback regarding if a task has been
+// completed successfully or not. Use AddWithCallbacks or WithHandle to find out how a task ended.
+// 
+// - A FixedSizeQueue is safe for concurrent use, Add (and every other method) can be called from many
+// go routines at once. Actions are run without holding the queue's lock, so an action may add tasks to
+// the queue it runs on.
 
 
 package fsq
 
-import "fmt"
-import "errors"
+import "context"
+import "log/slog"
 import "strings"
+import "sync"
+import "sync/atomic"
+import "time"
+
+import "go.opentelemetry.io/otel/trace"
 
 type FixedSizeQueue struct {
+	mu sync.Mutex  //guards every field below, held by the exported methods. Unexported methods expect it to be held unless noted otherwise.
 	Name string
-	items *ringBuffer
+	namespace atomic.Pointer[string]  //optional, see SetNamespace. Written under the lock, read without it by QualifiedName.
+	items buffer[*task]  //the waiting tasks, dispatched through it by priority and in the dispatch order. See orderItems.
+	levels [priorityLevels]*ringBuffer[*task]  //what items holds, the waiting tasks of each priority level in the order they were added
+	seq uint64  //increased for every task that joins the waiting tasks, see relevel
 	tasksById map[int]*task
 	waitingTasksByExternalId map[string]*task
+	processingByExternalId map[string][]*task  //see CancelProcessing
+	waitingTasksByDedupKey map[string]*task  //nil without a dedup key func, see SetDedupKey
 	readyTaskPool *[]*task
 	countProcessing int
 	maxProcessing int
 	taskCount int
+	preallocated int  //number of tasks created up front by InitPreallocated
 	isRunning bool
+	halted bool  //no task is dispatched until the queue is started again, see StopNow
+	paused bool  //see Pause
+	batching bool  //tasks of an all or nothing batch are being added, nothing is dispatched. See AddAllOrNothing.
+	purging bool  //the waiting tasks are being withdrawn, nothing is dispatched. See PurgeWaiting.
+	probe *healthProbe
+	actionProbes map[string]*healthProbe  //by action name, see SetActionHealthProbe
+	maintenanceWindows []MaintenanceWindow
+	maintenanceStop chan struct{}  //closed to end the maintenance window check, nil when not checking
+	budgets map[string]*budget  //by tenant, "" is the queue wide budget
+	budgetTimer Timer  //dispatches tasks held back by a budget once it refills
+	budgetWakeAt time.Time  //when budgetTimer fires
+	guards map[string]ActionGuard  //by action name, "" is the default guard
+	actions map[string]ContextAction  //registered actions by name, see RegisterContextAction
+	executionModes map[string]ExecutionMode  //by action name, missing means InProcess
+	parked map[string][]*task  //waiting tasks whose registered action was removed, by action name
+	overBudget map[string][]*task  //waiting tasks held back by a spent budget, by tenant
+	unparked []*task  //parked tasks whose action was registered again (or budget refilled), dispatched before the ring buffer
+	epoch uint64  //increased on every change to the queue's tasks, see SnapshotView
+	configVersion uint64  //increased on every change to the runtime configuration, see ConfigVersion
+	configChanges []ConfigChange  //audit log of runtime configuration changes, oldest first
+	abandonTimeout time.Duration  //tasks processing longer than this are abandoned, disabled when <= 0
+	abandonGrace time.Duration  //how long an action is given to return once its context was cancelled, see SetAbandonGrace
+	onAbandoned func(abandoned AbandonedTask)
+	onError func(taskID string, err error)  //see OnError
+	abandonedTasks map[int]AbandonedTask  //abandoned tasks whose action is still running, by task id
+	dispatchWindow time.Duration  //completions are dispatched together after this window, see SetDispatchWindow
+	dispatchBatchPending bool  //a batch dispatch is scheduled for the end of the current window
+	procsMultiplier int  //when > 0, maxProcessing follows procsMultiplier * GOMAXPROCS
+	memory *memoryGuard
+	clock Clock  //see SetClock
+	rateLimitPause map[string]bool  //action names whose dispatching pauses when they are rate limited
+	rateLimitedUntil map[string]time.Time  //paused action names, by when they resume
+	subscribers []*Subscription
+	env interface{}  //dependencies handed to context actions, see SetEnv
+	tempDirs bool  //every task execution gets a scratch directory, see SetTempDirs
+	tempDirParent string  //where scratch directories are created, empty for os.TempDir
+	paramTemplates bool  //string params are expanded as templates at dispatch, see SetParamTemplates
+	templateEnv []string  //environment variables param templates can read as .Env, see SetTemplateEnv
+	schedules map[string]*scheduledJob  //recurring tasks by name, see AddSchedule
+	scheduleStore ScheduleStore  //keeps the last fire time of schedules across restarts, optional
+	scheduleLeaser ScheduleLeaser  //decides which instance fires a shared schedule's occurrences, optional
+	scheduleHolder string  //names this instance to the leaser
+	frozen bool  //intake and dispatch are paused for inspection, see Freeze
+	strictFIFO bool  //see SetStrictFIFO
+	dispatchOrder DispatchOrder  //see SetDispatchOrder
+	strategy DispatchStrategy  //see SetDispatchStrategy
+	picked int  //index of the waiting task picked to be dispatched next by the dispatch strategy
+	fairness map[string]*fairness  //by dispatch order or strategy, see Fairness
+	starvationThreshold time.Duration  //see SetStarvationThreshold
+	starvationStop chan struct{}  //closed to end the starvation check, nil when not checking
+	lastStarted chan struct{}  //closed once the last task dispatched in strict FIFO mode called its action
+	idleWaiters []chan struct{}  //closed once the queue has no waiting or processing tasks, see Registry.ShutdownAll
+	roomWaiters []chan struct{}  //closed once a slot may have freed up, see AddWait
+	triggers []Trigger  //in the order they were added, see AddTrigger
+	results *resultWriter  //feeds the result sink, nil without one. See SetResultSink.
+	maxTraced int  //see SetTracing, 0 when tracing is off
+	traces map[string]*taskTrace  //by external id
+	traceOrder []string  //traced external ids, oldest first
+	draining bool  //new tasks are rejected while the submitted ones finish, see BeginDrain
+	overflow []*task  //tasks accepted beyond the capacity, oldest first. See SetSoftCapacity.
+	overflowLimit int  //0 when the capacity is hard
+	overflowStats OverflowStats  //Peak, Accepted and Rejected
+	pressure PressureLevel
+	maxTaskAge time.Duration  //see SetMaxTaskAge, 0 when tasks may live forever
+	retryPolicy RetryPolicy  //see SetRetryPolicy
+	maxDeadLetters int  //see SetDeadLetters, 0 when failed tasks aren't kept
+	deadLetters []*deadLetter  //oldest first
+	redactions map[string][]string  //lower cased key patterns of sensitive params by action name, "" applies to every action. See AddRedaction.
+	createdAt time.Time
+	stats Stats  //counters of the current stats epoch, see ResetStats
+	history *History  //see SetHistory, nil when attempts aren't recorded
+	dedupKey DedupKeyFunc  //see SetDedupKey, nil when only external ids are compared
+	delayed []*task  //tasks held back until their notBefore time, soonest first. See AddAfter.
+	delayTimer *time.Timer  //wakes the queue when the first delayed task is due
+	warmups map[string]*warmup  //warm hooks by action name, see SetWarmup
+	baseCtx context.Context  //the contexts of actions and warm hooks derive from it, cancelled by Stop. Nil until the queue is first started.
+	cancelBase context.CancelCauseFunc
+	tracer trace.Tracer  //see SetTracerProvider, nil when tasks aren't traced
+	logger atomic.Pointer[slog.Logger]  //see SetLogger, nil logs nothing. Read without the lock.
 }
 
-var Queue *FixedSizeQueue
-
 
 // @size: the max size of the queue. Defaults to 1 if size of <= 0 is passed in
+// - See New for a constructor that validates its settings and takes options.
 func Init(size int, name string, maxProcessCount int) *FixedSizeQueue {
+	return newQueue(size, name, maxProcessCount, realClock{})
+}
+
+
+// the queue Init returns, with @clock set before anything is stamped with it
+func newQueue(size int, name string, maxProcessCount int, clock Clock) *FixedSizeQueue {
 	if size <= 0 {
 		size = 1
 	}
 
-	rbItems := make([]*task, size)
-	rb := &ringBuffer{
-		MaxSize: size,
-		items:   &rbItems,
-	}
-
 	queue := FixedSizeQueue{
 		Name: name,
-		items: rb,
+		levels: newLevels(size),
 		tasksById: map[int]*task{},
 		waitingTasksByExternalId: map[string]*task{},
 		readyTaskPool: &[]*task{},
 		maxProcessing: maxProcessCount,
+		clock: clock,
+		createdAt: clock.Now(),
 	}
+	queue.orderItems()
 
-	Queue = &queue
 	return &queue
 }
 
 
+// Starts the queue. Schedules that missed fire times while the queue wasn't running catch up as their
+// CatchUpPolicy asks for, and the warm hooks of registered actions are called (see SetWarmup).
 func(q *FixedSizeQueue) Start() {
+	q.mu.Lock()
 	q.isRunning = true
+	q.halted = false
+	q.startBaseContext()
+	q.startHealthProbe()
+	q.startMaintenanceCheck()
+	q.startMemoryCheck()
+	q.startStarvationCheck()
+	q.startWarmups()
+	missed := q.missedOccurrences(q.now())
+	q.startSchedules()
+	q.mu.Unlock()
+
+	// adding takes the lock
+	for _, o := range missed {
+		if q.claimOccurrence(o.job, o.at) {
+			q.addOccurrence(o.job, o.at)
+		}
+	}
 }
 
 
+// Stops the queue: Adds are rejected until it is started again, waiting tasks are still dispatched. The
+// contexts of context actions and warm hooks are cancelled, see AddContextAction.
 func(q *FixedSizeQueue) Stop() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.stopIntake()
+	q.stopBaseContext()
+}
+
+
+// Rejects Adds from now on and stops the queue's background checks and schedules, tasks keep being dispatched.
+func (q *FixedSizeQueue) stopIntake() {
 	q.isRunning = false
+	q.stopHealthProbe()
+	q.stopMaintenanceCheck()
+	q.stopMemoryCheck()
+	q.stopStarvationCheck()
+	q.stopSchedules()
+
+	// Adds waiting for room fail now
+	q.notifyRoom()
 }
 
 
 func(q *FixedSizeQueue) IsRunning() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
 	return q.isRunning
 }
 
 
-func(q *FixedSizeQueue) Add(action func(params map[string]interface{}) error, params map[string]interface{}, id string) error {
+// - Adds a task running @action with @params, under the external @id.
+// - @opts change how the task is handled, e.g. Add(action, params, id, WithPriority(PriorityHigh), WithRetries(3)).
+// The first invalid option is returned as an error and the task isn't added.
+func(q *FixedSizeQueue) Add(action func(params map[string]interface{}) error, params map[string]interface{}, id string, opts ...AddOption) error {
+	options, err := applyAddOptions(opts)
+	if err != nil {
+		return err
+	}
+
+	return q.add(context.Background(), action, params, id, options)
+}
+
+
+// settings for a single task, given by the different Add variants
+type addOptions struct {
+	cost int
+	tenant string
+	actionName string
+	byName bool  //the action is looked up in the registry by actionName
+	ctxAction ContextAction  //used instead of the plain action when set
+	deadline time.Time  //zero when the task has no deadline
+	enqueuedAt time.Time  //when the task was first added, zero for now. Set for moved tasks.
+	release func()  //called once the task is done with its payload, see AddReader
+	onSuccess func()  //see AddWithCallbacks
+	onFailure func(err error)
+	handle *TaskHandle  //see WithHandle
+	handleOut **TaskHandle  //where add puts the task's handle, see WithHandle
+	priority Priority  //see AddWithPriority
+	delay time.Duration  //added to the time of the Add to get notBefore, see AddAfter
+	notBefore time.Time  //zero when the task is eligible right away
+	timeout time.Duration  //see AddWithTimeout, 0 for none
+	maxAttempts int  //see WithRetries, 0 follows the retry policy
+	traceParent trace.SpanContext  //see SetTracerProvider
+	wait bool  //wait for room instead of failing, see AddWait
+}
+
+
+// - The capacity check and the enqueue happen under the same lock, so concurrent Adds can't both take the last slot.
+// - @ctx is the submission context, see AddContext.
+func (q *FixedSizeQueue) add(ctx context.Context, action func(params map[string]interface{}) error, params map[string]interface{}, id string, opts addOptions) error {
+	if opts.handleOut != nil {
+		*opts.handleOut = nil
+		opts.handle = &TaskHandle{ExternalId: id, done: make(chan struct{})}
+	}
+
+	err := ctx.Err()
+	if err != nil {
+		return err
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	// the lock may have taken a while
+	err = ctx.Err()
+	if err != nil {
+		return err
+	}
+
+	for opts.wait && err == nil && q.isRunning && !q.hasRoom() {
+		// waiting doesn't help an id that is already waiting
+		_, err = q.isValidId(id)
+		if err == nil {
+			err = q.waitForRoom(ctx)
+		}
+	}
+
+	if err == nil {
+		span := q.startEnqueueSpan(ctx, id)
+		if span != nil {
+			opts.traceParent = span.SpanContext()
+		}
+
+		err = q.enqueue(action, params, id, opts)
+		q.endEnqueueSpan(span, err)
+	}
+
+	if err != nil {
+		q.stats.Rejected++
+		q.trace(id, "rejected", "%s", err)
+	} else if opts.handleOut != nil {
+		*opts.handleOut = opts.handle
+	}
+
+	return err
+}
+
+
+// Same as add, with the lock held.
+func (q *FixedSizeQueue) enqueue(action func(params map[string]interface{}) error, params map[string]interface{}, id string, opts addOptions) error {
 	if !q.isRunning {
-		errMsg := fmt.Sprintf("FixedSizeQueue %s is not running. Try starting and then adding.", q.Name)
-		return errors.New(errMsg)
+		return q.addError(id, ErrNotRunning)
 	}
 
-	if q.items.IsFull {
-		errMsg := fmt.Sprintf("FixedSizeQueue %s has no capacity at this time. Try later.", q.Name)
-		return errors.New(errMsg)
+	err := q.admitFrozen()
+	if err != nil {
+		return err
 	}
 
-	_, err := q.isValidId(id)
+	err = q.admitDraining()
 	if err != nil {
 		return err
 	}
-	
+
+	if opts.delay > 0 {
+		opts.notBefore = q.now().Add(opts.delay)
+	}
+	delayed := opts.notBefore.After(q.now())
+
+	// with soft capacity, a full queue still takes tasks into its overflow, delayed tasks need a slot
+	overflow := !delayed && (q.isFull() || len(q.overflow) > 0)
+	if (delayed && q.isFull()) || (overflow && !q.admitOverflow()) {
+		return q.addError(id, ErrQueueFull)
+	}
+
+	_, err = q.isValidId(id)
+	if err != nil {
+		return err
+	}
+
+	dedupKey, err := q.admitDedupKey(id, opts.actionName, params)
+	if err != nil {
+		return err
+	}
+
+	err = q.admitMemory()
+	if err != nil {
+		return err
+	}
+
+	err = q.admitCost(opts.cost, opts.tenant)
+	if err != nil {
+		return err
+	}
+
 	var taskToUse *task
 
 	if len(*q.readyTaskPool) > 0 {
@@ -111,10 +369,46 @@ func(q *FixedSizeQueue) Add(action func(params map[string]interface{}) error, pa
 
 	taskToUse.SetStateWaiting()
 	taskToUse.SetAction(action)
+	taskToUse.SetContextAction(opts.ctxAction, opts.deadline)
+	taskToUse.SetRelease(opts.release)
+	taskToUse.SetCallbacks(opts.onSuccess, opts.onFailure)
+	taskToUse.SetHandle(opts.handle)
+	taskToUse.SetPriority(opts.priority)
+	taskToUse.SetNotBefore(opts.notBefore)
+	taskToUse.SetOneShot(opts.release != nil)
+	taskToUse.SetTimeout(opts.timeout)
+	taskToUse.SetMaxAttempts(opts.maxAttempts)
 	taskToUse.SetParams(params)
 	taskToUse.SetExternalId(id)
-	q.waitingTasksByExternalId[id] = taskToUse
-	q.items.Enqueue(taskToUse)
+	taskToUse.SetCost(opts.cost, opts.tenant)
+	taskToUse.SetActionName(opts.actionName)
+	taskToUse.SetByName(opts.byName)
+	taskToUse.SetDedupKey(dedupKey)
+	if opts.enqueuedAt.IsZero() {
+		opts.enqueuedAt = q.now()
+	}
+	taskToUse.SetEnqueuedAt(opts.enqueuedAt)
+	taskToUse.SetWaitingSince(opts.enqueuedAt)
+	taskToUse.SetTraceParent(opts.traceParent)
+
+	if delayed {
+		q.enqueueDelayed(taskToUse)
+	} else if overflow {
+		q.enqueueOverflow(taskToUse)
+	} else {
+		err = q.enqueueWaiting(taskToUse)
+		if err != nil {
+			// Don't expect this to happen since IsFull was checked, adding for safety.
+			taskToUse.Clean()
+			*q.readyTaskPool = append(*q.readyTaskPool, taskToUse)
+			return err
+		}
+	}
+
+	q.rememberWaiting(taskToUse)
+	q.stats.Enqueued++
+	q.epoch++
+	q.trace(id, "admitted", "%d waiting ahead", q.countWaiting() - 1)
 	q.processTask()
 	return nil
 }
@@ -124,52 +418,250 @@ func (q *FixedSizeQueue) isValidId(id string) (bool, error) {
 	// check if empty string
 	trimmed := strings.TrimSpace(id)
 	if len(trimmed) == 0 {
-		return false, errors.New("Id for task is not valid, only uses space characters.")
+		return false, q.addError(id, ErrInvalidID)
 	}
 
 	// check if tasks with id already exists in waiting tasks
 	_, ok := q.waitingTasksByExternalId[id]
 
 	if ok {
-		return false, errors.New("Id for task is already waiting to be processed.")
+		return false, q.addError(id, ErrDuplicateID)
 	}
 
 	return true, nil
 }
 
 
-func (q *FixedSizeQueue) processTask() {
-	if q.countProcessing >= q.maxProcessing {
-		return
+// Dispatches the next waiting task if there is a free process and nothing is holding dispatch back.
+// Returns true if a task was dispatched.
+func (q *FixedSizeQueue) processTask() bool {
+	if q.halted || q.batching || q.purging {
+		return false
 	}
 
-	task := q.items.Dequeue()
+	q.releaseDelayed()
+	q.releaseOverBudget()
+
+	if q.countProcessing >= q.effectiveMaxProcessing() {
+		return false
+	}
+
+	if q.frozen || q.paused || !q.isHealthy() || q.underMemoryPressure() {
+		return false
+	}
+
+	task := q.nextWaiting()
+
+	// tasks whose registered action was removed (or is paused) are parked until it is registered again (or resumes),
+	// tasks past the max task age are dropped instead of being run, tasks over budget are parked until it refills
+	for task != nil && ((!q.strictFIFO && q.parkIfHeld(task)) || q.dropIfTooOld(task) || (!q.strictFIFO && q.parkIfOverBudget(task))) {
+		task = q.nextWaiting()
+	}
 
 	if task == nil {
-		return
+		return false
+	}
+
+	// strict FIFO mode doesn't let other tasks overtake a held one
+	if q.strictFIFO && q.isHeld(task) {
+		q.trace(task.externalId, "held", "action %s is unregistered, rate limited or warming up", task.actionName)
+		return false
+	}
+
+	if !q.dispatchCost(task) {
+		q.trace(task.externalId, "held", "budget of tenant %q is spent", task.tenant)
+		return false
 	}
 
-	delete(q.waitingTasksByExternalId, task.externalId)
+	q.removeNextWaiting()
+	q.epoch++
+	q.trace(task.externalId, "dispatched", "waited %s, picked by %s over %d others", q.now().Sub(task.enqueuedAt), q.strategyInUse(), q.countWaiting())
+
+	q.forgetWaiting(task)
 	q.countProcessing++
 	task.SetStateProcessing()
+	task.SetStartedAt(q.now())
+	task.SetAttempt(task.attempt + 1)
+	task.SetRunParams(nil)
+	task.SetResult(&runResult{})
+	q.noteDispatch(task)
+	task.SetContext(q.executionContext(task))
+	q.logTask(slog.LevelDebug, "task started", task, nil, slog.Duration("waited", task.startedAt.Sub(task.enqueuedAt)))
+	q.rememberProcessing(task)
+	q.orderStart(task)
 	go q.actionWrapper(task)
+	return true
+}
+
+
+// Returns the task that is dispatched next without removing it. Tasks released from parking go first.
+func (q *FixedSizeQueue) nextWaiting() *task {
+	if len(q.unparked) > 0 {
+		return q.unparked[0]
+	}
+
+	if q.picksByStrategy() {
+		return q.pick()
+	}
+
+	return q.items.Peek()
+}
+
+
+// Removes the task nextWaiting returns, and tops the buffer up from the overflow.
+func (q *FixedSizeQueue) removeNextWaiting() *task {
+	task := q.takeNextWaiting()
+	q.refillFromOverflow()
+	q.notifyRoom()
+	return task
+}
+
+
+func (q *FixedSizeQueue) takeNextWaiting() *task {
+	if len(q.unparked) > 0 {
+		task := q.unparked[0]
+		q.unparked = q.unparked[1:]
+		return task
+	}
+
+	if q.picksByStrategy() && q.items.Len() > 0 {
+		return q.removePicked()
+	}
+
+	return q.items.Dequeue()
+}
+
+
+// Dispatches waiting tasks until the queue is empty or no more tasks can be dispatched.
+func (q *FixedSizeQueue) dispatchWaiting() {
+	for q.processTask() {
+	}
+}
+
+
+// Same as dispatchWaiting, but takes the lock. Used by timers and tickers, which run in their own go routine.
+func (q *FixedSizeQueue) wake() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.dispatchWaiting()
 }
 
 
+// Runs in the task's own go routine, takes the lock only once the action returned.
 func (q *FixedSizeQueue) actionWrapper(task *task) {
-	err := task.CallAction()
+	timer := q.startAbandonTimer(task)
+	err := recoverPanic(func() error {
+		if err := q.expandParams(task); err != nil {
+			return err
+		}
+
+		return q.callWithTempDir(task)
+	})
+	task.cancel()
+	task.releasePayload()
+
+	// hands the turn on if the action wasn't called, e.g. because a guard skipped it
+	task.takeTurn()
+
+	if timer != nil {
+		timer.Stop()
+	}
+
+	q.mu.Lock()
+	// the task is cleaned once it finished
+	externalId := task.externalId
+	onError := q.onError
+	triggered, done := q.finish(task, err)
+	q.mu.Unlock()
+
+	if err != nil && onError != nil {
+		onError(externalId, err)
+	}
+
+	// adding takes the target queues' locks, and a target may be this queue
+	q.fireTriggers(triggered)
+
+	if done != nil {
+		done()
+	}
+}
+
+
+// Called by actionWrapper with the lock held once the task's action returned @err. Returns the tasks its
+// triggers add to other queues, and the task's completion callback (see AddWithCallbacks).
+func (q *FixedSizeQueue) finish(task *task, err error) ([]triggered, func()) {
+	if !task.settle() {
+		// the task was abandoned while its action ran and its slot was already reclaimed
+		q.recycleAbandoned(task)
+		return nil, nil
+	}
+
+	q.forgetProcessing(task)
+	if err != nil {
+		q.stats.Errors.count(ClassOf(err))
+	}
+
+	q.noteRateLimit(task, err)
+	err = q.narrowPartialFailure(task, err)
+	if q.retry(task, err) {
+		return nil, nil
+	}
+
+	return q.end(task, err, true)
+}
 
+
+// - Ends @task with @err once it was settled, frees its processing slot and returns what finish returns.
+// - A task whose action hasn't @returned yet (see AddWithTimeout) is kept like an abandoned task until it does.
+func (q *FixedSizeQueue) end(task *task, err error, returned bool) ([]triggered, func()) {
 	if err != nil {
-		// TODO: log error
+		q.stats.Failed++
+		q.trace(task.externalId, "failed", "ran %s: %s", q.now().Sub(task.startedAt), err)
+	} else {
+		q.stats.Completed++
+		q.trace(task.externalId, "completed", "ran %s", q.now().Sub(task.startedAt))
+	}
+
+	event := q.taskEvent(task, err)
+	event.Duration = event.At.Sub(task.startedAt)
+	event.Result = task.result.get()
+	if err != nil {
+		q.logTask(slog.LevelError, "task failed", task, err, slog.Duration("duration", event.Duration))
+	} else {
+		q.logTask(slog.LevelInfo, "task completed", task, nil, slog.Duration("duration", event.Duration))
+	}
+
+	if q.deadLetter(task, event) {
+		event.Reason = ReasonDLQ
+	}
+
+	q.publish(event)
+	q.recordAttempt(task, event)
+	q.endTaskSpan(task, event)
+	fired := q.matchTriggers(event, task.params)
+	done := task.completion(err)
+	task.resolve(event)
+
+	if q.results != nil {
+		q.results.push(event)
+	}
+
+	if returned {
+		// sets state back to ready state and removes info from task
+		task.Clean()
+		*q.readyTaskPool = append(*q.readyTaskPool, task)
+	} else {
+		q.keepAbandoned(task, event.At, ReasonTimeout)
 	}
 
-	// sets state back to ready state and removes info from task
-	task.Clean()
-	*q.readyTaskPool = append(*q.readyTaskPool, task)
 	q.countProcessing--
+	q.epoch++
 
 	// when the task's action is done, attempt to process the next waiting task
-	q.processTask()
+	q.dispatchAfterCompletion()
+	q.notifyIdle()
+	return fired, done
 }
 
 
@@ -1,10 +1,31 @@
 package fsq
 
 import "testing"
+import "context"
 import "errors"
 import "fmt"
 import "time"
+import "sync"
+import "sync/atomic"
+import "runtime"
+import "os"
+import "strconv"
+import "slices"
+import "sort"
+import "strings"
+import "log/slog"
+import "encoding/json"
+import "expvar"
+import "net/http"
+import "net/http/httptest"
+import "io"
+import "reflect"
 import "github.com/stretchr/testify/assert"
+import "go.opentelemetry.io/otel/attribute"
+import "go.opentelemetry.io/otel/codes"
+import "go.opentelemetry.io/otel/trace"
+import sdktrace "go.opentelemetry.io/otel/sdk/trace"
+import "go.opentelemetry.io/otel/sdk/trace/tracetest"
 
 
 // ---------------------------------------------------------------------------
@@ -24,7 +45,7 @@ func TestInit_WithValidInput(t *testing.T) {
 	assert.NotNil(q, "Init should not return nil")
 
 	assert.Equal(name, q.Name, "Queue name should be set correctly")
-	assert.Equal(size, q.items.MaxSize, "Queue max size should be set correctly")
+	assert.Equal(size, q.capacity(), "Queue max size should be set correctly")
 	assert.Equal(5, q.maxProcessing, "maxProcessing should be parsed and set as int")
 
 	assert.Empty(q.tasksById, "tasksById should be initialized as empty")
@@ -174,6 +195,34 @@ func TestAdd_ReturnsErrorIfQueueIsFull(t *testing.T) {
 }
 
 
+func TestAdd_ErrorsCanBeToldApart(t *testing.T) {
+	assert := assert.New(t)
+	q := Init(1, "TestQueue", 1)
+	q.SetNamespace("billing")
+
+	err := q.Add(noop, map[string]interface{}{}, "id-1")
+	assert.ErrorIs(err, ErrNotRunning)
+
+	q.Pause()
+	q.Start()
+	defer q.Stop()
+
+	assert.NoError(q.Add(noop, map[string]interface{}{}, "id-1"))
+	assert.ErrorIs(q.Add(noop, map[string]interface{}{}, "id-2"), ErrQueueFull)
+
+	assert.NoError(q.Resize(2))
+	assert.ErrorIs(q.Add(noop, map[string]interface{}{}, " "), ErrInvalidID)
+	err = q.Add(noop, map[string]interface{}{}, "id-1")
+	assert.ErrorIs(err, ErrDuplicateID)
+	assert.NotErrorIs(err, ErrQueueFull)
+
+	var addErr *AddError
+	assert.True(errors.As(err, &addErr))
+	assert.Equal("billing:TestQueue", addErr.Queue)
+	assert.Equal("id-1", addErr.Id)
+}
+
+
 func TestAdd_ReturnsErrorIfIdIsInvalid(t *testing.T) {
 	assert := assert.New(t)
 	q := Init(10, "TestQueue", 1)
@@ -213,10 +262,10 @@ func TestAdd_ReusesTaskFromReadyPool(t *testing.T) {
 	q.Start()
 
 	// used to verify that the task's action function actually is called when adding a task to the queue
-	wasCalled := false
+	var wasCalled atomic.Bool
 	callCheckerParams := map[string]interface{}{"key": "val"}
 	callChecker := func(params map[string]interface{}) error {
-		wasCalled = true
+		wasCalled.Store(true)
 		return nil
 	}
 
@@ -229,9 +278,12 @@ func TestAdd_ReusesTaskFromReadyPool(t *testing.T) {
 	assert.NoError(err1)
 	assert.Len(q.tasksById, 1)
 
+	// the task is returned to the pool under the queue's lock
 	time.Sleep(100 * time.Millisecond)
+	q.mu.Lock()
 	assert.Len(*q.readyTaskPool, 1)
-	assert.True(wasCalled)
+	q.mu.Unlock()
+	assert.True(wasCalled.Load())
 	
 	// 2nd task is re-used from readyTaskPool and is immediately dequeued and processing takes 1 second
 	err2 := q.Add(sleeper, sleeperParams, "ext-2")
@@ -254,10 +306,10 @@ func TestAdd_ReusesTaskFromReadyPool(t *testing.T) {
 // TESTING RING BUFFER (ringBuffer.go)
 // ---------------------------------------------------------------------------
 // ---------------------------------------------------------------------------
-func createRingBuffer(size int) *ringBuffer {
+func createRingBuffer(size int) *ringBuffer[*task] {
 	items := make([]*task, size)
 
-	return &ringBuffer{
+	return &ringBuffer[*task]{
 		MaxSize: size,
 		items: &items,
 	}
@@ -327,6 +379,165 @@ func TestDequeue_WhenEmpty_ShouldReturnNil(t *testing.T) {
 }
 
 
+func TestPeek_ReturnsHeadWithoutRemoving(t *testing.T) {
+	assert := assert.New(t)
+	rb := createRingBuffer(2)
+
+	assert.Nil(rb.Peek())
+
+	t1 := &task{id: 1}
+	_ = rb.Enqueue(t1)
+	_ = rb.Enqueue(&task{id: 2})
+
+	assert.Equal(t1, rb.Peek())
+	assert.Equal(2, rb.CurrentSize)
+	assert.Equal(t1, rb.Dequeue())
+}
+
+
+func TestPeekTail_ReturnsNewestAcrossWrap(t *testing.T) {
+	assert := assert.New(t)
+	rb := createRingBuffer(2)
+
+	assert.Nil(rb.PeekTail())
+
+	t1, t2, t3 := &task{id: 1}, &task{id: 2}, &task{id: 3}
+	_ = rb.Enqueue(t1)
+	assert.Equal(t1, rb.PeekTail())
+
+	_ = rb.Enqueue(t2)
+	rb.Dequeue()
+	_ = rb.Enqueue(t3)
+
+	assert.Equal(t3, rb.PeekTail())
+	assert.Equal(t2, rb.Peek())
+	assert.Equal(2, rb.CurrentSize)
+}
+
+
+func TestRemove_KeepsOrderOfOtherItems(t *testing.T) {
+	assert := assert.New(t)
+
+	// removes each position of a wrapped buffer, covering both shift directions
+	for remove := 1; remove <= 4; remove++ {
+		rb := createRingBuffer(4)
+		_ = rb.Enqueue(&task{id: 0})
+		_ = rb.Enqueue(&task{id: 0})
+		rb.Dequeue()
+		rb.Dequeue()
+
+		for id := 1; id <= 4; id++ {
+			_ = rb.Enqueue(&task{id: id})
+		}
+
+		removed, ok := rb.Remove(func(t *task) bool {
+			return t.id == remove
+		})
+		assert.True(ok)
+		assert.Equal(remove, removed.id)
+		assert.False(rb.IsFull)
+
+		ids := []int{}
+		for _, t := range rb.Tasks() {
+			ids = append(ids, t.id)
+		}
+
+		expected := []int{}
+		for id := 1; id <= 4; id++ {
+			if id != remove {
+				expected = append(expected, id)
+			}
+		}
+		assert.Equal(expected, ids)
+
+		// the buffer keeps working after the removal
+		assert.NoError(rb.Enqueue(&task{id: 5}))
+		assert.Equal(5, rb.PeekTail().id)
+		assert.Equal(expected[0], rb.Dequeue().id)
+	}
+}
+
+
+func TestRemove_NoMatch(t *testing.T) {
+	assert := assert.New(t)
+	rb := createRingBuffer(2)
+	_ = rb.Enqueue(&task{id: 1})
+
+	_, ok := rb.Remove(func(t *task) bool {
+		return t.id == 2
+	})
+	assert.False(ok)
+	assert.Equal(1, rb.CurrentSize)
+}
+
+
+func TestSegments_SplitsAtTheEndOfTheArray(t *testing.T) {
+	assert := assert.New(t)
+	rb := createRingBuffer(3)
+
+	first, second := rb.Segments()
+	assert.Empty(first)
+	assert.Empty(second)
+
+	t1, t2, t3, t4 := &task{id: 1}, &task{id: 2}, &task{id: 3}, &task{id: 4}
+	_ = rb.Enqueue(t1)
+	_ = rb.Enqueue(t2)
+
+	first, second = rb.Segments()
+	assert.Equal([]*task{t1, t2}, first)
+	assert.Empty(second)
+
+	_ = rb.Enqueue(t3)
+	rb.Dequeue()
+	_ = rb.Enqueue(t4)
+
+	first, second = rb.Segments()
+	assert.Equal([]*task{t2, t3}, first)
+	assert.Equal([]*task{t4}, second)
+}
+
+
+func TestTasks_ReturnsItemsInOrderAcrossWrap(t *testing.T) {
+	assert := assert.New(t)
+	rb := createRingBuffer(3)
+
+	assert.Empty(rb.Tasks())
+
+	t1, t2, t3, t4 := &task{id: 1}, &task{id: 2}, &task{id: 3}, &task{id: 4}
+	_ = rb.Enqueue(t1)
+	_ = rb.Enqueue(t2)
+	_ = rb.Enqueue(t3)
+	rb.Dequeue()
+	_ = rb.Enqueue(t4)
+
+	assert.Equal([]*task{t2, t3, t4}, rb.Tasks())
+	assert.Equal(3, rb.CurrentSize, "Tasks should not remove items")
+}
+
+
+func TestDequeueTail_TakesNewestAcrossWrap(t *testing.T) {
+	assert := assert.New(t)
+	rb := createRingBuffer(3)
+
+	assert.Nil(rb.DequeueTail())
+
+	t1, t2, t3, t4 := &task{id: 1}, &task{id: 2}, &task{id: 3}, &task{id: 4}
+	_ = rb.Enqueue(t1)
+	_ = rb.Enqueue(t2)
+	_ = rb.Enqueue(t3)
+	rb.Dequeue()
+	_ = rb.Enqueue(t4)
+
+	assert.Equal(t4, rb.DequeueTail())
+	assert.Equal(t3, rb.DequeueTail())
+	assert.False(rb.IsFull)
+
+	// the freed slots are reused
+	_ = rb.Enqueue(t1)
+	assert.Equal([]*task{t2, t1}, rb.Tasks())
+}
+
+
 // ---------------------------------------------------------------------------
 // ---------------------------------------------------------------------------
 // TESTING TASK (tasks.go)
@@ -491,4 +702,8144 @@ func TestTask_CallAction_ErrorFromAction(t *testing.T) {
 	err := tk.CallAction()
 	assert.Error(err)
 	assert.EqualError(err, "fail inside action")
-}
\ No newline at end of file
+}
+
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+// TESTING HEALTH PROBE (healthProbe.go)
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+func TestHealthProbe_UnhealthyHoldsWaitingTasks(t *testing.T) {
+	assert := assert.New(t)
+	q := Init(5, "TestQueue", 2)
+
+	var healthy atomic.Bool
+	q.SetHealthProbe(healthy.Load, 10 * time.Millisecond)
+	q.Start()
+	defer q.Stop()
+
+	assert.False(q.IsHealthy())
+
+	var calls atomic.Int32
+	action := func(params map[string]interface{}) error {
+		calls.Add(1)
+		return nil
+	}
+
+	err := q.Add(action, map[string]interface{}{}, "id-1")
+	assert.NoError(err)
+	assert.Equal(1, q.items.Len(), "task should be held in the queue while unhealthy")
+	assert.Contains(q.waitingTasksByExternalId, "id-1")
+
+	// once the probe recovers, the held task is dispatched without another Add
+	healthy.Store(true)
+	assert.Eventually(func() bool {
+		return calls.Load() == 1
+	}, time.Second, 10 * time.Millisecond)
+	assert.True(q.IsHealthy())
+}
+
+
+func TestHealthProbe_ClearResumesDispatching(t *testing.T) {
+	assert := assert.New(t)
+	q := Init(5, "TestQueue", 2)
+
+	q.SetHealthProbe(func() bool { return false }, time.Hour)
+	q.Start()
+	defer q.Stop()
+
+	var calls atomic.Int32
+	action := func(params map[string]interface{}) error {
+		calls.Add(1)
+		return nil
+	}
+
+	assert.NoError(q.Add(action, map[string]interface{}{}, "id-1"))
+	assert.NoError(q.Add(action, map[string]interface{}{}, "id-2"))
+	assert.Equal(2, q.items.Len())
+
+	q.ClearHealthProbe()
+	assert.True(q.IsHealthy())
+	assert.Equal(0, q.items.Len(), "clearing the probe should dispatch waiting tasks")
+	assert.Eventually(func() bool {
+		return calls.Load() == 2
+	}, time.Second, 10 * time.Millisecond)
+}
+
+
+func TestHealthProbe_NotPolledWhileStopped(t *testing.T) {
+	assert := assert.New(t)
+	q := Init(5, "TestQueue", 2)
+
+	var checks atomic.Int32
+	q.SetHealthProbe(func() bool {
+		checks.Add(1)
+		return true
+	}, 5 * time.Millisecond)
+
+	// probe is checked once when set, but not polled until the queue starts
+	time.Sleep(30 * time.Millisecond)
+	assert.Equal(int32(1), checks.Load())
+	assert.Nil(q.probe.stop)
+
+	q.Start()
+	assert.NotNil(q.probe.stop)
+	q.Stop()
+	assert.Nil(q.probe.stop)
+}
+
+
+func TestActionHealthProbe_UnhealthyActionIsParkedWhileOthersRun(t *testing.T) {
+	assert := assert.New(t)
+	q := Init(5, "TestQueue", 2)
+
+	var calls sync.Map
+	count := func(name string) func(params map[string]interface{}) error {
+		return func(params map[string]interface{}) error {
+			calls.Store(name, true)
+			return nil
+		}
+	}
+	assert.NoError(q.RegisterAction("flaky", count("flaky")))
+	assert.NoError(q.RegisterAction("steady", count("steady")))
+
+	var healthy atomic.Bool
+	q.SetHealthProbe(func() bool { return true }, time.Hour)
+	q.SetActionHealthProbe("flaky", healthy.Load, 10 * time.Millisecond)
+	q.Start()
+	defer q.Stop()
+
+	assert.True(q.IsHealthy())
+	assert.False(q.IsActionHealthy("flaky"))
+	assert.True(q.IsActionHealthy("steady"))
+	assert.Equal([]string{"flaky"}, q.SnapshotView().UnhealthyActions)
+
+	assert.NoError(q.AddByName("flaky", map[string]interface{}{}, "id-1"))
+	assert.NoError(q.AddByName("steady", map[string]interface{}{}, "id-2"))
+
+	// the healthy action isn't held up by the unhealthy one
+	assert.Eventually(func() bool {
+		_, ok := calls.Load("steady")
+		return ok
+	}, time.Second, 10 * time.Millisecond)
+	assert.Equal([]string{"id-1"}, q.ParkedTasks("flaky"))
+	_, ok := calls.Load("flaky")
+	assert.False(ok)
+
+	// once its probe recovers, the parked task is dispatched without another Add
+	healthy.Store(true)
+	assert.Eventually(func() bool {
+		_, ok := calls.Load("flaky")
+		return ok
+	}, time.Second, 10 * time.Millisecond)
+	assert.Empty(q.ParkedTasks("flaky"))
+	assert.Empty(q.SnapshotView().UnhealthyActions)
+}
+
+
+func TestActionHealthProbe_ClearDispatchesParkedTasks(t *testing.T) {
+	assert := assert.New(t)
+	q := Init(5, "TestQueue", 2)
+
+	var calls atomic.Int32
+	assert.NoError(q.RegisterAction("flaky", func(params map[string]interface{}) error {
+		calls.Add(1)
+		return nil
+	}))
+
+	q.SetActionHealthProbe("flaky", func() bool { return false }, time.Hour)
+	q.Start()
+	defer q.Stop()
+
+	assert.NoError(q.AddByName("flaky", map[string]interface{}{}, "id-1"))
+	assert.Equal([]string{"id-1"}, q.ParkedTasks("flaky"))
+
+	q.ClearActionHealthProbe("flaky")
+	assert.True(q.IsActionHealthy("flaky"))
+	assert.Eventually(func() bool {
+		return calls.Load() == 1
+	}, time.Second, 10 * time.Millisecond)
+}
+
+
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+// TESTING MAINTENANCE WINDOWS (maintenance.go)
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+func TestMaintenanceWindow_IsActive(t *testing.T) {
+	assert := assert.New(t)
+
+	// every day from 23:00 until 01:00
+	w := MaintenanceWindow{
+		Start: 23 * time.Hour,
+		Duration: 2 * time.Hour,
+		Location: time.UTC,
+	}
+
+	assert.True(w.IsActive(time.Date(2024, 3, 4, 23, 30, 0, 0, time.UTC)))
+	assert.True(w.IsActive(time.Date(2024, 3, 5, 0, 59, 0, 0, time.UTC)), "window should extend past midnight")
+	assert.False(w.IsActive(time.Date(2024, 3, 5, 1, 0, 0, 0, time.UTC)))
+	assert.False(w.IsActive(time.Date(2024, 3, 5, 12, 0, 0, 0, time.UTC)))
+}
+
+
+func TestMaintenanceWindow_IsActiveOnlyOnDays(t *testing.T) {
+	assert := assert.New(t)
+
+	// sundays from 02:00 until 04:00
+	w := MaintenanceWindow{
+		Days: []time.Weekday{time.Sunday},
+		Start: 2 * time.Hour,
+		Duration: 2 * time.Hour,
+		Location: time.UTC,
+	}
+
+	sunday := time.Date(2024, 3, 3, 3, 0, 0, 0, time.UTC)
+	monday := time.Date(2024, 3, 4, 3, 0, 0, 0, time.UTC)
+
+	assert.True(w.IsActive(sunday))
+	assert.False(w.IsActive(monday))
+}
+
+
+func TestMaintenanceWindow_IsActiveKeepsLocalTimeAcrossDST(t *testing.T) {
+	assert := assert.New(t)
+	loc := loadLocation(t, "America/New_York")
+
+	// every day from 03:00 until 04:00, clocks go from 02:00 to 03:00 on 2024-03-10 and back on 2024-11-03
+	w := MaintenanceWindow{
+		Start: 3 * time.Hour,
+		Duration: time.Hour,
+		Location: loc,
+	}
+
+	assert.False(w.IsActive(time.Date(2024, 3, 10, 3, 0, 0, 0, loc).Add(-time.Minute)))
+	assert.True(w.IsActive(time.Date(2024, 3, 10, 3, 30, 0, 0, loc)))
+	assert.False(w.IsActive(time.Date(2024, 3, 10, 4, 30, 0, 0, loc)))
+
+	assert.False(w.IsActive(time.Date(2024, 11, 3, 2, 30, 0, 0, loc)))
+	assert.True(w.IsActive(time.Date(2024, 11, 3, 3, 30, 0, 0, loc)))
+	assert.False(w.IsActive(time.Date(2024, 11, 3, 4, 0, 0, 0, loc)))
+}
+
+
+func TestAddMaintenanceWindow_Validates(t *testing.T) {
+	assert := assert.New(t)
+	q := Init(5, "TestQueue", 2)
+
+	err := q.AddMaintenanceWindow(MaintenanceWindow{Start: time.Hour})
+	assert.EqualError(err, "Maintenance window duration must be greater than 0.")
+
+	err = q.AddMaintenanceWindow(MaintenanceWindow{Start: 25 * time.Hour, Duration: time.Hour})
+	assert.EqualError(err, "Maintenance window start 25h0m0s must be within a single day.")
+
+	err = q.AddMaintenanceWindow(MaintenanceWindow{Duration: time.Hour, MaxProcessing: -1})
+	assert.EqualError(err, "Maintenance window MaxProcessing can't be negative.")
+
+	assert.Empty(q.maintenanceWindows)
+}
+
+
+func TestMaintenanceWindow_ReducesConcurrency(t *testing.T) {
+	assert := assert.New(t)
+	q := Init(5, "TestQueue", 3)
+
+	// a window that covers the whole day, every day
+	err := q.AddMaintenanceWindow(MaintenanceWindow{Duration: oneDay, MaxProcessing: 1})
+	assert.NoError(err)
+	q.Start()
+	defer q.Stop()
+
+	assert.True(q.InMaintenance())
+	assert.Equal(1, q.effectiveMaxProcessing())
+
+	params := map[string]interface{}{"amt": 1}
+	assert.NoError(q.Add(sleeper, params, "id-1"))
+	assert.NoError(q.Add(sleeper, params, "id-2"))
+
+	assert.Equal(1, q.countProcessing)
+	assert.Equal(1, q.items.Len(), "2nd task should wait while the window allows one process")
+
+	// clearing the windows restores the full concurrency
+	q.ClearMaintenanceWindows()
+	assert.False(q.InMaintenance())
+	assert.Equal(3, q.effectiveMaxProcessing())
+	assert.Equal(0, q.items.Len())
+}
+
+
+func TestMaintenanceWindow_PausesDispatching(t *testing.T) {
+	assert := assert.New(t)
+	q := Init(5, "TestQueue", 3)
+
+	assert.NoError(q.AddMaintenanceWindow(MaintenanceWindow{Duration: oneDay}))
+	q.Start()
+	defer q.Stop()
+
+	assert.NoError(q.Add(sleeper, map[string]interface{}{"amt": 0}, "id-1"))
+	assert.Equal(0, q.countProcessing)
+	assert.Equal(1, q.items.Len())
+}
+
+
+
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+// TESTING BUDGETS (budget.go)
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+func noop(params map[string]interface{}) error {
+	return nil
+}
+
+
+func TestSetBudget_Validates(t *testing.T) {
+	assert := assert.New(t)
+	q := Init(5, "TestQueue", 2)
+
+	assert.EqualError(q.SetBudget("", 0, time.Minute, BudgetReject), "Budget limit must be greater than 0.")
+	assert.EqualError(q.SetBudget("", 10, 0, BudgetReject), "Budget interval must be greater than 0.")
+	assert.Empty(q.BudgetSpends())
+}
+
+
+func TestAddWithCost_RejectsWhenBudgetIsSpent(t *testing.T) {
+	assert := assert.New(t)
+	q := Init(10, "TestQueue", 5)
+	q.Start()
+
+	assert.NoError(q.SetBudget("tenant-a", 10, time.Hour, BudgetReject))
+
+	params := map[string]interface{}{}
+	assert.NoError(q.AddWithCost(noop, params, "id-1", 6, "tenant-a"))
+	assert.NoError(q.AddWithCost(noop, params, "id-2", 4, "tenant-a"))
+
+	err := q.AddWithCost(noop, params, "id-3", 1, "tenant-a")
+	assert.EqualError(err, `FixedSizeQueue TestQueue budget for tenant "tenant-a" is spent for this interval. Try later.`)
+
+	// other tenants (without a budget) are not affected
+	assert.NoError(q.AddWithCost(noop, params, "id-4", 100, "tenant-b"))
+
+	spend, ok := q.BudgetSpend("tenant-a")
+	assert.True(ok)
+	assert.Equal(10, spend.Spent)
+	assert.Equal(10, spend.TotalSpent)
+	assert.Equal(1, spend.Rejected)
+
+	_, ok = q.BudgetSpend("tenant-b")
+	assert.False(ok)
+}
+
+
+func TestAddWithCost_CostOverLimitIsRejected(t *testing.T) {
+	assert := assert.New(t)
+	q := Init(10, "TestQueue", 5)
+	q.Start()
+
+	assert.NoError(q.SetBudget("", 5, time.Hour, BudgetDefer))
+
+	err := q.AddWithCost(noop, map[string]interface{}{}, "id-1", 6, "")
+	assert.EqualError(err, "FixedSizeQueue TestQueue task cost 6 exceeds the budget limit 5.")
+
+	err = q.AddWithCost(noop, map[string]interface{}{}, "id-2", -1, "")
+	assert.EqualError(err, "Task cost can't be negative.")
+}
+
+
+func TestAddWithCost_DefersUntilBudgetRefills(t *testing.T) {
+	assert := assert.New(t)
+	q := Init(10, "TestQueue", 5)
+	q.Start()
+
+	interval := 100 * time.Millisecond
+	assert.NoError(q.SetBudget("", 5, interval, BudgetDefer))
+
+	var calls atomic.Int32
+	action := func(params map[string]interface{}) error {
+		calls.Add(1)
+		return nil
+	}
+
+	params := map[string]interface{}{}
+	assert.NoError(q.AddWithCost(action, params, "id-1", 5, ""))
+	assert.NoError(q.AddWithCost(action, params, "id-2", 5, ""))
+
+	// 2nd task is accepted, but parked until the next interval
+	assert.Equal(1, q.Stats().Waiting)
+	assert.Len(q.SnapshotView().Parked, 1)
+	spend, _ := q.BudgetSpend("")
+	assert.Equal(1, spend.Deferred)
+
+	assert.Eventually(func() bool {
+		return calls.Load() == 2
+	}, time.Second, 10 * time.Millisecond)
+
+	spend, _ = q.BudgetSpend("")
+	assert.Equal(10, spend.TotalSpent)
+}
+
+
+func TestAddWithCost_OverBudgetTenantDoesNotBlockOthers(t *testing.T) {
+	assert := assert.New(t)
+
+	q := Init(10, "TestQueue", 1)
+	assert.NoError(q.SetBudget("tenant-a", 1, time.Hour, BudgetDefer))
+	q.Start()
+	defer q.Stop()
+
+	ran := make(chan string, 10)
+	record := func(params map[string]interface{}) error {
+		ran <- params["id"].(string)
+		return nil
+	}
+
+	assert.NoError(q.AddWithCost(record, map[string]interface{}{"id": "a1"}, "a1", 1, "tenant-a"))
+	assert.Equal("a1", <-ran)
+
+	// tenant-a's budget is spent, its task is parked and the tasks behind it are dispatched
+	assert.NoError(q.AddWithCost(record, map[string]interface{}{"id": "a2"}, "a2", 1, "tenant-a"))
+	assert.NoError(q.AddWithCost(record, map[string]interface{}{"id": "b1"}, "b1", 1, "tenant-b"))
+	assert.NoError(q.AddWithCost(record, map[string]interface{}{"id": "b2"}, "b2", 1, "tenant-b"))
+	assert.Equal("b1", <-ran)
+	assert.Equal("b2", <-ran)
+
+	view := q.SnapshotView()
+	assert.Len(view.Parked, 1)
+	assert.Equal("a2", view.Parked[0].ExternalId)
+
+	stats := q.Stats()
+	assert.Equal(1, stats.Waiting)
+	assert.Len(stats.Budgets, 1)
+	assert.Equal("tenant-a", stats.Budgets[0].Tenant)
+	assert.Equal(1, stats.Budgets[0].Spent)
+	assert.Equal(1, stats.Budgets[0].Deferred)
+
+	// removing the budget releases the parked task
+	q.RemoveBudget("tenant-a")
+	assert.Equal("a2", <-ran)
+	assert.Empty(q.Stats().Budgets)
+}
+
+
+func TestRemoveBudget_ReleasesDeferredTasks(t *testing.T) {
+	assert := assert.New(t)
+	q := Init(10, "TestQueue", 5)
+	q.Start()
+
+	assert.NoError(q.SetBudget("", 1, time.Hour, BudgetDefer))
+
+	params := map[string]interface{}{}
+	assert.NoError(q.AddWithCost(noop, params, "id-1", 1, ""))
+	assert.NoError(q.AddWithCost(noop, params, "id-2", 1, ""))
+	assert.Equal(1, q.Stats().Waiting)
+
+	q.RemoveBudget("")
+	assert.Equal(0, q.Stats().Waiting)
+	assert.Empty(q.BudgetSpends())
+}
+
+
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+// TESTING GOMAXPROCS AWARE CONCURRENCY (procs.go)
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+func TestInitWithProcs_DerivesMaxProcessing(t *testing.T) {
+	assert := assert.New(t)
+	procs := runtime.GOMAXPROCS(0)
+
+	q := InitWithProcs(10, "TestQueue", 2)
+	assert.Equal(2 * procs, q.MaxProcessing())
+
+	q = InitWithProcs(10, "TestQueue", 0)
+	assert.Equal(procs, q.MaxProcessing(), "multiplier should default to 1")
+}
+
+
+func TestInitWithProcs_FollowsGOMAXPROCSChanges(t *testing.T) {
+	assert := assert.New(t)
+	previous := runtime.GOMAXPROCS(2)
+	defer runtime.GOMAXPROCS(previous)
+
+	q := InitWithProcs(10, "TestQueue", 3)
+	assert.Equal(6, q.MaxProcessing())
+
+	runtime.GOMAXPROCS(1)
+	assert.Equal(3, q.MaxProcessing())
+	assert.Equal(3, q.effectiveMaxProcessing())
+}
+
+
+func TestMaxProcessing_WithoutProcsMultiplier(t *testing.T) {
+	assert := assert.New(t)
+	q := Init(10, "TestQueue", 7)
+
+	assert.Equal(7, q.MaxProcessing())
+}
+
+
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+// TESTING MEMORY PRESSURE (memoryPressure.go)
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+func TestMemoryLimit_RejectsAddsUnderPressure(t *testing.T) {
+	assert := assert.New(t)
+	q := Init(5, "TestQueue", 2)
+
+	var used atomic.Uint64
+	used.Store(200)
+	q.SetMemoryLimit(100, used.Load, time.Hour)
+	q.Start()
+	defer q.Stop()
+
+	assert.True(q.UnderMemoryPressure())
+
+	err := q.Add(noop, map[string]interface{}{}, "id-1")
+	assert.EqualError(err, "FixedSizeQueue TestQueue is under memory pressure. Try later.")
+	assert.Equal(1, q.MemoryRejections())
+}
+
+
+func TestMemoryLimit_HoldsDispatchUntilPressureSubsides(t *testing.T) {
+	assert := assert.New(t)
+	q := Init(5, "TestQueue", 1)
+
+	var used atomic.Uint64
+	q.SetMemoryLimit(100, used.Load, 10 * time.Millisecond)
+	q.Start()
+	defer q.Stop()
+
+	block := make(chan struct{})
+	blocker := func(params map[string]interface{}) error {
+		<-block
+		return nil
+	}
+
+	var calls atomic.Int32
+	action := func(params map[string]interface{}) error {
+		calls.Add(1)
+		return nil
+	}
+
+	// the 1st task takes the only process, the 2nd waits behind it
+	assert.NoError(q.Add(blocker, map[string]interface{}{}, "id-1"))
+	assert.NoError(q.Add(action, map[string]interface{}{}, "id-2"))
+
+	used.Store(200)
+	assert.Eventually(q.UnderMemoryPressure, time.Second, 10 * time.Millisecond)
+
+	// the process frees up, but the 2nd task is held back while under pressure
+	close(block)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(int32(0), calls.Load())
+
+	used.Store(50)
+	assert.Eventually(func() bool {
+		return calls.Load() == 1
+	}, time.Second, 10 * time.Millisecond)
+	assert.False(q.UnderMemoryPressure())
+}
+
+
+func TestMemoryLimit_DefaultGaugeReadsHeap(t *testing.T) {
+	assert := assert.New(t)
+	q := Init(5, "TestQueue", 2)
+
+	q.SetMemoryLimit(1 << 62, nil, 0)
+	assert.False(q.UnderMemoryPressure())
+	assert.Equal(defaultMemoryCheckInterval, q.memory.interval)
+
+	q.ClearMemoryLimit()
+	assert.Nil(q.memory)
+}
+
+
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+// TESTING ACTION GUARDS (guards.go)
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+func TestSetActionGuard_WrapsNamedActions(t *testing.T) {
+	assert := assert.New(t)
+	q := Init(5, "TestQueue", 2)
+	q.Start()
+
+	var guarded, unguarded atomic.Int32
+	q.SetActionGuard("export", func(params map[string]interface{}, run func() error) error {
+		guarded.Add(1)
+		return run()
+	})
+
+	var calls atomic.Int32
+	action := func(params map[string]interface{}) error {
+		calls.Add(1)
+		return nil
+	}
+
+	assert.NoError(q.AddNamed("export", action, map[string]interface{}{}, "id-1"))
+	assert.NoError(q.AddNamed("import", action, map[string]interface{}{}, "id-2"))
+	assert.NoError(q.Add(action, map[string]interface{}{}, "id-3"))
+
+	assert.Eventually(func() bool {
+		return calls.Load() == 3
+	}, time.Second, 10 * time.Millisecond)
+	assert.Equal(int32(1), guarded.Load())
+
+	// the default guard covers the tasks without a guard of their own
+	q.SetActionGuard("", func(params map[string]interface{}, run func() error) error {
+		unguarded.Add(1)
+		return run()
+	})
+
+	assert.NoError(q.AddNamed("import", action, map[string]interface{}{}, "id-4"))
+	assert.Eventually(func() bool {
+		return unguarded.Load() == 1
+	}, time.Second, 10 * time.Millisecond)
+
+	q.SetActionGuard("", nil)
+	assert.NotContains(q.guards, "")
+}
+
+
+func TestCallGuarded_GuardCanSkipAction(t *testing.T) {
+	assert := assert.New(t)
+	q := Init(5, "TestQueue", 2)
+
+	called := false
+	q.SetActionGuard("", func(params map[string]interface{}, run func() error) error {
+		return errors.New("not now")
+	})
+
+	tk := &task{
+		params: map[string]interface{}{},
+		action: func(params map[string]interface{}) error {
+			called = true
+			return nil
+		},
+	}
+
+	assert.EqualError(q.callGuarded(tk), "not now")
+	assert.False(called)
+}
+
+
+func TestProcsShareGuard_LimitsConcurrentActions(t *testing.T) {
+	assert := assert.New(t)
+	previous := runtime.GOMAXPROCS(4)
+	defer runtime.GOMAXPROCS(previous)
+
+	guard := ProcsShareGuard(0.5)
+
+	var running, maxRunning atomic.Int32
+	run := func() error {
+		n := running.Add(1)
+		for {
+			m := maxRunning.Load()
+			if n <= m || maxRunning.CompareAndSwap(m, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		running.Add(-1)
+		return nil
+	}
+
+	done := make(chan struct{})
+	for i := 0; i < 6; i++ {
+		go func() {
+			guard(nil, run)
+			done <- struct{}{}
+		}()
+	}
+
+	for i := 0; i < 6; i++ {
+		<-done
+	}
+
+	assert.Equal(int32(2), maxRunning.Load())
+}
+
+
+func TestMemoryCeilingGuard_RejectsOverCeiling(t *testing.T) {
+	assert := assert.New(t)
+
+	estimate := func(params map[string]interface{}) uint64 {
+		size, _ := params["size"].(int)
+		return uint64(size)
+	}
+	guard := MemoryCeilingGuard(100, estimate)
+
+	// while a 60 byte action runs, a 50 byte action doesn't fit but a 40 byte one does
+	err := guard(map[string]interface{}{"size": 60}, func() error {
+		errInner := guard(map[string]interface{}{"size": 50}, func() error {
+			return nil
+		})
+		assert.EqualError(errInner, "Memory ceiling of 100 bytes reached, 60 reserved and task needs 50.")
+
+		return guard(map[string]interface{}{"size": 40}, func() error {
+			return nil
+		})
+	})
+	assert.NoError(err)
+
+	// once the action is done its reservation is released
+	err = guard(map[string]interface{}{"size": 100}, func() error {
+		return nil
+	})
+	assert.NoError(err)
+}
+
+
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+// TESTING ACTION REGISTRY (actions.go)
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+func TestRegisterAction_Validates(t *testing.T) {
+	assert := assert.New(t)
+	q := Init(5, "TestQueue", 2)
+
+	assert.EqualError(q.RegisterAction("  ", noop), "Action name is not valid, only uses space characters.")
+	assert.EqualError(q.RegisterAction("noop", nil), "Action noop can't be nil.")
+	assert.False(q.IsRegistered("noop"))
+
+	assert.NoError(q.RegisterAction("noop", noop))
+	assert.True(q.IsRegistered("noop"))
+}
+
+
+func TestAddByName_RunsRegisteredAction(t *testing.T) {
+	assert := assert.New(t)
+	q := Init(5, "TestQueue", 2)
+	q.Start()
+
+	var got atomic.Value
+	assert.NoError(q.RegisterAction("store", func(params map[string]interface{}) error {
+		got.Store(params["key"])
+		return nil
+	}))
+
+	err := q.AddByName("missing", map[string]interface{}{}, "id-1")
+	assert.EqualError(err, "Action missing is not registered.")
+
+	assert.NoError(q.AddByName("store", map[string]interface{}{"key": "val"}, "id-2"))
+	assert.Eventually(func() bool {
+		return got.Load() == "val"
+	}, time.Second, 10 * time.Millisecond)
+}
+
+
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+// TESTING SUBPROCESS ISOLATION (isolation.go)
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+const isolationQueueName = "isolation-test-queue"
+
+// the test binary is re-executed as the child process for isolated actions, so the isolated actions
+// have to be registered before any test runs
+func isolationQueue() *FixedSizeQueue {
+	q := Init(5, isolationQueueName, 2)
+
+	q.RegisterAction("check-param", func(params map[string]interface{}) error {
+		if params["n"] != float64(42) {
+			return errors.New(fmt.Sprintf("unexpected param %v", params["n"]))
+		}
+		return nil
+	})
+
+	q.RegisterAction("fail", func(params map[string]interface{}) error {
+		return errors.New("failed in child")
+	})
+
+	q.RegisterAction("crash", func(params map[string]interface{}) error {
+		panic("crashed in child")
+	})
+
+	q.RegisterAction("hang", func(params map[string]interface{}) error {
+		time.Sleep(time.Minute)
+		return nil
+	})
+
+	q.SetExecutionMode("check-param", Subprocess)
+	q.SetExecutionMode("fail", Subprocess)
+	q.SetExecutionMode("crash", Subprocess)
+	q.SetExecutionMode("hang", Subprocess)
+	return q
+}
+
+
+func TestMain(m *testing.M) {
+	ServeIsolated(isolationQueue())
+	os.Exit(m.Run())
+}
+
+
+func TestRunIsolated_PassesParamsAndResult(t *testing.T) {
+	assert := assert.New(t)
+	q := isolationQueue()
+
+	assert.NoError(q.runRegistered(context.Background(), "check-param", map[string]interface{}{"n": 42}))
+	assert.EqualError(q.runRegistered(context.Background(), "check-param", map[string]interface{}{"n": 1}), "unexpected param 1")
+	assert.EqualError(q.runRegistered(context.Background(), "fail", map[string]interface{}{}), "failed in child")
+}
+
+
+func TestRunIsolated_CrashDoesNotTakeDownHost(t *testing.T) {
+	assert := assert.New(t)
+	q := isolationQueue()
+
+	err := q.runRegistered(context.Background(), "crash", map[string]interface{}{})
+
+	assert.Error(err)
+	assert.Contains(err.Error(), "Isolated action crash did not complete")
+	assert.Contains(err.Error(), "crashed in child")
+}
+
+
+func TestRunIsolated_ContextKillsChild(t *testing.T) {
+	assert := assert.New(t)
+	q := isolationQueue()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100 * time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err := q.runRegistered(ctx, "hang", map[string]interface{}{})
+
+	assert.ErrorIs(err, context.DeadlineExceeded)
+	assert.Less(time.Since(start), 10 * time.Second, "the child should be killed once the context is done")
+}
+
+
+func TestRunIsolated_ParamsMustSerialize(t *testing.T) {
+	assert := assert.New(t)
+	q := isolationQueue()
+
+	err := q.runRegistered(context.Background(), "fail", map[string]interface{}{"ch": make(chan int)})
+
+	assert.Error(err)
+	assert.Contains(err.Error(), "Params for isolated action fail can't be serialized")
+}
+
+
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+// TESTING PLUGIN LOADING (plugin.go)
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+func TestLoadPlugin_MissingFile(t *testing.T) {
+	assert := assert.New(t)
+	q := Init(5, "TestQueue", 2)
+
+	err := q.LoadPlugin("does-not-exist.so")
+
+	assert.Error(err)
+	assert.Contains(err.Error(), "Plugin does-not-exist.so can't be opened")
+	assert.Empty(q.actions)
+}
+
+
+func TestUnregisterAction_ParksWaitingTasksUntilReregistered(t *testing.T) {
+	assert := assert.New(t)
+	q := Init(5, "TestQueue", 1)
+	q.Start()
+
+	var calls atomic.Int32
+	work := func(params map[string]interface{}) error {
+		calls.Add(1)
+		return nil
+	}
+	assert.NoError(q.RegisterAction("work", work))
+
+	// the blocker takes the only process, so the named task waits behind it
+	block := make(chan struct{})
+	blocker := func(params map[string]interface{}) error {
+		<-block
+		return nil
+	}
+	assert.NoError(q.Add(blocker, map[string]interface{}{}, "blocker"))
+	assert.NoError(q.AddByName("work", map[string]interface{}{}, "id-1"))
+
+	assert.True(q.UnregisterAction("work"))
+	assert.False(q.UnregisterAction("work"))
+
+	close(block)
+	assert.Eventually(func() bool {
+		return len(q.ParkedTasks("work")) == 1
+	}, time.Second, 10 * time.Millisecond)
+	assert.Equal([]string{"id-1"}, q.ParkedTasks("work"))
+	assert.Equal(0, q.items.Len())
+
+	// a parked task is still waiting, so its id can't be added again
+	err := q.Add(work, map[string]interface{}{}, "id-1")
+	assert.EqualError(err, "Id for task is already waiting to be processed.")
+
+	assert.NoError(q.RegisterAction("work", work))
+	assert.Empty(q.ParkedTasks("work"))
+	assert.Eventually(func() bool {
+		return calls.Load() == 1
+	}, time.Second, 10 * time.Millisecond)
+}
+
+
+
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+// TESTING SNAPSHOT VIEW (view.go)
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+func TestSnapshotView_ShowsWaitingAndProcessing(t *testing.T) {
+	assert := assert.New(t)
+	q := Init(5, "TestQueue", 1)
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	clock := NewFakeClock(start)
+	q.SetClock(clock)
+	q.Start()
+
+	block := make(chan struct{})
+	defer close(block)
+	blocker := func(params map[string]interface{}) error {
+		<-block
+		return nil
+	}
+
+	assert.NoError(q.AddNamed("block", blocker, map[string]interface{}{}, "id-1"))
+	assert.NoError(q.AddWithCost(noop, map[string]interface{}{}, "id-2", 3, "tenant-a"))
+	assert.NoError(q.Add(noop, map[string]interface{}{}, "id-3"))
+
+	clock.Advance(time.Minute)
+	view := q.SnapshotView()
+
+	assert.Equal("TestQueue", view.Name)
+	assert.True(view.Running)
+	assert.True(view.Healthy)
+	assert.Equal(5, view.Capacity)
+	assert.Equal(1, view.MaxProcessing)
+	assert.Equal(1, view.EffectiveMaxProcessing)
+	assert.Equal([]TaskView{{ExternalId: "id-1", ActionName: "block", EnqueuedAt: start, Attempts: 1}}, view.Processing)
+	assert.Equal([]TaskView{
+		{ExternalId: "id-2", Tenant: "tenant-a", Cost: 3, EnqueuedAt: start, WaitTime: time.Minute},
+		{ExternalId: "id-3", EnqueuedAt: start, WaitTime: time.Minute},
+	}, view.Waiting)
+	assert.Empty(view.Parked)
+	assert.Empty(view.Budgets)
+}
+
+
+func TestSnapshotView_EpochTracksChanges(t *testing.T) {
+	assert := assert.New(t)
+	q := Init(5, "TestQueue", 0)
+	q.Start()
+
+	first := q.SnapshotView()
+	second := q.SnapshotView()
+	assert.Equal(first.Epoch, second.Epoch)
+
+	assert.NoError(q.Add(noop, map[string]interface{}{}, "id-1"))
+
+	third := q.SnapshotView()
+	assert.Greater(third.Epoch, second.Epoch)
+	assert.Len(third.Waiting, 1)
+}
+
+
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+// TESTING RUNTIME CONFIGURATION (config.go)
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+func TestSetMaxProcessing_DispatchesWaitingTasks(t *testing.T) {
+	assert := assert.New(t)
+	q := Init(5, "TestQueue", 0)
+	q.Start()
+
+	assert.NoError(q.Add(sleeper, map[string]interface{}{"amt": 0}, "id-1"))
+	assert.Equal(1, q.items.Len())
+
+	version := q.ConfigVersion()
+	assert.NoError(q.SetMaxProcessing(2))
+	assert.Equal(0, q.items.Len())
+	assert.Equal(2, q.MaxProcessing())
+	assert.Equal(version + 1, q.ConfigVersion())
+
+	assert.EqualError(q.SetMaxProcessing(-1), "Max processing can't be negative.")
+	assert.Equal(version + 1, q.ConfigVersion(), "a failed change should not increase the version")
+}
+
+
+func TestSetMaxProcessing_StopsFollowingProcs(t *testing.T) {
+	assert := assert.New(t)
+	q := InitWithProcs(5, "TestQueue", 2)
+
+	assert.NoError(q.SetMaxProcessing(3))
+	assert.Equal(3, q.MaxProcessing())
+}
+
+
+func TestCompareAndSetMaxProcessing_DetectsConflicts(t *testing.T) {
+	assert := assert.New(t)
+	q := Init(5, "TestQueue", 1)
+
+	// two operators read the same version
+	version := q.ConfigVersion()
+
+	newVersion, err := q.CompareAndSetMaxProcessing(version, 4)
+	assert.NoError(err)
+	assert.Equal(version + 1, newVersion)
+
+	_, err = q.CompareAndSetMaxProcessing(version, 8)
+	assert.ErrorIs(err, ErrConfigConflict)
+	assert.Equal(4, q.MaxProcessing(), "the conflicting change should not be applied")
+
+	_, err = q.CompareAndResize(version, 10)
+	assert.ErrorIs(err, ErrConfigConflict)
+
+	_, err = q.CompareAndSetBudget(version, "", 10, time.Minute, BudgetReject)
+	assert.ErrorIs(err, ErrConfigConflict)
+
+	newVersion, err = q.CompareAndResize(newVersion, 10)
+	assert.NoError(err)
+	assert.Equal(10, q.capacity())
+
+	_, err = q.CompareAndSetBudget(newVersion, "", 10, time.Minute, BudgetReject)
+	assert.NoError(err)
+}
+
+
+func TestResize_KeepsWaitingTasksInOrder(t *testing.T) {
+	assert := assert.New(t)
+	q := Init(3, "TestQueue", 0)
+	q.Start()
+
+	assert.NoError(q.Add(noop, map[string]interface{}{}, "id-1"))
+	assert.NoError(q.Add(noop, map[string]interface{}{}, "id-2"))
+
+	assert.EqualError(q.Resize(1), "FixedSizeQueue TestQueue can't shrink to 1, 2 tasks are waiting.")
+	assert.EqualError(q.Resize(0), "Queue size must be greater than 0.")
+
+	assert.NoError(q.Resize(2))
+	assert.True(q.isFull())
+	assert.Error(q.Add(noop, map[string]interface{}{}, "id-3"))
+
+	assert.NoError(q.Resize(5))
+	assert.NoError(q.Add(noop, map[string]interface{}{}, "id-3"))
+
+	ids := []string{}
+	for _, task := range q.items.Tasks() {
+		ids = append(ids, task.externalId)
+	}
+	assert.Equal([]string{"id-1", "id-2", "id-3"}, ids)
+}
+
+
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+// TESTING CONFIGURATION AUDIT LOG (audit.go)
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+func TestConfigChanges_RecordsPrincipalAndValues(t *testing.T) {
+	assert := assert.New(t)
+	q := Init(5, "TestQueue", 2)
+
+	assert.NoError(q.As("alice").SetMaxProcessing(4))
+	assert.NoError(q.As("autoscaler").Resize(8))
+	assert.NoError(q.SetBudget("tenant-a", 10, time.Minute, BudgetDefer))
+	q.As("bob").RemoveBudget("tenant-a")
+
+	// failed and conflicting changes are not recorded
+	assert.Error(q.As("alice").SetMaxProcessing(-1))
+	_, err := q.As("alice").CompareAndResize(0, 3)
+	assert.ErrorIs(err, ErrConfigConflict)
+
+	changes := q.ConfigChanges()
+	assert.Len(changes, 4)
+
+	assert.Equal("alice", changes[0].Principal)
+	assert.Equal("maxProcessing", changes[0].Setting)
+	assert.Equal("2", changes[0].Old)
+	assert.Equal("4", changes[0].New)
+	assert.Equal(uint64(1), changes[0].Version)
+	assert.False(changes[0].At.IsZero())
+
+	assert.Equal("autoscaler", changes[1].Principal)
+	assert.Equal("size", changes[1].Setting)
+	assert.Equal("5", changes[1].Old)
+	assert.Equal("8", changes[1].New)
+
+	assert.Equal("", changes[2].Principal)
+	assert.Equal("budget[tenant-a]", changes[2].Setting)
+	assert.Equal("", changes[2].Old)
+	assert.Equal("10 per 1m0s (defer)", changes[2].New)
+
+	assert.Equal("bob", changes[3].Principal)
+	assert.Equal("10 per 1m0s (defer)", changes[3].Old)
+	assert.Equal("", changes[3].New)
+	assert.Equal(q.ConfigVersion(), changes[3].Version)
+}
+
+
+func TestConfigChanges_KeepsMostRecent(t *testing.T) {
+	assert := assert.New(t)
+	q := Init(5, "TestQueue", 0)
+
+	for i := 1; i <= maxConfigChanges + 5; i++ {
+		assert.NoError(q.SetMaxProcessing(i))
+	}
+
+	changes := q.ConfigChanges()
+	assert.Len(changes, maxConfigChanges)
+	assert.Equal("6", changes[0].New)
+	assert.Equal(strconv.Itoa(maxConfigChanges + 5), changes[len(changes) - 1].New)
+}
+
+
+func TestConfigChanges_RecordsOtherSettings(t *testing.T) {
+	assert := assert.New(t)
+	q := Init(5, "TestQueue", 2)
+
+	assert.NoError(q.AddMaintenanceWindow(MaintenanceWindow{Duration: time.Hour}))
+	q.ClearMaintenanceWindows()
+	q.SetMemoryLimit(1 << 40, nil, time.Hour)
+	q.ClearMemoryLimit()
+	q.SetProcsMultiplier(2)
+
+	settings := []string{}
+	for _, change := range q.ConfigChanges() {
+		settings = append(settings, change.Setting + ":" + change.Old + "->" + change.New)
+	}
+
+	assert.Equal([]string{
+		"maintenanceWindows:0->1",
+		"maintenanceWindows:1->0",
+		"memoryLimit:->1099511627776",
+		"memoryLimit:1099511627776->",
+		"procsMultiplier:0->2",
+	}, settings)
+}
+
+
+func TestConfigChanges_RuntimeSettersAreAudited(t *testing.T) {
+	assert := assert.New(t)
+	q := Init(5, "TestQueue", 2)
+
+	// runtime settings, by the method that changes them
+	audited := map[string]struct {
+		change func()
+		setting string
+	}{
+		"SetAbandonTimeout": {func() { q.SetAbandonTimeout(time.Minute) }, "abandonTimeout"},
+		"SetAbandonGrace": {func() { q.SetAbandonGrace(time.Second) }, "abandonGrace"},
+		"SetBudget": {func() { q.SetBudget("tenant-a", 1, time.Minute, BudgetReject) }, "budget[tenant-a]"},
+		"SetMaxProcessing": {func() { q.SetMaxProcessing(3) }, "maxProcessing"},
+		"SetDeadLetters": {func() { q.SetDeadLetters(5) }, "deadLetters"},
+		"SetDedupKey": {func() { q.SetDedupKey(DedupByParams("id")) }, "dedupKey"},
+		"SetDispatchWindow": {func() { q.SetDispatchWindow(time.Minute) }, "dispatchWindow"},
+		"SetEnv": {func() { q.SetEnv(struct{}{}) }, "env"},
+		"SetStarvationThreshold": {func() { q.SetStarvationThreshold(time.Minute) }, "starvationThreshold"},
+		"SetActionGuard": {func() { q.SetActionGuard("a", func(params map[string]interface{}, run func() error) error { return run() }) }, "actionGuard[a]"},
+		"SetExecutionMode": {func() { q.SetExecutionMode("a", InProcess) }, "executionMode[a]"},
+		"SetMaxTaskAge": {func() { q.SetMaxTaskAge(time.Hour) }, "maxTaskAge"},
+		"SetMemoryLimit": {func() { q.SetMemoryLimit(1 << 40, nil, time.Hour) }, "memoryLimit"},
+		"SetStrictFIFO": {func() { q.SetStrictFIFO(true) }, "strictFIFO"},
+		"SetDispatchOrder": {func() { q.SetDispatchOrder(LIFO) }, "dispatchOrder"},
+		"SetDispatchStrategy": {func() { q.SetDispatchStrategy(nil) }, "dispatchStrategy"},
+		"SetParamTemplates": {func() { q.SetParamTemplates(true) }, "paramTemplates"},
+		"SetTemplateEnv": {func() { q.SetTemplateEnv("REGION") }, "templateEnv"},
+		"SetProcsMultiplier": {func() { q.SetProcsMultiplier(2) }, "procsMultiplier"},
+		"SetRateLimitPause": {func() { q.SetRateLimitPause("a", true) }, "rateLimitPause[a]"},
+		"SetRetryPolicy": {func() { q.SetRetryPolicy(RetryPolicy{MaxAttempts: 3}) }, "retryPolicy"},
+		"SetSoftCapacity": {func() { q.SetSoftCapacity(5) }, "softCapacity"},
+		"SetTempDirs": {func() { q.SetTempDirs("") }, "tempDirs"},
+		"ClearTempDirs": {func() { q.ClearTempDirs() }, "tempDirs"},
+		"SetTracing": {func() { q.SetTracing(10) }, "tracing"},
+		"Pause": {func() { q.Pause() }, "paused"},
+		"Resume": {func() { q.Resume() }, "paused"},
+		"BeginDrain": {func() { q.BeginDrain() }, "draining"},
+		"EndDrain": {func() { q.EndDrain() }, "draining"},
+	}
+
+	// setters wiring the queue to its dependencies and hooks, which aren't runtime settings
+	wiring := []string{"SetActionHealthProbe", "SetClock", "SetHealthProbe", "SetHistory", "SetLogger", "SetNamespace", "SetResultSink",
+		"SetScheduleStore", "SetScheduleLeaser", "SetTracerProvider", "SetWarmup"}
+
+	methods := reflect.TypeOf(q)
+	for i := 0; i < methods.NumMethod(); i++ {
+		name := methods.Method(i).Name
+		if !strings.HasPrefix(name, "Set") {
+			continue
+		}
+
+		_, ok := audited[name]
+		assert.True(ok || slices.Contains(wiring, name), "%s must record its changes with changeConfig, or be listed as wiring", name)
+	}
+
+	for name, setter := range audited {
+		version := q.ConfigVersion()
+		setter.change()
+
+		changes := q.ConfigChanges()
+		assert.Equal(version + 1, q.ConfigVersion(), name)
+		assert.Equal(setter.setting, changes[len(changes) - 1].Setting, name)
+	}
+}
+
+
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+// TESTING ADMIN API (admin.go)
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+var adminTokens = map[string]Role{
+	"view-token": RoleViewer,
+	"oper-token": RoleOperator,
+	"admn-token": RoleAdmin,
+}
+
+
+func adminRequest(h http.Handler, method string, path string, token string, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer " + token)
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+
+func TestAdminHandler_RequiresAuthorization(t *testing.T) {
+	assert := assert.New(t)
+	q := Init(5, "TestQueue", 2)
+	h := NewAdminHandler(StaticTokens(adminTokens), q)
+
+	assert.Equal(http.StatusUnauthorized, adminRequest(h, "GET", "/queues", "", "").Code)
+	assert.Equal(http.StatusUnauthorized, adminRequest(h, "GET", "/queues", "wrong", "").Code)
+	assert.Equal(http.StatusOK, adminRequest(h, "GET", "/queues", "view-token", "").Code)
+
+	// a nil authorizer denies everything
+	h = NewAdminHandler(nil, q)
+	assert.Equal(http.StatusUnauthorized, adminRequest(h, "GET", "/queues", "admn-token", "").Code)
+}
+
+
+func TestAdminHandler_ViewerCanOnlyRead(t *testing.T) {
+	assert := assert.New(t)
+	q := Init(5, "TestQueue", 2)
+	h := NewAdminHandler(StaticTokens(adminTokens), q)
+
+	rec := adminRequest(h, "GET", "/queues/TestQueue", "view-token", "")
+	assert.Equal(http.StatusOK, rec.Code)
+
+	view := QueueView{}
+	assert.NoError(json.Unmarshal(rec.Body.Bytes(), &view))
+	assert.Equal("TestQueue", view.Name)
+	assert.Equal(5, view.Capacity)
+
+	assert.Equal(http.StatusNotFound, adminRequest(h, "GET", "/queues/Other", "view-token", "").Code)
+
+	rec = adminRequest(h, "POST", "/queues/TestQueue/start", "view-token", "")
+	assert.Equal(http.StatusForbidden, rec.Code)
+	assert.Contains(rec.Body.String(), "Role operator is required, caller has role viewer.")
+	assert.False(q.IsRunning())
+
+	rec = adminRequest(h, "PUT", "/queues/TestQueue/max-processing", "view-token", `{"value": 4}`)
+	assert.Equal(http.StatusForbidden, rec.Code)
+	assert.Equal(2, q.MaxProcessing())
+}
+
+
+func TestAdminHandler_OperatorCanStartAndStop(t *testing.T) {
+	assert := assert.New(t)
+	q := Init(5, "TestQueue", 2)
+	h := NewAdminHandler(StaticTokens(adminTokens), q)
+
+	assert.Equal(http.StatusOK, adminRequest(h, "POST", "/queues/TestQueue/start", "oper-token", "").Code)
+	assert.True(q.IsRunning())
+	assert.Equal(http.StatusOK, adminRequest(h, "POST", "/queues/TestQueue/stop", "oper-token", "").Code)
+	assert.False(q.IsRunning())
+	assert.Equal(http.StatusOK, adminRequest(h, "POST", "/queues/TestQueue/pause", "oper-token", "").Code)
+	assert.True(q.IsPaused())
+	assert.Equal(http.StatusOK, adminRequest(h, "POST", "/queues/TestQueue/resume", "oper-token", "").Code)
+	assert.False(q.IsPaused())
+
+	// pausing is audited under the caller's principal
+	changes := q.ConfigChanges()
+	assert.Equal(2, len(changes))
+	assert.Equal("paused", changes[0].Setting)
+	assert.Equal("token:oper", changes[0].Principal)
+	assert.Equal("token:oper", changes[1].Principal)
+
+	rec := adminRequest(h, "PUT", "/queues/TestQueue/size", "oper-token", `{"value": 10}`)
+	assert.Equal(http.StatusForbidden, rec.Code)
+}
+
+
+func TestAdminHandler_CancelAndPurge(t *testing.T) {
+	assert := assert.New(t)
+	q := Init(5, "TestQueue", 1)
+	h := NewAdminHandler(StaticTokens(adminTokens), q)
+	q.Start()
+	defer q.Stop()
+
+	release := make(chan struct{})
+	defer close(release)
+	blocking := func(ctx context.Context, params map[string]interface{}) error {
+		select {
+		case <-release:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	assert.NoError(q.AddContextAction(blocking, map[string]interface{}{}, "running"))
+	assert.NoError(q.Add(noop, map[string]interface{}{}, "1"))
+	assert.NoError(q.Add(noop, map[string]interface{}{}, "2"))
+	assert.NoError(q.Add(noop, map[string]interface{}{}, "3"))
+
+	// read-only callers can't cancel or purge tasks
+	assert.Equal(http.StatusForbidden, adminRequest(h, "POST", "/queues/TestQueue/tasks/1/cancel", "view-token", "").Code)
+	assert.Equal(http.StatusForbidden, adminRequest(h, "POST", "/queues/TestQueue/purge", "view-token", "").Code)
+	assert.Equal(http.StatusForbidden, adminRequest(h, "POST", "/queues/TestQueue/purge", "oper-token", "").Code)
+	assert.Equal(3, q.Stats().Waiting)
+
+	rec := adminRequest(h, "POST", "/queues/TestQueue/tasks/1/cancel", "oper-token", "")
+	assert.Equal(http.StatusOK, rec.Code)
+	assert.JSONEq(`{"externalId": "1", "processing": false}`, rec.Body.String())
+	assert.Equal(http.StatusNotFound, adminRequest(h, "POST", "/queues/TestQueue/tasks/1/cancel", "oper-token", "").Code)
+
+	rec = adminRequest(h, "POST", "/queues/TestQueue/purge", "admn-token", "")
+	assert.Equal(http.StatusOK, rec.Code)
+	assert.JSONEq(`{"cancelled": ["2", "3"]}`, rec.Body.String())
+	assert.Equal(0, q.Stats().Waiting)
+
+	// a processing task has its context cancelled
+	rec = adminRequest(h, "POST", "/queues/TestQueue/tasks/running/cancel", "oper-token", "")
+	assert.Equal(http.StatusOK, rec.Code)
+	assert.JSONEq(`{"externalId": "running", "processing": true}`, rec.Body.String())
+	assert.Eventually(func() bool {
+		return q.Stats().Processing == 0
+	}, time.Second, 10 * time.Millisecond)
+}
+
+
+func TestAdminHandler_AdminChangesConfig(t *testing.T) {
+	assert := assert.New(t)
+	q := Init(5, "TestQueue", 2)
+	h := NewAdminHandler(StaticTokens(adminTokens), q)
+
+	rec := adminRequest(h, "PUT", "/queues/TestQueue/max-processing", "admn-token", `{"value": 4}`)
+	assert.Equal(http.StatusOK, rec.Code)
+	assert.JSONEq(`{"version": 1}`, rec.Body.String())
+	assert.Equal(4, q.MaxProcessing())
+
+	// a stale version conflicts
+	rec = adminRequest(h, "PUT", "/queues/TestQueue/size", "admn-token", `{"value": 10, "version": 0}`)
+	assert.Equal(http.StatusConflict, rec.Code)
+
+	rec = adminRequest(h, "PUT", "/queues/TestQueue/size", "admn-token", `{"value": 10, "version": 1}`)
+	assert.Equal(http.StatusOK, rec.Code)
+	assert.Equal(10, q.capacity())
+
+	rec = adminRequest(h, "PUT", "/queues/TestQueue/size", "admn-token", `{"value": 0}`)
+	assert.Equal(http.StatusBadRequest, rec.Code)
+	assert.Contains(rec.Body.String(), "Queue size must be greater than 0.")
+
+	rec = adminRequest(h, "PUT", "/queues/TestQueue/size", "admn-token", `not json`)
+	assert.Equal(http.StatusBadRequest, rec.Code)
+
+	// changes are audited with the caller's principal
+	rec = adminRequest(h, "GET", "/queues/TestQueue/config-changes", "view-token", "")
+	assert.Equal(http.StatusOK, rec.Code)
+
+	changes := []ConfigChange{}
+	assert.NoError(json.Unmarshal(rec.Body.Bytes(), &changes))
+	assert.Len(changes, 2)
+	assert.Equal("token:admn", changes[0].Principal)
+	assert.Equal("maxProcessing", changes[0].Setting)
+	assert.Equal("size", changes[1].Setting)
+}
+
+
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+// TESTING ADMIN FEDERATION (federation.go)
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+func TestFederationHandler_AggregatesPeers(t *testing.T) {
+	assert := assert.New(t)
+
+	q1 := Init(5, "queue-a", 2)
+	q2 := Init(7, "queue-b", 3)
+	server1 := httptest.NewServer(NewAdminHandler(StaticTokens(adminTokens), q1))
+	defer server1.Close()
+	server2 := httptest.NewServer(NewAdminHandler(StaticTokens(adminTokens), q2))
+	defer server2.Close()
+
+	h := NewFederationHandler(StaticTokens(adminTokens), nil,
+		AdminPeer{Name: "peer-1", URL: server1.URL, Token: "admn-token"},
+		AdminPeer{Name: "peer-2", URL: server2.URL + "/", Token: "view-token"},
+		AdminPeer{Name: "peer-3", URL: "http://127.0.0.1:1", Token: "admn-token"},
+		AdminPeer{Name: "peer-4", URL: server1.URL, Token: "wrong"},
+	)
+
+	assert.Equal(http.StatusUnauthorized, adminRequest(h, "GET", "/peers/queues", "", "").Code)
+
+	rec := adminRequest(h, "GET", "/peers/queues", "view-token", "")
+	assert.Equal(http.StatusOK, rec.Code)
+
+	results := []PeerQueues{}
+	assert.NoError(json.Unmarshal(rec.Body.Bytes(), &results))
+	assert.Len(results, 4)
+
+	assert.Equal("peer-1", results[0].Peer)
+	assert.Len(results[0].Queues, 1)
+	assert.Equal("queue-a", results[0].Queues[0].Name)
+	assert.Empty(results[0].Error)
+
+	assert.Equal("queue-b", results[1].Queues[0].Name)
+	assert.Equal(7, results[1].Queues[0].Capacity)
+
+	assert.Contains(results[2].Error, "Peer peer-3 can't be reached")
+	assert.Empty(results[2].Queues)
+
+	assert.Equal("Peer peer-4 responded with status 401.", results[3].Error)
+}
+
+
+func TestFederationHandler_ForwardsWithRoles(t *testing.T) {
+	assert := assert.New(t)
+
+	q := Init(5, "queue-a", 2)
+	server := httptest.NewServer(NewAdminHandler(StaticTokens(adminTokens), q))
+	defer server.Close()
+
+	h := NewFederationHandler(StaticTokens(adminTokens), nil,
+		AdminPeer{Name: "peer-1", URL: server.URL, Token: "admn-token"},
+	)
+
+	rec := adminRequest(h, "GET", "/peers/peer-1/queues/queue-a", "view-token", "")
+	assert.Equal(http.StatusOK, rec.Code)
+	assert.Contains(rec.Body.String(), `"Name":"queue-a"`)
+
+	// the caller's role on the federation is checked before forwarding
+	rec = adminRequest(h, "PUT", "/peers/peer-1/queues/queue-a/max-processing", "oper-token", `{"value": 9}`)
+	assert.Equal(http.StatusForbidden, rec.Code)
+	assert.Equal(2, q.MaxProcessing())
+
+	rec = adminRequest(h, "PUT", "/peers/peer-1/queues/queue-a/max-processing", "admn-token", `{"value": 9}`)
+	assert.Equal(http.StatusOK, rec.Code)
+	assert.Equal(9, q.MaxProcessing())
+
+	rec = adminRequest(h, "POST", "/peers/peer-1/queues/queue-a/start", "oper-token", "")
+	assert.Equal(http.StatusOK, rec.Code)
+	assert.True(q.IsRunning())
+
+	// purging needs admin on the federation too, whatever the peer's token allows
+	assert.NoError(q.AddAfter(noop, map[string]interface{}{}, "later", time.Hour))
+	rec = adminRequest(h, "POST", "/peers/peer-1/queues/queue-a/purge", "oper-token", "")
+	assert.Equal(http.StatusForbidden, rec.Code)
+	assert.Equal(1, q.Stats().Waiting)
+
+	rec = adminRequest(h, "POST", "/peers/peer-1/queues/queue-a/purge", "admn-token", "")
+	assert.Equal(http.StatusOK, rec.Code)
+	assert.Equal(0, q.Stats().Waiting)
+
+	// errors from the peer are passed through
+	assert.Equal(http.StatusNotFound, adminRequest(h, "GET", "/peers/peer-1/queues/missing", "view-token", "").Code)
+	assert.Equal(http.StatusNotFound, adminRequest(h, "GET", "/peers/peer-9/queues/queue-a", "view-token", "").Code)
+}
+
+
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+// TESTING NAMESPACES (namespace.go)
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+func TestQualifiedName_IncludesNamespace(t *testing.T) {
+	assert := assert.New(t)
+	q := Init(1, "emails", 1)
+
+	assert.Equal("", q.Namespace())
+	assert.Equal("emails", q.QualifiedName())
+
+	assert.NoError(q.SetNamespace("billing"))
+	assert.Equal("billing", q.Namespace())
+	assert.Equal("billing:emails", q.QualifiedName())
+
+	assert.EqualError(q.SetNamespace(" "), "Namespace is not valid, only uses space characters.")
+	assert.EqualError(q.SetNamespace("a:b"), `Namespace a:b can't contain ":".`)
+	assert.Equal("billing", q.Namespace())
+
+	view := q.SnapshotView()
+	assert.Equal("emails", view.Name)
+	assert.Equal("billing", view.Namespace)
+	assert.Equal("billing:emails", view.QualifiedName)
+
+	err := q.Add(noop, map[string]interface{}{}, "id-1")
+	assert.EqualError(err, "FixedSizeQueue billing:emails is not running. Try starting and then adding.")
+}
+
+
+func TestSetNamespace_WhileQueueRuns(t *testing.T) {
+	assert := assert.New(t)
+	q := Init(10, "emails", 2)
+	assert.NoError(q.SetNamespace("team-0"))
+	q.Start()
+	defer q.Stop()
+
+	// errors and events name the queue while the namespace changes, run with -race
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 50; i++ {
+			assert.NoError(q.SetNamespace(fmt.Sprintf("team-%d", i % 2)))
+		}
+	}()
+
+	for i := 0; i < 50; i++ {
+		q.Add(noop, map[string]interface{}{}, fmt.Sprintf("id-%d", i))
+		assert.Contains(q.QualifiedName(), ":emails")
+	}
+	<-done
+}
+
+
+func TestAdminHandler_QueuesWithSameNameInDifferentNamespaces(t *testing.T) {
+	assert := assert.New(t)
+	q1 := Init(1, "emails", 1)
+	q1.SetNamespace("billing")
+	q2 := Init(2, "emails", 1)
+	q2.SetNamespace("marketing")
+
+	h := NewAdminHandler(StaticTokens(adminTokens), q1, q2)
+
+	rec := adminRequest(h, "GET", "/queues/marketing:emails", "view-token", "")
+	assert.Equal(http.StatusOK, rec.Code)
+
+	view := QueueView{}
+	assert.NoError(json.Unmarshal(rec.Body.Bytes(), &view))
+	assert.Equal(2, view.Capacity)
+
+	rec = adminRequest(h, "GET", "/queues", "view-token", "")
+	views := []QueueView{}
+	assert.NoError(json.Unmarshal(rec.Body.Bytes(), &views))
+	assert.Len(views, 2)
+	assert.Equal("billing:emails", views[0].QualifiedName)
+	assert.Equal("marketing:emails", views[1].QualifiedName)
+}
+
+
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+// TESTING CONCURRENT USE (fsq.go)
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+func TestAdd_ConcurrentAddsNeverExceedCapacity(t *testing.T) {
+	assert := assert.New(t)
+	size := 50
+	q := Init(size, "concurrent", 2)
+	q.Start()
+
+	// the first 2 tasks block their processes, so every other accepted task stays waiting
+	blocker := make(chan struct{})
+	var ran int32
+	blocking := func(params map[string]interface{}) error {
+		<-blocker
+		atomic.AddInt32(&ran, 1)
+		return nil
+	}
+
+	var accepted int32
+	done := make(chan struct{})
+	producers := 20
+
+	for p := 0; p < producers; p++ {
+		go func() {
+			defer func() { done <- struct{}{} }()
+
+			for i := 0; i < 10; i++ {
+				err := q.Add(blocking, map[string]interface{}{}, fmt.Sprintf("p%d-%d", p, i))
+				if err == nil {
+					atomic.AddInt32(&accepted, 1)
+				}
+			}
+		}()
+	}
+
+	for p := 0; p < producers; p++ {
+		<-done
+	}
+
+	// 2 processing plus a full ring buffer
+	assert.Equal(int32(size + 2), atomic.LoadInt32(&accepted))
+
+	close(blocker)
+	assert.Eventually(func() bool {
+		return atomic.LoadInt32(&ran) == int32(size + 2)
+	}, time.Second, 10 * time.Millisecond)
+}
+
+
+func TestConcurrentUse_ProducersReadersAndConfigChanges(t *testing.T) {
+	assert := assert.New(t)
+	q := Init(20, "concurrent", 2)
+	q.SetTracing(10)
+	q.Start()
+	defer q.Stop()
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+
+	// readers and config changes run alongside the producers, go test -race checks they don't conflict
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for i := 0; ; i++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+
+				q.SnapshotView()
+				q.Stats()
+				q.Position(fmt.Sprintf("p%d-%d", r, i))
+				q.Trace(fmt.Sprintf("p%d-%d", r, i))
+				if r == 0 {
+					q.SetMaxProcessing(1 + i % 4)
+					q.Resize(10 + i % 20)
+				}
+			}
+		}()
+	}
+
+	producers := sync.WaitGroup{}
+	for p := 0; p < 8; p++ {
+		producers.Add(1)
+
+		go func() {
+			defer producers.Done()
+
+			for i := 0; i < 50; i++ {
+				q.Add(noop, map[string]interface{}{}, fmt.Sprintf("p%d-%d", p, i))
+			}
+		}()
+	}
+
+	producers.Wait()
+	close(stop)
+	wg.Wait()
+
+	assert.Eventually(func() bool {
+		stats := q.Stats()
+		return stats.Completed == stats.Enqueued
+	}, time.Second, 10 * time.Millisecond)
+
+	stats := q.Stats()
+	assert.Equal(8 * 50, stats.Enqueued + stats.Rejected)
+}
+
+
+func TestAdd_ActionCanAddToItsOwnQueue(t *testing.T) {
+	assert := assert.New(t)
+	q := Init(5, "reentrant", 1)
+	q.Start()
+
+	var ran int32
+	var follow func(params map[string]interface{}) error
+	follow = func(params map[string]interface{}) error {
+		n := atomic.AddInt32(&ran, 1)
+		if n < 3 {
+			return q.Add(follow, map[string]interface{}{}, strconv.Itoa(int(n)))
+		}
+		return nil
+	}
+
+	assert.NoError(q.Add(follow, map[string]interface{}{}, "0"))
+	assert.Eventually(func() bool {
+		return atomic.LoadInt32(&ran) == 3
+	}, time.Second, 10 * time.Millisecond)
+}
+
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+// TESTING ABANDONED TASKS (abandon.go)
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+func TestAbandonTimeout_ReclaimsSlotOfBlockedAction(t *testing.T) {
+	assert := assert.New(t)
+	q := Init(5, "abandon", 1)
+	q.Start()
+	q.SetAbandonTimeout(20 * time.Millisecond)
+
+	abandonedIds := make(chan string, 1)
+	q.OnAbandoned(func(abandoned AbandonedTask) {
+		abandonedIds <- abandoned.ExternalId
+	})
+
+	blocker := make(chan struct{})
+	var ran int32
+	blocking := func(params map[string]interface{}) error {
+		<-blocker
+		return nil
+	}
+	counting := func(params map[string]interface{}) error {
+		atomic.AddInt32(&ran, 1)
+		return nil
+	}
+
+	assert.NoError(q.AddNamed("stuck", blocking, map[string]interface{}{}, "id-1"))
+	assert.NoError(q.Add(counting, map[string]interface{}{}, "id-2"))
+
+	// the second task only runs once the first one was abandoned
+	assert.Equal("id-1", <-abandonedIds)
+	assert.Eventually(func() bool {
+		return atomic.LoadInt32(&ran) == 1
+	}, time.Second, 10 * time.Millisecond)
+
+	abandonedTasks := q.AbandonedTasks()
+	assert.Len(abandonedTasks, 1)
+	assert.Equal("id-1", abandonedTasks[0].ExternalId)
+	assert.Equal("stuck", abandonedTasks[0].ActionName)
+	assert.False(abandonedTasks[0].AbandonedAt.Before(abandonedTasks[0].StartedAt))
+
+	view := q.SnapshotView()
+	assert.Len(view.Abandoned, 1)
+	assert.Equal("id-1", view.Abandoned[0].ExternalId)
+
+	// once the action returns, the task is forgotten and its slot isn't released a second time
+	close(blocker)
+	assert.Eventually(func() bool {
+		return len(q.AbandonedTasks()) == 0
+	}, time.Second, 10 * time.Millisecond)
+	q.mu.Lock()
+	assert.Equal(0, q.countProcessing)
+	q.mu.Unlock()
+	assert.Empty(q.SnapshotView().Abandoned)
+}
+
+
+func TestAbandonTimeout_DoesNotAbandonActionsThatReturn(t *testing.T) {
+	assert := assert.New(t)
+	q := Init(5, "abandon", 1)
+	q.Start()
+	q.SetAbandonTimeout(50 * time.Millisecond)
+
+	var abandonedCount int32
+	q.OnAbandoned(func(abandoned AbandonedTask) {
+		atomic.AddInt32(&abandonedCount, 1)
+	})
+
+	for i := 0; i < 3; i++ {
+		assert.NoError(q.Add(noop, map[string]interface{}{}, strconv.Itoa(i)))
+	}
+
+	assert.Eventually(func() bool {
+		return len(q.SnapshotView().Processing) == 0
+	}, time.Second, 10 * time.Millisecond)
+
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(int32(0), atomic.LoadInt32(&abandonedCount))
+	assert.Empty(q.AbandonedTasks())
+}
+
+
+func TestAbandonGrace_ContextActionsThatGiveUpAreNotAbandoned(t *testing.T) {
+	assert := assert.New(t)
+	q := Init(5, "abandon", 1)
+	q.Start()
+	q.SetAbandonTimeout(10 * time.Millisecond)
+	q.SetAbandonGrace(time.Second)
+
+	var abandonedCount int32
+	q.OnAbandoned(func(abandoned AbandonedTask) {
+		atomic.AddInt32(&abandonedCount, 1)
+	})
+
+	cooperative := func(ctx context.Context, params map[string]interface{}) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	assert.NoError(q.AddContextAction(cooperative, map[string]interface{}{}, "id-1"))
+
+	// the action returns once its context was cancelled at the timeout, and fails as it returns
+	assert.Eventually(func() bool {
+		return q.Stats().Failed == 1
+	}, time.Second, time.Millisecond)
+	assert.Equal(int32(0), atomic.LoadInt32(&abandonedCount))
+	assert.Empty(q.AbandonedTasks())
+}
+
+
+func TestAbandonGrace_AbandonsActionsThatIgnoreCancellation(t *testing.T) {
+	assert := assert.New(t)
+	q := Init(5, "abandon", 1)
+	q.Start()
+	q.SetAbandonTimeout(10 * time.Millisecond)
+	q.SetAbandonGrace(30 * time.Millisecond)
+
+	abandoned := make(chan AbandonedTask, 1)
+	q.OnAbandoned(func(info AbandonedTask) {
+		abandoned <- info
+	})
+
+	cancelled := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+	stubborn := func(ctx context.Context, params map[string]interface{}) error {
+		<-ctx.Done()
+		close(cancelled)
+		<-release
+		return nil
+	}
+
+	assert.NoError(q.AddContextAction(stubborn, map[string]interface{}{}, "id-1"))
+
+	// the context is cancelled at the timeout, the task is only abandoned once the grace period passed
+	<-cancelled
+	assert.Empty(q.AbandonedTasks())
+
+	info := <-abandoned
+	assert.Equal("id-1", info.ExternalId)
+	assert.Equal(ReasonTimeout, info.Reason)
+	assert.GreaterOrEqual(info.AbandonedAt.Sub(info.StartedAt), 40 * time.Millisecond)
+}
+
+
+func TestAbandon_IgnoresTimersOfAnEarlierRun(t *testing.T) {
+	assert := assert.New(t)
+	q := Init(5, "abandon", 1)
+	q.Start()
+
+	assert.NoError(q.Add(noop, map[string]interface{}{}, "id-1"))
+	assert.Eventually(func() bool {
+		return q.Stats().Completed == 1
+	}, time.Second, time.Millisecond)
+
+	q.mu.Lock()
+	recycled := q.tasksById[1]
+	staleRun := recycled.run
+	q.mu.Unlock()
+
+	// the recycled task runs again, under another id
+	release := make(chan struct{})
+	blocking := func(params map[string]interface{}) error {
+		<-release
+		return nil
+	}
+	assert.NoError(q.Add(blocking, map[string]interface{}{}, "id-2"))
+	assert.Eventually(func() bool {
+		return len(q.SnapshotView().Processing) == 1
+	}, time.Second, time.Millisecond)
+
+	// timers started for the first run fire late
+	q.expire(recycled, staleRun, ReasonTimeout, 0)
+	q.timeOut(recycled, staleRun)
+
+	assert.Empty(q.AbandonedTasks())
+	processing := q.SnapshotView().Processing
+	assert.Len(processing, 1)
+	assert.Equal("id-2", processing[0].ExternalId)
+
+	close(release)
+	assert.Eventually(func() bool {
+		return q.Stats().Completed == 2
+	}, time.Second, time.Millisecond)
+	assert.Equal(0, q.Stats().Failed)
+}
+
+
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+// TESTING DISPATCH WINDOW (dispatchWindow.go)
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+func TestSetDispatchWindow_BatchesDispatchAfterCompletions(t *testing.T) {
+	assert := assert.New(t)
+	q := Init(5, "window", 1)
+	q.Start()
+	assert.NoError(q.SetDispatchWindow(50 * time.Millisecond))
+
+	started := make(chan time.Time, 2)
+	record := func(params map[string]interface{}) error {
+		started <- time.Now()
+		return nil
+	}
+
+	assert.NoError(q.Add(record, map[string]interface{}{}, "id-1"))
+	assert.NoError(q.Add(record, map[string]interface{}{}, "id-2"))
+
+	// the 1st task is dispatched by Add, the 2nd waits for the window after the 1st completed
+	first := <-started
+	second := <-started
+	assert.GreaterOrEqual(second.Sub(first), 40 * time.Millisecond)
+
+	changes := q.ConfigChanges()
+	assert.Equal("dispatchWindow", changes[len(changes) - 1].Setting)
+	assert.Equal("50ms", changes[len(changes) - 1].New)
+}
+
+
+func TestSetDispatchWindow_ZeroDispatchesRightAway(t *testing.T) {
+	assert := assert.New(t)
+	q := Init(5, "window", 1)
+	q.Start()
+
+	assert.EqualError(q.SetDispatchWindow(-time.Millisecond), "Dispatch window can't be negative.")
+	assert.NoError(q.SetDispatchWindow(0))
+
+	var ran int32
+	counting := func(params map[string]interface{}) error {
+		atomic.AddInt32(&ran, 1)
+		return nil
+	}
+
+	for i := 0; i < 3; i++ {
+		assert.NoError(q.Add(counting, map[string]interface{}{}, strconv.Itoa(i)))
+	}
+
+	assert.Eventually(func() bool {
+		return atomic.LoadInt32(&ran) == 3
+	}, time.Second, time.Millisecond)
+}
+
+
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+// TESTING WARM-UP (warmup.go)
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+func TestInitPreallocated_ReusesPreallocatedTasks(t *testing.T) {
+	assert := assert.New(t)
+	q := InitPreallocated(4, "warm", 1)
+	q.Start()
+
+	assert.Len(*q.readyTaskPool, 4)
+	assert.Len(q.tasksById, 4)
+	assert.Equal(4, q.SnapshotView().PreallocatedTasks)
+	assert.Equal(4, q.Stats().Preallocated)
+
+	// the footprint isn't an activity counter, a new stats epoch keeps it
+	q.ResetStats()
+	assert.Equal(4, q.Stats().Preallocated)
+
+	blocker := make(chan struct{})
+	blocking := func(params map[string]interface{}) error {
+		<-blocker
+		return nil
+	}
+
+	for i := 0; i < 4; i++ {
+		assert.NoError(q.Add(blocking, map[string]interface{}{}, strconv.Itoa(i)))
+	}
+
+	// no new tasks were created for the burst
+	q.mu.Lock()
+	assert.Len(q.tasksById, 4)
+	assert.Len(*q.readyTaskPool, 0)
+	q.mu.Unlock()
+
+	close(blocker)
+}
+
+
+func TestInit_DoesNotPreallocate(t *testing.T) {
+	assert := assert.New(t)
+	q := Init(4, "cold", 1)
+
+	assert.Empty(q.tasksById)
+	assert.Equal(0, q.SnapshotView().PreallocatedTasks)
+	assert.Equal(0, q.Stats().Preallocated)
+}
+
+
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+// TESTING FIXED SIZE BUFFER (buffer.go)
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+func TestFixedSizeBuffer_TryPutGet(t *testing.T) {
+	assert := assert.New(t)
+	b := NewFixedSizeBuffer[string](2)
+
+	assert.Equal(2, b.Cap())
+	assert.NoError(b.TryPut("a"))
+	assert.NoError(b.TryPut("b"))
+	assert.ErrorIs(b.TryPut("c"), ErrQueueFull)
+	assert.Equal(2, b.Len())
+
+	item, ok := b.Get()
+	assert.True(ok)
+	assert.Equal("a", item)
+
+	assert.NoError(b.TryPut("c"))
+
+	item, _ = b.Get()
+	assert.Equal("b", item)
+	item, _ = b.Get()
+	assert.Equal("c", item)
+
+	item, ok = b.Get()
+	assert.False(ok)
+	assert.Equal("", item)
+}
+
+
+func TestFixedSizeBuffer_PutWaitsForSpace(t *testing.T) {
+	assert := assert.New(t)
+	b := NewFixedSizeBuffer[int](1)
+	b.Put(1)
+
+	var put int32
+	go func() {
+		b.Put(2)
+		atomic.StoreInt32(&put, 1)
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(int32(0), atomic.LoadInt32(&put), "Put should wait while the buffer is full")
+
+	item, _ := b.Get()
+	assert.Equal(1, item)
+
+	assert.Eventually(func() bool {
+		return atomic.LoadInt32(&put) == 1
+	}, time.Second, 10 * time.Millisecond)
+
+	item, _ = b.Get()
+	assert.Equal(2, item)
+}
+
+
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+// TESTING PRIORITY BUFFER (priorityBuffer.go)
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+type prioritized struct {
+	id string
+	level int
+}
+
+
+func levelOf(item prioritized) int {
+	return item.level
+}
+
+
+func drain(b *FixedSizeBuffer[prioritized]) []string {
+	ids := []string{}
+
+	for {
+		item, ok := b.Get()
+		if !ok {
+			return ids
+		}
+		ids = append(ids, item.id)
+	}
+}
+
+
+func TestPriorityBuffer_StrictDraining(t *testing.T) {
+	assert := assert.New(t)
+	b, err := NewPriorityBuffer(3, 2, levelOf)
+	assert.NoError(err)
+	assert.Equal(6, b.Cap())
+
+	assert.NoError(b.TryPut(prioritized{"low-1", 2}))
+	assert.NoError(b.TryPut(prioritized{"high-1", 0}))
+	assert.NoError(b.TryPut(prioritized{"mid-1", 1}))
+	assert.NoError(b.TryPut(prioritized{"high-2", 0}))
+	assert.ErrorIs(b.TryPut(prioritized{"high-3", 0}), ErrQueueFull, "a full level rejects items even if others have space")
+	assert.NoError(b.TryPut(prioritized{"clamped", 9}))
+
+	assert.Equal([]string{"high-1", "high-2", "mid-1", "low-1", "clamped"}, drain(b))
+}
+
+
+func TestPriorityBuffer_WeightedDraining(t *testing.T) {
+	assert := assert.New(t)
+	b, err := NewPriorityBuffer(2, 5, levelOf, 2, 1)
+	assert.NoError(err)
+
+	for i := 1; i <= 4; i++ {
+		assert.NoError(b.TryPut(prioritized{fmt.Sprintf("high-%d", i), 0}))
+		assert.NoError(b.TryPut(prioritized{fmt.Sprintf("low-%d", i), 1}))
+	}
+
+	// the order the items are listed in is the order they are drained
+	listed := []string{}
+	for _, item := range b.items.Tasks() {
+		listed = append(listed, item.id)
+	}
+
+	expected := []string{"high-1", "high-2", "low-1", "high-3", "high-4", "low-2", "low-3", "low-4"}
+	assert.Equal(expected, listed)
+	assert.Equal(expected, drain(b))
+}
+
+
+func TestPriorityBuffer_PeekAndPeekTail(t *testing.T) {
+	assert := assert.New(t)
+	b, _ := NewPriorityBuffer(3, 2, levelOf)
+
+	_, ok := b.Peek()
+	assert.False(ok)
+	_, ok = b.PeekTail()
+	assert.False(ok)
+
+	assert.NoError(b.TryPut(prioritized{"mid-1", 1}))
+	assert.NoError(b.TryPut(prioritized{"high-1", 0}))
+	assert.NoError(b.TryPut(prioritized{"mid-2", 1}))
+
+	head, ok := b.Peek()
+	assert.True(ok)
+	assert.Equal("high-1", head.id)
+
+	tail, ok := b.PeekTail()
+	assert.True(ok)
+	assert.Equal("mid-2", tail.id)
+	assert.Equal(3, b.Len())
+}
+
+
+func TestPriorityBuffer_Remove(t *testing.T) {
+	assert := assert.New(t)
+	b, _ := NewPriorityBuffer(2, 2, levelOf)
+
+	assert.NoError(b.TryPut(prioritized{"high-1", 0}))
+	assert.NoError(b.TryPut(prioritized{"low-1", 1}))
+	assert.NoError(b.TryPut(prioritized{"low-2", 1}))
+
+	removed, ok := b.Remove(func(item prioritized) bool {
+		return item.id == "low-1"
+	})
+	assert.True(ok)
+	assert.Equal("low-1", removed.id)
+
+	_, ok = b.Remove(func(item prioritized) bool {
+		return item.id == "missing"
+	})
+	assert.False(ok)
+
+	assert.Equal([]string{"high-1", "low-2"}, drain(b))
+}
+
+
+func TestPriorityBuffer_InvalidWeights(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := NewPriorityBuffer(2, 5, levelOf, 1)
+	assert.EqualError(err, "Priority buffer has 2 levels but 1 weights.")
+
+	_, err = NewPriorityBuffer(2, 5, levelOf, 1, 0)
+	assert.EqualError(err, "Priority buffer weights must be greater than 0.")
+
+	_, err = NewPriorityBuffer(0, 5, levelOf)
+	assert.EqualError(err, "Priority buffer must have at least 1 level.")
+}
+
+
+
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+// TESTING CLOCK (clock.go)
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+func TestFakeClock_OnlyMovesForward(t *testing.T) {
+	assert := assert.New(t)
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	clock := NewFakeClock(start)
+
+	clock.Advance(time.Second)
+	assert.Equal(start.Add(time.Second), clock.Now())
+
+	clock.Advance(-time.Hour)
+	assert.Equal(start.Add(time.Second), clock.Now())
+}
+
+
+func TestFakeClock_AfterFuncFiresOnAdvance(t *testing.T) {
+	assert := assert.New(t)
+	clock := NewFakeClock(time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC))
+
+	fired := make(chan string, 3)
+	clock.AfterFunc(time.Minute, func() { fired <- "minute" })
+	stopped := clock.AfterFunc(time.Second, func() { fired <- "stopped" })
+	clock.AfterFunc(time.Hour, func() { fired <- "hour" })
+
+	assert.True(stopped.Stop())
+	assert.False(stopped.Stop())
+
+	clock.Advance(30 * time.Second)
+	assert.Never(func() bool {
+		return len(fired) > 0
+	}, 50 * time.Millisecond, 10 * time.Millisecond)
+
+	clock.Advance(30 * time.Second)
+	assert.Equal("minute", <-fired)
+	assert.Empty(fired)
+}
+
+
+func TestSetClock_DeferredBudgetRefillsOnAdvance(t *testing.T) {
+	assert := assert.New(t)
+	q := Init(5, "clock", 1)
+	clock := NewFakeClock(time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC))
+	q.SetClock(clock)
+	q.Start()
+
+	assert.NoError(q.SetBudget("", 1, time.Hour, BudgetDefer))
+
+	ran := make(chan string, 2)
+	action := func(params map[string]interface{}) error {
+		ran <- params["id"].(string)
+		return nil
+	}
+	assert.NoError(q.AddWithCost(action, map[string]interface{}{"id": "id-1"}, "id-1", 1, ""))
+	assert.NoError(q.AddWithCost(action, map[string]interface{}{"id": "id-2"}, "id-2", 1, ""))
+	assert.Equal("id-1", <-ran)
+
+	// held back until the fake clock passes the interval, however long it takes in real time
+	assert.Eventually(func() bool {
+		return len(q.SnapshotView().Parked) == 1
+	}, time.Second, time.Millisecond)
+	clock.Advance(time.Hour)
+	assert.Equal("id-2", <-ran)
+}
+
+
+func TestSetClock_WaitTimeIsNeverNegative(t *testing.T) {
+	assert := assert.New(t)
+	q := Init(5, "clock", 0)
+	q.Start()
+
+	// the task is enqueued at a wall time far in the future, as if NTP had stepped the clock back since
+	q.SetClock(NewFakeClock(time.Now().Add(time.Hour)))
+	assert.NoError(q.Add(noop, map[string]interface{}{}, "id-1"))
+
+	q.SetClock(nil)
+	view := q.SnapshotView()
+	assert.Equal(time.Duration(0), view.Waiting[0].WaitTime)
+
+	// the real clock's readings carry the monotonic clock
+	assert.NoError(q.Add(noop, map[string]interface{}{}, "id-2"))
+	assert.GreaterOrEqual(q.SnapshotView().Waiting[1].WaitTime, time.Duration(0))
+}
+
+
+func TestSetClock_UsedForAuditAndBudgets(t *testing.T) {
+	assert := assert.New(t)
+	q := Init(5, "clock", 1)
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	clock := NewFakeClock(start)
+	q.SetClock(clock)
+	q.Start()
+
+	assert.NoError(q.SetBudget("", 1, time.Minute, BudgetReject))
+	assert.Equal(start, q.ConfigChanges()[0].At)
+
+	assert.NoError(q.AddWithCost(noop, map[string]interface{}{}, "id-1", 1, ""))
+	assert.Error(q.AddWithCost(noop, map[string]interface{}{}, "id-2", 1, ""))
+
+	// the budget refills when the fake clock passes the interval
+	clock.Advance(time.Minute)
+	assert.NoError(q.AddWithCost(noop, map[string]interface{}{}, "id-2", 1, ""))
+}
+
+
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+// TESTING EVENTS (events.go)
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+func TestSubscribe_EverySubscriberGetsEvents(t *testing.T) {
+	assert := assert.New(t)
+	q := Init(5, "events", 1)
+	q.Start()
+
+	sub1 := q.Subscribe(10, DropOldest)
+	sub2 := q.Subscribe(10, DropOldest)
+	defer sub1.Close()
+	defer sub2.Close()
+
+	failing := func(params map[string]interface{}) error {
+		return errors.New("boom")
+	}
+
+	assert.NoError(q.AddNamed("ok", noop, map[string]interface{}{}, "id-1"))
+	assert.NoError(q.Add(failing, map[string]interface{}{}, "id-2"))
+
+	for _, sub := range []*Subscription{sub1, sub2} {
+		first := <-sub.Events()
+		assert.Equal(EventCompleted, first.Type)
+		assert.Equal("id-1", first.ExternalId)
+		assert.Equal("ok", first.ActionName)
+		assert.Equal("events", first.Queue)
+
+		second := <-sub.Events()
+		assert.Equal(EventFailed, second.Type)
+		assert.Equal("id-2", second.ExternalId)
+		assert.EqualError(second.Err, "boom")
+	}
+}
+
+
+func TestSubscribe_EventsCarryRunDuration(t *testing.T) {
+	assert := assert.New(t)
+	q := Init(5, "events", 1)
+	q.Start()
+	defer q.Stop()
+
+	sub := q.Subscribe(10, DropOldest)
+	defer sub.Close()
+
+	assert.NoError(q.Add(func(params map[string]interface{}) error {
+		time.Sleep(20 * time.Millisecond)
+		return nil
+	}, map[string]interface{}{}, "slow"))
+
+	event := <-sub.Events()
+	assert.GreaterOrEqual(event.Duration, 20 * time.Millisecond)
+	assert.Less(event.Duration, time.Second)
+}
+
+
+func TestSubscribe_DropOldestKeepsNewestEvents(t *testing.T) {
+	assert := assert.New(t)
+	q := Init(5, "events", 1)
+	q.Start()
+
+	slow := q.Subscribe(2, DropOldest)
+	defer slow.Close()
+	fast := q.Subscribe(10, DropOldest)
+	defer fast.Close()
+
+	for i := 1; i <= 4; i++ {
+		assert.NoError(q.Add(noop, map[string]interface{}{}, strconv.Itoa(i)))
+		// the fast subscriber isn't held up by the slow one
+		assert.Equal(strconv.Itoa(i), (<-fast.Events()).ExternalId)
+	}
+
+	assert.Equal(2, slow.Dropped())
+	assert.Equal("3", (<-slow.Events()).ExternalId)
+	assert.Equal("4", (<-slow.Events()).ExternalId)
+}
+
+
+func TestSubscribe_DisconnectClosesChannel(t *testing.T) {
+	assert := assert.New(t)
+	q := Init(5, "events", 1)
+	q.Start()
+
+	sub := q.Subscribe(1, Disconnect)
+	watcher := q.Subscribe(10, DropOldest)
+	defer watcher.Close()
+
+	assert.NoError(q.Add(noop, map[string]interface{}{}, "id-1"))
+	<-watcher.Events()
+	assert.NoError(q.Add(noop, map[string]interface{}{}, "id-2"))
+	<-watcher.Events()
+
+	// the buffered event is still delivered, then the channel is closed
+	assert.Equal("id-1", (<-sub.Events()).ExternalId)
+	assert.Eventually(func() bool {
+		select {
+		case _, ok := <-sub.Events():
+			return !ok
+		default:
+			return false
+		}
+	}, time.Second, time.Millisecond)
+
+	// closing a disconnected subscription does nothing
+	sub.Close()
+	q.mu.Lock()
+	assert.Equal([]*Subscription{watcher}, q.subscribers)
+	q.mu.Unlock()
+}
+
+
+func TestSubscribeFiltered_OnlyDeliversMatchingEvents(t *testing.T) {
+	assert := assert.New(t)
+	q := Init(10, "events", 1)
+	q.Start()
+
+	failing := func(params map[string]interface{}) error {
+		return errors.New("boom")
+	}
+
+	sub := q.SubscribeFiltered(EventFilter{
+		Types: []EventType{EventFailed},
+		ExternalIdPrefix: "order-",
+		Tenants: []string{"tenant-a"},
+	}, 1, Disconnect)
+	defer sub.Close()
+	all := q.Subscribe(10, DropOldest)
+	defer all.Close()
+
+	assert.NoError(q.AddWithCost(noop, map[string]interface{}{}, "order-1", 1, "tenant-a"))
+	assert.NoError(q.AddWithCost(failing, map[string]interface{}{}, "order-2", 1, "tenant-b"))
+	assert.NoError(q.AddWithCost(failing, map[string]interface{}{}, "user-3", 1, "tenant-a"))
+	assert.NoError(q.AddWithCost(failing, map[string]interface{}{}, "order-4", 1, "tenant-a"))
+
+	for i := 0; i < 4; i++ {
+		<-all.Events()
+	}
+
+	// only order-4 matched, so the small buffer didn't overflow
+	event, ok := <-sub.Events()
+	assert.True(ok)
+	assert.Equal("order-4", event.ExternalId)
+	assert.Len(sub.Events(), 0)
+	assert.Equal(0, sub.Dropped())
+}
+
+
+func TestEventFilter_ActionNames(t *testing.T) {
+	assert := assert.New(t)
+	filter := EventFilter{ActionNames: []string{"email"}}
+
+	assert.True(filter.matches(Event{ActionName: "email"}))
+	assert.False(filter.matches(Event{ActionName: "sms"}))
+	assert.True(EventFilter{}.matches(Event{ActionName: "sms"}))
+}
+
+
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+// TESTING ERROR CLASSES (errorClass.go)
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+func TestClassOf(t *testing.T) {
+	assert := assert.New(t)
+	base := errors.New("boom")
+
+	assert.Equal(ClassUnclassified, ClassOf(base))
+	assert.Equal(ClassUnclassified, ClassOf(nil))
+	assert.Equal(ClassRetryable, ClassOf(Retryable(base)))
+	assert.Equal(ClassPermanent, ClassOf(Permanent(base)))
+	assert.Equal(ClassRateLimited, ClassOf(RateLimited(base, time.Second)))
+	assert.Equal(ClassCancelled, ClassOf(Cancelled(base)))
+	assert.Equal(ClassCancelled, ClassOf(fmt.Errorf("calling api: %w", context.Canceled)))
+
+	// the class survives wrapping, and the original error stays reachable
+	wrapped := fmt.Errorf("calling api: %w", Permanent(base))
+	assert.Equal(ClassPermanent, ClassOf(wrapped))
+	assert.ErrorIs(wrapped, base)
+	assert.Equal("boom", Permanent(base).Error())
+
+	assert.Nil(Retryable(nil))
+}
+
+
+func TestRetryAfter(t *testing.T) {
+	assert := assert.New(t)
+	base := errors.New("slow down")
+
+	after, ok := RetryAfter(RateLimited(base, 3 * time.Second))
+	assert.True(ok)
+	assert.Equal(3 * time.Second, after)
+
+	_, ok = RetryAfter(RateLimited(base, 0))
+	assert.False(ok)
+
+	_, ok = RetryAfter(Retryable(base))
+	assert.False(ok)
+}
+
+
+func TestEvents_CarryErrorClass(t *testing.T) {
+	assert := assert.New(t)
+	q := Init(5, "classes", 1)
+	q.Start()
+
+	sub := q.SubscribeFiltered(EventFilter{Classes: []ErrorClass{ClassPermanent}}, 10, DropOldest)
+	defer sub.Close()
+	all := q.Subscribe(10, DropOldest)
+	defer all.Close()
+
+	retryable := func(params map[string]interface{}) error {
+		return Retryable(errors.New("timeout"))
+	}
+	permanent := func(params map[string]interface{}) error {
+		return Permanent(errors.New("bad input"))
+	}
+
+	assert.NoError(q.Add(retryable, map[string]interface{}{}, "id-1"))
+	assert.NoError(q.Add(permanent, map[string]interface{}{}, "id-2"))
+
+	assert.Equal(ClassRetryable, (<-all.Events()).Class)
+	assert.Equal(ClassPermanent, (<-all.Events()).Class)
+
+	event := <-sub.Events()
+	assert.Equal("id-2", event.ExternalId)
+	assert.Len(sub.Events(), 0)
+}
+
+
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+// TESTING RATE LIMIT PAUSES (rateLimit.go)
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+func TestSetRateLimitPause_PausesOnlyTheRateLimitedAction(t *testing.T) {
+	assert := assert.New(t)
+	q := Init(10, "ratelimit", 1)
+	q.Start()
+	q.SetRateLimitPause("api", true)
+
+	var apiCalls, otherCalls int32
+	api := func(params map[string]interface{}) error {
+		if atomic.AddInt32(&apiCalls, 1) == 1 {
+			return RateLimited(errors.New("429"), 100 * time.Millisecond)
+		}
+		return nil
+	}
+	other := func(params map[string]interface{}) error {
+		atomic.AddInt32(&otherCalls, 1)
+		return nil
+	}
+
+	assert.NoError(q.AddNamed("api", api, map[string]interface{}{}, "api-1"))
+	assert.NoError(q.AddNamed("api", api, map[string]interface{}{}, "api-2"))
+	assert.NoError(q.AddNamed("other", other, map[string]interface{}{}, "other-1"))
+
+	// api-2 is parked while the action is paused, other-1 still runs
+	assert.Eventually(func() bool {
+		return atomic.LoadInt32(&otherCalls) == 1
+	}, time.Second, time.Millisecond)
+	assert.Equal(int32(1), atomic.LoadInt32(&apiCalls))
+	assert.Equal([]string{"api-2"}, q.ParkedTasks("api"))
+
+	_, paused := q.RateLimitedUntil("api")
+	assert.True(paused)
+
+	// dispatching resumes once the retry-after passed
+	assert.Eventually(func() bool {
+		return atomic.LoadInt32(&apiCalls) == 2
+	}, time.Second, 10 * time.Millisecond)
+	assert.Empty(q.ParkedTasks("api"))
+
+	_, paused = q.RateLimitedUntil("api")
+	assert.False(paused)
+}
+
+
+func TestSetRateLimitPause_DisabledByDefault(t *testing.T) {
+	assert := assert.New(t)
+	q := Init(10, "ratelimit", 1)
+	q.Start()
+
+	var calls int32
+	api := func(params map[string]interface{}) error {
+		atomic.AddInt32(&calls, 1)
+		return RateLimited(errors.New("429"), time.Hour)
+	}
+
+	assert.NoError(q.AddNamed("api", api, map[string]interface{}{}, "api-1"))
+	assert.NoError(q.AddNamed("api", api, map[string]interface{}{}, "api-2"))
+
+	assert.Eventually(func() bool {
+		return atomic.LoadInt32(&calls) == 2
+	}, time.Second, time.Millisecond)
+
+	_, paused := q.RateLimitedUntil("api")
+	assert.False(paused)
+}
+
+
+func TestSetRateLimitPause_WithoutRetryAfter(t *testing.T) {
+	assert := assert.New(t)
+	q := Init(10, "ratelimit", 1)
+	q.Start()
+	q.SetRateLimitPause("api", true)
+
+	api := func(params map[string]interface{}) error {
+		return RateLimited(errors.New("429"), 0)
+	}
+
+	start := time.Now()
+	assert.NoError(q.AddNamed("api", api, map[string]interface{}{}, "api-1"))
+
+	// the action is paused for at least a second, though the downstream didn't say how long
+	assert.Eventually(func() bool {
+		_, paused := q.RateLimitedUntil("api")
+		return paused
+	}, time.Second, time.Millisecond)
+
+	until, _ := q.RateLimitedUntil("api")
+	assert.GreaterOrEqual(until.Sub(start), minRateLimitWait)
+}
+
+
+func TestSetRateLimitPause_ParkedTasksKeepTheirSlot(t *testing.T) {
+	assert := assert.New(t)
+	q := Init(2, "ratelimit", 1)
+	q.Start()
+	q.SetRateLimitPause("api", true)
+
+	var calls int32
+	api := func(params map[string]interface{}) error {
+		atomic.AddInt32(&calls, 1)
+		return RateLimited(errors.New("429"), time.Hour)
+	}
+
+	assert.NoError(q.AddNamed("api", api, map[string]interface{}{}, "api-1"))
+	assert.Eventually(func() bool {
+		_, paused := q.RateLimitedUntil("api")
+		return paused
+	}, time.Second, time.Millisecond)
+
+	assert.NoError(q.AddNamed("api", api, map[string]interface{}{}, "api-2"))
+	assert.NoError(q.AddNamed("api", api, map[string]interface{}{}, "api-3"))
+	assert.Equal([]string{"api-2", "api-3"}, q.ParkedTasks("api"))
+
+	// the parked tasks take up both slots
+	assert.ErrorIs(q.AddNamed("api", api, map[string]interface{}{}, "api-4"), ErrQueueFull)
+	assert.Equal(2, q.Stats().Waiting)
+	assert.Equal(int32(1), atomic.LoadInt32(&calls))
+}
+
+
+func TestPartialFailure_ReportsFailedItems(t *testing.T) {
+	assert := assert.New(t)
+	q := Init(5, "batch", 1)
+	q.Start()
+
+	sub := q.Subscribe(10, DropOldest)
+	defer sub.Close()
+
+	batch := func(params map[string]interface{}) error {
+		return &PartialFailure{Failed: map[string]error{
+			"item-3": Retryable(errors.New("timeout")),
+			"item-1": Permanent(errors.New("bad input")),
+		}}
+	}
+
+	assert.NoError(q.Add(batch, map[string]interface{}{}, "batch-1"))
+
+	event := <-sub.Events()
+	assert.Equal(EventFailed, event.Type)
+	assert.EqualError(event.Err, "2 items failed: item-1, item-3")
+	assert.Len(event.FailedItems, 2)
+	assert.Equal(ClassRetryable, ClassOf(event.FailedItems["item-3"]))
+	assert.Equal(ClassPermanent, ClassOf(event.FailedItems["item-1"]))
+
+	_, ok := FailedItems(errors.New("boom"))
+	assert.False(ok)
+}
+
+
+func TestPartialFailure_RetriesAndDeadLettersOnlyFailedItems(t *testing.T) {
+	assert := assert.New(t)
+	q := Init(5, "batch", 1)
+	q.SetRetryPolicy(RetryPolicy{MaxAttempts: 2})
+	q.SetDeadLetters(10)
+	q.Start()
+	defer q.Stop()
+
+	runs := make(chan []string, 2)
+	batch := func(params map[string]interface{}) error {
+		items := params[BatchItemsParam].(map[string]interface{})
+		keys := []string{}
+		for key := range items {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		runs <- keys
+
+		failed := map[string]error{"b": Retryable(errors.New("timeout"))}
+		if _, ok := items["c"]; ok {
+			failed["c"] = Permanent(errors.New("bad input"))
+		}
+
+		return &PartialFailure{Failed: failed}
+	}
+
+	params := map[string]interface{}{"to": "x", BatchItemsParam: map[string]interface{}{"a": 1, "b": 2, "c": 3}}
+	var handle *TaskHandle
+	err := q.Add(batch, params, "batch-1", WithHandle(&handle))
+	assert.NoError(err)
+	assert.EqualError(handle.Wait(context.Background()), "1 items failed: b")
+
+	// the retry only runs the item that failed and may succeed
+	assert.Equal([]string{"a", "b", "c"}, <-runs)
+	assert.Equal([]string{"b"}, <-runs)
+
+	letters := q.DeadLetters()
+	assert.Len(letters, 2)
+
+	assert.Equal("batch-1/c", letters[0].ExternalId)
+	assert.Equal(map[string]interface{}{"c": 3}, letters[0].Params[BatchItemsParam])
+	assert.Equal("x", letters[0].Params["to"])
+	assert.EqualError(letters[0].Err, "bad input")
+
+	assert.Equal("batch-1", letters[1].ExternalId)
+	assert.Equal(map[string]interface{}{"b": 2}, letters[1].Params[BatchItemsParam])
+	assert.Equal(2, letters[1].Attempts)
+
+	// the caller's params aren't changed
+	assert.Len(params[BatchItemsParam], 3)
+}
+
+
+func TestPartialFailure_Class(t *testing.T) {
+	assert := assert.New(t)
+
+	permanent := &PartialFailure{Failed: map[string]error{"a": Permanent(errors.New("bad")), "b": Cancelled(errors.New("stop"))}}
+	assert.Equal(ClassPermanent, ClassOf(permanent))
+
+	mixed := &PartialFailure{Failed: map[string]error{"a": Permanent(errors.New("bad")), "b": Retryable(errors.New("timeout"))}}
+	assert.Equal(ClassRetryable, ClassOf(mixed))
+
+	plain := &PartialFailure{Failed: map[string]error{"a": errors.New("boom")}}
+	assert.Equal(ClassUnclassified, ClassOf(plain))
+
+	// a marked partial failure keeps its mark
+	assert.Equal(ClassPermanent, ClassOf(Permanent(mixed)))
+}
+
+
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+// TESTING DEADLINES (deadline.go)
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+func TestAddWithDeadline_ContextCarriesDeadline(t *testing.T) {
+	assert := assert.New(t)
+	q := Init(5, "deadline", 1)
+	q.Start()
+
+	deadline := time.Now().Add(time.Hour)
+	seen := make(chan time.Time, 1)
+	action := func(ctx context.Context, params map[string]interface{}) error {
+		d, _ := ctx.Deadline()
+		seen <- d
+		return nil
+	}
+
+	assert.NoError(q.AddWithDeadline(action, map[string]interface{}{}, "id-1", deadline))
+	assert.True(deadline.Equal(<-seen))
+}
+
+
+func TestAddWithDeadline_ExpiredWhileWaiting(t *testing.T) {
+	assert := assert.New(t)
+	q := Init(5, "deadline", 1)
+	q.Start()
+
+	blocker := make(chan struct{})
+	blocking := func(params map[string]interface{}) error {
+		<-blocker
+		return nil
+	}
+
+	errs := make(chan error, 1)
+	action := func(ctx context.Context, params map[string]interface{}) error {
+		errs <- ctx.Err()
+		return ctx.Err()
+	}
+
+	assert.NoError(q.Add(blocking, map[string]interface{}{}, "id-1"))
+	assert.NoError(q.AddWithDeadline(action, map[string]interface{}{}, "id-2", time.Now().Add(20 * time.Millisecond)))
+	assert.False(q.SnapshotView().Waiting[0].Deadline.IsZero())
+
+	time.Sleep(50 * time.Millisecond)
+	close(blocker)
+
+	assert.ErrorIs(<-errs, context.DeadlineExceeded)
+}
+
+
+func TestAddWithDeadline_ZeroDeadlineMeansNone(t *testing.T) {
+	assert := assert.New(t)
+	q := Init(5, "deadline", 1)
+	q.Start()
+
+	seen := make(chan bool, 1)
+	action := func(ctx context.Context, params map[string]interface{}) error {
+		_, ok := ctx.Deadline()
+		seen <- ok
+		return nil
+	}
+
+	assert.NoError(q.AddWithDeadline(action, map[string]interface{}{}, "id-1", time.Time{}))
+	assert.False(<-seen)
+}
+
+
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+// TESTING SUBMISSION CONTEXT (submit.go)
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+func TestAddContext_DoneContextIsNotAdded(t *testing.T) {
+	assert := assert.New(t)
+	q := Init(5, "submit", 0)
+	q.Start()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := q.AddContext(ctx, noop, map[string]interface{}{}, "id-1")
+	assert.ErrorIs(err, context.Canceled)
+	assert.Empty(q.SnapshotView().Waiting)
+}
+
+
+func TestAddContext_CancellingAfterAddDoesNotAffectTask(t *testing.T) {
+	assert := assert.New(t)
+	q := Init(5, "submit", 1)
+	q.Start()
+
+	blocker := make(chan struct{})
+	done := make(chan struct{})
+	action := func(params map[string]interface{}) error {
+		<-blocker
+		close(done)
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	assert.NoError(q.AddContext(ctx, action, map[string]interface{}{}, "id-1"))
+	cancel()
+	close(blocker)
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		assert.Fail("task should run to completion")
+	}
+}
+
+
+func TestAddWait_BlocksUntilSlotFreesUp(t *testing.T) {
+	assert := assert.New(t)
+	q := Init(1, "submit", 1)
+	q.Start()
+	defer q.Stop()
+
+	release := make(chan struct{})
+	assert.NoError(q.Add(func(params map[string]interface{}) error {
+		<-release
+		return nil
+	}, map[string]interface{}{}, "running"))
+	assert.NoError(q.Add(noop, map[string]interface{}{}, "1"))
+	assert.Error(q.Add(noop, map[string]interface{}{}, "2"), "the queue is full")
+
+	added := make(chan error)
+	go func() { added <- q.AddWait(context.Background(), noop, map[string]interface{}{}, "2") }()
+
+	select {
+	case <-added:
+		assert.Fail("AddWait should block while the queue is full")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// dispatching "1" frees its slot
+	close(release)
+	select {
+	case err := <-added:
+		assert.NoError(err)
+	case <-time.After(time.Second):
+		assert.Fail("AddWait should return once a slot frees up")
+	}
+
+	assert.Eventually(func() bool { return q.Stats().Completed == 3 }, time.Second, 10 * time.Millisecond)
+}
+
+
+func TestAddWait_CancelAndContext(t *testing.T) {
+	assert := assert.New(t)
+	q := Init(1, "submit", 1)
+	q.Pause()
+	q.Start()
+	defer q.Stop()
+
+	assert.NoError(q.Add(noop, map[string]interface{}{}, "1"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20 * time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(q.AddWait(ctx, noop, map[string]interface{}{}, "2"), context.DeadlineExceeded)
+
+	// errors other than a full queue aren't waited out
+	assert.Error(q.AddWait(context.Background(), noop, map[string]interface{}{}, "1"))
+
+	added := make(chan error)
+	go func() { added <- q.AddWait(context.Background(), noop, map[string]interface{}{}, "2") }()
+	time.Sleep(20 * time.Millisecond)
+	assert.NoError(q.Cancel("1"))
+	assert.NoError(<-added)
+
+	// stopping the queue wakes waiting adds, they fail
+	go func() { added <- q.AddWait(context.Background(), noop, map[string]interface{}{}, "3") }()
+	time.Sleep(20 * time.Millisecond)
+	q.Stop()
+	assert.Error(<-added)
+}
+
+
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+// TESTING ENVIRONMENT (env.go)
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+type testEnv struct {
+	greeting string
+}
+
+
+func TestSetEnv_ActionsGetEnvFromContext(t *testing.T) {
+	assert := assert.New(t)
+	q := Init(5, "env", 1)
+	q.SetEnv(&testEnv{greeting: "hello"})
+	q.Start()
+
+	seen := make(chan string, 1)
+	action := func(ctx context.Context, params map[string]interface{}) error {
+		env, ok := Env[*testEnv](ctx)
+		if !ok {
+			return errors.New("no env")
+		}
+		seen <- env.greeting
+		return nil
+	}
+
+	assert.NoError(q.AddWithDeadline(action, map[string]interface{}{}, "id-1", time.Time{}))
+	assert.Equal("hello", <-seen)
+}
+
+
+func TestEnv_WrongTypeOrMissing(t *testing.T) {
+	assert := assert.New(t)
+
+	_, ok := Env[*testEnv](context.Background())
+	assert.False(ok)
+
+	ctx := context.WithValue(context.Background(), envKey{}, "not an env")
+	_, ok = Env[*testEnv](ctx)
+	assert.False(ok)
+}
+
+
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+// TESTING TEMP DIRS (tempDir.go)
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+func TestSetTempDirs_CreatesAndRemovesScratchDir(t *testing.T) {
+	assert := assert.New(t)
+	parent := t.TempDir()
+	q := Init(5, "tempdirs", 1)
+	q.SetTempDirs(parent)
+	q.Start()
+
+	sub := q.Subscribe(10, DropOldest)
+	defer sub.Close()
+
+	dirs := make(chan string, 1)
+	action := func(ctx context.Context, params map[string]interface{}) error {
+		dir, ok := TempDir(ctx)
+		if !ok {
+			return errors.New("no temp dir")
+		}
+		dirs <- dir
+		return os.WriteFile(dir + "/out.txt", []byte("scratch"), 0600)
+	}
+
+	assert.NoError(q.AddWithDeadline(action, map[string]interface{}{}, "id-1", time.Time{}))
+	dir := <-dirs
+	assert.True(strings.HasPrefix(dir, parent))
+
+	event := <-sub.Events()
+	assert.Equal(EventCompleted, event.Type)
+
+	_, err := os.Stat(dir)
+	assert.True(os.IsNotExist(err), "the scratch dir should be removed once the action returned")
+}
+
+
+func TestSetTempDirs_FailsTaskWhenDirCantBeCreated(t *testing.T) {
+	assert := assert.New(t)
+	q := Init(5, "tempdirs", 1)
+	q.SetTempDirs(t.TempDir() + "/missing/parent")
+	q.Start()
+
+	sub := q.Subscribe(10, DropOldest)
+	defer sub.Close()
+
+	var ran int32
+	action := func(params map[string]interface{}) error {
+		atomic.AddInt32(&ran, 1)
+		return nil
+	}
+
+	assert.NoError(q.Add(action, map[string]interface{}{}, "id-1"))
+	event := <-sub.Events()
+	assert.Equal(EventFailed, event.Type)
+	assert.Contains(event.Err.Error(), "Temp dir for task id-1 can't be created")
+	assert.Equal(int32(0), atomic.LoadInt32(&ran))
+
+	q.ClearTempDirs()
+	assert.NoError(q.Add(action, map[string]interface{}{}, "id-2"))
+	assert.Equal(EventCompleted, (<-sub.Events()).Type)
+}
+
+
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+// TESTING EXEC ACTION (execAction.go)
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+func TestRegisterExecAction_RunsCommandWithArgsAndEnv(t *testing.T) {
+	assert := assert.New(t)
+	out := t.TempDir() + "/out.txt"
+	q := Init(5, "exec", 1)
+	q.Start()
+
+	assert.NoError(q.RegisterExecAction("write", "sh", "-c", `echo "$GREETING $1" > "$2"`, "sh"))
+
+	sub := q.Subscribe(10, DropOldest)
+	defer sub.Close()
+
+	params := map[string]interface{}{
+		"args": []interface{}{"world", out},
+		"env": map[string]interface{}{"GREETING": "hello"},
+	}
+	assert.NoError(q.AddByName("write", params, "id-1"))
+	assert.Equal(EventCompleted, (<-sub.Events()).Type)
+
+	content, err := os.ReadFile(out)
+	assert.NoError(err)
+	assert.Equal("hello world\n", string(content))
+}
+
+
+func TestRegisterExecAction_FailsWithExitCodeAndOutput(t *testing.T) {
+	assert := assert.New(t)
+	q := Init(5, "exec", 1)
+	q.Start()
+
+	assert.NoError(q.RegisterExecAction("fail", "sh", "-c", "echo broken >&2; exit 3"))
+	assert.NoError(q.RegisterExecAction("bad-args", "true"))
+
+	sub := q.Subscribe(10, DropOldest)
+	defer sub.Close()
+
+	assert.NoError(q.AddByName("fail", map[string]interface{}{}, "id-1"))
+	event := <-sub.Events()
+
+	var execErr *ExecError
+	assert.True(errors.As(event.Err, &execErr))
+	assert.Equal(3, execErr.ExitCode)
+	assert.EqualError(event.Err, "Command sh exited with code 3: broken")
+
+	assert.NoError(q.AddByName("bad-args", map[string]interface{}{"args": "not a list"}, "id-2"))
+	assert.EqualError((<-sub.Events()).Err, "Param args must be a list of strings, got string.")
+}
+
+
+func TestRegisterExecAction_OutputIsTheResult(t *testing.T) {
+	assert := assert.New(t)
+	q := Init(5, "exec", 1)
+	q.SetHistory(NewHistory(10))
+	q.Start()
+
+	results := make(chan []Event, 1)
+	q.SetResultSink(ResultSinkFunc(func(batch []Event) error {
+		results <- batch
+		return nil
+	}), ResultSinkOptions{BatchSize: 1})
+
+	assert.NoError(q.RegisterExecAction("echo", "sh", "-c", "echo out; echo err >&2"))
+	assert.NoError(q.AddByName("echo", map[string]interface{}{}, "id-1"))
+
+	batch := <-results
+	assert.Equal(EventCompleted, batch[0].Type)
+	assert.Equal(ExecResult{Command: "sh", Output: "out\nerr\n"}, batch[0].Result)
+
+	last, ok := q.history.Last("id-1")
+	assert.True(ok)
+	assert.Equal(batch[0].Result, last.Result)
+}
+
+
+func TestRegisterExecAction_KillsCommandWhenAbandoned(t *testing.T) {
+	assert := assert.New(t)
+	q := Init(5, "exec", 1)
+	q.SetAbandonTimeout(50 * time.Millisecond)
+	q.SetAbandonGrace(5 * time.Second)
+	q.Start()
+
+	assert.NoError(q.RegisterExecAction("sleep", "sleep", "10"))
+
+	sub := q.Subscribe(10, DropOldest)
+	defer sub.Close()
+
+	start := time.Now()
+	assert.NoError(q.AddByName("sleep", map[string]interface{}{}, "id-1"))
+
+	// the command is killed at the timeout, so the task ends before the grace period is up
+	event := <-sub.Events()
+	assert.Equal(EventFailed, event.Type)
+	assert.ErrorIs(event.Err, context.Canceled)
+	var execErr *ExecError
+	assert.True(errors.As(event.Err, &execErr))
+	assert.Less(time.Since(start), 5 * time.Second)
+	assert.Empty(q.AbandonedTasks())
+}
+
+
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+// TESTING HTTP ACTION (httpAction.go)
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+func TestRegisterHTTPAction_MakesDescribedRequest(t *testing.T) {
+	assert := assert.New(t)
+
+	requests := make(chan *http.Request, 1)
+	bodies := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := new(strings.Builder)
+		_, _ = io.Copy(body, r.Body)
+		requests <- r
+		bodies <- body.String()
+		w.Header().Set("X-Id", "7")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"id":7}`))
+	}))
+	defer server.Close()
+
+	q := Init(5, "http", 1)
+	q.Start()
+	assert.NoError(q.RegisterHTTPAction("call", nil))
+
+	sub := q.Subscribe(10, DropOldest)
+	defer sub.Close()
+
+	params := map[string]interface{}{
+		"method": "POST",
+		"url": server.URL + "/users/{{.id}}",
+		"id": 42,
+		"headers": map[string]interface{}{"X-Token": "secret"},
+		"body": `{"name":"ada"}`,
+	}
+	assert.NoError(q.AddByName("call", params, "id-1"))
+
+	event := <-sub.Events()
+	assert.Equal(EventCompleted, event.Type)
+	result, ok := event.Result.(HTTPResult)
+	assert.True(ok)
+	assert.Equal(http.StatusCreated, result.StatusCode)
+	assert.Equal("7", result.Header.Get("X-Id"))
+	assert.Equal(`{"id":7}`, string(result.Body))
+
+	r := <-requests
+	assert.Equal("POST", r.Method)
+	assert.Equal("/users/42", r.URL.Path)
+	assert.Equal("secret", r.Header.Get("X-Token"))
+	assert.Equal(`{"name":"ada"}`, <-bodies)
+}
+
+
+func TestRegisterHTTPAction_EscapesURLParams(t *testing.T) {
+	assert := assert.New(t)
+
+	requests := make(chan *http.Request, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests <- r
+	}))
+	defer server.Close()
+
+	q := Init(5, "http", 1)
+	q.Start()
+	assert.NoError(q.RegisterHTTPAction("call", nil))
+
+	sub := q.Subscribe(10, DropOldest)
+	defer sub.Close()
+
+	params := map[string]interface{}{
+		"url": server.URL + "/users/{{.id}}/items?name={{.name}}&page={{if .page}}{{.page}}{{end}}",
+		"id": "../admin?x=1#",
+		"name": "a&b=c d",
+		"page": 2,
+	}
+	assert.NoError(q.AddByName("call", params, "id-1"))
+	event := <-sub.Events()
+	assert.Equal(EventCompleted, event.Type, event.Err)
+
+	r := <-requests
+	assert.Equal("/users/..%2Fadmin%3Fx=1%23/items", r.URL.RawPath)
+	assert.Equal("/users/../admin?x=1#/items", r.URL.Path)
+	assert.Equal("a&b=c d", r.URL.Query().Get("name"))
+	assert.Equal("2", r.URL.Query().Get("page"))
+	assert.Len(r.URL.Query(), 2)
+}
+
+
+func TestRegisterHTTPAction_CancelledWhenQueueStops(t *testing.T) {
+	assert := assert.New(t)
+
+	arrived := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(arrived)
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	q := Init(5, "http", 1)
+	q.Start()
+	assert.NoError(q.RegisterHTTPAction("call", nil))
+
+	sub := q.Subscribe(10, DropOldest)
+	defer sub.Close()
+
+	assert.NoError(q.AddByName("call", map[string]interface{}{"url": server.URL}, "id-1"))
+	<-arrived
+	q.Stop()
+
+	event := <-sub.Events()
+	assert.Equal(EventFailed, event.Type)
+	assert.Equal(ReasonQueueShutdown, event.Reason)
+	assert.Equal(ClassCancelled, event.Class)
+}
+
+
+func TestRegisterHTTPAction_ClassifiesFailures(t *testing.T) {
+	assert := assert.New(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status, _ := strconv.Atoi(r.URL.Query().Get("status"))
+		if status == http.StatusTooManyRequests {
+			w.Header().Set("Retry-After", "7")
+		}
+		w.WriteHeader(status)
+		w.Write([]byte("nope"))
+	}))
+	defer server.Close()
+
+	q := Init(5, "http", 1)
+	q.Start()
+	assert.NoError(q.RegisterHTTPAction("call", server.Client()))
+
+	sub := q.Subscribe(10, DropOldest)
+	defer sub.Close()
+
+	for _, status := range []int{429, 500, 404} {
+		params := map[string]interface{}{"url": fmt.Sprintf("%s/?status=%d", server.URL, status)}
+		assert.NoError(q.AddByName("call", params, strconv.Itoa(status)))
+	}
+
+	rateLimited := <-sub.Events()
+	assert.Equal(ClassRateLimited, rateLimited.Class)
+	after, _ := RetryAfter(rateLimited.Err)
+	assert.Equal(7 * time.Second, after)
+
+	var httpErr *HTTPError
+	assert.True(errors.As(rateLimited.Err, &httpErr))
+	assert.Equal(429, httpErr.StatusCode)
+	assert.Equal("nope", httpErr.Body)
+
+	assert.Equal(ClassRetryable, (<-sub.Events()).Class)
+	assert.Equal(ClassPermanent, (<-sub.Events()).Class)
+
+	// a url template referring to a missing param fails permanently
+	assert.NoError(q.AddByName("call", map[string]interface{}{"url": server.URL + "/{{.missing}}"}, "bad"))
+	assert.Equal(ClassPermanent, (<-sub.Events()).Class)
+}
+
+
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+// TESTING PARAM TEMPLATES (paramTemplate.go)
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+func TestSetParamTemplates_ExpandsAtDispatch(t *testing.T) {
+	assert := assert.New(t)
+	t.Setenv("FSQ_TEST_REGION", "eu-west")
+
+	q := Init(5, "templates", 1)
+	q.SetClock(NewFakeClock(time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)))
+	q.SetParamTemplates(true)
+	q.SetTemplateEnv("FSQ_TEST_REGION")
+	q.Start()
+
+	seen := make(chan map[string]interface{}, 1)
+	action := func(params map[string]interface{}) error {
+		seen <- params
+		return nil
+	}
+
+	params := map[string]interface{}{
+		"file": `report-{{.Task.Now.Format "2006-01-02"}}-{{.Task.ExternalId}}.csv`,
+		"region": "{{.Env.FSQ_TEST_REGION}}",
+		"user": "user-{{.id}}",
+		"id": 7,
+		"plain": "no template",
+	}
+	assert.NoError(q.AddNamed("report", action, params, "daily"))
+
+	expanded := <-seen
+	assert.Equal("report-2024-03-01-daily.csv", expanded["file"])
+	assert.Equal("eu-west", expanded["region"])
+	assert.Equal("user-7", expanded["user"])
+	assert.Equal(7, expanded["id"])
+	assert.Equal("no template", expanded["plain"])
+
+	// the caller's map is left as it was
+	assert.Equal("user-{{.id}}", params["user"])
+}
+
+
+func TestSetParamTemplates_BadTemplateFailsTask(t *testing.T) {
+	assert := assert.New(t)
+	q := Init(5, "templates", 1)
+	q.SetParamTemplates(true)
+	q.Start()
+
+	sub := q.Subscribe(10, DropOldest)
+	defer sub.Close()
+
+	var ran int32
+	action := func(params map[string]interface{}) error {
+		atomic.AddInt32(&ran, 1)
+		return nil
+	}
+
+	assert.NoError(q.Add(action, map[string]interface{}{"name": "{{.missing}}"}, "id-1"))
+
+	event := <-sub.Events()
+	assert.Equal(ClassPermanent, event.Class)
+	assert.Contains(event.Err.Error(), "Param name of task id-1")
+	assert.Equal(int32(0), atomic.LoadInt32(&ran))
+}
+
+
+func TestSetParamTemplates_OnlyAllowedEnv(t *testing.T) {
+	assert := assert.New(t)
+	t.Setenv("FSQ_TEST_REGION", "eu-west")
+	t.Setenv("FSQ_TEST_SECRET", "hunter2")
+
+	q := Init(5, "templates", 1)
+	q.SetParamTemplates(true)
+	q.SetTemplateEnv("FSQ_TEST_REGION")
+	q.Start()
+
+	sub := q.Subscribe(10, DropOldest)
+	defer sub.Close()
+
+	assert.NoError(q.Add(noop, map[string]interface{}{"key": "{{.Env.FSQ_TEST_SECRET}}"}, "id-1"))
+
+	event := <-sub.Events()
+	assert.Equal(ClassPermanent, event.Class)
+	assert.NotContains(event.Err.Error(), "hunter2")
+}
+
+
+func TestSetParamTemplates_RetriesKeepTemplates(t *testing.T) {
+	assert := assert.New(t)
+	q := Init(5, "templates", 1)
+	q.SetParamTemplates(true)
+	q.SetRetryPolicy(RetryPolicy{MaxAttempts: 2})
+	q.SetDeadLetters(5)
+	q.Start()
+
+	seen := make(chan interface{}, 2)
+	action := func(params map[string]interface{}) error {
+		seen <- params["run"]
+		return errors.New("down")
+	}
+
+	params := map[string]interface{}{"run": "{{.Task.ExternalId}}"}
+	var handle *TaskHandle
+	err := q.Add(action, params, "id-1", WithHandle(&handle))
+	assert.NoError(err)
+	assert.Error(handle.Wait(context.Background()))
+
+	assert.Equal("id-1", <-seen)
+	assert.Equal("id-1", <-seen)
+
+	letters := q.DeadLetters()
+	assert.Len(letters, 1)
+	assert.Equal("{{.Task.ExternalId}}", letters[0].Params["run"])
+	assert.Equal("{{.Task.ExternalId}}", params["run"])
+}
+
+
+func TestSetParamTemplates_DisabledByDefault(t *testing.T) {
+	assert := assert.New(t)
+	q := Init(5, "templates", 1)
+	q.Start()
+
+	seen := make(chan interface{}, 1)
+	action := func(params map[string]interface{}) error {
+		seen <- params["name"]
+		return nil
+	}
+
+	assert.NoError(q.Add(action, map[string]interface{}{"name": "{{.missing}}"}, "id-1"))
+	assert.Equal("{{.missing}}", <-seen)
+}
+
+
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+// TESTING SCHEDULES (schedule.go)
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+func loadLocation(t *testing.T, name string) *time.Location {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Skipf("time zone %s is not available: %s", name, err)
+	}
+
+	return loc
+}
+
+
+func TestSchedule_NextRunsKeepLocalTimeAcrossDST(t *testing.T) {
+	assert := assert.New(t)
+	loc := loadLocation(t, "America/New_York")
+
+	s := Schedule{Times: []time.Duration{9 * time.Hour}, Location: loc}
+	runs := s.NextRuns(time.Date(2024, 3, 9, 12, 0, 0, 0, loc), 3)
+
+	assert.Equal([]time.Time{
+		time.Date(2024, 3, 10, 9, 0, 0, 0, loc),
+		time.Date(2024, 3, 11, 9, 0, 0, 0, loc),
+		time.Date(2024, 3, 12, 9, 0, 0, 0, loc),
+	}, runs)
+
+	// the day of the change is 23 hours long
+	assert.Equal(23 * time.Hour, runs[0].Sub(time.Date(2024, 3, 9, 9, 0, 0, 0, loc)))
+}
+
+
+func TestSchedule_SkippedTimeFiresAfterChange(t *testing.T) {
+	assert := assert.New(t)
+	loc := loadLocation(t, "America/New_York")
+
+	s := Schedule{Times: []time.Duration{2 * time.Hour + 30 * time.Minute}, Location: loc}
+	next := s.Next(time.Date(2024, 3, 10, 0, 0, 0, 0, loc))
+
+	assert.Equal(time.Date(2024, 3, 10, 3, 30, 0, 0, loc), next)
+}
+
+
+func TestSchedule_RepeatedTimeFiresOnce(t *testing.T) {
+	assert := assert.New(t)
+	loc := loadLocation(t, "America/New_York")
+
+	s := Schedule{Times: []time.Duration{time.Hour + 30 * time.Minute}, Location: loc}
+	runs := s.NextRuns(time.Date(2024, 11, 3, 0, 0, 0, 0, loc), 2)
+
+	assert.Equal(3, runs[0].Day())
+	assert.Equal(4, runs[1].Day())
+}
+
+
+func TestSchedule_NextOnlyOnDays(t *testing.T) {
+	assert := assert.New(t)
+
+	s := Schedule{
+		Days: []time.Weekday{time.Monday, time.Friday},
+		Times: []time.Duration{18 * time.Hour, 6 * time.Hour},
+		Location: time.UTC,
+	}
+
+	// Tuesday
+	runs := s.NextRuns(time.Date(2024, 1, 2, 12, 0, 0, 0, time.UTC), 3)
+
+	assert.Equal([]time.Time{
+		time.Date(2024, 1, 5, 6, 0, 0, 0, time.UTC),
+		time.Date(2024, 1, 5, 18, 0, 0, 0, time.UTC),
+		time.Date(2024, 1, 8, 6, 0, 0, 0, time.UTC),
+	}, runs)
+}
+
+
+func TestAddSchedule_Validates(t *testing.T) {
+	assert := assert.New(t)
+	q := Init(5, "schedules", 1)
+
+	assert.Error(q.AddSchedule("none", Schedule{}, noop, nil))
+	assert.Error(q.AddSchedule("late", Schedule{Times: []time.Duration{25 * time.Hour}}, noop, nil))
+	assert.Error(q.AddSchedule("nil", Schedule{Times: []time.Duration{time.Hour}}, nil, nil))
+
+	assert.NoError(q.AddSchedule("daily", Schedule{Times: []time.Duration{time.Hour}}, noop, nil))
+	assert.Error(q.AddSchedule("daily", Schedule{Times: []time.Duration{time.Hour}}, noop, nil))
+}
+
+
+func TestAddSchedule_AddsTaskAtFireTime(t *testing.T) {
+	assert := assert.New(t)
+
+	q := Init(5, "schedules", 1)
+	clock := NewFakeClock(time.Date(2024, 1, 1, 8, 59, 59, 950000000, time.UTC))
+	q.SetClock(clock)
+
+	sub := q.Subscribe(10, DropOldest)
+	defer sub.Close()
+
+	s := Schedule{Times: []time.Duration{9 * time.Hour}, Location: time.UTC}
+	assert.NoError(q.AddSchedule("report", s, noop, nil))
+	q.Start()
+	defer q.Stop()
+
+	event := <-sub.Events()
+	assert.Equal("report@2024-01-01T09:00:00Z", event.ExternalId)
+	assert.Equal("report", event.ActionName)
+	assert.Equal(EventCompleted, event.Type, "tasks get empty params for nil ones")
+
+	clock.Advance(time.Second)
+	runs, err := q.NextRuns("report", 1)
+	assert.NoError(err)
+	assert.Equal([]time.Time{time.Date(2024, 1, 2, 9, 0, 0, 0, time.UTC)}, runs)
+}
+
+
+func TestRemoveSchedule(t *testing.T) {
+	assert := assert.New(t)
+	q := Init(5, "schedules", 1)
+
+	assert.NoError(q.AddSchedule("daily", Schedule{Times: []time.Duration{time.Hour}}, noop, nil))
+	q.Start()
+	defer q.Stop()
+
+	assert.True(q.RemoveSchedule("daily"))
+	assert.False(q.RemoveSchedule("daily"))
+
+	_, err := q.NextRuns("daily", 1)
+	assert.Error(err)
+}
+
+
+
+func TestSchedule_JitterStaysBelowLimit(t *testing.T) {
+	assert := assert.New(t)
+
+	s := Schedule{Times: []time.Duration{time.Hour}, Jitter: 10 * time.Millisecond}
+	seen := map[time.Duration]bool{}
+
+	for i := 0; i < 100; i++ {
+		d := s.jitter()
+		assert.True(d >= 0 && d < s.Jitter)
+		seen[d] = true
+	}
+
+	assert.Greater(len(seen), 1)
+	assert.Equal(time.Duration(0), Schedule{}.jitter())
+	assert.Error(Init(5, "schedules", 1).AddSchedule("bad", Schedule{Times: []time.Duration{time.Hour}, Jitter: -time.Second}, noop, nil))
+}
+
+
+func TestSchedule_JitteredOccurrenceKeepsFireTime(t *testing.T) {
+	assert := assert.New(t)
+
+	q := Init(5, "schedules", 1)
+	q.SetClock(NewFakeClock(time.Date(2024, 1, 1, 8, 59, 59, 990000000, time.UTC)))
+
+	sub := q.Subscribe(10, DropOldest)
+	defer sub.Close()
+
+	s := Schedule{Times: []time.Duration{9 * time.Hour}, Location: time.UTC, Jitter: 50 * time.Millisecond}
+	q.AddSchedule("report", s, noop, nil)
+	q.Start()
+	defer q.Stop()
+
+	assert.Equal("report@2024-01-01T09:00:00Z", (<-sub.Events()).ExternalId)
+}
+
+
+func TestSchedule_EveryFiresOnMultiplesOfInterval(t *testing.T) {
+	assert := assert.New(t)
+
+	s := Schedule{Every: 15 * time.Minute}
+	runs := s.NextRuns(time.Date(2024, 1, 1, 9, 7, 0, 0, time.UTC), 3)
+
+	assert.Equal([]time.Time{
+		time.Date(2024, 1, 1, 9, 15, 0, 0, time.UTC),
+		time.Date(2024, 1, 1, 9, 30, 0, 0, time.UTC),
+		time.Date(2024, 1, 1, 9, 45, 0, 0, time.UTC),
+	}, runs)
+
+	// Friday evening, the weekend is skipped
+	weekdays := Schedule{Every: time.Hour, Days: []time.Weekday{time.Monday, time.Friday}, Location: time.UTC}
+	assert.Equal(time.Date(2024, 1, 8, 0, 0, 0, 0, time.UTC), weekdays.Next(time.Date(2024, 1, 5, 23, 30, 0, 0, time.UTC)))
+}
+
+
+func TestSchedule_NextOnMonthDays(t *testing.T) {
+	assert := assert.New(t)
+
+	s := Schedule{Times: []time.Duration{0}, MonthDays: []int{29}, Months: []time.Month{time.February}, Location: time.UTC}
+	assert.Equal(time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC), s.Next(time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)))
+
+	// either the 13th or a Friday
+	either := Schedule{Times: []time.Duration{0}, MonthDays: []int{13}, Days: []time.Weekday{time.Friday}, Location: time.UTC}
+	runs := either.NextRuns(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), 3)
+	assert.Equal([]int{5, 12, 13}, []int{runs[0].Day(), runs[1].Day(), runs[2].Day()})
+
+	never := Schedule{Times: []time.Duration{0}, MonthDays: []int{30}, Months: []time.Month{time.February}}
+	assert.True(never.Next(time.Now()).IsZero())
+}
+
+
+func TestAddSchedule_ValidatesIntervalsAndDays(t *testing.T) {
+	assert := assert.New(t)
+	q := Init(5, "schedules", 1)
+
+	assert.Error(q.AddSchedule("both", Schedule{Times: []time.Duration{time.Hour}, Every: time.Hour}, noop, nil))
+	assert.Error(q.AddSchedule("short", Schedule{Every: time.Millisecond}, noop, nil))
+	assert.Error(q.AddSchedule("negative", Schedule{Every: -time.Hour}, noop, nil))
+	assert.Error(q.AddSchedule("day", Schedule{Times: []time.Duration{time.Hour}, MonthDays: []int{32}}, noop, nil))
+	assert.Error(q.AddSchedule("month", Schedule{Times: []time.Duration{time.Hour}, Months: []time.Month{13}}, noop, nil))
+	assert.NoError(q.AddSchedule("hourly", Schedule{Every: time.Hour}, noop, nil))
+}
+
+
+func TestAddSchedule_EveryAddsTasks(t *testing.T) {
+	assert := assert.New(t)
+
+	q := Init(5, "schedules", 1)
+	q.SetClock(NewFakeClock(time.Date(2024, 1, 1, 8, 59, 59, 950000000, time.UTC)))
+
+	sub := q.Subscribe(10, DropOldest)
+	defer sub.Close()
+
+	assert.NoError(q.AddSchedule("poll", Schedule{Every: time.Minute}, noop, nil))
+	q.Start()
+	defer q.Stop()
+
+	assert.Equal("poll@2024-01-01T09:00:00Z", (<-sub.Events()).ExternalId)
+
+	status, err := q.ScheduleStatus("poll")
+	assert.NoError(err)
+	assert.Equal(time.Date(2024, 1, 1, 9, 1, 0, 0, time.UTC), status.Next)
+}
+
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+// TESTING SCHEDULE CATCH-UP (scheduleCatchUp.go)
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+func hourly(policy CatchUpPolicy) Schedule {
+	times := []time.Duration{}
+	for h := 0; h < 24; h++ {
+		times = append(times, time.Duration(h) * time.Hour)
+	}
+
+	return Schedule{Times: times, Location: time.UTC, CatchUp: policy}
+}
+
+
+func catchUpQueue(t *testing.T, policy CatchUpPolicy) (*FixedSizeQueue, *FileScheduleStore, chan string) {
+	q := Init(10, "catchup", 5)
+	q.SetClock(NewFakeClock(time.Date(2024, 1, 1, 8, 30, 0, 0, time.UTC)))
+
+	store := NewFileScheduleStore(t.TempDir() + "/schedules.json")
+	store.SetLastFired("hourly", time.Date(2024, 1, 1, 5, 0, 0, 0, time.UTC))
+	q.SetScheduleStore(store)
+
+	ids := make(chan string, 10)
+	sub := q.Subscribe(10, DropOldest)
+	t.Cleanup(sub.Close)
+	go func() {
+		for event := range sub.Events() {
+			ids <- event.ExternalId
+		}
+	}()
+
+	q.AddSchedule("hourly", hourly(policy), noop, nil)
+	return q, store, ids
+}
+
+
+func TestCatchUp_RunAllMissed(t *testing.T) {
+	assert := assert.New(t)
+	q, store, ids := catchUpQueue(t, RunAllMissed)
+	q.Start()
+	defer q.Stop()
+
+	got := map[string]bool{}
+	for i := 0; i < 3; i++ {
+		got[<-ids] = true
+	}
+
+	assert.Equal(map[string]bool{
+		"hourly@2024-01-01T06:00:00Z": true,
+		"hourly@2024-01-01T07:00:00Z": true,
+		"hourly@2024-01-01T08:00:00Z": true,
+	}, got)
+
+	last, ok, err := store.LastFired("hourly")
+	assert.NoError(err)
+	assert.True(ok)
+	assert.True(last.Equal(time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC)))
+}
+
+
+func TestCatchUp_RunOnceMissed(t *testing.T) {
+	assert := assert.New(t)
+	q, _, ids := catchUpQueue(t, RunOnceMissed)
+	q.Start()
+	defer q.Stop()
+
+	assert.Equal("hourly@2024-01-01T08:00:00Z", <-ids)
+
+	select {
+	case id := <-ids:
+		assert.Fail("unexpected catch-up", id)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+
+func TestCatchUp_SkipMissed(t *testing.T) {
+	assert := assert.New(t)
+	q, store, _ := catchUpQueue(t, SkipMissed)
+	q.Start()
+	defer q.Stop()
+
+	last, _, err := store.LastFired("hourly")
+	assert.NoError(err)
+	assert.True(last.Equal(time.Date(2024, 1, 1, 5, 0, 0, 0, time.UTC)))
+}
+
+
+func TestCatchUp_RemembersLastFireWithoutStore(t *testing.T) {
+	assert := assert.New(t)
+
+	q := Init(10, "catchup", 5)
+	clock := NewFakeClock(time.Date(2024, 1, 1, 8, 30, 0, 0, time.UTC))
+	q.SetClock(clock)
+	q.AddSchedule("hourly", hourly(RunOnceMissed), noop, nil)
+
+	// nothing to catch up on the first start
+	sub := q.Subscribe(10, DropOldest)
+	defer sub.Close()
+
+	q.Start()
+	q.addOccurrence(q.schedules["hourly"], time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC))
+	assert.Equal("hourly@2024-01-01T08:00:00Z", (<-sub.Events()).ExternalId)
+	q.Stop()
+
+	clock.Advance(3 * time.Hour)
+	q.Start()
+	defer q.Stop()
+
+	assert.Equal("hourly@2024-01-01T11:00:00Z", (<-sub.Events()).ExternalId)
+}
+
+
+func TestFileScheduleStore(t *testing.T) {
+	assert := assert.New(t)
+	path := t.TempDir() + "/schedules.json"
+
+	store := NewFileScheduleStore(path)
+	_, ok, err := store.LastFired("daily")
+	assert.NoError(err)
+	assert.False(ok)
+
+	at := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+	assert.NoError(store.SetLastFired("daily", at))
+
+	// a new store reads what the previous one wrote
+	last, ok, err := NewFileScheduleStore(path).LastFired("daily")
+	assert.NoError(err)
+	assert.True(ok)
+	assert.True(last.Equal(at))
+
+	os.WriteFile(path, []byte("not json"), 0600)
+	_, _, err = store.LastFired("daily")
+	assert.Error(err)
+}
+
+
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+// TESTING BLACKOUTS (blackout.go)
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+func TestBlackoutCalendar_Covering(t *testing.T) {
+	assert := assert.New(t)
+
+	c := BlackoutCalendar{Name: "holidays", Periods: []BlackoutPeriod{
+		BlackoutDay(2024, time.December, 25, time.UTC, "Christmas"),
+	}}
+
+	p, ok := c.Covering(time.Date(2024, 12, 25, 23, 59, 0, 0, time.UTC))
+	assert.True(ok)
+	assert.Equal("Christmas", p.Reason)
+
+	_, ok = c.Covering(time.Date(2024, 12, 26, 0, 0, 0, 0, time.UTC))
+	assert.False(ok)
+}
+
+
+func blackoutQueue(t *testing.T, policy BlackoutPolicy, period BlackoutPeriod) (*FixedSizeQueue, *Subscription) {
+	q := Init(10, "blackout", 5)
+	q.SetClock(NewFakeClock(time.Date(2024, 1, 1, 8, 59, 59, 950000000, time.UTC)))
+
+	s := hourly(SkipMissed)
+	s.Blackouts = []BlackoutCalendar{{Name: "holidays", Periods: []BlackoutPeriod{period}}}
+	s.Blackout = policy
+	q.AddSchedule("hourly", s, noop, nil)
+
+	sub := q.Subscribe(10, DropOldest)
+	t.Cleanup(sub.Close)
+
+	q.Start()
+	t.Cleanup(q.Stop)
+	return q, sub
+}
+
+
+func TestBlackout_SkipsOccurrence(t *testing.T) {
+	assert := assert.New(t)
+	q, sub := blackoutQueue(t, SkipBlackedOut, BlackoutDay(2024, time.January, 1, time.UTC, "New Year's Day"))
+
+	var status ScheduleStatus
+	assert.Eventually(func() bool {
+		status, _ = q.ScheduleStatus("hourly")
+		return status.LastBlackout != nil
+	}, time.Second, 10 * time.Millisecond)
+
+	assert.Equal(BlackoutDecision{
+		Occurrence: time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC),
+		Calendar: "holidays",
+		Reason: "New Year's Day",
+	}, *status.LastBlackout)
+	assert.Equal(time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC), status.Next)
+	assert.True(status.LastFired.IsZero())
+	assert.Empty(sub.Events())
+}
+
+
+func TestBlackout_DefersOccurrence(t *testing.T) {
+	assert := assert.New(t)
+
+	start := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+	end := start.Add(100 * time.Millisecond)
+	q, sub := blackoutQueue(t, DeferBlackedOut, BlackoutPeriod{Start: start, End: end, Reason: "freeze"})
+
+	// fires with its original fire time once the blackout ends
+	assert.Equal("hourly@2024-01-01T09:00:00Z", (<-sub.Events()).ExternalId)
+
+	status, err := q.ScheduleStatus("hourly")
+	assert.NoError(err)
+	assert.Equal(BlackoutDecision{
+		Occurrence: start,
+		Calendar: "holidays",
+		Reason: "freeze",
+		Deferred: true,
+		Until: end,
+	}, *status.LastBlackout)
+	assert.Equal(start, status.LastFired)
+}
+
+
+func TestBlackout_CatchUpSkipsBlackedOut(t *testing.T) {
+	assert := assert.New(t)
+
+	q := Init(10, "blackout", 5)
+	clock := NewFakeClock(time.Date(2024, 1, 1, 8, 30, 0, 0, time.UTC))
+	q.SetClock(clock)
+
+	s := hourly(RunAllMissed)
+	s.Blackouts = []BlackoutCalendar{{Name: "freeze", Periods: []BlackoutPeriod{{
+		Start: time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC),
+		End: time.Date(2024, 1, 1, 11, 0, 0, 0, time.UTC),
+	}}}}
+	q.AddSchedule("hourly", s, noop, nil)
+
+	sub := q.Subscribe(10, DropOldest)
+	defer sub.Close()
+
+	q.Start()
+	q.addOccurrence(q.schedules["hourly"], time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC))
+	assert.Equal("hourly@2024-01-01T08:00:00Z", (<-sub.Events()).ExternalId)
+	q.Stop()
+
+	clock.Advance(3 * time.Hour)
+	q.Start()
+	defer q.Stop()
+
+	got := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		got[(<-sub.Events()).ExternalId] = true
+	}
+
+	assert.Equal(map[string]bool{
+		"hourly@2024-01-01T09:00:00Z": true,
+		"hourly@2024-01-01T11:00:00Z": true,
+	}, got)
+}
+
+
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+// TESTING SCHEDULE MANAGEMENT (scheduleControl.go)
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+func TestSchedules_ListsByName(t *testing.T) {
+	assert := assert.New(t)
+	q := Init(5, "schedules", 1)
+	q.SetClock(NewFakeClock(time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC)))
+
+	q.AddSchedule("nightly", Schedule{Times: []time.Duration{2 * time.Hour}, Location: time.UTC}, noop, nil)
+	q.AddSchedule("hourly", hourly(SkipMissed), noop, nil)
+	q.Start()
+	defer q.Stop()
+
+	statuses := q.Schedules()
+	assert.Equal(2, len(statuses))
+	assert.Equal("hourly", statuses[0].Name)
+	assert.Equal(time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC), statuses[0].Next)
+	assert.Equal("nightly", statuses[1].Name)
+	assert.Equal(time.Date(2024, 1, 2, 2, 0, 0, 0, time.UTC), statuses[1].Next)
+}
+
+
+func TestPauseSchedule(t *testing.T) {
+	assert := assert.New(t)
+	q := Init(5, "schedules", 1)
+	clock := NewFakeClock(time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC))
+	q.SetClock(clock)
+
+	q.AddSchedule("hourly", hourly(RunAllMissed), noop, nil)
+	q.Start()
+	defer q.Stop()
+
+	assert.NoError(q.PauseSchedule("hourly"))
+	status, _ := q.ScheduleStatus("hourly")
+	assert.True(status.Paused)
+	assert.True(status.Next.IsZero())
+
+	// stays paused across a restart
+	q.Stop()
+	q.Start()
+	status, _ = q.ScheduleStatus("hourly")
+	assert.True(status.Next.IsZero())
+
+	clock.Advance(90 * time.Minute)
+	assert.NoError(q.ResumeSchedule("hourly"))
+	status, _ = q.ScheduleStatus("hourly")
+	assert.False(status.Paused)
+	assert.Equal(time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC), status.Next)
+
+	assert.ErrorIs(q.PauseSchedule("missing"), ErrNoSchedule)
+	assert.ErrorIs(q.ResumeSchedule("missing"), ErrNoSchedule)
+}
+
+
+func TestTriggerSchedule(t *testing.T) {
+	assert := assert.New(t)
+	q := Init(5, "schedules", 1)
+	q.SetClock(NewFakeClock(time.Date(2024, 1, 1, 8, 15, 0, 0, time.UTC)))
+
+	sub := q.Subscribe(10, DropOldest)
+	defer sub.Close()
+
+	q.AddSchedule("hourly", hourly(SkipMissed), noop, nil)
+	assert.Error(q.TriggerSchedule("hourly"), "the queue isn't running")
+
+	q.Start()
+	defer q.Stop()
+	q.PauseSchedule("hourly")
+
+	assert.NoError(q.TriggerSchedule("hourly"))
+	assert.Equal("hourly@2024-01-01T08:15:00Z", (<-sub.Events()).ExternalId)
+
+	status, _ := q.ScheduleStatus("hourly")
+	assert.Equal(time.Date(2024, 1, 1, 8, 15, 0, 0, time.UTC), status.LastFired)
+	assert.ErrorIs(q.TriggerSchedule("missing"), ErrNoSchedule)
+}
+
+
+func TestAdminHandler_Schedules(t *testing.T) {
+	assert := assert.New(t)
+	q := Init(5, "TestQueue", 2)
+	q.SetClock(NewFakeClock(time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC)))
+	q.AddSchedule("hourly", hourly(SkipMissed), noop, nil)
+	q.Start()
+	defer q.Stop()
+
+	h := NewAdminHandler(StaticTokens(adminTokens), q)
+
+	rec := adminRequest(h, "GET", "/queues/TestQueue/schedules", "view-token", "")
+	assert.Equal(http.StatusOK, rec.Code)
+	statuses := []ScheduleStatus{}
+	assert.NoError(json.Unmarshal(rec.Body.Bytes(), &statuses))
+	assert.Equal("hourly", statuses[0].Name)
+
+	assert.Equal(http.StatusNotFound, adminRequest(h, "GET", "/queues/TestQueue/schedules/missing", "view-token", "").Code)
+	assert.Equal(http.StatusForbidden, adminRequest(h, "POST", "/queues/TestQueue/schedules/hourly/pause", "view-token", "").Code)
+
+	rec = adminRequest(h, "POST", "/queues/TestQueue/schedules/hourly/pause", "oper-token", "")
+	assert.Equal(http.StatusOK, rec.Code)
+	status := ScheduleStatus{}
+	assert.NoError(json.Unmarshal(rec.Body.Bytes(), &status))
+	assert.True(status.Paused)
+
+	rec = adminRequest(h, "POST", "/queues/TestQueue/schedules/hourly/resume", "oper-token", "")
+	assert.Equal(http.StatusOK, rec.Code)
+	assert.False(q.Schedules()[0].Paused)
+
+	rec = adminRequest(h, "POST", "/queues/TestQueue/schedules/hourly/trigger", "oper-token", "")
+	assert.Equal(http.StatusOK, rec.Code)
+	assert.NoError(json.Unmarshal(rec.Body.Bytes(), &status))
+	assert.Equal(time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC), status.LastFired.UTC())
+
+	// a trigger whose task can't be added, here because the queue is stopped, is a conflict
+	q.Stop()
+	assert.Equal(http.StatusConflict, adminRequest(h, "POST", "/queues/TestQueue/schedules/hourly/trigger", "oper-token", "").Code)
+}
+
+
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+// TESTING SCHEDULE LEASES (scheduleLease.go)
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+func TestMemoryScheduleLeaser_ClaimsOnce(t *testing.T) {
+	assert := assert.New(t)
+	l := NewMemoryScheduleLeaser()
+	at := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+
+	claimed, err := l.Claim("hourly", at, "a")
+	assert.NoError(err)
+	assert.True(claimed)
+
+	claimed, _ = l.Claim("hourly", at, "b")
+	assert.False(claimed)
+
+	claimed, _ = l.Claim("nightly", at, "b")
+	assert.True(claimed)
+
+	claimed, _ = l.Claim("hourly", at.Add(time.Hour), "b")
+	assert.True(claimed)
+}
+
+
+func TestDirScheduleLeaser_ClaimsOnce(t *testing.T) {
+	assert := assert.New(t)
+	dir := t.TempDir() + "/leases"
+	at := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+
+	claimed, err := NewDirScheduleLeaser(dir).Claim("hourly/report", at, "worker-1")
+	assert.NoError(err)
+	assert.True(claimed)
+
+	// another instance sharing the directory
+	claimed, err = NewDirScheduleLeaser(dir).Claim("hourly/report", at, "worker-2")
+	assert.NoError(err)
+	assert.False(claimed)
+
+	entries, _ := os.ReadDir(dir)
+	assert.Equal(1, len(entries))
+	holder, _ := os.ReadFile(dir + "/" + entries[0].Name())
+	assert.Equal("worker-1", string(holder))
+
+	// claims from over a day before are pruned
+	claimed, _ = NewDirScheduleLeaser(dir).Claim("hourly/report", at.Add(25 * time.Hour), "worker-2")
+	assert.True(claimed)
+	entries, _ = os.ReadDir(dir)
+	assert.Equal(1, len(entries))
+}
+
+
+func TestSetScheduleLeaser_FiresOnOneInstance(t *testing.T) {
+	assert := assert.New(t)
+	leaser := NewMemoryScheduleLeaser()
+
+	var ran int32
+	action := func(params map[string]interface{}) error {
+		atomic.AddInt32(&ran, 1)
+		return nil
+	}
+
+	queues := []*FixedSizeQueue{}
+	for i := 0; i < 3; i++ {
+		q := Init(5, "instance", 1)
+		q.SetClock(NewFakeClock(time.Date(2024, 1, 1, 8, 59, 59, 950000000, time.UTC)))
+		q.SetScheduleLeaser(leaser, strconv.Itoa(i))
+		q.AddSchedule("report", Schedule{Times: []time.Duration{9 * time.Hour}, Location: time.UTC}, action, nil)
+		queues = append(queues, q)
+	}
+
+	for _, q := range queues {
+		q.Start()
+		defer q.Stop()
+	}
+
+	assert.Eventually(func() bool {
+		fired := 0
+		for _, q := range queues {
+			status, _ := q.ScheduleStatus("report")
+			if status.Next.Day() == 2 {
+				fired++
+			}
+		}
+		return fired == len(queues)
+	}, time.Second, 10 * time.Millisecond)
+
+	assert.Eventually(func() bool { return atomic.LoadInt32(&ran) == 1 }, time.Second, 10 * time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(int32(1), atomic.LoadInt32(&ran))
+}
+
+
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+// TESTING FREEZE (freeze.go)
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+func TestFreeze_PausesIntakeAndDispatch(t *testing.T) {
+	assert := assert.New(t)
+	q := Init(5, "frozen", 1)
+	q.Start()
+
+	release := make(chan struct{})
+	var ran int32
+	action := func(params map[string]interface{}) error {
+		atomic.AddInt32(&ran, 1)
+		<-release
+		return nil
+	}
+
+	for i := 0; i < 3; i++ {
+		assert.NoError(q.Add(action, map[string]interface{}{"n": i}, fmt.Sprintf("id-%d", i)))
+	}
+
+	state := q.Freeze()
+	assert.True(q.IsFrozen())
+	assert.True(state.View.Frozen)
+	assert.Equal(1, len(state.View.Processing))
+	assert.Equal(2, len(state.View.Waiting))
+	assert.Equal(map[string]map[string]interface{}{
+		"id-1": {"n": 1},
+		"id-2": {"n": 2},
+	}, state.Params)
+	assert.Equal(3, state.TaskCount)
+
+	assert.Error(q.Add(action, map[string]interface{}{}, "id-3"))
+
+	// the processing task finishes, the waiting ones stay put
+	release <- struct{}{}
+	assert.Eventually(func() bool { return len(q.SnapshotView().Processing) == 0 }, time.Second, 10 * time.Millisecond)
+	assert.Equal(int32(1), atomic.LoadInt32(&ran))
+	assert.Equal(2, len(q.SnapshotView().Waiting))
+
+	q.Thaw()
+	assert.False(q.IsFrozen())
+	close(release)
+	assert.Eventually(func() bool { return atomic.LoadInt32(&ran) == 3 }, time.Second, 10 * time.Millisecond)
+	assert.NoError(q.Add(action, map[string]interface{}{}, "id-3"))
+}
+
+
+func TestFreeze_StateIsACopy(t *testing.T) {
+	assert := assert.New(t)
+
+	// nothing is dispatched, so the task stays waiting
+	q := Init(5, "frozen", 0)
+	q.RegisterAction("b", noop)
+	q.RegisterAction("a", noop)
+	q.Start()
+
+	params := map[string]interface{}{"n": 1}
+	assert.NoError(q.Add(noop, params, "id-1"))
+
+	state := q.Freeze()
+	q.Thaw()
+	q.Thaw()
+
+	params["n"] = 2
+	assert.Equal(1, state.Params["id-1"]["n"])
+	assert.Equal([]string{"a", "b"}, state.RegisteredActions)
+	assert.False(q.IsFrozen())
+}
+
+
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+// TESTING DISPATCH ORDER (ordering.go)
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+func TestSetStrictFIFO_StartsActionsInOrder(t *testing.T) {
+	assert := assert.New(t)
+	q := Init(100, "strict", 10)
+	q.SetStrictFIFO(true)
+	q.Start()
+
+	var mu sync.Mutex
+	order := []int{}
+	var done int32
+
+	for i := 0; i < 100; i++ {
+		n := i
+		action := func(params map[string]interface{}) error {
+			mu.Lock()
+			order = append(order, n)
+			mu.Unlock()
+
+			time.Sleep(time.Millisecond)
+			atomic.AddInt32(&done, 1)
+			return nil
+		}
+		assert.NoError(q.Add(action, map[string]interface{}{}, strconv.Itoa(i)))
+	}
+
+	assert.Eventually(func() bool { return atomic.LoadInt32(&done) == 100 }, time.Second, 10 * time.Millisecond)
+
+	for i, n := range order {
+		assert.Equal(i, n)
+	}
+}
+
+
+// Adds a task for the registered action "a" behind a blocking task, followed by a plain one, then removes
+// "a". Returns the queue, the function releasing the blocking task and the external ids of the tasks run.
+func heldHead(t *testing.T, strict bool) (*FixedSizeQueue, func(), chan string) {
+	q := Init(5, "strict", 1)
+	q.SetStrictFIFO(strict)
+	q.Start()
+
+	ran := make(chan string, 5)
+	record := func(params map[string]interface{}) error {
+		ran <- params["id"].(string)
+		return nil
+	}
+
+	release := make(chan struct{})
+	q.Add(func(params map[string]interface{}) error {
+		<-release
+		return nil
+	}, map[string]interface{}{}, "blocker")
+
+	q.RegisterAction("a", record)
+	q.AddByName("a", map[string]interface{}{"id": "id-1"}, "id-1")
+	q.Add(record, map[string]interface{}{"id": "id-2"}, "id-2")
+	q.UnregisterAction("a")
+
+	return q, func() { close(release) }, ran
+}
+
+
+func TestSetStrictFIFO_HeldTaskHoldsQueue(t *testing.T) {
+	assert := assert.New(t)
+
+	// by default the held task is parked and overtaken
+	q, release, ran := heldHead(t, false)
+	release()
+	assert.Equal("id-2", <-ran)
+	assert.Equal([]string{"id-1"}, q.ParkedTasks("a"))
+
+	q, release, ran = heldHead(t, true)
+	release()
+	select {
+	case id := <-ran:
+		assert.Fail("overtook the held task", id)
+	case <-time.After(50 * time.Millisecond):
+	}
+	assert.Empty(q.ParkedTasks("a"))
+
+	q.RegisterAction("a", func(params map[string]interface{}) error {
+		ran <- params["id"].(string)
+		return nil
+	})
+	assert.Equal("id-1", <-ran)
+	assert.Equal("id-2", <-ran)
+}
+
+
+func TestSetStrictFIFO_SkippedActionPassesTurn(t *testing.T) {
+	assert := assert.New(t)
+	q := Init(5, "strict", 5)
+	q.SetStrictFIFO(true)
+	q.SetActionGuard("skipped", func(params map[string]interface{}, run func() error) error {
+		return errors.New("skipped")
+	})
+	q.Start()
+
+	var ran int32
+	action := func(params map[string]interface{}) error {
+		atomic.AddInt32(&ran, 1)
+		return nil
+	}
+
+	assert.NoError(q.AddNamed("skipped", action, map[string]interface{}{}, "id-1"))
+	assert.NoError(q.Add(action, map[string]interface{}{}, "id-2"))
+	assert.Eventually(func() bool { return atomic.LoadInt32(&ran) == 1 }, time.Second, 10 * time.Millisecond)
+}
+
+
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+// TESTING LIFO BUFFER (lifoBuffer.go)
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+func TestLIFOBuffer_GetTakesNewestFirst(t *testing.T) {
+	assert := assert.New(t)
+	b := NewLIFOBuffer[int](3)
+
+	for i := 1; i <= 3; i++ {
+		assert.NoError(b.TryPut(i))
+	}
+	assert.Error(b.TryPut(4))
+
+	newest, _ := b.Peek()
+	oldest, _ := b.PeekTail()
+	assert.Equal(3, newest)
+	assert.Equal(1, oldest)
+	assert.Equal([]int{3, 2, 1}, b.items.Tasks())
+
+	item, _ := b.Get()
+	assert.Equal(3, item)
+	assert.NoError(b.TryPut(5))
+
+	item, _ = b.Get()
+	assert.Equal(5, item)
+}
+
+
+func TestLIFOBuffer_RemoveMatchesNewestFirst(t *testing.T) {
+	assert := assert.New(t)
+	b := NewLIFOBuffer[int](5)
+
+	for _, i := range []int{1, 2, 3, 4} {
+		b.TryPut(i)
+	}
+
+	even, ok := b.Remove(func(i int) bool { return i % 2 == 0 })
+	assert.True(ok)
+	assert.Equal(4, even)
+	assert.Equal([]int{3, 2, 1}, b.items.Tasks())
+}
+
+
+func TestSetDispatchOrder_LIFO(t *testing.T) {
+	assert := assert.New(t)
+	q := Init(5, "lifo", 1)
+	assert.NoError(q.SetDispatchOrder(LIFO))
+	q.Start()
+
+	release := make(chan struct{})
+	q.Add(func(params map[string]interface{}) error {
+		<-release
+		return nil
+	}, map[string]interface{}{}, "blocker")
+
+	ran := make(chan string, 3)
+	for _, id := range []string{"a", "b", "c"} {
+		q.Add(func(params map[string]interface{}) error {
+			ran <- params["id"].(string)
+			return nil
+		}, map[string]interface{}{"id": id}, id)
+	}
+
+	waiting := []string{}
+	for _, task := range q.SnapshotView().Waiting {
+		waiting = append(waiting, task.ExternalId)
+	}
+	assert.Equal([]string{"c", "b", "a"}, waiting)
+
+	close(release)
+	assert.Equal("c", <-ran)
+	assert.Equal("b", <-ran)
+	assert.Equal("a", <-ran)
+}
+
+
+// returns true if @q dispatches the newest task of each priority first
+func takesNewest(q *FixedSizeQueue) bool {
+	_, lifo := q.items.(*priorityBuffer[*task]).levels[normalLevel].(*lifoBuffer[*task])
+	return lifo
+}
+
+
+func TestSetDispatchOrder_Validates(t *testing.T) {
+	assert := assert.New(t)
+	q := Init(5, "lifo", 1)
+
+	assert.Error(q.SetDispatchOrder(DispatchOrder(7)))
+	assert.NoError(q.SetDispatchOrder(LIFO))
+
+	changes := q.ConfigChanges()
+	assert.Equal(1, len(changes))
+	assert.Equal("dispatchOrder", changes[0].Setting)
+	assert.Equal("FIFO", changes[0].Old)
+	assert.Equal("LIFO", changes[0].New)
+
+	// the queue dispatches through LIFO buffers, unless strict FIFO mode overrides it
+	assert.True(takesNewest(q))
+
+	q.SetStrictFIFO(true)
+	assert.False(takesNewest(q))
+}
+
+
+func TestSetDispatchOrder_Random(t *testing.T) {
+	assert := assert.New(t)
+	q := Init(20, "random", 1)
+	assert.NoError(q.SetDispatchOrder(Random))
+	q.Start()
+
+	release := make(chan struct{})
+	q.Add(func(params map[string]interface{}) error {
+		<-release
+		return nil
+	}, map[string]interface{}{}, "blocker")
+
+	ran := make(chan int, 19)
+	for i := 0; i < 19; i++ {
+		q.Add(func(params map[string]interface{}) error {
+			ran <- params["n"].(int)
+			return nil
+		}, map[string]interface{}{"n": i}, strconv.Itoa(i))
+	}
+
+	close(release)
+
+	order := []int{}
+	seen := map[int]bool{}
+	for i := 0; i < 19; i++ {
+		n := <-ran
+		order = append(order, n)
+		seen[n] = true
+	}
+
+	// every task ran once, and (all but certainly) not in the order they were added
+	assert.Equal(19, len(seen))
+	assert.False(sort.IntsAreSorted(order))
+	assert.Equal("Random", Random.String())
+}
+
+
+func TestRandomBuffer_DequeueTakesPickedItem(t *testing.T) {
+	assert := assert.New(t)
+
+	b := newRandomBuffer(newRingBuffer[int](8))
+	// wrap around the end of the items
+	for i := 0; i < 5; i++ {
+		assert.NoError(b.Enqueue(-1))
+		b.ringBuffer.Dequeue()
+	}
+	for i := 0; i < 8; i++ {
+		assert.NoError(b.Enqueue(i))
+	}
+
+	taken := []int{}
+	for b.Len() > 0 {
+		picked := b.Peek()
+		assert.Equal(picked, b.Dequeue())
+		taken = append(taken, picked)
+	}
+
+	sort.Ints(taken)
+	assert.Equal([]int{0, 1, 2, 3, 4, 5, 6, 7}, taken)
+	assert.Equal(0, b.Dequeue())
+	assert.NoError(b.Enqueue(9))
+	assert.Equal(9, b.Dequeue())
+}
+
+
+func TestSetDispatchStrategy_PicksTask(t *testing.T) {
+	assert := assert.New(t)
+	q := Init(5, "strategy", 1)
+
+	// the most costly task first
+	q.SetDispatchStrategy(DispatchStrategyFunc(func(waiting []TaskView) int {
+		best := 0
+		for i, task := range waiting {
+			if task.Cost > waiting[best].Cost {
+				best = i
+			}
+		}
+		return best
+	}))
+	q.Start()
+
+	release := make(chan struct{})
+	q.Add(func(params map[string]interface{}) error {
+		<-release
+		return nil
+	}, map[string]interface{}{}, "blocker")
+
+	ran := make(chan string, 3)
+	record := func(params map[string]interface{}) error {
+		ran <- params["id"].(string)
+		return nil
+	}
+
+	for _, cost := range []int{2, 5, 1} {
+		id := strconv.Itoa(cost)
+		assert.NoError(q.AddWithCost(record, map[string]interface{}{"id": id}, id, cost, ""))
+	}
+
+	close(release)
+	assert.Equal("5", <-ran)
+	assert.Equal("2", <-ran)
+	assert.Equal("1", <-ran)
+
+	changes := q.ConfigChanges()
+	assert.Equal("dispatchStrategy", changes[0].Setting)
+	assert.Equal("fsq.DispatchStrategyFunc", changes[0].New)
+}
+
+
+func TestSetDispatchStrategy_OutOfRangePicksOldest(t *testing.T) {
+	assert := assert.New(t)
+	q := Init(5, "strategy", 1)
+	q.SetDispatchOrder(LIFO)
+	q.SetDispatchStrategy(DispatchStrategyFunc(func(waiting []TaskView) int {
+		return len(waiting)
+	}))
+	q.Start()
+
+	release := make(chan struct{})
+	q.Add(func(params map[string]interface{}) error {
+		<-release
+		return nil
+	}, map[string]interface{}{}, "blocker")
+
+	ran := make(chan string, 2)
+	for _, id := range []string{"a", "b"} {
+		q.Add(func(params map[string]interface{}) error {
+			ran <- params["id"].(string)
+			return nil
+		}, map[string]interface{}{"id": id}, id)
+	}
+
+	close(release)
+	assert.Equal("a", <-ran, "the strategy overrides LIFO")
+	assert.Equal("b", <-ran)
+
+	// removing the strategy goes back to the dispatch order
+	q.SetDispatchStrategy(nil)
+	assert.False(q.picksByStrategy())
+	assert.True(takesNewest(q))
+}
+
+
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+// TESTING FAIRNESS (fairness.go)
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+func TestFairness_PerStrategy(t *testing.T) {
+	assert := assert.New(t)
+	q := Init(10, "fairness", 1)
+	clock := NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	q.SetClock(clock)
+	q.SetStarvationThreshold(time.Minute)
+	q.Start()
+
+	release := make(chan struct{})
+	assert.NoError(q.Add(func(params map[string]interface{}) error {
+		<-release
+		return nil
+	}, map[string]interface{}{}, "blocker"))
+
+	var done int32
+	action := func(params map[string]interface{}) error {
+		atomic.AddInt32(&done, 1)
+		return nil
+	}
+
+	q.AddWithCost(action, map[string]interface{}{}, "a-1", 0, "a")
+	q.AddWithCost(action, map[string]interface{}{}, "a-2", 0, "a")
+	q.AddWithCost(action, map[string]interface{}{}, "b-1", 0, "b")
+
+	clock.Advance(2 * time.Minute)
+	close(release)
+	assert.Eventually(func() bool { return atomic.LoadInt32(&done) == 3 }, time.Second, 10 * time.Millisecond)
+
+	q.SetDispatchOrder(LIFO)
+	assert.NoError(q.Add(action, map[string]interface{}{}, "c-1"))
+
+	stats := q.Fairness()
+	assert.Equal(2, len(stats))
+
+	fifo := stats[0]
+	assert.Equal("FIFO", fifo.Strategy)
+	assert.Equal(4, fifo.Dispatched)
+	assert.Equal(2 * time.Minute, fifo.MaxWait)
+	assert.Equal(90 * time.Second, fifo.MeanWait)
+	assert.Equal(map[string]int{"": 1, "a": 2, "b": 1}, fifo.TenantDispatches)
+	assert.Equal(0.5, fifo.TenantShares["a"])
+	assert.Equal(3, fifo.Starved)
+
+	lifo := stats[1]
+	assert.Equal("LIFO", lifo.Strategy)
+	assert.Equal(1, lifo.Dispatched)
+	assert.Equal(time.Duration(0), lifo.MaxWait)
+}
+
+
+func TestSetStarvationThreshold_PublishesOncePerTask(t *testing.T) {
+	assert := assert.New(t)
+
+	interval := starvationCheckInterval
+	starvationCheckInterval = 10 * time.Millisecond
+	defer func() { starvationCheckInterval = interval }()
+
+	// nothing is dispatched, so the tasks keep waiting
+	q := Init(5, "fairness", 0)
+	clock := NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	q.SetClock(clock)
+	q.SetStarvationThreshold(time.Minute)
+	q.Start()
+	defer q.Stop()
+
+	sub := q.SubscribeFiltered(EventFilter{Types: []EventType{EventStarving}}, 10, DropOldest)
+	defer sub.Close()
+
+	q.Add(noop, map[string]interface{}{}, "old")
+	clock.Advance(2 * time.Minute)
+	q.Add(noop, map[string]interface{}{}, "new")
+
+	event := <-sub.Events()
+	assert.Equal(EventStarving, event.Type)
+	assert.Equal("old", event.ExternalId)
+
+	select {
+	case event = <-sub.Events():
+		assert.Fail("published again", event.ExternalId)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+// TESTING MUX (mux.go)
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+
+func TestMux_RoutesByActionName(t *testing.T) {
+	assert := assert.New(t)
+
+	emails := Init(5, "email", 1)
+	reports := Init(5, "report", 1)
+	other := Init(5, "other", 1)
+
+	mux, err := NewMux(RouteByActionName("other"), emails, reports, other)
+	assert.NoError(err)
+
+	mux.Start()
+	defer mux.Stop()
+
+	var done int32
+	release := make(chan struct{})
+	action := func(params map[string]interface{}) error {
+		<-release
+		atomic.AddInt32(&done, 1)
+		return nil
+	}
+
+	assert.NoError(mux.AddNamed("email", action, map[string]interface{}{}, "e-1"))
+	assert.NoError(mux.AddNamed("report", action, map[string]interface{}{}, "r-1"))
+	assert.NoError(mux.Add(action, map[string]interface{}{}, "o-1"))
+	assert.Error(mux.AddNamed("unknown", action, map[string]interface{}{}, "u-1"))
+
+	assert.Equal("e-1", emails.SnapshotView().Processing[0].ExternalId)
+	assert.Equal("r-1", reports.SnapshotView().Processing[0].ExternalId)
+	assert.Equal("o-1", other.SnapshotView().Processing[0].ExternalId)
+
+	close(release)
+	assert.Eventually(func() bool { return atomic.LoadInt32(&done) == 3 }, time.Second, 10 * time.Millisecond)
+}
+
+
+func TestMux_SnapshotViewTotals(t *testing.T) {
+	assert := assert.New(t)
+
+	a := Init(3, "a", 1)
+	b := Init(4, "b", 1)
+
+	mux, err := NewMux(RouteByTenant("a"), a, b)
+	assert.NoError(err)
+
+	mux.Start()
+	defer mux.Stop()
+
+	release := make(chan struct{})
+	defer close(release)
+	action := func(params map[string]interface{}) error {
+		<-release
+		return nil
+	}
+
+	mux.AddWithCost(action, map[string]interface{}{}, "1", 1, "b")
+	mux.AddWithCost(action, map[string]interface{}{}, "2", 1, "b")
+	mux.Add(action, map[string]interface{}{}, "3")
+
+	view := mux.SnapshotView()
+	assert.Equal(2, len(view.Queues))
+	assert.Equal(7, view.Capacity)
+	assert.Equal(2, view.Processing)
+	assert.Equal(1, view.Waiting)
+	assert.Equal("3", view.Queues[0].Processing[0].ExternalId)
+	assert.Equal("2", view.Queues[1].Waiting[0].ExternalId)
+}
+
+
+func TestNewMux_RejectsDuplicateQueues(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := NewMux(RouteByTenant("a"), Init(1, "a", 1), Init(1, "a", 1))
+	assert.Error(err)
+
+	_, err = NewMux(nil, Init(1, "a", 1))
+	assert.Error(err)
+}
+
+
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+// TESTING HASH RING (hashRing.go)
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+
+func TestRouteByHash_SameIdSameQueue(t *testing.T) {
+	assert := assert.New(t)
+
+	route := RouteByHash("a", "b", "c")
+	counts := map[string]int{}
+
+	for i := 0; i < 300; i++ {
+		id := fmt.Sprintf("id-%d", i)
+		name := route(Route{ExternalId: id})
+		assert.Equal(name, route(Route{ExternalId: id}))
+		counts[name]++
+	}
+
+	// every shard gets a share
+	assert.Equal(3, len(counts))
+	for _, count := range counts {
+		assert.Greater(count, 30)
+	}
+}
+
+
+func TestRouteByHash_AddingQueueMovesFewIds(t *testing.T) {
+	assert := assert.New(t)
+
+	before := RouteByHash("a", "b", "c")
+	after := RouteByHash("a", "b", "c", "d")
+
+	moved := 0
+	for i := 0; i < 1000; i++ {
+		id := fmt.Sprintf("id-%d", i)
+		from := before(Route{ExternalId: id})
+		to := after(Route{ExternalId: id})
+
+		if from != to {
+			assert.Equal("d", to)
+			moved++
+		}
+	}
+
+	assert.Greater(moved, 100)
+	assert.Less(moved, 400)
+}
+
+
+func TestNewShardedMux_DedupsAcrossShards(t *testing.T) {
+	assert := assert.New(t)
+
+	mux, err := NewShardedMux(Init(5, "shard-0", 1), Init(5, "shard-1", 1), Init(5, "shard-2", 1))
+	assert.NoError(err)
+
+	mux.Start()
+	defer mux.Stop()
+
+	release := make(chan struct{})
+	defer close(release)
+	action := func(params map[string]interface{}) error {
+		<-release
+		return nil
+	}
+
+	// the first one processes, the second waits behind it on the same shard
+	assert.NoError(mux.Add(action, map[string]interface{}{}, "order-42"))
+	assert.NoError(mux.Add(action, map[string]interface{}{}, "order-42"))
+	assert.Error(mux.Add(action, map[string]interface{}{}, "order-42"))
+}
+
+
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+// TESTING MOVE (move.go)
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+
+func TestMux_MoveWaitingTask(t *testing.T) {
+	assert := assert.New(t)
+
+	busy := Init(5, "busy", 1)
+	spare := Init(5, "spare", 1)
+	clock := NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	busy.SetClock(clock)
+	spare.SetClock(clock)
+
+	mux, err := NewMux(RouteByTenant("busy"), busy, spare)
+	assert.NoError(err)
+
+	mux.Start()
+	defer mux.Stop()
+
+	release := make(chan struct{})
+	blocking := func(params map[string]interface{}) error {
+		<-release
+		return nil
+	}
+
+	var got interface{}
+	var done int32
+	action := func(params map[string]interface{}) error {
+		got = params["n"]
+		atomic.AddInt32(&done, 1)
+		return nil
+	}
+
+	assert.NoError(mux.Add(blocking, map[string]interface{}{}, "head"))
+	assert.NoError(mux.AddNamed("work", action, map[string]interface{}{"n": 7}, "stuck"))
+	enqueuedAt := busy.SnapshotView().Waiting[0].EnqueuedAt
+
+	// processing tasks can't move
+	assert.Error(mux.Move("head", "busy", "spare"))
+	assert.Error(mux.Move("missing", "busy", "spare"))
+	assert.Error(mux.Move("stuck", "busy", "nowhere"))
+
+	clock.Advance(time.Minute)
+	spare.Freeze()
+	assert.Error(mux.Move("stuck", "busy", "spare"))
+	assert.Equal(1, len(busy.SnapshotView().Waiting))
+	spare.Thaw()
+
+	assert.NoError(mux.Move("stuck", "busy", "spare"))
+	assert.Eventually(func() bool { return atomic.LoadInt32(&done) == 1 }, time.Second, 10 * time.Millisecond)
+	assert.Equal(7, got)
+	assert.Equal(0, len(busy.SnapshotView().Waiting))
+
+	stats := spare.Fairness()
+	assert.Equal(time.Minute, stats[0].MaxWait)
+	assert.False(enqueuedAt.IsZero())
+
+	// the id is free again on the source
+	assert.NoError(busy.Add(blocking, map[string]interface{}{}, "stuck"))
+	close(release)
+}
+
+
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+// TESTING SHUTDOWN (shutdown.go)
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+
+func TestMux_ShutdownAllDrainsInParallel(t *testing.T) {
+	assert := assert.New(t)
+
+	a := Init(5, "a", 1)
+	b := Init(5, "b", 1)
+
+	mux, err := NewMux(RouteByTenant("a"), a, b)
+	assert.NoError(err)
+	mux.Start()
+
+	var done int32
+	action := func(params map[string]interface{}) error {
+		time.Sleep(20 * time.Millisecond)
+		atomic.AddInt32(&done, 1)
+		return nil
+	}
+
+	mux.AddWithCost(action, map[string]interface{}{}, "a-1", 0, "a")
+	mux.AddWithCost(action, map[string]interface{}{}, "a-2", 0, "a")
+	mux.AddWithCost(action, map[string]interface{}{}, "b-1", 0, "b")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	reports := mux.ShutdownAll(ctx)
+	assert.Equal(int32(3), atomic.LoadInt32(&done))
+	assert.Equal(2, len(reports))
+	assert.Equal("a", reports[0].Queue)
+	assert.True(reports[0].Drained)
+	assert.True(reports[1].Drained)
+	assert.NoError(reports[1].Err)
+
+	// intake is stopped
+	assert.Error(mux.Add(noop, map[string]interface{}{}, "late"))
+}
+
+
+func TestRegistry_ShutdownAllDrainsEveryRegisteredQueue(t *testing.T) {
+	assert := assert.New(t)
+
+	r := Registry{}
+	b := Init(5, "b", 1)
+	a := Init(5, "a", 1)
+	assert.NoError(r.Register(b))
+	assert.NoError(r.Register(a))
+	a.Start()
+	b.Start()
+
+	var done int32
+	action := func(params map[string]interface{}) error {
+		time.Sleep(20 * time.Millisecond)
+		atomic.AddInt32(&done, 1)
+		return nil
+	}
+
+	a.Add(action, map[string]interface{}{}, "a-1")
+	a.Add(action, map[string]interface{}{}, "a-2")
+	b.Add(action, map[string]interface{}{}, "b-1")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	reports := r.ShutdownAll(ctx)
+	assert.Equal(int32(3), atomic.LoadInt32(&done))
+	assert.Equal(2, len(reports))
+	assert.Equal("a", reports[0].Queue, "reports are ordered by qualified name")
+	assert.Equal("b", reports[1].Queue)
+	assert.True(reports[0].Drained)
+	assert.True(reports[1].Drained)
+
+	// intake is stopped, the queues stay registered
+	assert.Error(a.Add(noop, map[string]interface{}{}, "late"))
+	assert.Error(b.Add(noop, map[string]interface{}{}, "late"))
+	assert.Equal(2, len(r.List()))
+}
+
+
+func TestMux_ShutdownAllLetsContextActionsFinish(t *testing.T) {
+	assert := assert.New(t)
+
+	q := Init(5, "ctx", 1)
+	mux, err := NewMux(RouteByTenant("ctx"), q)
+	assert.NoError(err)
+	mux.Start()
+
+	started := make(chan struct{})
+	var finished, cancelledEarly atomic.Bool
+	action := func(ctx context.Context, params map[string]interface{}) error {
+		close(started)
+		select {
+		case <-time.After(20 * time.Millisecond):
+			finished.Store(true)
+			return nil
+		case <-ctx.Done():
+			cancelledEarly.Store(true)
+			return ctx.Err()
+		}
+	}
+
+	assert.NoError(q.AddContextAction(action, map[string]interface{}{}, "1"))
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	reports := mux.ShutdownAll(ctx)
+	assert.True(reports[0].Drained)
+	assert.True(finished.Load())
+	assert.False(cancelledEarly.Load())
+
+	// the base context is cancelled once the queue drained
+	assert.ErrorIs(context.Cause(q.baseContext()), ErrQueueStopped)
+}
+
+
+func TestMux_ShutdownAllReportsLeftovers(t *testing.T) {
+	assert := assert.New(t)
+
+	q := Init(5, "stuck", 1)
+	mux, err := NewMux(RouteByTenant("stuck"), q)
+	assert.NoError(err)
+	mux.Start()
+
+	release := make(chan struct{})
+	defer close(release)
+	blocking := func(params map[string]interface{}) error {
+		<-release
+		return nil
+	}
+
+	mux.Add(blocking, map[string]interface{}{}, "1")
+	mux.Add(blocking, map[string]interface{}{}, "2")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20 * time.Millisecond)
+	defer cancel()
+
+	reports := mux.ShutdownAll(ctx)
+	assert.False(reports[0].Drained)
+	assert.ErrorIs(reports[0].Err, context.DeadlineExceeded)
+	assert.Equal(1, reports[0].Waiting)
+	assert.Equal(1, reports[0].Processing)
+
+	var drainErr *DrainError
+	assert.ErrorAs(reports[0].Err, &drainErr)
+	assert.Equal([]string{"2"}, drainErr.Waiting)
+	assert.Equal([]string{"1"}, drainErr.Processing)
+}
+
+
+func TestStopAndDrain_WaitsForTasks(t *testing.T) {
+	assert := assert.New(t)
+
+	q := Init(5, "drain", 1)
+	q.Start()
+
+	var done int32
+	var cancelledEarly atomic.Bool
+	action := func(ctx context.Context, params map[string]interface{}) error {
+		time.Sleep(20 * time.Millisecond)
+		if ctx.Err() != nil {
+			cancelledEarly.Store(true)
+		}
+
+		atomic.AddInt32(&done, 1)
+		return nil
+	}
+
+	assert.NoError(q.AddContextAction(action, map[string]interface{}{}, "1"))
+	assert.NoError(q.AddContextAction(action, map[string]interface{}{}, "2"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	assert.NoError(q.StopAndDrain(ctx))
+	assert.Equal(int32(2), atomic.LoadInt32(&done))
+	assert.False(cancelledEarly.Load(), "contexts are cancelled once the queue drained")
+	assert.False(q.IsRunning())
+	assert.Error(q.Add(noop, map[string]interface{}{}, "late"))
+}
+
+
+func TestStopAndDrain_ListsUnfinishedTasks(t *testing.T) {
+	assert := assert.New(t)
+
+	q := Init(5, "drain", 1)
+	q.Start()
+
+	release := make(chan struct{})
+	defer close(release)
+	blocking := func(params map[string]interface{}) error {
+		<-release
+		return nil
+	}
+
+	assert.NoError(q.Add(blocking, map[string]interface{}{}, "1"))
+	assert.NoError(q.Add(blocking, map[string]interface{}{}, "2"))
+	assert.NoError(q.AddAfter(noop, map[string]interface{}{}, "later", time.Hour))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20 * time.Millisecond)
+	defer cancel()
+
+	err := q.StopAndDrain(ctx)
+	assert.ErrorIs(err, context.DeadlineExceeded)
+
+	var drainErr *DrainError
+	assert.ErrorAs(err, &drainErr)
+	assert.Equal("drain", drainErr.Queue)
+	assert.Equal([]string{"1"}, drainErr.Processing)
+	assert.Equal([]string{"2", "later"}, drainErr.Waiting)
+	assert.Contains(err.Error(), "3 unfinished tasks (1, 2, later)")
+}
+
+
+func TestStopNow_DiscardsWaitingTasks(t *testing.T) {
+	assert := assert.New(t)
+
+	q := Init(5, "stop", 1)
+	q.Start()
+
+	sub := q.Subscribe(10, DropOldest)
+	defer sub.Close()
+
+	var runs int32
+	inFlight := func(ctx context.Context, params map[string]interface{}) error {
+		atomic.AddInt32(&runs, 1)
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	var callbackErr error
+	assert.NoError(q.AddContextAction(inFlight, map[string]interface{}{}, "running"))
+	assert.NoError(q.AddWithCallbacks(noop, map[string]interface{}{"n": 1}, "1", nil, func(err error) { callbackErr = err }))
+	var handle *TaskHandle
+	err := q.Add(noop, map[string]interface{}{"n": 2}, "2", WithHandle(&handle))
+	assert.NoError(err)
+	assert.NoError(q.AddAfter(noop, map[string]interface{}{"n": 3}, "later", time.Hour))
+
+	discarded := q.StopNow()
+	assert.Equal(3, len(discarded))
+	assert.Equal("1", discarded[0].ExternalId)
+	assert.Equal(map[string]interface{}{"n": 2}, discarded[1].Params)
+	assert.Equal("later", discarded[2].ExternalId)
+
+	assert.ErrorIs(callbackErr, ErrTaskDiscarded)
+	assert.ErrorIs(handle.Err(), ErrTaskDiscarded)
+	result, _ := handle.Result()
+	assert.Equal(ReasonQueueShutdown, result.Reason)
+
+	// the processing task is told to give up
+	for event := range sub.Events() {
+		if event.ExternalId == "running" {
+			assert.Equal(ReasonQueueShutdown, event.Reason)
+			break
+		}
+	}
+
+	assert.Equal(3, q.Stats().Discarded)
+	assert.Equal(int32(1), atomic.LoadInt32(&runs))
+	assert.Error(q.Add(noop, map[string]interface{}{}, "late"))
+	assert.Empty(q.SnapshotView().Waiting)
+
+	// starting again dispatches again
+	q.Start()
+	defer q.Stop()
+	assert.NoError(q.Add(noop, map[string]interface{}{}, "again"))
+	assert.Eventually(func() bool { return q.Stats().Completed == 1 }, time.Second, 10 * time.Millisecond)
+}
+
+
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+// TESTING TRIGGERS (trigger.go)
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+
+func TestAddTrigger_EnqueuesOnMatchingCompletion(t *testing.T) {
+	assert := assert.New(t)
+
+	orders := Init(5, "orders", 2)
+	mail := Init(5, "mail", 2)
+	orders.Start()
+	mail.Start()
+	defer orders.Stop()
+	defer mail.Stop()
+
+	var mu sync.Mutex
+	sent := []string{}
+	mail.RegisterAction("receipt", func(params map[string]interface{}) error {
+		mu.Lock()
+		defer mu.Unlock()
+		sent = append(sent, fmt.Sprintf("%v", params["order"]))
+		return nil
+	})
+
+	err := orders.AddTrigger(Trigger{
+		Name: "receipt",
+		When: EventFilter{Types: []EventType{EventCompleted}, ActionNames: []string{"checkout"}},
+		Match: func(event Event, params map[string]interface{}) bool { return params["paid"] == true },
+		Target: mail,
+		ActionName: "receipt",
+	})
+	assert.NoError(err)
+	assert.Error(orders.AddTrigger(Trigger{Name: "receipt", Target: mail, ActionName: "receipt"}))
+
+	failing := func(params map[string]interface{}) error { return errors.New("declined") }
+
+	orders.AddNamed("checkout", noop, map[string]interface{}{"order": 1, "paid": true}, "o-1")
+	orders.AddNamed("checkout", noop, map[string]interface{}{"order": 2, "paid": false}, "o-2")
+	orders.AddNamed("checkout", failing, map[string]interface{}{"order": 3, "paid": true}, "o-3")
+	orders.AddNamed("refund", noop, map[string]interface{}{"order": 4, "paid": true}, "o-4")
+
+	assert.Eventually(func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(sent) == 1
+	}, time.Second, 10 * time.Millisecond)
+
+	time.Sleep(20 * time.Millisecond)
+	mu.Lock()
+	assert.Equal([]string{"1"}, sent)
+	mu.Unlock()
+
+	assert.True(orders.RemoveTrigger("receipt"))
+	assert.False(orders.RemoveTrigger("receipt"))
+}
+
+
+func TestAddTrigger_SameQueue(t *testing.T) {
+	assert := assert.New(t)
+
+	q := Init(5, "pipeline", 1)
+	q.Start()
+	defer q.Stop()
+
+	var done int32
+	q.RegisterAction("second", func(params map[string]interface{}) error {
+		atomic.AddInt32(&done, 1)
+		return nil
+	})
+
+	q.AddTrigger(Trigger{
+		Name: "then",
+		When: EventFilter{ActionNames: []string{"first"}},
+		Target: q,
+		ActionName: "second",
+		Params: func(event Event, params map[string]interface{}) map[string]interface{} {
+			return map[string]interface{}{"from": event.ExternalId}
+		},
+	})
+
+	assert.NoError(q.AddNamed("first", noop, map[string]interface{}{}, "step-1"))
+	assert.Eventually(func() bool { return atomic.LoadInt32(&done) == 1 }, time.Second, 10 * time.Millisecond)
+}
+
+
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+// TESTING RESULT SINKS (resultSink.go)
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+
+func TestSetResultSink_WritesBatches(t *testing.T) {
+	assert := assert.New(t)
+
+	q := Init(10, "results", 5)
+	q.Start()
+	defer q.Stop()
+
+	var mu sync.Mutex
+	batches := [][]Event{}
+	q.SetResultSink(ResultSinkFunc(func(results []Event) error {
+		mu.Lock()
+		defer mu.Unlock()
+		batches = append(batches, results)
+		return nil
+	}), ResultSinkOptions{BatchSize: 2, FlushInterval: time.Hour})
+
+	failing := func(params map[string]interface{}) error { return errors.New("boom") }
+	q.Add(noop, map[string]interface{}{}, "1")
+	q.Add(failing, map[string]interface{}{}, "2")
+
+	// the full batch is written right away, the partial one on flush
+	assert.Eventually(func() bool { return q.ResultSinkStats().Written == 2 }, time.Second, 10 * time.Millisecond)
+	q.Add(noop, map[string]interface{}{}, "3")
+	assert.Eventually(func() bool { return q.ResultSinkStats().Pending == 1 }, time.Second, 10 * time.Millisecond)
+	assert.NoError(q.FlushResults())
+	assert.Equal(3, q.ResultSinkStats().Written)
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(2, len(batches))
+	assert.Equal(2, len(batches[0]))
+	assert.Equal(1, len(batches[1]))
+
+	failed := 0
+	for _, batch := range batches {
+		for _, result := range batch {
+			if result.Type == EventFailed {
+				failed++
+			}
+		}
+	}
+	assert.Equal(1, failed)
+}
+
+
+func TestSetResultSink_RetriesAndGivesUp(t *testing.T) {
+	assert := assert.New(t)
+
+	q := Init(10, "results", 1)
+	q.Start()
+	defer q.Stop()
+
+	var calls int32
+	q.SetResultSink(ResultSinkFunc(func(results []Event) error {
+		if atomic.AddInt32(&calls, 1) < 3 {
+			return errors.New("unavailable")
+		}
+		return nil
+	}), ResultSinkOptions{BatchSize: 10, FlushInterval: time.Hour, Retries: 2, RetryDelay: time.Millisecond})
+
+	q.Add(noop, map[string]interface{}{}, "1")
+	assert.Eventually(func() bool { return q.ResultSinkStats().Pending == 1 }, time.Second, 10 * time.Millisecond)
+
+	// succeeds on the last retry
+	assert.NoError(q.FlushResults())
+	assert.Equal(int32(3), atomic.LoadInt32(&calls))
+	assert.Equal(1, q.ResultSinkStats().Written)
+
+	q.SetResultSink(ResultSinkFunc(func(results []Event) error {
+		return errors.New("down")
+	}), ResultSinkOptions{FlushInterval: time.Hour, Retries: -1})
+
+	q.Add(noop, map[string]interface{}{}, "2")
+	assert.Eventually(func() bool { return q.ResultSinkStats().Pending == 1 }, time.Second, 10 * time.Millisecond)
+	assert.Error(q.FlushResults())
+	assert.Equal(1, q.ResultSinkStats().Failed)
+
+	// removing the sink writes what is left
+	q.SetResultSink(nil, ResultSinkOptions{})
+	assert.Equal(ResultSinkStats{}, q.ResultSinkStats())
+}
+
+
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+// TESTING REDACTION (redaction.go)
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+
+func TestRedactParams(t *testing.T) {
+	assert := assert.New(t)
+
+	q := Init(5, "redaction", 1)
+	assert.NoError(q.AddRedaction("", "password", "*token*"))
+	assert.NoError(q.AddRedaction("charge", "card"))
+	assert.Error(q.AddRedaction("", "[oops"))
+
+	params := map[string]interface{}{
+		"user": "ana",
+		"Password": "hunter2",
+		"auth": map[string]interface{}{"AccessToken": "abc", "scope": "read"},
+		"card": "4111",
+	}
+
+	redacted := q.RedactParams("charge", params)
+	assert.Equal("ana", redacted["user"])
+	assert.Equal(Redacted, redacted["Password"])
+	assert.Equal(Redacted, redacted["card"])
+	assert.Equal(map[string]interface{}{"AccessToken": Redacted, "scope": "read"}, redacted["auth"])
+
+	// other actions only get the queue wide rules, and the params themselves are untouched
+	assert.Equal("4111", q.RedactParams("refund", params)["card"])
+	assert.Equal("hunter2", params["Password"])
+
+	changes := q.ConfigChanges()
+	assert.Equal("redaction", changes[len(changes) - 1].Setting)
+
+	q.ClearRedactions()
+	assert.Equal("hunter2", q.RedactParams("charge", params)["Password"])
+}
+
+
+func TestFreeze_RedactsParams(t *testing.T) {
+	assert := assert.New(t)
+
+	q := Init(5, "redaction", 0)
+	q.AddRedaction("", "secret")
+	q.Start()
+	defer q.Stop()
+
+	q.Add(noop, map[string]interface{}{"secret": "s3cr3t", "n": 1}, "1")
+
+	state := q.Freeze()
+	assert.Equal(map[string]interface{}{"secret": Redacted, "n": 1}, state.Params["1"])
+}
+
+
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+// TESTING BYTE PAYLOADS (bytesPayload.go)
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+
+func TestAddBytes(t *testing.T) {
+	assert := assert.New(t)
+
+	q := Init(5, "bytes", 1)
+	q.Start()
+	defer q.Stop()
+
+	payload := []byte(`{"order":42}`)
+	got := make(chan []byte, 1)
+
+	err := q.AddBytes(func(ctx context.Context, data []byte) error {
+		assert.NotNil(ctx)
+		got <- data
+		return nil
+	}, payload, "1")
+	assert.NoError(err)
+
+	select {
+	case data := <-got:
+		assert.Equal(payload, data)
+		// handed over without a copy
+		assert.Equal(&payload[0], &data[0])
+	case <-time.After(time.Second):
+		assert.Fail("action not called")
+	}
+
+	assert.Equal(0, len(noParams))
+}
+
+
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+// TESTING READER PAYLOADS (readerPayload.go)
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+
+type trackedBody struct {
+	io.Reader
+	closed atomic.Int32
+}
+
+
+func (b *trackedBody) Close() error {
+	b.closed.Add(1)
+	return nil
+}
+
+
+func TestAddReader_ClosesAfterAction(t *testing.T) {
+	assert := assert.New(t)
+
+	q := Init(5, "reader", 1)
+	q.Start()
+	defer q.Stop()
+
+	body := &trackedBody{Reader: strings.NewReader("large upload")}
+	got := make(chan string, 1)
+
+	err := q.AddReader(func(ctx context.Context, r io.Reader) error {
+		data, err := io.ReadAll(r)
+		got <- string(data)
+		return err
+	}, body, "1")
+	assert.NoError(err)
+
+	assert.Equal("large upload", <-got)
+	assert.Eventually(func() bool { return body.closed.Load() == 1 }, time.Second, 10 * time.Millisecond)
+}
+
+
+func TestAddReader_ClosesWhenSkippedOrRejected(t *testing.T) {
+	assert := assert.New(t)
+
+	q := Init(1, "reader", 1)
+	q.SetActionGuard("", func(params map[string]interface{}, run func() error) error { return nil })
+
+	// not running
+	rejected := &trackedBody{Reader: strings.NewReader("")}
+	assert.Error(q.AddReader(func(ctx context.Context, r io.Reader) error { return nil }, rejected, "1"))
+	assert.Equal(int32(1), rejected.closed.Load())
+
+	q.Start()
+	defer q.Stop()
+
+	var called int32
+	skipped := &trackedBody{Reader: strings.NewReader("")}
+	assert.NoError(q.AddReader(func(ctx context.Context, r io.Reader) error {
+		atomic.AddInt32(&called, 1)
+		return nil
+	}, skipped, "2"))
+
+	assert.Eventually(func() bool { return skipped.closed.Load() == 1 }, time.Second, 10 * time.Millisecond)
+	assert.Equal(int32(0), atomic.LoadInt32(&called))
+}
+
+
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+// TESTING TRACING (trace.go)
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+
+func traceSteps(entries []TraceEntry) []string {
+	steps := []string{}
+	for _, entry := range entries {
+		steps = append(steps, entry.Step)
+	}
+
+	return steps
+}
+
+
+func TestSetTracing_RecordsDecisions(t *testing.T) {
+	assert := assert.New(t)
+
+	q := Init(5, "tracing", 1)
+	clock := NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	q.SetClock(clock)
+	q.SetTracing(10)
+	q.Start()
+	defer q.Stop()
+
+	release := make(chan struct{})
+	var done int32
+	blocking := func(params map[string]interface{}) error {
+		<-release
+		atomic.AddInt32(&done, 1)
+		return nil
+	}
+
+	q.Add(blocking, map[string]interface{}{}, "first")
+	q.Add(blocking, map[string]interface{}{}, "second")
+	assert.Error(q.Add(blocking, map[string]interface{}{}, "second"))
+
+	clock.Advance(30 * time.Second)
+	close(release)
+	assert.Eventually(func() bool { return atomic.LoadInt32(&done) == 2 }, time.Second, 10 * time.Millisecond)
+
+	trace := q.Trace("second")
+	assert.Equal([]string{"admitted", "rejected", "dispatched", "completed"}, traceSteps(trace))
+	assert.Equal("0 waiting ahead", trace[0].Detail)
+	assert.Equal("waited 30s, picked by FIFO over 0 others", trace[2].Detail)
+	assert.Equal(0, len(q.Trace("unknown")))
+}
+
+
+func TestSetTracing_EvictsOldestIds(t *testing.T) {
+	assert := assert.New(t)
+
+	q := Init(5, "tracing", 1)
+	q.SetTracing(2)
+	q.Start()
+	defer q.Stop()
+
+	q.Add(noop, map[string]interface{}{}, "1")
+	q.Add(noop, map[string]interface{}{}, "2")
+	q.Add(noop, map[string]interface{}{}, "3")
+
+	assert.Equal(0, len(q.Trace("1")))
+	assert.NotEqual(0, len(q.Trace("3")))
+
+	q.SetTracing(0)
+	assert.Equal(0, len(q.Trace("3")))
+}
+
+
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+// TESTING OUTCOME REASONS (reason.go)
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+
+func TestEvent_Reason(t *testing.T) {
+	assert := assert.New(t)
+
+	q := Init(10, "reasons", 5)
+	q.Start()
+	defer q.Stop()
+
+	sub := q.Subscribe(10, DropOldest)
+	defer sub.Close()
+
+	q.Add(noop, map[string]interface{}{}, "ok")
+	q.Add(func(params map[string]interface{}) error { return errors.New("boom") }, map[string]interface{}{}, "error")
+	q.Add(func(params map[string]interface{}) error { return Cancelled(errors.New("stop")) }, map[string]interface{}{}, "cancelled")
+	q.Add(func(params map[string]interface{}) error { panic("boom") }, map[string]interface{}{}, "panicked")
+
+	waitOnContext := func(ctx context.Context, params map[string]interface{}) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	q.AddWithDeadline(waitOnContext, map[string]interface{}{}, "timeout", time.Now().Add(20 * time.Millisecond))
+	q.AddWithDeadline(waitOnContext, map[string]interface{}{}, "expired", time.Now().Add(-time.Second))
+
+	reasons := map[string]OutcomeReason{}
+	for len(reasons) < 6 {
+		select {
+		case event := <-sub.Events():
+			reasons[event.ExternalId] = event.Reason
+		case <-time.After(time.Second):
+			assert.FailNow("missing events", reasons)
+		}
+	}
+
+	assert.Equal(map[string]OutcomeReason{
+		"ok": ReasonSuccess,
+		"error": ReasonActionError,
+		"cancelled": ReasonCancelled,
+		"panicked": ReasonPanicked,
+		"timeout": ReasonTimeout,
+		"expired": ReasonExpired,
+	}, reasons)
+}
+
+
+func TestActionPanic_EndsTaskWithoutRetrying(t *testing.T) {
+	assert := assert.New(t)
+
+	q := Init(5, "panic", 1)
+	q.SetRetryPolicy(RetryPolicy{MaxAttempts: 3})
+	q.Start()
+	defer q.Stop()
+
+	sub := q.Subscribe(10, DropOldest)
+	defer sub.Close()
+
+	var runs atomic.Int32
+	q.Add(func(params map[string]interface{}) error {
+		runs.Add(1)
+		panic("boom")
+	}, map[string]interface{}{}, "panicked")
+	q.Add(noop, map[string]interface{}{}, "next")
+
+	events := map[string]Event{}
+	for len(events) < 2 {
+		select {
+		case event := <-sub.Events():
+			events[event.ExternalId] = event
+		case <-time.After(time.Second):
+			assert.FailNow("missing events", events)
+		}
+	}
+
+	assert.Equal(EventFailed, events["panicked"].Type)
+	assert.Equal(ReasonPanicked, events["panicked"].Reason)
+	assert.Equal(ClassPermanent, events["panicked"].Class)
+	assert.ErrorIs(events["panicked"].Err, ErrActionPanicked)
+	assert.ErrorContains(events["panicked"].Err, "boom")
+	assert.Equal(int32(1), runs.Load())
+
+	// the panicking task's slot was freed for the next one
+	assert.Equal(ReasonSuccess, events["next"].Reason)
+	assert.Equal(0, q.Stats().Processing)
+}
+
+
+func TestEventFilter_Reasons(t *testing.T) {
+	assert := assert.New(t)
+
+	filter := EventFilter{Reasons: []OutcomeReason{ReasonTimeout}}
+	assert.True(filter.matches(Event{Type: EventAbandoned, Reason: ReasonTimeout}))
+	assert.False(filter.matches(Event{Type: EventCompleted, Reason: ReasonSuccess}))
+	assert.False(filter.matches(Event{Type: EventStarving}))
+
+	text, _ := json.Marshal(Event{Reason: ReasonExpired})
+	assert.Contains(string(text), `"Reason":"Expired"`)
+}
+
+
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+// TESTING MAX TASK AGE (maxAge.go)
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+
+func TestSetMaxTaskAge_DropsOldWaitingTasks(t *testing.T) {
+	assert := assert.New(t)
+
+	q := Init(5, "age", 1)
+	clock := NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	q.SetClock(clock)
+	q.SetMaxTaskAge(time.Minute)
+	q.Start()
+	defer q.Stop()
+
+	sub := q.Subscribe(10, DropOldest)
+	defer sub.Close()
+
+	release := make(chan struct{})
+	blocking := func(params map[string]interface{}) error {
+		<-release
+		return nil
+	}
+
+	var ran int32
+	counting := func(params map[string]interface{}) error {
+		atomic.AddInt32(&ran, 1)
+		return nil
+	}
+
+	q.Add(blocking, map[string]interface{}{}, "head")
+	q.Add(counting, map[string]interface{}{}, "old")
+	clock.Advance(2 * time.Minute)
+	q.Add(counting, map[string]interface{}{}, "fresh")
+	close(release)
+
+	events := map[string]Event{}
+	for len(events) < 3 {
+		event := <-sub.Events()
+		events[event.ExternalId] = event
+	}
+
+	assert.Equal(EventFailed, events["old"].Type)
+	assert.Equal(ReasonMaxAge, events["old"].Reason)
+	assert.ErrorIs(events["old"].Err, ErrTaskTooOld)
+	assert.Equal(ReasonSuccess, events["fresh"].Reason)
+	assert.Equal(int32(1), atomic.LoadInt32(&ran))
+}
+
+
+func TestSetMaxTaskAge_AbandonsOldProcessingTasks(t *testing.T) {
+	assert := assert.New(t)
+
+	q := Init(5, "age", 1)
+	q.SetMaxTaskAge(20 * time.Millisecond)
+	q.Start()
+	defer q.Stop()
+
+	sub := q.SubscribeFiltered(EventFilter{Types: []EventType{EventAbandoned}}, 10, DropOldest)
+	defer sub.Close()
+
+	cancelled := make(chan struct{})
+	q.AddWithDeadline(func(ctx context.Context, params map[string]interface{}) error {
+		<-ctx.Done()
+		close(cancelled)
+		return ctx.Err()
+	}, map[string]interface{}{}, "slow", time.Time{})
+
+	select {
+	case event := <-sub.Events():
+		assert.Equal(ReasonMaxAge, event.Reason)
+	case <-time.After(time.Second):
+		assert.Fail("not abandoned")
+	}
+
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		assert.Fail("context not cancelled")
+	}
+}
+
+
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+// TESTING DRAIN (drain.go)
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+
+func TestBeginDrain_RejectsNewAndReportsPositions(t *testing.T) {
+	assert := assert.New(t)
+
+	q := Init(5, "drain", 1)
+	q.Start()
+	defer q.Stop()
+
+	release := make(chan struct{})
+	var done int32
+	blocking := func(params map[string]interface{}) error {
+		<-release
+		atomic.AddInt32(&done, 1)
+		return nil
+	}
+
+	q.Add(blocking, map[string]interface{}{}, "1")
+	q.Add(blocking, map[string]interface{}{}, "2")
+	q.Add(blocking, map[string]interface{}{}, "3")
+
+	q.BeginDrain()
+	assert.True(q.IsDraining())
+	assert.True(q.SnapshotView().Draining)
+	assert.ErrorIs(q.Add(noop, map[string]interface{}{}, "4"), ErrDraining)
+
+	position, ok := q.Position("1")
+	assert.True(ok)
+	assert.Equal(TaskPosition{Processing: true}, position)
+
+	position, _ = q.Position("3")
+	assert.Equal(TaskPosition{Ahead: 1}, position)
+
+	_, ok = q.Position("4")
+	assert.False(ok)
+
+	// the submitted tasks still finish
+	close(release)
+	assert.Eventually(func() bool { return atomic.LoadInt32(&done) == 3 }, time.Second, 10 * time.Millisecond)
+
+	q.EndDrain()
+	assert.NoError(q.Add(noop, map[string]interface{}{}, "4"))
+}
+
+
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+// TESTING SOFT CAPACITY (softCapacity.go)
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+
+func TestSetSoftCapacity_AcceptsBurstsIntoOverflow(t *testing.T) {
+	assert := assert.New(t)
+
+	q := Init(2, "soft", 1)
+	q.SetSoftCapacity(4)
+	q.Start()
+	defer q.Stop()
+
+	sub := q.SubscribeFiltered(EventFilter{Types: []EventType{EventPressure}}, 10, DropOldest)
+	defer sub.Close()
+
+	release := make(chan struct{})
+	var mu sync.Mutex
+	order := []string{}
+	action := func(params map[string]interface{}) error {
+		<-release
+		mu.Lock()
+		defer mu.Unlock()
+		order = append(order, params["id"].(string))
+		return nil
+	}
+
+	// one processing, two waiting, four in the overflow
+	for i := 0; i < 7; i++ {
+		id := strconv.Itoa(i)
+		assert.NoError(q.Add(action, map[string]interface{}{"id": id}, id))
+	}
+	assert.Error(q.Add(action, map[string]interface{}{"id": "7"}, "7"))
+
+	stats := q.OverflowStats()
+	assert.Equal(OverflowStats{Limit: 4, InUse: 4, Peak: 4, Accepted: 4, Rejected: 1, Pressure: PressureCritical}, stats)
+	assert.Equal(6, len(q.SnapshotView().Waiting))
+
+	levels := []PressureLevel{}
+	for len(levels) < 3 {
+		levels = append(levels, (<-sub.Events()).Pressure)
+	}
+	assert.Equal([]PressureLevel{PressureElevated, PressureHigh, PressureCritical}, levels)
+
+	close(release)
+	assert.Eventually(func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(order) == 7
+	}, time.Second, 10 * time.Millisecond)
+
+	mu.Lock()
+	assert.Equal([]string{"0", "1", "2", "3", "4", "5", "6"}, order)
+	mu.Unlock()
+
+	assert.Equal(PressureNone, q.OverflowStats().Pressure)
+}
+
+
+func TestSetSoftCapacity_Disabled(t *testing.T) {
+	assert := assert.New(t)
+
+	q := Init(1, "soft", 0)
+	q.Start()
+	defer q.Stop()
+
+	q.Add(noop, map[string]interface{}{}, "1")
+	assert.Error(q.Add(noop, map[string]interface{}{}, "2"))
+	assert.Equal(OverflowStats{}, q.OverflowStats())
+}
+
+
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+// TESTING STATS (stats.go)
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+
+func TestStats_CountsActivity(t *testing.T) {
+	assert := assert.New(t)
+
+	q := Init(1, "stats", 1)
+	q.Start()
+	defer q.Stop()
+
+	failing := func(params map[string]interface{}) error { return errors.New("failed") }
+
+	assert.NoError(q.Add(noop, map[string]interface{}{}, "1"))
+	assert.Eventually(func() bool { return q.Stats().Completed == 1 }, time.Second, 10 * time.Millisecond)
+
+	assert.NoError(q.Add(failing, map[string]interface{}{}, "2"))
+	assert.Eventually(func() bool { return q.Stats().Failed == 1 }, time.Second, 10 * time.Millisecond)
+
+	assert.Error(q.Add(noop, map[string]interface{}{}, " "))
+
+	stats := q.Stats()
+	assert.Equal(uint64(0), stats.Epoch)
+	assert.False(stats.Since.IsZero())
+	assert.Equal(2, stats.Enqueued)
+	assert.Equal(1, stats.Rejected)
+}
+
+
+func TestResetStats_StartsNewEpoch(t *testing.T) {
+	assert := assert.New(t)
+
+	q := Init(1, "stats", 1)
+	q.Start()
+	defer q.Stop()
+
+	assert.NoError(q.Add(noop, map[string]interface{}{}, "1"))
+	assert.Eventually(func() bool { return q.Stats().Completed == 1 }, time.Second, 10 * time.Millisecond)
+
+	ended := q.ResetStats()
+	assert.Equal(uint64(0), ended.Epoch)
+	assert.Equal(1, ended.Enqueued)
+	assert.Equal(1, ended.Completed)
+
+	stats := q.Stats()
+	assert.Equal(uint64(1), stats.Epoch)
+	assert.False(stats.Since.Before(ended.Since))
+	assert.Equal(0, stats.Enqueued)
+	assert.Equal(0, stats.Completed)
+	assert.Empty(q.Fairness())
+
+	assert.NoError(q.Add(noop, map[string]interface{}{}, "2"))
+	assert.Eventually(func() bool { return q.Stats().Completed == 1 }, time.Second, 10 * time.Millisecond)
+	assert.Equal(1, q.Stats().Enqueued)
+	assert.Equal(uint64(1), q.ResetStats().Epoch)
+}
+
+
+func TestStats_CurrentLoad(t *testing.T) {
+	assert := assert.New(t)
+
+	q := InitPreallocated(3, "stats", 1)
+	stats := q.Stats()
+	assert.Equal(3, stats.Capacity)
+	assert.Equal(3, stats.ReadyPool)
+
+	q.Start()
+	defer q.Stop()
+
+	release := make(chan struct{})
+	assert.NoError(q.Add(func(params map[string]interface{}) error {
+		<-release
+		return nil
+	}, map[string]interface{}{}, "running"))
+	assert.NoError(q.Add(noop, map[string]interface{}{}, "1"))
+	assert.NoError(q.Add(noop, map[string]interface{}{}, "2", WithDelay(time.Hour)))
+
+	stats = q.Stats()
+	assert.Equal(2, stats.Waiting)
+	assert.Equal(1, stats.Processing)
+	assert.Equal(0, stats.ReadyPool)
+
+	close(release)
+	assert.Eventually(func() bool { return q.Stats().Completed == 2 }, time.Second, 10 * time.Millisecond)
+
+	stats = q.Stats()
+	assert.Equal(1, stats.Waiting)
+	assert.Equal(0, stats.Processing)
+	assert.Equal(2, stats.ReadyPool)
+	assert.Equal(3, stats.Enqueued)
+}
+
+
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+// TESTING HISTORY (history.go)
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+
+func TestHistory_RecordsAttemptsAcrossRetries(t *testing.T) {
+	assert := assert.New(t)
+
+	history := NewHistory(10)
+	q := Init(1, "history", 1)
+	q.SetHistory(history)
+	q.Start()
+	defer q.Stop()
+
+	var runs int32
+	action := func(params map[string]interface{}) error {
+		if atomic.AddInt32(&runs, 1) < 3 {
+			return errors.New("not yet")
+		}
+		return nil
+	}
+
+	for i := 0; i < 3; i++ {
+		assert.NoError(q.Add(action, map[string]interface{}{}, "job"))
+		assert.Eventually(func() bool { return len(history.Attempts("job")) == i + 1 }, time.Second, 10 * time.Millisecond)
+	}
+
+	attempts := history.Attempts("job")
+	for i, attempt := range attempts {
+		assert.Equal(i + 1, attempt.Number)
+		assert.Equal("history", attempt.Worker)
+		assert.False(attempt.StartedAt.IsZero())
+		assert.True(attempt.Duration >= 0)
+	}
+
+	assert.EqualError(attempts[0].Err, "not yet")
+	assert.Equal(ReasonActionError, attempts[1].Reason)
+	assert.NoError(attempts[2].Err)
+	assert.Equal(ReasonSuccess, attempts[2].Reason)
+
+	last, ok := history.Last("job")
+	assert.True(ok)
+	assert.Equal(3, last.Number)
+
+	_, ok = history.Last("other")
+	assert.False(ok)
+	assert.Empty(history.Attempts("other"))
+}
+
+
+func TestHistory_SharedAndBounded(t *testing.T) {
+	assert := assert.New(t)
+
+	history := NewHistory(2)
+	q1 := Init(1, "first", 1)
+	q2 := Init(1, "second", 1)
+	q1.SetHistory(history)
+	q2.SetHistory(history)
+	q1.Start()
+	q2.Start()
+	defer q1.Stop()
+	defer q2.Stop()
+
+	assert.NoError(q1.Add(noop, map[string]interface{}{}, "a"))
+	assert.Eventually(func() bool { return len(history.Attempts("a")) == 1 }, time.Second, 10 * time.Millisecond)
+	assert.NoError(q2.Add(noop, map[string]interface{}{}, "a"))
+	assert.Eventually(func() bool { return len(history.Attempts("a")) == 2 }, time.Second, 10 * time.Millisecond)
+
+	attempts := history.Attempts("a")
+	assert.Equal("first", attempts[0].Worker)
+	assert.Equal("second", attempts[1].Worker)
+
+	for _, id := range []string{"b", "c"} {
+		assert.NoError(q1.Add(noop, map[string]interface{}{}, id))
+		assert.Eventually(func() bool { return len(history.Attempts(id)) == 1 }, time.Second, 10 * time.Millisecond)
+	}
+
+	// a was run first, so it was dropped
+	assert.Empty(history.Attempts("a"))
+}
+
+
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+// TESTING DEDUP (dedup.go)
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+
+func TestSetDedupKey_RejectsSemanticDuplicates(t *testing.T) {
+	assert := assert.New(t)
+
+	q := Init(5, "dedup", 1)
+	q.SetDedupKey(DedupByParams("customer"))
+	q.Start()
+	defer q.Stop()
+
+	release := make(chan struct{})
+	q.RegisterAction("sync", func(params map[string]interface{}) error {
+		<-release
+		return nil
+	})
+
+	// 1 is processing, so it isn't a duplicate of 2
+	assert.NoError(q.AddByName("sync", map[string]interface{}{"customer": "a"}, "1"))
+	assert.Eventually(func() bool { return len(q.SnapshotView().Processing) == 1 }, time.Second, 10 * time.Millisecond)
+	assert.NoError(q.AddByName("sync", map[string]interface{}{"customer": "a", "attempt": 1}, "2"))
+
+	err := q.AddByName("sync", map[string]interface{}{"customer": "a", "attempt": 2}, "3")
+	assert.EqualError(err, "Task is a duplicate of task 2, which is waiting to be processed.")
+	assert.NoError(q.AddByName("sync", map[string]interface{}{"customer": "b"}, "4"))
+
+	// external ids still count
+	assert.Error(q.AddByName("sync", map[string]interface{}{"customer": "c"}, "4"))
+
+	close(release)
+	assert.Eventually(func() bool { return len(q.SnapshotView().Processing) == 0 }, time.Second, 10 * time.Millisecond)
+	assert.NoError(q.AddByName("sync", map[string]interface{}{"customer": "a"}, "5"))
+
+	changes := q.ConfigChanges()
+	assert.Equal("dedupKey", changes[0].Setting)
+	assert.Equal("externalId", changes[0].Old)
+	assert.Equal("custom", changes[0].New)
+}
+
+
+func TestSetDedupKey_IndexesWaitingTasks(t *testing.T) {
+	assert := assert.New(t)
+
+	q := Init(5, "dedup", 1)
+	q.Start()
+	defer q.Stop()
+
+	release := make(chan struct{})
+	defer close(release)
+	blocking := func(params map[string]interface{}) error {
+		<-release
+		return nil
+	}
+
+	assert.NoError(q.Add(blocking, map[string]interface{}{}, "running"))
+	assert.NoError(q.Add(blocking, map[string]interface{}{"key": "x"}, "1"))
+	assert.NoError(q.Add(blocking, map[string]interface{}{"key": "x"}, "2"))
+
+	byKey := func(externalId string, actionName string, params map[string]interface{}) string {
+		key, _ := params["key"].(string)
+		return key
+	}
+
+	q.SetDedupKey(byKey)
+	assert.Error(q.Add(blocking, map[string]interface{}{"key": "x"}, "3"))
+
+	// tasks without a key are never duplicates
+	assert.NoError(q.Add(blocking, map[string]interface{}{}, "4"))
+	assert.NoError(q.Add(blocking, map[string]interface{}{}, "5"))
+
+	q.SetDedupKey(nil)
+	assert.NoError(q.Add(blocking, map[string]interface{}{"key": "x"}, "6"))
+}
+
+
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+// TESTING WARM HOOKS (warmup.go)
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+
+func TestSetWarmup_ParksTasksUntilWarm(t *testing.T) {
+	assert := assert.New(t)
+
+	q := Init(5, "warm", 2)
+	q.SetEnv("env")
+
+	warm := make(chan struct{})
+	var warmed int32
+	var sawEnv atomic.Bool
+	assert.NoError(q.SetWarmup("load", func(ctx context.Context) error {
+		env, _ := Env[string](ctx)
+		sawEnv.Store(env == "env")
+		<-warm
+		atomic.StoreInt32(&warmed, 1)
+		return nil
+	}))
+
+	var ranWarm int32
+	var ran int32
+	q.RegisterAction("load", func(params map[string]interface{}) error {
+		atomic.StoreInt32(&ranWarm, atomic.LoadInt32(&warmed))
+		atomic.AddInt32(&ran, 1)
+		return nil
+	})
+
+	q.Start()
+	defer q.Stop()
+
+	assert.NoError(q.AddByName("load", map[string]interface{}{}, "1"))
+	assert.Eventually(func() bool { return len(q.ParkedTasks("load")) == 1 }, time.Second, 10 * time.Millisecond)
+	assert.Equal(int32(0), atomic.LoadInt32(&ran))
+
+	// other tasks aren't held back
+	assert.NoError(q.Add(noop, map[string]interface{}{}, "2"))
+	assert.Eventually(func() bool { return q.Stats().Completed == 1 }, time.Second, 10 * time.Millisecond)
+
+	close(warm)
+	failed, err := q.WaitWarm(context.Background())
+	assert.NoError(err)
+	assert.Nil(failed)
+	assert.True(sawEnv.Load())
+
+	assert.Eventually(func() bool { return atomic.LoadInt32(&ran) == 1 }, time.Second, 10 * time.Millisecond)
+	assert.Equal(int32(1), atomic.LoadInt32(&ranWarm))
+}
+
+
+func TestSetWarmup_RetriesFailedHooks(t *testing.T) {
+	assert := assert.New(t)
+
+	q := Init(5, "warm", 1)
+
+	var calls int32
+	assert.NoError(q.SetWarmup("load", func(ctx context.Context) error {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			return errors.New("cold")
+		}
+		return nil
+	}))
+	assert.Error(q.SetWarmup(" ", nil))
+
+	q.Start()
+	failed, err := q.WaitWarm(context.Background())
+	assert.NoError(err)
+	assert.EqualError(failed["load"], "cold")
+	q.Stop()
+
+	q.Start()
+	failed, err = q.WaitWarm(context.Background())
+	assert.NoError(err)
+	assert.Nil(failed)
+	q.Stop()
+
+	// warm hooks that succeeded aren't called again
+	q.Start()
+	defer q.Stop()
+	q.WaitWarm(context.Background())
+	assert.Equal(int32(2), atomic.LoadInt32(&calls))
+}
+
+
+func TestWaitWarm_ContextDone(t *testing.T) {
+	assert := assert.New(t)
+
+	q := Init(5, "warm", 1)
+	q.SetWarmup("load", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	q.Start()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20 * time.Millisecond)
+	defer cancel()
+	_, err := q.WaitWarm(ctx)
+	assert.ErrorIs(err, context.DeadlineExceeded)
+
+	// Stop cancels the hooks' context
+	q.Stop()
+	failed, err := q.WaitWarm(context.Background())
+	assert.NoError(err)
+	assert.ErrorIs(failed["load"], context.Canceled)
+}
+
+
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+// TESTING QUEUE CONTEXT (queueContext.go)
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+
+func TestAddContextAction_CancelledOnStop(t *testing.T) {
+	assert := assert.New(t)
+
+	q := Init(5, "context", 1)
+	q.Start()
+
+	sub := q.Subscribe(10, DropOldest)
+	defer sub.Close()
+
+	started := make(chan struct{})
+	var cause atomic.Value
+	assert.NoError(q.AddContextAction(func(ctx context.Context, params map[string]interface{}) error {
+		close(started)
+		<-ctx.Done()
+		cause.Store(context.Cause(ctx))
+		return ctx.Err()
+	}, map[string]interface{}{}, "long"))
+
+	<-started
+	q.Stop()
+
+	event := <-sub.Events()
+	assert.Equal(EventFailed, event.Type)
+	assert.Equal(ReasonQueueShutdown, event.Reason)
+	assert.ErrorIs(event.Err, context.Canceled)
+	assert.Equal(ErrQueueStopped, cause.Load())
+}
+
+
+func TestAddContextAction_FreshContextAfterRestart(t *testing.T) {
+	assert := assert.New(t)
+
+	q := Init(5, "context", 1)
+	q.Start()
+	q.Stop()
+	q.Start()
+	defer q.Stop()
+
+	sub := q.Subscribe(10, DropOldest)
+	defer sub.Close()
+
+	assert.NoError(q.AddContextAction(func(ctx context.Context, params map[string]interface{}) error {
+		return ctx.Err()
+	}, map[string]interface{}{}, "1"))
+
+	event := <-sub.Events()
+	assert.Equal(EventCompleted, event.Type)
+	assert.Equal(ReasonSuccess, event.Reason)
+
+	// cancelled by the action itself, not by Stop
+	assert.NoError(q.AddContextAction(func(ctx context.Context, params map[string]interface{}) error {
+		return context.Canceled
+	}, map[string]interface{}{}, "2"))
+	assert.Equal(ReasonCancelled, (<-sub.Events()).Reason)
+}
+
+
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+// TESTING COMPLETION CALLBACKS (callbacks.go)
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+
+func TestAddWithCallbacks_SuccessAndFailure(t *testing.T) {
+	assert := assert.New(t)
+
+	q := Init(5, "callbacks", 1)
+	q.Start()
+	defer q.Stop()
+
+	succeeded := make(chan string, 2)
+	failed := make(chan error, 2)
+	onSuccess := func() { succeeded <- "ok" }
+	onFailure := func(err error) { failed <- err }
+
+	assert.NoError(q.AddWithCallbacks(noop, map[string]interface{}{}, "1", onSuccess, onFailure))
+	assert.Equal("ok", <-succeeded)
+
+	failing := func(params map[string]interface{}) error { return errors.New("boom") }
+	assert.NoError(q.AddWithCallbacks(failing, map[string]interface{}{}, "2", onSuccess, onFailure))
+	assert.EqualError(<-failed, "boom")
+
+	// either callback may be nil
+	assert.NoError(q.AddWithCallbacks(failing, map[string]interface{}{}, "3", onSuccess, nil))
+	assert.NoError(q.AddWithCallbacks(noop, map[string]interface{}{}, "4", nil, onFailure))
+	assert.Eventually(func() bool { return q.Stats().Completed + q.Stats().Failed == 4 }, time.Second, 10 * time.Millisecond)
+	assert.Empty(succeeded)
+	assert.Empty(failed)
+}
+
+
+func TestAddWithCallbacks_CanAddFromCallback(t *testing.T) {
+	assert := assert.New(t)
+
+	q := Init(5, "callbacks", 1)
+	q.Start()
+	defer q.Stop()
+
+	added := make(chan error, 1)
+	assert.NoError(q.AddWithCallbacks(noop, map[string]interface{}{}, "1", func() {
+		added <- q.Add(noop, map[string]interface{}{}, "follow-up")
+	}, nil))
+
+	assert.NoError(<-added)
+}
+
+
+func TestAddWithCallbacks_AbandonedAndDropped(t *testing.T) {
+	assert := assert.New(t)
+
+	q := Init(5, "callbacks", 1)
+	q.SetAbandonTimeout(20 * time.Millisecond)
+	q.Start()
+	defer q.Stop()
+
+	release := make(chan struct{})
+	defer close(release)
+	blocking := func(params map[string]interface{}) error {
+		<-release
+		return nil
+	}
+
+	failures := make(chan error, 2)
+	onFailure := func(err error) { failures <- err }
+
+	// 2 waits past the max age while 1 holds the only process until it is abandoned
+	assert.NoError(q.AddWithCallbacks(blocking, map[string]interface{}{}, "1", nil, onFailure))
+	q.SetMaxTaskAge(10 * time.Millisecond)
+	assert.NoError(q.AddWithCallbacks(noop, map[string]interface{}{}, "2", nil, onFailure))
+
+	assert.ErrorIs(<-failures, ErrTaskAbandoned)
+	assert.ErrorIs(<-failures, ErrTaskTooOld)
+}
+
+
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+// TESTING TASK HANDLES (handle.go)
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+
+func TestWithHandle_AwaitsTask(t *testing.T) {
+	assert := assert.New(t)
+
+	q := Init(5, "handle", 1)
+	q.Start()
+	defer q.Stop()
+
+	release := make(chan struct{})
+	var handle *TaskHandle
+	err := q.Add(func(params map[string]interface{}) error {
+		<-release
+		return errors.New("boom")
+	}, map[string]interface{}{}, "1", WithHandle(&handle))
+	assert.NoError(err)
+	assert.Equal("1", handle.ExternalId)
+
+	_, ended := handle.Result()
+	assert.False(ended)
+	assert.NoError(handle.Err())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10 * time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(handle.Wait(ctx), context.DeadlineExceeded)
+
+	close(release)
+	<-handle.Done()
+	assert.EqualError(handle.Err(), "boom")
+	assert.EqualError(handle.Wait(context.Background()), "boom")
+
+	result, ended := handle.Result()
+	assert.True(ended)
+	assert.Equal(EventFailed, result.Type)
+	assert.Equal(ReasonActionError, result.Reason)
+
+	err = q.Add(noop, map[string]interface{}{}, "2", WithHandle(&handle))
+	assert.NoError(err)
+	assert.NoError(handle.Wait(context.Background()))
+	result, _ = handle.Result()
+	assert.Equal(EventCompleted, result.Type)
+}
+
+
+func TestWithHandle_Rejected(t *testing.T) {
+	assert := assert.New(t)
+
+	q := Init(5, "handle", 1)
+
+	var handle *TaskHandle
+	err := q.Add(noop, map[string]interface{}{}, "1", WithHandle(&handle))
+	assert.Error(err)
+	assert.Nil(handle)
+
+	q.Start()
+	defer q.Stop()
+	assert.Error(q.Add(noop, map[string]interface{}{}, "1", WithHandle(nil)))
+}
+
+
+func TestWithHandle_ThroughMux(t *testing.T) {
+	assert := assert.New(t)
+
+	a := Init(5, "a", 1)
+	mux, err := NewMux(RouteByTenant("a"), a)
+	assert.NoError(err)
+	mux.Start()
+	defer mux.Stop()
+
+	var handle *TaskHandle
+	assert.NoError(mux.Add(noop, map[string]interface{}{}, "1", WithHandle(&handle)))
+	assert.NoError(handle.Wait(context.Background()))
+}
+
+
+func TestWithHandle_Abandoned(t *testing.T) {
+	assert := assert.New(t)
+
+	q := Init(5, "handle", 1)
+	q.SetAbandonTimeout(10 * time.Millisecond)
+	q.Start()
+	defer q.Stop()
+
+	release := make(chan struct{})
+	defer close(release)
+
+	var handle *TaskHandle
+	err := q.Add(func(params map[string]interface{}) error {
+		<-release
+		return nil
+	}, map[string]interface{}{}, "1", WithHandle(&handle))
+	assert.NoError(err)
+
+	assert.ErrorIs(handle.Wait(context.Background()), ErrTaskAbandoned)
+	result, _ := handle.Result()
+	assert.Equal(EventAbandoned, result.Type)
+	assert.Equal(ReasonTimeout, result.Reason)
+}
+
+
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+// TESTING PRIORITIES (priority.go)
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+
+func TestAddWithPriority_DispatchesHigherPrioritiesFirst(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, order := range []DispatchOrder{FIFO, LIFO} {
+		q := Init(10, "priority", 1)
+		q.SetDispatchOrder(order)
+		q.Start()
+
+		release := make(chan struct{})
+		var mu sync.Mutex
+		ran := []string{}
+		action := func(params map[string]interface{}) error {
+			<-release
+			mu.Lock()
+			defer mu.Unlock()
+			ran = append(ran, params["id"].(string))
+			return nil
+		}
+
+		add := func(id string, priority Priority) {
+			assert.NoError(q.AddWithPriority(action, map[string]interface{}{"id": id}, id, priority))
+		}
+
+		// blocks the only process while the others wait
+		add("first", PriorityNormal)
+		add("low", PriorityLow)
+		add("normal-1", PriorityNormal)
+		add("high-1", PriorityHigh)
+		add("normal-2", PriorityNormal)
+		add("high-2", PriorityHigh)
+
+		expected := []string{"first", "high-1", "high-2", "normal-1", "normal-2", "low"}
+		if order == LIFO {
+			expected = []string{"first", "high-2", "high-1", "normal-2", "normal-1", "low"}
+		}
+
+		waiting := []string{}
+		for _, task := range q.SnapshotView().Waiting {
+			waiting = append(waiting, task.ExternalId)
+		}
+		assert.Equal(expected[1:], waiting)
+
+		close(release)
+		assert.Eventually(func() bool {
+			mu.Lock()
+			defer mu.Unlock()
+			return len(ran) == len(expected)
+		}, time.Second, 10 * time.Millisecond)
+
+		mu.Lock()
+		assert.Equal(expected, ran, order.String())
+		mu.Unlock()
+		q.Stop()
+	}
+}
+
+
+func TestAddWithPriority_StrictFIFOKeepsTheOrderTasksWereAddedIn(t *testing.T) {
+	assert := assert.New(t)
+
+	q := Init(3, "priority", 0)
+	q.Start()
+	defer q.Stop()
+
+	assert.NoError(q.AddWithPriority(noop, map[string]interface{}{}, "normal", PriorityNormal))
+	assert.NoError(q.AddWithPriority(noop, map[string]interface{}{}, "low", PriorityLow))
+	assert.NoError(q.AddWithPriority(noop, map[string]interface{}{}, "high", PriorityHigh))
+
+	waiting := func() []string {
+		ids := []string{}
+		for _, task := range q.SnapshotView().Waiting {
+			ids = append(ids, task.ExternalId)
+		}
+		return ids
+	}
+
+	assert.Equal([]string{"high", "normal", "low"}, waiting())
+
+	// strict FIFO mode moves every task into one level, in the order they were added
+	q.SetStrictFIFO(true)
+	assert.Equal([]string{"normal", "low", "high"}, waiting())
+	assert.NoError(q.Resize(4))
+	assert.NoError(q.AddWithPriority(noop, map[string]interface{}{}, "high-2", PriorityHigh))
+	assert.Equal([]string{"normal", "low", "high", "high-2"}, waiting())
+
+	q.SetStrictFIFO(false)
+	assert.Equal([]string{"high", "high-2", "normal", "low"}, waiting())
+	assert.True(q.isFull())
+}
+
+
+func TestAddWithPriority_Validation(t *testing.T) {
+	assert := assert.New(t)
+
+	q := Init(5, "priority", 0)
+	q.Start()
+	defer q.Stop()
+
+	assert.Error(q.AddWithPriority(noop, map[string]interface{}{}, "1", Priority(5)))
+	assert.NoError(q.AddWithPriority(noop, map[string]interface{}{}, "1", PriorityHigh))
+	assert.Equal(PriorityHigh, q.SnapshotView().Waiting[0].Priority)
+
+	text, err := PriorityLow.MarshalText()
+	assert.NoError(err)
+	assert.Equal("low", string(text))
+
+	var priority Priority
+	assert.NoError(priority.UnmarshalText([]byte("high")))
+	assert.Equal(PriorityHigh, priority)
+	assert.Error(priority.UnmarshalText([]byte("urgent")))
+}
+
+
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+// TESTING DELAYED TASKS (delay.go)
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+
+func TestAddAfter_DispatchesOnceDelayPassed(t *testing.T) {
+	assert := assert.New(t)
+
+	q := Init(5, "delay", 2)
+	q.Start()
+	defer q.Stop()
+
+	var mu sync.Mutex
+	ran := map[string]time.Time{}
+	action := func(params map[string]interface{}) error {
+		mu.Lock()
+		defer mu.Unlock()
+		ran[params["id"].(string)] = time.Now()
+		return nil
+	}
+
+	added := time.Now()
+	assert.NoError(q.AddAfter(action, map[string]interface{}{"id": "later"}, "later", 50 * time.Millisecond))
+	assert.NoError(q.Add(action, map[string]interface{}{"id": "now"}, "now"))
+
+	// dedup covers delayed tasks
+	assert.Error(q.Add(action, map[string]interface{}{"id": "later"}, "later"))
+
+	view := q.SnapshotView()
+	assert.Equal(1, len(view.Waiting))
+	assert.False(view.Waiting[0].NotBefore.IsZero())
+
+	assert.Eventually(func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(ran) == 2
+	}, time.Second, 10 * time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.True(ran["later"].Sub(added) >= 50 * time.Millisecond)
+	assert.True(ran["now"].Before(ran["later"]))
+}
+
+
+func TestAddAfter_TakesUpCapacity(t *testing.T) {
+	assert := assert.New(t)
+
+	q := Init(2, "delay", 0)
+	q.SetSoftCapacity(5)
+	q.Start()
+	defer q.Stop()
+
+	assert.NoError(q.AddAfter(noop, map[string]interface{}{}, "1", time.Hour))
+	assert.NoError(q.Add(noop, map[string]interface{}{}, "2"))
+
+	// the queue is full, so delayed tasks are rejected while others go to the overflow
+	assert.Error(q.AddAfter(noop, map[string]interface{}{}, "3", time.Hour))
+	assert.NoError(q.Add(noop, map[string]interface{}{}, "4"))
+	assert.Equal(1, q.OverflowStats().InUse)
+
+	position, ok := q.Position("1")
+	assert.True(ok)
+	assert.Equal(2, position.Ahead)
+}
+
+
+func TestAddAfter_FakeClock(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := NewFakeClock(time.Now())
+	q := Init(5, "delay", 1)
+	q.SetClock(clock)
+	q.Start()
+	defer q.Stop()
+
+	var ran int32
+	action := func(params map[string]interface{}) error {
+		atomic.AddInt32(&ran, 1)
+		return nil
+	}
+
+	assert.NoError(q.AddAfter(action, map[string]interface{}{}, "1", time.Minute))
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(int32(0), atomic.LoadInt32(&ran))
+
+	// the next dispatch attempt picks up the due task
+	clock.Advance(time.Minute)
+	assert.NoError(q.Add(action, map[string]interface{}{}, "2"))
+	assert.Eventually(func() bool { return atomic.LoadInt32(&ran) == 2 }, time.Second, 10 * time.Millisecond)
+}
+
+
+func TestAddAt_FollowsWallClock(t *testing.T) {
+	assert := assert.New(t)
+
+	clock := NewFakeClock(time.Date(2026, 1, 1, 22, 0, 0, 0, time.UTC))
+	q := Init(5, "delay", 1)
+	q.SetClock(clock)
+	q.Start()
+	defer q.Stop()
+
+	var ran int32
+	action := func(params map[string]interface{}) error {
+		atomic.AddInt32(&ran, 1)
+		return nil
+	}
+
+	offPeak := time.Date(2026, 1, 2, 2, 0, 0, 0, time.UTC)
+	assert.NoError(q.AddAt(action, map[string]interface{}{}, "nightly", offPeak))
+	assert.NoError(q.AddAt(action, map[string]interface{}{}, "past", offPeak.Add(-24 * time.Hour)))
+	assert.Eventually(func() bool { return atomic.LoadInt32(&ran) == 1 }, time.Second, 10 * time.Millisecond)
+	assert.Equal(offPeak, q.SnapshotView().Waiting[0].NotBefore)
+
+	clock.Advance(4 * time.Hour)
+	q.wakeDelayed()
+	assert.Eventually(func() bool { return atomic.LoadInt32(&ran) == 2 }, time.Second, 10 * time.Millisecond)
+}
+
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+// TESTING CRON EXPRESSIONS (cron.go)
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+func TestParseSchedule_Fields(t *testing.T) {
+	assert := assert.New(t)
+
+	s, err := ParseSchedule("*/20 9-10 * * mon-fri")
+	assert.NoError(err)
+	assert.Equal([]time.Duration{
+		9 * time.Hour, 9 * time.Hour + 20 * time.Minute, 9 * time.Hour + 40 * time.Minute,
+		10 * time.Hour, 10 * time.Hour + 20 * time.Minute, 10 * time.Hour + 40 * time.Minute,
+	}, s.Times)
+	assert.Equal([]time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday}, s.Days)
+	assert.Empty(s.MonthDays)
+	assert.Empty(s.Months)
+
+	s, err = ParseSchedule("30 2 1,15 JAN,jul 7")
+	assert.NoError(err)
+	assert.Equal([]time.Duration{2 * time.Hour + 30 * time.Minute}, s.Times)
+	assert.Equal([]int{1, 15}, s.MonthDays)
+	assert.Equal([]time.Month{time.January, time.July}, s.Months)
+	assert.Equal([]time.Weekday{time.Sunday}, s.Days)
+
+	s, err = ParseSchedule("0 22/1 * * *")
+	assert.NoError(err)
+	assert.Equal([]time.Duration{22 * time.Hour, 23 * time.Hour}, s.Times)
+}
+
+
+func TestParseSchedule_Shorthands(t *testing.T) {
+	assert := assert.New(t)
+
+	s, err := ParseSchedule("@every 90s")
+	assert.NoError(err)
+	assert.Equal(Schedule{Every: 90 * time.Second}, s)
+
+	s, err = ParseSchedule("@weekly")
+	assert.NoError(err)
+	s.Location = time.UTC
+	assert.Equal(time.Date(2024, 1, 7, 0, 0, 0, 0, time.UTC), s.Next(time.Date(2024, 1, 2, 12, 0, 0, 0, time.UTC)))
+
+	s, err = ParseSchedule("@monthly")
+	assert.NoError(err)
+	assert.Equal([]int{1}, s.MonthDays)
+	assert.Empty(s.Days)
+}
+
+
+func TestParseSchedule_Invalid(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * foo *", "5-1 * * * *", "*/0 * * * *", "@sometimes", "@every", "@every -1m"} {
+		_, err := ParseSchedule(spec)
+		assert.Error(err, spec)
+	}
+}
+
+
+func TestAddCron(t *testing.T) {
+	assert := assert.New(t)
+
+	q := Init(5, "cron", 1)
+	q.SetClock(NewFakeClock(time.Date(2024, 1, 1, 8, 59, 59, 950000000, time.Local)))
+
+	sub := q.Subscribe(10, DropOldest)
+	defer sub.Close()
+
+	assert.Error(q.AddCron("bad", "* * *", noop, nil))
+	assert.NoError(q.AddCron("report", "0 9 * * *", noop, nil))
+	q.Start()
+	defer q.Stop()
+
+	assert.Equal(EventCompleted, (<-sub.Events()).Type)
+	assert.Eventually(func() bool {
+		schedules := q.Schedules()
+		return len(schedules) == 1 && schedules[0].Next.Equal(time.Date(2024, 1, 2, 9, 0, 0, 0, time.Local))
+	}, time.Second, 10 * time.Millisecond)
+	assert.True(q.Schedules()[0].LastFired.Equal(time.Date(2024, 1, 1, 9, 0, 0, 0, time.Local)))
+	assert.True(q.RemoveSchedule("report"))
+	assert.Empty(q.Schedules())
+}
+
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+// TESTING RETRIES (retry.go)
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+func TestRetryPolicy_Backoff(t *testing.T) {
+	assert := assert.New(t)
+
+	p := RetryPolicy{MaxAttempts: 10, BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+	assert.Equal(100 * time.Millisecond, p.Backoff(1))
+	assert.Equal(200 * time.Millisecond, p.Backoff(2))
+	assert.Equal(800 * time.Millisecond, p.Backoff(4))
+	assert.Equal(time.Second, p.Backoff(5))
+	assert.Equal(time.Second, p.Backoff(1000))
+
+	unlimited := RetryPolicy{BaseDelay: time.Hour}
+	assert.Greater(unlimited.Backoff(1000), time.Duration(0))
+	assert.Equal(time.Duration(0), RetryPolicy{}.Backoff(3))
+}
+
+
+func TestSetRetryPolicy_RetriesUntilSuccess(t *testing.T) {
+	assert := assert.New(t)
+
+	q := Init(5, "retry", 1)
+	q.SetRetryPolicy(RetryPolicy{MaxAttempts: 3, BaseDelay: 10 * time.Millisecond})
+	q.Start()
+	defer q.Stop()
+
+	sub := q.Subscribe(10, DropOldest)
+	defer sub.Close()
+
+	var runs int32
+	var handle *TaskHandle
+	err := q.Add(func(params map[string]interface{}) error {
+		if atomic.AddInt32(&runs, 1) < 3 {
+			return errors.New("flaky")
+		}
+
+		return nil
+	}, map[string]interface{}{}, "1", WithHandle(&handle))
+	assert.NoError(err)
+	assert.NoError(handle.Wait(context.Background()))
+
+	for attempt := 1; attempt <= 2; attempt++ {
+		event := <-sub.Events()
+		assert.Equal(EventRetrying, event.Type)
+		assert.Equal(attempt, event.Attempt)
+		assert.EqualError(event.Err, "flaky")
+		assert.False(event.RetryAt.IsZero())
+	}
+
+	event := <-sub.Events()
+	assert.Equal(EventCompleted, event.Type)
+	assert.Equal(3, event.Attempt)
+
+	stats := q.Stats()
+	assert.Equal(2, stats.Retried)
+	assert.Equal(1, stats.Completed)
+	assert.Equal(0, stats.Failed)
+	assert.Equal("retryPolicy", q.ConfigChanges()[0].Setting)
+}
+
+
+func TestSetRetryPolicy_FailsAfterMaxAttempts(t *testing.T) {
+	assert := assert.New(t)
+
+	q := Init(5, "retry", 1)
+	q.SetRetryPolicy(RetryPolicy{MaxAttempts: 2})
+	q.Start()
+	defer q.Stop()
+
+	var runs int32
+	fail := func(params map[string]interface{}) error {
+		return errors.New(fmt.Sprintf("run %d", atomic.AddInt32(&runs, 1)))
+	}
+
+	var handle *TaskHandle
+	err := q.Add(fail, map[string]interface{}{}, "1", WithHandle(&handle))
+	assert.NoError(err)
+	assert.EqualError(handle.Wait(context.Background()), "run 2")
+
+	// permanent errors end the task right away
+	err = q.Add(func(params map[string]interface{}) error {
+		return Permanent(errors.New("bad input"))
+	}, map[string]interface{}{}, "2", WithHandle(&handle))
+	assert.NoError(err)
+	assert.EqualError(handle.Wait(context.Background()), "bad input")
+
+	result, _ := handle.Result()
+	assert.Equal(1, result.Attempt)
+	assert.Equal(2, q.Stats().Failed)
+	assert.Equal(1, q.Stats().Retried)
+}
+
+
+func TestSetRetryPolicy_BranchesOnErrorClass(t *testing.T) {
+	assert := assert.New(t)
+
+	q := Init(5, "retry", 1)
+	q.SetRetryPolicy(RetryPolicy{MaxAttempts: 2, ClassifiedOnly: true})
+	q.Start()
+	defer q.Stop()
+
+	var runs int32
+	fail := func(err error) func(params map[string]interface{}) error {
+		return func(params map[string]interface{}) error {
+			atomic.AddInt32(&runs, 1)
+			return err
+		}
+	}
+
+	// unclassified errors aren't retried by a classified only policy, retryable ones are
+	var handle *TaskHandle
+	err := q.Add(fail(errors.New("plain")), map[string]interface{}{}, "plain", WithHandle(&handle))
+	assert.NoError(err)
+	assert.Error(handle.Wait(context.Background()))
+	assert.Equal(int32(1), atomic.LoadInt32(&runs))
+
+	err = q.Add(fail(Retryable(errors.New("flaky"))), map[string]interface{}{}, "retryable", WithHandle(&handle))
+	assert.NoError(err)
+	assert.Error(handle.Wait(context.Background()))
+	assert.Equal(int32(3), atomic.LoadInt32(&runs))
+
+	err = q.Add(fail(Permanent(errors.New("bad input"))), map[string]interface{}{}, "permanent", WithHandle(&handle))
+	assert.NoError(err)
+	assert.Error(handle.Wait(context.Background()))
+	assert.Equal(int32(4), atomic.LoadInt32(&runs))
+
+	stats := q.Stats()
+	assert.Equal(1, stats.Errors.Unclassified)
+	assert.Equal(2, stats.Errors.Retryable)
+	assert.Equal(1, stats.Errors.Permanent)
+	assert.Equal(0, stats.Errors.RateLimited)
+	assert.Equal(1, stats.Retried)
+}
+
+
+func TestSetRetryPolicy_RateLimitedWithoutRetryAfterBacksOff(t *testing.T) {
+	assert := assert.New(t)
+
+	q := Init(5, "retry", 1)
+	q.SetRetryPolicy(RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Hour})
+	q.Start()
+	defer q.Stop()
+
+	assert.NoError(q.Add(func(params map[string]interface{}) error {
+		return RateLimited(errors.New("429"), 0)
+	}, map[string]interface{}{}, "1"))
+
+	assert.Eventually(func() bool { return len(q.SnapshotView().Waiting) == 1 }, time.Second, time.Millisecond)
+
+	// the downstream didn't say how long to wait, the retry waits as long as the policy allows
+	view := q.SnapshotView().Waiting[0]
+	assert.True(view.NotBefore.After(time.Now().Add(59 * time.Minute)))
+	assert.Equal(1, q.Stats().Errors.RateLimited)
+}
+
+
+func TestSetRetryPolicy_WaitingRetryInView(t *testing.T) {
+	assert := assert.New(t)
+
+	q := Init(5, "retry", 1)
+	q.SetRetryPolicy(RetryPolicy{MaxAttempts: 5, BaseDelay: time.Hour})
+	q.Start()
+	defer q.Stop()
+
+	assert.NoError(q.Add(func(params map[string]interface{}) error {
+		return errors.New("down")
+	}, map[string]interface{}{}, "1"))
+
+	assert.Eventually(func() bool { return len(q.SnapshotView().Waiting) == 1 }, time.Second, 10 * time.Millisecond)
+
+	view := q.SnapshotView().Waiting[0]
+	assert.Equal(1, view.Attempts)
+	assert.Equal("down", view.LastError)
+	assert.True(view.NotBefore.After(time.Now().Add(59 * time.Minute)))
+
+	// the id stays taken while the task waits for its retry
+	assert.Error(q.Add(noop, map[string]interface{}{}, "1"))
+}
+
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+// TESTING DEAD LETTERS (deadLetter.go)
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+func TestSetDeadLetters_KeepsFailedTasks(t *testing.T) {
+	assert := assert.New(t)
+
+	q := Init(5, "dead", 1)
+	q.SetDeadLetters(10)
+	q.SetRetryPolicy(RetryPolicy{MaxAttempts: 2})
+	q.Start()
+	defer q.Stop()
+
+	params := map[string]interface{}{"to": "a@example.com"}
+	var handle *TaskHandle
+	err := q.Add(func(params map[string]interface{}) error {
+		return errors.New("smtp down")
+	}, params, "mail-1", WithHandle(&handle))
+	assert.NoError(err)
+	assert.EqualError(handle.Wait(context.Background()), "smtp down")
+
+	result, _ := handle.Result()
+	assert.Equal(ReasonDLQ, result.Reason)
+
+	letters := q.DeadLetters()
+	assert.Equal(1, len(letters))
+	assert.Equal("mail-1", letters[0].ExternalId)
+	assert.Equal(params, letters[0].Params)
+	assert.EqualError(letters[0].Err, "smtp down")
+	assert.Equal(ReasonActionError, letters[0].Reason)
+	assert.Equal(2, letters[0].Attempts)
+	assert.True(letters[0].Requeueable)
+	assert.False(letters[0].FailedAt.Before(letters[0].EnqueuedAt))
+	assert.Equal(1, q.Stats().DeadLettered)
+
+	// successes aren't kept
+	q.Add(noop, map[string]interface{}{}, "ok", WithHandle(&handle))
+	handle.Wait(context.Background())
+	assert.Equal(1, len(q.DeadLetters()))
+}
+
+
+func TestDeadLetters_CopiesAndRedactsParams(t *testing.T) {
+	assert := assert.New(t)
+
+	q := Init(5, "dead", 1)
+	q.SetDeadLetters(10)
+	assert.NoError(q.AddRedaction("charge", "card"))
+	q.Start()
+	defer q.Stop()
+
+	cards := make(chan interface{}, 2)
+	var runs int32
+	charge := func(params map[string]interface{}) error {
+		cards <- params["card"]
+		if atomic.AddInt32(&runs, 1) == 1 {
+			params["card"] = "changed by the action"
+			return errors.New("declined")
+		}
+		return nil
+	}
+
+	params := map[string]interface{}{"card": "4111", "items": []interface{}{map[string]interface{}{"card": "5500"}}}
+	assert.NoError(q.AddNamed("charge", charge, params, "charge-1"))
+	assert.Equal("4111", <-cards)
+	assert.Eventually(func() bool { return len(q.DeadLetters()) == 1 }, time.Second, time.Millisecond)
+
+	letter := q.DeadLetters()[0]
+	assert.Equal(Redacted, letter.Params["card"])
+	assert.Equal([]interface{}{map[string]interface{}{"card": Redacted}}, letter.Params["items"])
+
+	// changing the params handed out doesn't reach the dead letter
+	letter.Params["card"] = "changed"
+	assert.Equal(Redacted, q.DeadLetters()[0].Params["card"])
+
+	// the task is requeued with the params it had when it failed, not the redacted ones
+	assert.NoError(q.RequeueDeadLetter("charge-1"))
+	assert.Equal("changed by the action", <-cards)
+}
+
+
+func TestSetDeadLetters_DropsOldest(t *testing.T) {
+	assert := assert.New(t)
+
+	q := Init(5, "dead", 1)
+	q.SetDeadLetters(2)
+	q.Start()
+	defer q.Stop()
+
+	fail := func(params map[string]interface{}) error {
+		return errors.New("boom")
+	}
+
+	for _, id := range []string{"1", "2", "3", "2"} {
+		var handle *TaskHandle
+		err := q.Add(fail, map[string]interface{}{}, id, WithHandle(&handle))
+		assert.NoError(err)
+		handle.Wait(context.Background())
+	}
+
+	letters := q.DeadLetters()
+	assert.Equal([]string{"3", "2"}, []string{letters[0].ExternalId, letters[1].ExternalId})
+
+	q.SetDeadLetters(1)
+	assert.Equal("2", q.DeadLetters()[0].ExternalId)
+}
+
+
+func TestRequeueDeadLetter(t *testing.T) {
+	assert := assert.New(t)
+
+	q := Init(5, "dead", 1)
+	q.SetDeadLetters(10)
+	q.Start()
+	defer q.Stop()
+
+	var runs int32
+	flaky := func(params map[string]interface{}) error {
+		if atomic.AddInt32(&runs, 1) == 1 {
+			return errors.New("first run fails")
+		}
+
+		return nil
+	}
+
+	var handle *TaskHandle
+	err := q.Add(flaky, map[string]interface{}{"n": 1}, "1", WithHandle(&handle))
+	assert.NoError(err)
+	handle.Wait(context.Background())
+
+	assert.ErrorIs(q.RequeueDeadLetter("missing"), ErrNoDeadLetter)
+	assert.NoError(q.RequeueDeadLetter("1"))
+	assert.Empty(q.DeadLetters())
+	assert.Eventually(func() bool { return q.Stats().Completed == 1 }, time.Second, 10 * time.Millisecond)
+	assert.Equal(int32(2), atomic.LoadInt32(&runs))
+}
+
+
+func TestRemoveAndPurgeDeadLetters(t *testing.T) {
+	assert := assert.New(t)
+
+	q := Init(5, "dead", 1)
+	q.SetDeadLetters(10)
+	q.Start()
+	defer q.Stop()
+
+	for _, id := range []string{"1", "2", "3"} {
+		var handle *TaskHandle
+		q.Add(func(params map[string]interface{}) error {
+			return errors.New("boom")
+		}, map[string]interface{}{}, id, WithHandle(&handle))
+		handle.Wait(context.Background())
+	}
+
+	assert.True(q.RemoveDeadLetter("2"))
+	assert.False(q.RemoveDeadLetter("2"))
+	assert.Equal(2, q.PurgeDeadLetters())
+	assert.Empty(q.DeadLetters())
+}
+
+
+func TestRequeueDeadLetter_ReaderPayload(t *testing.T) {
+	assert := assert.New(t)
+
+	q := Init(5, "dead", 1)
+	q.SetDeadLetters(10)
+	q.Start()
+	defer q.Stop()
+
+	assert.NoError(q.AddReader(func(ctx context.Context, body io.Reader) error {
+		return errors.New("bad upload")
+	}, io.NopCloser(strings.NewReader("data")), "upload"))
+
+	assert.Eventually(func() bool { return len(q.DeadLetters()) == 1 }, time.Second, 10 * time.Millisecond)
+	assert.False(q.DeadLetters()[0].Requeueable)
+	assert.Error(q.RequeueDeadLetter("upload"))
+}
+
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+// TESTING TASK TIMEOUTS (timeout.go)
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+func TestAddWithTimeout_FreesSlotOfHungAction(t *testing.T) {
+	assert := assert.New(t)
+
+	q := Init(5, "timeout", 1)
+	q.SetRetryPolicy(RetryPolicy{MaxAttempts: 3})
+	q.Start()
+	defer q.Stop()
+
+	sub := q.Subscribe(10, DropOldest)
+	defer sub.Close()
+
+	release := make(chan struct{})
+	hung := func(params map[string]interface{}) error {
+		<-release
+		return nil
+	}
+
+	assert.NoError(q.AddWithTimeout(hung, map[string]interface{}{}, "hung", 20 * time.Millisecond))
+	assert.NoError(q.Add(noop, map[string]interface{}{}, "next"))
+
+	event := <-sub.Events()
+	assert.Equal(EventFailed, event.Type)
+	assert.Equal("hung", event.ExternalId)
+	assert.ErrorIs(event.Err, ErrTaskTimeout)
+	assert.Equal(ReasonTimeout, event.Reason)
+
+	// the slot is free for the next task while the hung action still runs, and it isn't retried
+	event = <-sub.Events()
+	assert.Equal(EventCompleted, event.Type)
+	assert.Equal("next", event.ExternalId)
+	assert.Equal(1, q.Stats().Failed)
+	assert.Equal(0, q.Stats().Retried)
+	assert.Equal("hung", q.AbandonedTasks()[0].ExternalId)
+
+	close(release)
+	assert.Eventually(func() bool { return len(q.AbandonedTasks()) == 0 }, time.Second, 10 * time.Millisecond)
+
+	q.mu.Lock()
+	assert.Equal(2, len(*q.readyTaskPool))
+	q.mu.Unlock()
+}
+
+
+func TestAddContextWithTimeout_ContextExpires(t *testing.T) {
+	assert := assert.New(t)
+
+	q := Init(5, "timeout", 1)
+	q.Start()
+	defer q.Stop()
+
+	sub := q.Subscribe(10, DropOldest)
+	defer sub.Close()
+
+	var hasDeadline atomic.Bool
+	assert.NoError(q.AddContextWithTimeout(func(ctx context.Context, params map[string]interface{}) error {
+		_, ok := ctx.Deadline()
+		hasDeadline.Store(ok)
+		<-ctx.Done()
+		return ctx.Err()
+	}, map[string]interface{}{}, "1", 20 * time.Millisecond))
+
+	event := <-sub.Events()
+	assert.Equal(EventFailed, event.Type)
+	assert.Equal(ReasonTimeout, event.Reason)
+	assert.True(hasDeadline.Load())
+	assert.Eventually(func() bool { return len(q.AbandonedTasks()) == 0 }, time.Second, 10 * time.Millisecond)
+}
+
+
+func TestAddWithTimeout_FastActionCompletes(t *testing.T) {
+	assert := assert.New(t)
+
+	q := Init(5, "timeout", 1)
+	q.Start()
+	defer q.Stop()
+
+	assert.NoError(q.AddWithTimeout(noop, map[string]interface{}{}, "1", time.Second))
+	assert.Eventually(func() bool { return q.Stats().Completed == 1 }, time.Second, 10 * time.Millisecond)
+	assert.Empty(q.AbandonedTasks())
+}
+
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+// TESTING PAUSE (pause.go)
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+func TestPause_TakesTasksWithoutDispatching(t *testing.T) {
+	assert := assert.New(t)
+
+	q := Init(2, "pause", 1)
+	q.Start()
+	defer q.Stop()
+
+	q.Pause()
+	q.Pause()
+	assert.True(q.IsPaused())
+	assert.True(q.SnapshotView().Paused)
+
+	var runs int32
+	action := func(params map[string]interface{}) error {
+		atomic.AddInt32(&runs, 1)
+		return nil
+	}
+
+	assert.NoError(q.Add(action, map[string]interface{}{}, "1"))
+	assert.NoError(q.Add(action, map[string]interface{}{}, "2"))
+	assert.Error(q.Add(action, map[string]interface{}{}, "3"), "a paused queue still has a capacity")
+
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(int32(0), atomic.LoadInt32(&runs))
+	assert.Equal(2, len(q.SnapshotView().Waiting))
+
+	q.Resume()
+	assert.False(q.IsPaused())
+	assert.Eventually(func() bool { return atomic.LoadInt32(&runs) == 2 }, time.Second, 10 * time.Millisecond)
+}
+
+
+func TestPause_LetsProcessingTasksFinish(t *testing.T) {
+	assert := assert.New(t)
+
+	q := Init(5, "pause", 1)
+	q.Start()
+	defer q.Stop()
+
+	release := make(chan struct{})
+	assert.NoError(q.Add(func(params map[string]interface{}) error {
+		<-release
+		return nil
+	}, map[string]interface{}{}, "running"))
+	assert.NoError(q.Add(noop, map[string]interface{}{}, "waiting"))
+
+	q.Pause()
+	close(release)
+
+	assert.Eventually(func() bool { return q.Stats().Completed == 1 }, time.Second, 10 * time.Millisecond)
+	assert.Equal("waiting", q.SnapshotView().Waiting[0].ExternalId)
+
+	q.Resume()
+	q.Resume()
+	assert.Eventually(func() bool { return q.Stats().Completed == 2 }, time.Second, 10 * time.Millisecond)
+}
+
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+// TESTING CANCELLATION (cancel.go)
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+func TestCancel_WithdrawsWaitingTask(t *testing.T) {
+	assert := assert.New(t)
+
+	q := Init(2, "cancel", 1)
+	q.Start()
+	defer q.Stop()
+
+	release := make(chan struct{})
+	defer close(release)
+	assert.NoError(q.Add(func(params map[string]interface{}) error {
+		<-release
+		return nil
+	}, map[string]interface{}{}, "running"))
+
+	var callbackErr error
+	assert.NoError(q.AddWithCallbacks(noop, map[string]interface{}{}, "1", nil, func(err error) { callbackErr = err }))
+	var handle *TaskHandle
+	err := q.Add(noop, map[string]interface{}{}, "2", WithHandle(&handle))
+	assert.NoError(err)
+	assert.Error(q.Add(noop, map[string]interface{}{}, "3"), "the queue is full")
+
+	assert.NoError(q.Cancel("1"))
+	assert.ErrorIs(callbackErr, ErrTaskCancelled)
+	assert.ErrorIs(q.Cancel("1"), ErrTaskNotWaiting)
+	assert.ErrorIs(q.Cancel("running"), ErrTaskNotWaiting)
+
+	assert.NoError(q.Cancel("2"))
+	assert.ErrorIs(handle.Err(), ErrTaskCancelled)
+	result, _ := handle.Result()
+	assert.Equal(ReasonCancelled, result.Reason)
+
+	// the slots and ids are free again
+	assert.Empty(q.SnapshotView().Waiting)
+	assert.NoError(q.Add(noop, map[string]interface{}{}, "1"))
+	assert.NoError(q.Add(noop, map[string]interface{}{}, "3"))
+	assert.Equal(2, q.Stats().Cancelled)
+}
+
+
+func TestCancel_DelayedAndParkedTasks(t *testing.T) {
+	assert := assert.New(t)
+
+	q := Init(5, "cancel", 1)
+	q.Start()
+	defer q.Stop()
+
+	assert.NoError(q.AddAfter(noop, map[string]interface{}{}, "later", time.Hour))
+
+	// a task of an action that was unregistered is parked
+	q.Pause()
+	assert.NoError(q.RegisterAction("work", noop))
+	assert.NoError(q.AddByName("work", map[string]interface{}{}, "parked"))
+	q.UnregisterAction("work")
+	q.Resume()
+	assert.Eventually(func() bool { return len(q.ParkedTasks("work")) == 1 }, time.Second, 10 * time.Millisecond)
+
+	assert.NoError(q.Cancel("later"))
+	assert.NoError(q.Cancel("parked"))
+	assert.Empty(q.SnapshotView().Waiting)
+	assert.Empty(q.SnapshotView().Parked)
+}
+
+
+func TestPurgeWaiting_DoesNotRunDueDelayedTasks(t *testing.T) {
+	assert := assert.New(t)
+
+	q := Init(10, "p", 2)
+	clock := NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	q.SetClock(clock)
+	q.Start()
+	defer q.Stop()
+
+	var ran atomic.Int32
+	counting := func(params map[string]interface{}) error {
+		ran.Add(1)
+		return nil
+	}
+
+	assert.NoError(q.AddAfter(counting, map[string]interface{}{}, "1", time.Hour))
+	assert.NoError(q.AddAfter(counting, map[string]interface{}{}, "2", time.Hour))
+	clock.Advance(2 * time.Hour)
+
+	// withdrawing the first task frees a slot, which mustn't start the second one while it is purged
+	assert.Equal([]string{"1", "2"}, q.PurgeWaiting())
+	assert.Equal(0, q.Stats().Waiting)
+	assert.Equal(0, q.Stats().Processing)
+
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(int32(0), ran.Load())
+}
+
+
+func TestCancelProcessing_CancelsContext(t *testing.T) {
+	assert := assert.New(t)
+
+	q := Init(5, "cancel", 1)
+	q.SetRetryPolicy(RetryPolicy{MaxAttempts: 3})
+	q.Start()
+	defer q.Stop()
+
+	sub := q.Subscribe(10, DropOldest)
+	defer sub.Close()
+
+	started := make(chan struct{})
+	assert.NoError(q.AddContextAction(func(ctx context.Context, params map[string]interface{}) error {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	}, map[string]interface{}{}, "long"))
+
+	<-started
+	position, ok := q.Position("long")
+	assert.True(ok)
+	assert.True(position.Processing)
+	assert.ErrorIs(q.CancelProcessing("missing"), ErrTaskNotProcessing)
+	assert.NoError(q.CancelProcessing("long"))
+
+	event := <-sub.Events()
+	assert.Equal(EventFailed, event.Type)
+	assert.Equal(ReasonCancelled, event.Reason)
+	assert.Equal(0, q.Stats().Retried)
+
+	_, ok = q.Position("long")
+	assert.False(ok)
+	assert.ErrorIs(q.CancelProcessing("long"), ErrTaskNotProcessing)
+}
+
+
+func TestCancelProcessing_ForgetsAbandonedTasks(t *testing.T) {
+	assert := assert.New(t)
+
+	q := Init(5, "cancel", 1)
+	q.SetAbandonTimeout(10 * time.Millisecond)
+	q.Start()
+	defer q.Stop()
+
+	release := make(chan struct{})
+	defer close(release)
+	assert.NoError(q.Add(func(params map[string]interface{}) error {
+		<-release
+		return nil
+	}, map[string]interface{}{}, "hung"))
+
+	assert.Eventually(func() bool { return len(q.AbandonedTasks()) == 1 }, time.Second, 10 * time.Millisecond)
+	assert.ErrorIs(q.CancelProcessing("hung"), ErrTaskNotProcessing)
+}
+
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+// TESTING BATCHES (batch.go)
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+func TestAddAll_StopsAtFirstRejection(t *testing.T) {
+	assert := assert.New(t)
+
+	q := Init(2, "batch", 1)
+	q.Pause()
+	q.Start()
+	defer q.Stop()
+
+	accepted, err := q.AddAll([]TaskSpec{
+		{ExternalId: "1", Action: noop, Params: map[string]interface{}{}},
+		{ExternalId: "2", Action: noop, Params: map[string]interface{}{}, Priority: PriorityHigh},
+		{ExternalId: "3", Action: noop, Params: map[string]interface{}{}},
+	})
+	assert.Equal(2, accepted)
+	assert.ErrorIs(err, ErrQueueFull)
+
+	var addErr *AddError
+	assert.True(errors.As(err, &addErr))
+	assert.Equal("3", addErr.Id)
+
+	waiting := q.SnapshotView().Waiting
+	assert.Len(waiting, 2)
+	assert.Equal("2", waiting[0].ExternalId)
+
+	q.Resume()
+	assert.Eventually(func() bool { return q.Stats().Completed == 2 }, time.Second, 10 * time.Millisecond)
+}
+
+
+func TestAddAllOrNothing_RollsBackOnRejection(t *testing.T) {
+	assert := assert.New(t)
+
+	q := Init(3, "batch", 2)
+	q.Start()
+	defer q.Stop()
+
+	var mu sync.Mutex
+	ran := []string{}
+	action := func(params map[string]interface{}) error {
+		mu.Lock()
+		defer mu.Unlock()
+		ran = append(ran, params["id"].(string))
+		return nil
+	}
+
+	spec := func(id string) TaskSpec {
+		return TaskSpec{ExternalId: id, Action: action, Params: map[string]interface{}{"id": id}}
+	}
+
+	// the batch doesn't fit, none of it runs
+	accepted, err := q.AddAllOrNothing([]TaskSpec{spec("1"), spec("2"), spec("3"), spec("4")})
+	assert.Equal(0, accepted)
+	assert.ErrorIs(err, ErrQueueFull)
+
+	accepted, err = q.AddAllOrNothing([]TaskSpec{spec("1"), spec("1")})
+	assert.Equal(0, accepted)
+	assert.ErrorIs(err, ErrDuplicateID)
+
+	assert.Empty(q.SnapshotView().Waiting)
+	assert.Equal(0, q.Stats().Enqueued)
+
+	accepted, err = q.AddAllOrNothing([]TaskSpec{spec("1"), spec("2"), spec("3")})
+	assert.Equal(3, accepted)
+	assert.NoError(err)
+
+	assert.Eventually(func() bool { return q.Stats().Completed == 3 }, time.Second, 10 * time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	assert.ElementsMatch([]string{"1", "2", "3"}, ran)
+}
+
+
+func TestAddAll_RegisteredActions(t *testing.T) {
+	assert := assert.New(t)
+
+	q := Init(5, "batch", 1)
+	q.Start()
+	defer q.Stop()
+
+	done := make(chan string, 1)
+	assert.NoError(q.RegisterAction("send", func(params map[string]interface{}) error {
+		done <- params["to"].(string)
+		return nil
+	}))
+
+	accepted, err := q.AddAll([]TaskSpec{
+		{ExternalId: "1", ActionName: "send", Params: map[string]interface{}{"to": "a"}},
+		{ExternalId: "2", ActionName: "missing", Params: map[string]interface{}{}},
+	})
+	assert.Equal(1, accepted)
+	assert.EqualError(err, "Action missing is not registered.")
+	assert.Equal("a", <-done)
+}
+
+
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+// TESTING NEW (options.go)
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+func TestNew_DefaultsAndOptions(t *testing.T) {
+	assert := assert.New(t)
+
+	q, err := New("emails")
+	assert.NoError(err)
+	view := q.SnapshotView()
+	assert.Equal(DefaultSize, view.Capacity)
+	assert.Equal(DefaultMaxProcessing, view.MaxProcessing)
+	assert.Equal(0, view.PreallocatedTasks)
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	q, err = New("emails", WithSize(5), WithMaxProcessing(3), WithNamespace("billing"), WithClock(NewFakeClock(start)), WithPreallocation())
+	assert.NoError(err)
+	view = q.SnapshotView()
+	assert.Equal(5, view.Capacity)
+	assert.Equal(3, view.MaxProcessing)
+	assert.Equal(5, view.PreallocatedTasks)
+	assert.Equal("billing:emails", view.QualifiedName)
+	assert.Equal(start, view.TakenAt)
+	// the queue is stamped with the fake clock from the start
+	assert.Equal(start, q.Stats().Since)
+
+	q.Start()
+	defer q.Stop()
+	done := make(chan struct{})
+	assert.NoError(q.Add(func(params map[string]interface{}) error {
+		close(done)
+		return nil
+	}, map[string]interface{}{}, "1"))
+	<-done
+}
+
+
+func TestNew_RejectsInvalidOptions(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := New(" ")
+	assert.EqualError(err, "Queue name is not valid, only uses space characters.")
+
+	_, err = New("emails", WithSize(0))
+	assert.EqualError(err, "FixedSizeQueue emails can't be created: Queue size must be greater than 0.")
+
+	_, err = New("emails", WithMaxProcessing(-1))
+	assert.EqualError(err, "FixedSizeQueue emails can't be created: Max processing must be greater than 0.")
+
+	_, err = New("emails", WithNamespace("a:b"))
+	assert.Error(err)
+
+	_, err = New("emails", WithNamespace(""))
+	assert.EqualError(err, "FixedSizeQueue emails can't be created: Namespace is not valid, only uses space characters.")
+
+	_, err = New("billing:emails")
+	assert.EqualError(err, `Queue name billing:emails can't contain ":".`)
+
+	q, err := New("emails", WithClock(nil))
+	assert.Nil(q)
+	assert.EqualError(err, "FixedSizeQueue emails can't be created: Clock can't be nil.")
+}
+
+
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+// TESTING ADD OPTIONS (addOption.go)
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+func TestAddOptions_PriorityDelayAndCallback(t *testing.T) {
+	assert := assert.New(t)
+
+	q := Init(5, "options", 1)
+	q.Pause()
+	q.Start()
+	defer q.Stop()
+
+	ended := make(chan error, 3)
+	callback := func(err error) { ended <- err }
+	failing := func(params map[string]interface{}) error { return errors.New("boom") }
+
+	assert.NoError(q.Add(noop, map[string]interface{}{}, "normal", WithCallback(callback)))
+	assert.NoError(q.Add(failing, map[string]interface{}{}, "high", WithPriority(PriorityHigh), WithCallback(callback)))
+	assert.NoError(q.Add(noop, map[string]interface{}{}, "later", WithDelay(time.Hour)))
+
+	view := q.SnapshotView()
+	assert.Equal("high", view.Waiting[0].ExternalId)
+	assert.False(view.Waiting[2].NotBefore.IsZero())
+
+	q.Resume()
+	assert.EqualError(<-ended, "boom")
+	assert.NoError(<-ended)
+}
+
+
+func TestAddOptions_RetriesAndTimeout(t *testing.T) {
+	assert := assert.New(t)
+
+	q := Init(5, "options", 2)
+	q.Start()
+	defer q.Stop()
+
+	var runs atomic.Int32
+	failing := func(params map[string]interface{}) error {
+		runs.Add(1)
+		return errors.New("boom")
+	}
+
+	// the queue doesn't retry, the task does
+	assert.NoError(q.Add(failing, map[string]interface{}{}, "retried", WithRetries(2)))
+	assert.Eventually(func() bool { return q.Stats().Failed == 1 }, time.Second, 10 * time.Millisecond)
+	assert.Equal(int32(3), runs.Load())
+
+	// and the other way around
+	q.SetRetryPolicy(RetryPolicy{MaxAttempts: 5})
+	runs.Store(0)
+	assert.NoError(q.Add(failing, map[string]interface{}{}, "once", WithRetries(0)))
+	assert.Eventually(func() bool { return q.Stats().Failed == 2 }, time.Second, 10 * time.Millisecond)
+	assert.Equal(int32(1), runs.Load())
+
+	release := make(chan struct{})
+	defer close(release)
+	ended := make(chan error, 1)
+	assert.NoError(q.Add(func(params map[string]interface{}) error {
+		<-release
+		return nil
+	}, map[string]interface{}{}, "hung", WithTimeout(10 * time.Millisecond), WithCallback(func(err error) { ended <- err })))
+	assert.ErrorIs(<-ended, ErrTaskTimeout)
+}
+
+
+func TestAddOptions_CostTenantAndDeadlineOnEveryEntryPoint(t *testing.T) {
+	assert := assert.New(t)
+
+	q := Init(5, "options", 1)
+	assert.NoError(q.RegisterAction("named", noop))
+	q.Pause()
+	q.Start()
+	defer q.Stop()
+
+	ctxAction := func(ctx context.Context, params map[string]interface{}) error { return nil }
+	deadline := time.Now().Add(time.Hour).Round(0)
+
+	assert.NoError(q.AddByName("named", map[string]interface{}{}, "by-name", WithPriority(PriorityHigh), WithTenant("a")))
+	assert.NoError(q.AddContext(context.Background(), noop, map[string]interface{}{}, "context", WithCost(2), WithTenant("b")))
+	assert.NoError(q.AddWait(context.Background(), noop, map[string]interface{}{}, "wait", WithCost(3)))
+	assert.NoError(q.AddContextAction(ctxAction, map[string]interface{}{}, "deadline", WithDeadline(deadline)))
+
+	waiting := map[string]TaskView{}
+	for _, view := range q.SnapshotView().Waiting {
+		waiting[view.ExternalId] = view
+	}
+
+	assert.Equal(PriorityHigh, waiting["by-name"].Priority)
+	assert.Equal("named", waiting["by-name"].ActionName)
+	assert.Equal("a", waiting["by-name"].Tenant)
+	assert.Equal(2, waiting["context"].Cost)
+	assert.Equal("b", waiting["context"].Tenant)
+	assert.Equal(3, waiting["wait"].Cost)
+	assert.True(deadline.Equal(waiting["deadline"].Deadline))
+
+	assert.EqualError(q.AddByName("named", map[string]interface{}{}, "bad", WithCost(-1)), "Task cost can't be negative.")
+	assert.EqualError(q.AddContext(context.Background(), noop, map[string]interface{}{}, "bad", WithCost(-1)), "Task cost can't be negative.")
+	assert.EqualError(q.AddWait(context.Background(), noop, map[string]interface{}{}, "bad", WithCost(-1)), "Task cost can't be negative.")
+	assert.EqualError(q.AddContextAction(ctxAction, map[string]interface{}{}, "bad", WithCost(-1)), "Task cost can't be negative.")
+}
+
+
+func TestAddOptions_InvalidOptionsAreRejected(t *testing.T) {
+	assert := assert.New(t)
+
+	q := Init(5, "options", 1)
+	q.Start()
+	defer q.Stop()
+
+	assert.EqualError(q.Add(noop, map[string]interface{}{}, "1", WithRetries(-1)), "Task retries can't be negative.")
+	assert.Error(q.Add(noop, map[string]interface{}{}, "1", WithPriority(Priority(5))))
+	assert.Error(q.Add(noop, map[string]interface{}{}, "1", WithCallback(nil)))
+	assert.Empty(q.SnapshotView().Waiting)
+	assert.Equal(0, q.Stats().Rejected)
+}
+
+
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+// TESTING THE REGISTRY (registry.go)
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+func TestRegistry_QueuesCoexist(t *testing.T) {
+	assert := assert.New(t)
+
+	r := Registry{}
+	emails := Init(5, "emails", 1)
+	reports := Init(5, "reports", 1)
+	reports.SetNamespace("billing")
+
+	assert.Empty(r.List())
+	assert.NoError(r.Register(reports))
+	assert.NoError(r.Register(emails))
+	assert.NoError(r.Register(emails))
+	assert.EqualError(r.Register(Init(5, "emails", 1)), "Registry already has a FixedSizeQueue named emails.")
+
+	q, ok := r.Get("billing:reports")
+	assert.True(ok)
+	assert.Same(reports, q)
+	_, ok = r.Get("reports")
+	assert.False(ok)
+
+	assert.Equal([]*FixedSizeQueue{reports, emails}, r.List())
+
+	assert.True(r.Deregister("emails"))
+	assert.False(r.Deregister("emails"))
+	assert.Equal([]*FixedSizeQueue{reports}, r.List())
+}
+
+
+func TestRegistry_Default(t *testing.T) {
+	assert := assert.New(t)
+
+	q := Init(5, "registry-default", 1)
+	assert.NoError(Register(q))
+	defer Deregister("registry-default")
+
+	registered, ok := Get("registry-default")
+	assert.True(ok)
+	assert.Same(q, registered)
+	assert.Contains(List(), q)
+}
+
+
+func TestRegistry_ConcurrentUse(t *testing.T) {
+	r := Registry{}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			name := strconv.Itoa(i)
+			r.Register(Init(1, name, 1))
+			r.Get(name)
+			r.List()
+			r.Deregister(name)
+		}()
+	}
+	wg.Wait()
+
+	assert.Empty(t, r.List())
+}
+
+
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+// TESTING THE MANAGER (manager.go)
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+func TestManager_LifecycleAndStats(t *testing.T) {
+	assert := assert.New(t)
+
+	emails := Init(5, "emails", 1)
+	reports := Init(5, "reports", 1)
+	_, err := NewManager(emails, Init(5, "emails", 1))
+	assert.EqualError(err, "Manager already has a FixedSizeQueue named emails.")
+
+	m, err := NewManager(emails, reports)
+	assert.NoError(err)
+	q, ok := m.Queue("reports")
+	assert.True(ok)
+	assert.Same(reports, q)
+	assert.Equal([]*FixedSizeQueue{emails, reports}, m.Queues())
+
+	m.StartAll()
+	assert.True(emails.IsRunning())
+	assert.True(reports.IsRunning())
+
+	assert.NoError(emails.Add(noop, map[string]interface{}{}, "1"))
+	assert.NoError(emails.Add(noop, map[string]interface{}{}, "2"))
+	assert.NoError(reports.Add(func(params map[string]interface{}) error { return errors.New("boom") }, map[string]interface{}{}, "1"))
+	assert.Eventually(func() bool {
+		total := m.Stats().Total
+		return total.Completed == 2 && total.Failed == 1
+	}, time.Second, 10 * time.Millisecond)
+
+	stats := m.Stats()
+	assert.Equal(2, stats.Queues["emails"].Enqueued)
+	assert.Equal(1, stats.Queues["reports"].Failed)
+	assert.Equal(3, stats.Total.Enqueued)
+	assert.Equal(10, stats.Total.Capacity)
+
+	m.StopAll()
+	assert.False(emails.IsRunning())
+	assert.False(reports.IsRunning())
+}
+
+
+func TestManager_DrainAll(t *testing.T) {
+	assert := assert.New(t)
+
+	emails := Init(5, "emails", 1)
+	reports := Init(5, "reports", 1)
+	m, _ := NewManager(emails, reports)
+	m.StartAll()
+
+	release := make(chan struct{})
+	assert.NoError(emails.Add(func(params map[string]interface{}) error {
+		<-release
+		return nil
+	}, map[string]interface{}{}, "slow"))
+	assert.NoError(reports.Add(noop, map[string]interface{}{}, "fast"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50 * time.Millisecond)
+	defer cancel()
+	err := m.DrainAll(ctx)
+	assert.ErrorIs(err, context.DeadlineExceeded)
+
+	var drainErr *DrainError
+	assert.True(errors.As(err, &drainErr))
+	assert.Equal("emails", drainErr.Queue)
+	assert.Equal([]string{"slow"}, drainErr.Processing)
+	assert.ErrorIs(reports.Add(noop, map[string]interface{}{}, "late"), ErrNotRunning)
+
+	close(release)
+	m.StartAll()
+	assert.NoError(emails.Add(noop, map[string]interface{}{}, "1"))
+	assert.NoError(m.DrainAll(context.Background()))
+	assert.Equal(2, m.Stats().Queues["emails"].Completed)
+}
+
+
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+// TESTING EXPVAR (expvar.go)
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+func TestPublishExpvar(t *testing.T) {
+	assert := assert.New(t)
+
+	q := Init(5, "expvar", 1)
+	q.SetNamespace("billing")
+	q.Start()
+	defer q.Stop()
+
+	assert.NoError(q.PublishExpvar())
+	assert.EqualError(q.PublishExpvar(), "Expvar fsq.billing:expvar is already published.")
+
+	assert.NoError(q.Add(noop, map[string]interface{}{}, "1"))
+	assert.Error(q.Add(noop, map[string]interface{}{}, " "))
+	assert.Eventually(func() bool { return q.Stats().Completed == 1 }, time.Second, 10 * time.Millisecond)
+
+	published := map[string]interface{}{}
+	assert.NoError(json.Unmarshal([]byte(expvar.Get("fsq.billing:expvar").String()), &published))
+	assert.Equal(1.0, published["Completed"])
+	assert.Equal(1.0, published["Rejected"])
+	assert.Equal(5.0, published["Capacity"])
+	assert.Equal(0.0, published["Waiting"])
+}
+
+
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+// TESTING TRACING (tracing.go)
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+// returns the ended spans of @recorder by name, in the order they ended
+func spansByName(recorder *tracetest.SpanRecorder) map[string][]sdktrace.ReadOnlySpan {
+	spans := map[string][]sdktrace.ReadOnlySpan{}
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = append(spans[span.Name()], span)
+	}
+
+	return spans
+}
+
+
+func TestTracing_SpansFollowTheSubmission(t *testing.T) {
+	assert := assert.New(t)
+
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	q := Init(5, "tracing", 1)
+	q.SetTracerProvider(provider)
+	q.Start()
+	defer q.Stop()
+
+	ctx, request := provider.Tracer("test").Start(context.Background(), "request")
+	assert.NoError(q.AddContext(ctx, func(params map[string]interface{}) error {
+		time.Sleep(10 * time.Millisecond)
+		return nil
+	}, map[string]interface{}{}, "1"))
+	request.End()
+
+	assert.Eventually(func() bool { return len(spansByName(recorder)["fsq.execute"]) == 1 }, time.Second, 10 * time.Millisecond)
+	spans := spansByName(recorder)
+
+	enqueue := spans["fsq.enqueue"][0]
+	wait := spans["fsq.wait"][0]
+	execute := spans["fsq.execute"][0]
+	assert.Equal(request.SpanContext().SpanID(), enqueue.Parent().SpanID())
+	assert.Equal(enqueue.SpanContext().SpanID(), wait.Parent().SpanID())
+	assert.Equal(enqueue.SpanContext().SpanID(), execute.Parent().SpanID())
+	assert.Equal(request.SpanContext().TraceID(), execute.SpanContext().TraceID())
+
+	assert.False(execute.StartTime().Before(wait.EndTime()))
+	assert.GreaterOrEqual(execute.EndTime().Sub(execute.StartTime()), 10 * time.Millisecond)
+	assert.Contains(execute.Attributes(), attribute.String("fsq.task.id", "1"))
+	assert.Contains(execute.Attributes(), attribute.String("fsq.queue", "tracing"))
+	assert.Equal(codes.Unset, execute.Status().Code)
+}
+
+
+func TestTracing_FailuresRetriesAndContextActions(t *testing.T) {
+	assert := assert.New(t)
+
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	q := Init(5, "tracing", 1)
+	q.SetTracerProvider(provider)
+	q.SetRetryPolicy(RetryPolicy{MaxAttempts: 2})
+	q.Start()
+	defer q.Stop()
+
+	assert.NoError(q.Add(func(params map[string]interface{}) error { return errors.New("boom") }, map[string]interface{}{}, "failing"))
+	assert.Eventually(func() bool { return q.Stats().Failed == 1 }, time.Second, 10 * time.Millisecond)
+
+	spans := spansByName(recorder)
+	assert.Len(spans["fsq.enqueue"], 1)
+	assert.Len(spans["fsq.wait"], 2)
+	assert.Len(spans["fsq.execute"], 2)
+	for i, execute := range spans["fsq.execute"] {
+		assert.Equal(codes.Error, execute.Status().Code)
+		assert.Equal("boom", execute.Status().Description)
+		assert.Contains(execute.Attributes(), attribute.Int("fsq.attempt", i + 1))
+	}
+
+	// rejected adds are recorded too
+	assert.Error(q.Add(noop, map[string]interface{}{}, " "))
+	enqueues := spansByName(recorder)["fsq.enqueue"]
+	assert.Equal(codes.Error, enqueues[len(enqueues) - 1].Status().Code)
+
+	spanIds := make(chan trace.SpanID, 1)
+	assert.NoError(q.AddContextAction(func(ctx context.Context, params map[string]interface{}) error {
+		spanIds <- trace.SpanFromContext(ctx).SpanContext().SpanID()
+		return nil
+	}, map[string]interface{}{}, "ctx"))
+	spanId := <-spanIds
+
+	assert.Eventually(func() bool { return len(spansByName(recorder)["fsq.execute"]) == 3 }, time.Second, 10 * time.Millisecond)
+	assert.Equal(spanId, spansByName(recorder)["fsq.execute"][2].SpanContext().SpanID())
+}
+
+
+func TestTracing_OffByDefault(t *testing.T) {
+	assert := assert.New(t)
+
+	q := Init(5, "tracing", 1)
+	q.Start()
+	defer q.Stop()
+
+	valid := make(chan bool, 1)
+	assert.NoError(q.AddContextAction(func(ctx context.Context, params map[string]interface{}) error {
+		valid <- trace.SpanFromContext(ctx).SpanContext().IsValid()
+		return nil
+	}, map[string]interface{}{}, "1"))
+	assert.False(<-valid)
+}
+
+
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+// TESTING LOGGING (logger.go)
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+// collects log records as JSON lines, safe for concurrent use
+type logBuffer struct {
+	mu sync.Mutex
+	lines []map[string]interface{}
+}
+
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	line := map[string]interface{}{}
+	err := json.Unmarshal(p, &line)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.lines = append(b.lines, line)
+	return len(p), err
+}
+
+
+// returns the records with the message @msg
+func (b *logBuffer) records(msg string) []map[string]interface{} {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	records := []map[string]interface{}{}
+	for _, line := range b.lines {
+		if line["msg"] == msg {
+			records = append(records, line)
+		}
+	}
+
+	return records
+}
+
+
+func TestLogger_TaskLifecycle(t *testing.T) {
+	assert := assert.New(t)
+
+	logs := &logBuffer{}
+	logger := slog.New(slog.NewJSONHandler(logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	q, err := New("logging", WithLogger(logger))
+	assert.NoError(err)
+	q.Start()
+	defer q.Stop()
+
+	assert.NoError(q.AddNamed("send", noop, map[string]interface{}{}, "ok"))
+	assert.NoError(q.Add(func(params map[string]interface{}) error { return errors.New("boom") }, map[string]interface{}{}, "failing", WithRetries(1)))
+	assert.Eventually(func() bool { return len(logs.records("task failed")) == 1 }, time.Second, 10 * time.Millisecond)
+
+	assert.Len(logs.records("task started"), 3)
+
+	completed := logs.records("task completed")
+	assert.Len(completed, 1)
+	assert.Equal("logging", completed[0]["queue"])
+	assert.Equal("ok", completed[0]["id"])
+	assert.Equal("send", completed[0]["action"])
+	assert.Equal("INFO", completed[0]["level"])
+	assert.Contains(completed[0], "duration")
+
+	retrying := logs.records("task failed, retrying")
+	assert.Len(retrying, 1)
+	assert.Equal("WARN", retrying[0]["level"])
+	assert.Equal(1.0, retrying[0]["attempt"])
+
+	failed := logs.records("task failed")[0]
+	assert.Equal("ERROR", failed["level"])
+	assert.Equal("failing", failed["id"])
+	assert.Equal(2.0, failed["attempt"])
+	assert.Equal("boom", failed["error"])
+
+	_, err = New("logging", WithLogger(nil))
+	assert.Error(err)
+}
+
+
+func TestLogger_ErrorsWithoutCaller(t *testing.T) {
+	assert := assert.New(t)
+
+	logs := &logBuffer{}
+	q := Init(5, "logging", 1)
+	q.SetLogger(slog.New(slog.NewJSONHandler(logs, nil)))
+	assert.NoError(q.SetWarmup("send", func(ctx context.Context) error { return errors.New("cold") }))
+	q.Start()
+	defer q.Stop()
+
+	assert.Eventually(func() bool { return len(logs.records("warming up the action failed")) == 1 }, time.Second, 10 * time.Millisecond)
+	record := logs.records("warming up the action failed")[0]
+	assert.Equal("cold", record["error"])
+	assert.Equal("send", record["action"])
+
+	// the default logs nothing
+	q.SetLogger(nil)
+	assert.NoError(q.Add(noop, map[string]interface{}{}, "1"))
+	assert.Eventually(func() bool { return q.Stats().Completed == 1 }, time.Second, 10 * time.Millisecond)
+	assert.Empty(logs.records("task completed"))
+}
+
+
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+// TESTING THE ERROR HANDLER (onError.go)
+// ---------------------------------------------------------------------------
+// ---------------------------------------------------------------------------
+func TestOnError_CalledForEveryFailedRun(t *testing.T) {
+	assert := assert.New(t)
+
+	q := Init(5, "onError", 1)
+	q.Start()
+	defer q.Stop()
+
+	var mu sync.Mutex
+	failures := []string{}
+	q.OnError(func(taskID string, err error) {
+		mu.Lock()
+		failures = append(failures, taskID + ": " + err.Error())
+		mu.Unlock()
+
+		// the handler may use the queue
+		q.Stats()
+	})
+
+	assert.NoError(q.Add(noop, map[string]interface{}{}, "ok"))
+	assert.NoError(q.Add(func(params map[string]interface{}) error { return errors.New("boom") }, map[string]interface{}{}, "failing", WithRetries(1)))
+	assert.Eventually(func() bool { return q.Stats().Failed == 1 }, time.Second, 10 * time.Millisecond)
+
+	assert.Eventually(func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(failures) == 2
+	}, time.Second, 10 * time.Millisecond)
+
+	mu.Lock()
+	assert.Equal([]string{"failing: boom", "failing: boom"}, failures)
+	mu.Unlock()
+
+	q.OnError(nil)
+	assert.NoError(q.Add(func(params map[string]interface{}) error { return errors.New("boom") }, map[string]interface{}{}, "unhandled"))
+	assert.Eventually(func() bool { return q.Stats().Failed == 2 }, time.Second, 10 * time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Len(failures, 2)
+}
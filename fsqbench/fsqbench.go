@@ -0,0 +1,160 @@
+// - fsqbench runs reusable benchmark scenarios against an fsq queue: how many producers add tasks, how
+// many tasks run at once, how large their payloads are, how long their actions take and how often they
+// fail. It tracks the queue's hot paths (adding, dispatching, completing) across releases, and lets users
+// benchmark their own action mixes.
+//
+// - Run a scenario once with Run, or from a Go benchmark with Bench, which reports tasks/s.
+
+package fsqbench
+
+import "fmt"
+import "errors"
+import "math/rand/v2"
+import "runtime"
+import "strings"
+import "sync"
+import "sync/atomic"
+import "testing"
+import "time"
+
+import "github.com/brybott/go_fsq"
+
+// A workload to run against a fresh queue.
+type Scenario struct {
+	Name string
+	QueueSize int  //capacity of the queue, 1 if <= 0
+	MaxProcessing int  //tasks running at once, 1 if <= 0
+	Producers int  //go routines adding tasks at once, 1 if <= 0
+	Tasks int  //tasks added in total, spread over the producers
+	PayloadSize int  //bytes in every task's "payload" param, no payload if <= 0
+	Work time.Duration  //how long the synthetic action takes, 0 returns right away
+	FailureRate float64  //share of tasks whose synthetic action fails, between 0 and 1
+	Action func(params map[string]interface{}) error  //replaces the synthetic action when set, e.g. to benchmark a real one
+}
+
+// How a scenario went.
+type Result struct {
+	Scenario string
+	Tasks int
+	Completed int
+	Failed int
+	Rejected int  //Adds retried because the queue was full
+	Elapsed time.Duration  //from the first Add to the last task finishing
+	Throughput float64  //finished tasks per second
+}
+
+// A few producer/consumer ratios, payload sizes and failure rates, for tracking the queue itself.
+var Scenarios = []Scenario{
+	{Name: "1p-1c", QueueSize: 100, MaxProcessing: 1, Producers: 1},
+	{Name: "8p-1c", QueueSize: 100, MaxProcessing: 1, Producers: 8},
+	{Name: "1p-8c", QueueSize: 100, MaxProcessing: 8, Producers: 1},
+	{Name: "8p-8c", QueueSize: 1000, MaxProcessing: 8, Producers: 8},
+	{Name: "8p-8c-4KiB", QueueSize: 1000, MaxProcessing: 8, Producers: 8, PayloadSize: 4096},
+	{Name: "8p-8c-10pct-failing", QueueSize: 1000, MaxProcessing: 8, Producers: 8, FailureRate: 0.1},
+}
+
+
+// Runs @s against a fresh queue and returns how it went. Every task is added, Adds rejected because the
+// queue is full are retried until they are accepted.
+func Run(s Scenario) (Result, error) {
+	if s.Tasks <= 0 {
+		return Result{}, errors.New(fmt.Sprintf("Scenario %s has no tasks.", s.Name))
+	}
+
+	producers := max(s.Producers, 1)
+	q := fsq.Init(max(s.QueueSize, 1), "fsqbench-" + s.Name, max(s.MaxProcessing, 1))
+	q.Start()
+	defer q.Stop()
+
+	payload := strings.Repeat("x", max(s.PayloadSize, 0))
+	action := s.Action
+	if action == nil {
+		action = synthetic(s.Work, s.FailureRate)
+	}
+
+	var finished sync.WaitGroup
+	finished.Add(s.Tasks)
+
+	var failed, rejected atomic.Int64
+	tracked := func(params map[string]interface{}) error {
+		defer finished.Done()
+
+		err := action(params)
+		if err != nil {
+			failed.Add(1)
+		}
+
+		return err
+	}
+
+	start := time.Now()
+
+	var producing sync.WaitGroup
+	for p := 0; p < producers; p++ {
+		producing.Add(1)
+
+		go func() {
+			defer producing.Done()
+
+			for i := p; i < s.Tasks; i += producers {
+				params := map[string]interface{}{}
+				if payload != "" {
+					params["payload"] = payload
+				}
+
+				for q.Add(tracked, params, fmt.Sprintf("task-%d", i)) != nil {
+					rejected.Add(1)
+					runtime.Gosched()
+				}
+			}
+		}()
+	}
+
+	producing.Wait()
+	finished.Wait()
+	elapsed := time.Since(start)
+
+	return Result{
+		Scenario: s.Name,
+		Tasks: s.Tasks,
+		Completed: s.Tasks - int(failed.Load()),
+		Failed: int(failed.Load()),
+		Rejected: int(rejected.Load()),
+		Elapsed: elapsed,
+		Throughput: float64(s.Tasks) / elapsed.Seconds(),
+	}, nil
+}
+
+
+// Runs @s with b.N tasks and reports the throughput (tasks/s) and the Adds rejected per task (rejected/op).
+func Bench(b *testing.B, s Scenario) {
+	b.Helper()
+	s.Tasks = b.N
+
+	b.ResetTimer()
+	result, err := Run(s)
+	b.StopTimer()
+
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportMetric(result.Throughput, "tasks/s")
+	b.ReportMetric(float64(result.Rejected) / float64(b.N), "rejected/op")
+}
+
+
+// returns the action used when a scenario doesn't bring its own
+func synthetic(work time.Duration, failureRate float64) func(params map[string]interface{}) error {
+	return func(params map[string]interface{}) error {
+		if work > 0 {
+			time.Sleep(work)
+		}
+
+		if failureRate > 0 && rand.Float64() < failureRate {
+			return errors.New("Synthetic failure.")
+		}
+
+		return nil
+	}
+}
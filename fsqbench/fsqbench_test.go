@@ -0,0 +1,48 @@
+package fsqbench
+
+import "testing"
+import "errors"
+import "github.com/stretchr/testify/assert"
+
+
+func TestRun(t *testing.T) {
+	assert := assert.New(t)
+
+	result, err := Run(Scenario{Name: "small", QueueSize: 2, MaxProcessing: 2, Producers: 4, Tasks: 200, PayloadSize: 16})
+
+	assert.NoError(err)
+	assert.Equal(200, result.Tasks)
+	assert.Equal(200, result.Completed)
+	assert.Equal(0, result.Failed)
+	assert.Greater(result.Throughput, 0.0)
+}
+
+
+func TestRun_CustomActionFailures(t *testing.T) {
+	assert := assert.New(t)
+
+	calls := 0
+	result, err := Run(Scenario{Name: "failing", QueueSize: 10, MaxProcessing: 1, Tasks: 10, Action: func(params map[string]interface{}) error {
+		calls++
+		if calls % 2 == 0 {
+			return errors.New("even")
+		}
+		return nil
+	}})
+
+	assert.NoError(err)
+	assert.Equal(5, result.Failed)
+	assert.Equal(5, result.Completed)
+
+	_, err = Run(Scenario{Name: "empty"})
+	assert.Error(err)
+}
+
+
+func BenchmarkScenarios(b *testing.B) {
+	for _, s := range Scenarios {
+		b.Run(s.Name, func(b *testing.B) {
+			Bench(b, s)
+		})
+	}
+}
@@ -0,0 +1,324 @@
+// - fsqdemo is an example application showing how fsq's parts fit together in a service: tasks are taken
+// in over HTTP and routed to a queue per action, outcomes are persisted by a result sink, attempts are kept
+// in a shared history, activity is exposed as stats and through the admin API, and shutdown drains every
+// queue before the process exits.
+//
+// - cmd/fsq-demo runs it as a server. Embed a Server with New and Handler to try fsq inside another
+// service, or to run integration tests against a full setup.
+//
+//	POST /tasks/{action}?id={id}    body: the task's params as a JSON object. 202 when added, 429 when rejected
+//	GET  /tasks/{id}                the task's attempts, see fsq.History
+//	GET  /stats                     fsq.Stats of every queue, and the mux's view
+//	     /admin/...                 the admin API, see fsq.AdminHandler
+
+package fsqdemo
+
+import "fmt"
+import "errors"
+import "context"
+import "encoding/json"
+import "net/http"
+import "os"
+import "strings"
+import "sync"
+import "time"
+
+import "github.com/brybott/go_fsq"
+
+// Settings of a demo server.
+type Config struct {
+	QueueSize int  //capacity of every queue, 100 if <= 0
+	MaxProcessing int  //tasks running at once per queue, 4 if <= 0
+	ResultsPath string  //file the outcome of every task is appended to as a JSON line, none if empty
+	AdminTokens map[string]fsq.Role  //bearer tokens of the admin API, see fsq.StaticTokens
+	Work time.Duration  //how long the demo actions take
+}
+
+// The outcome of a task as it is persisted, one JSON object per line.
+type Record struct {
+	Queue string `json:"queue"`
+	ExternalId string `json:"id"`
+	Action string `json:"action"`
+	Type string `json:"type"`
+	Reason string `json:"reason"`
+	Err string `json:"error,omitempty"`
+	At time.Time `json:"at"`
+}
+
+// A demo application: its queues, their history, and the HTTP handler serving them.
+type Server struct {
+	mux *fsq.Mux
+	history *fsq.History
+	handler *http.ServeMux
+	resultsMu sync.Mutex
+	results *os.File  //nil without a ResultsPath
+}
+
+// the body of the error responses
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// an attempt in the body of GET /tasks/{id}, see fsq.Attempt
+type attemptResponse struct {
+	Number int `json:"number"`
+	Worker string `json:"worker"`
+	StartedAt time.Time `json:"startedAt"`
+	Duration string `json:"duration"`
+	Reason string `json:"reason"`
+	Err string `json:"error,omitempty"`
+}
+
+// the body of GET /stats
+type statsResponse struct {
+	Queues map[string]fsq.Stats `json:"queues"`
+	View fsq.MuxView `json:"view"`
+}
+
+
+// Returns a demo server with an "email" and a "report" queue, each running the action of the same name.
+// Call Start before serving Handler.
+func New(config Config) (*Server, error) {
+	if config.QueueSize <= 0 {
+		config.QueueSize = 100
+	}
+
+	if config.MaxProcessing <= 0 {
+		config.MaxProcessing = 4
+	}
+
+	s := &Server{history: fsq.NewHistory(0)}
+
+	if config.ResultsPath != "" {
+		results, err := os.OpenFile(config.ResultsPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Results file %s can't be opened: %s", config.ResultsPath, err))
+		}
+
+		s.results = results
+	}
+
+	actions := map[string]func(params map[string]interface{}) error{
+		"email": sendEmail(config.Work),
+		"report": buildReport(config.Work),
+	}
+
+	queues := []*fsq.FixedSizeQueue{}
+	for _, name := range []string{"email", "report"} {
+		q := fsq.Init(config.QueueSize, name, config.MaxProcessing)
+		q.RegisterAction(name, actions[name])
+		q.SetHistory(s.history)
+		q.SetDedupKey(fsq.DedupByParams("to", "subject", "month"))
+
+		if s.results != nil {
+			q.SetResultSink(fsq.ResultSinkFunc(s.writeResults), fsq.ResultSinkOptions{})
+		}
+
+		queues = append(queues, q)
+	}
+
+	// reports load their templates before the first one is built
+	queues[1].SetWarmup("report", func(ctx context.Context) error {
+		select {
+		case <-time.After(config.Work):
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+
+	mux, err := fsq.NewMux(fsq.RouteByActionName("email"), queues...)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mux = mux
+	s.handler = http.NewServeMux()
+	s.handler.HandleFunc("POST /tasks/{action}", s.addTask)
+	s.handler.HandleFunc("GET /tasks/{id}", s.viewTask)
+	s.handler.HandleFunc("GET /stats", s.viewStats)
+	s.handler.Handle("/admin/", http.StripPrefix("/admin", fsq.NewAdminHandler(fsq.StaticTokens(config.AdminTokens), queues...)))
+	return s, nil
+}
+
+
+// Returns the handler serving the server's endpoints.
+func (s *Server) Handler() http.Handler {
+	return s.handler
+}
+
+
+// Returns the server's queues, e.g. to add tasks without going through HTTP.
+func (s *Server) Mux() *fsq.Mux {
+	return s.mux
+}
+
+
+// Starts every queue.
+func (s *Server) Start() {
+	s.mux.Start()
+}
+
+
+// - Stops taking in tasks and waits until every queue drained or @ctx is done, then persists the
+// outcomes that are left and closes the results file.
+// - Returns how the shutdown of each queue went.
+func (s *Server) Shutdown(ctx context.Context) []fsq.ShutdownReport {
+	reports := s.mux.ShutdownAll(ctx)
+
+	// removing the sinks writes what they have left
+	for _, q := range s.mux.Queues() {
+		q.SetResultSink(nil, fsq.ResultSinkOptions{})
+	}
+
+	s.resultsMu.Lock()
+	defer s.resultsMu.Unlock()
+
+	if s.results != nil {
+		s.results.Close()
+		s.results = nil
+	}
+
+	return reports
+}
+
+
+func (s *Server) addTask(w http.ResponseWriter, r *http.Request) {
+	action := r.PathValue("action")
+	q, ok := s.mux.Queue(action)
+	if !ok {
+		writeJSON(w, http.StatusNotFound, errorResponse{Error: fmt.Sprintf("Action %s doesn't exist.", action)})
+		return
+	}
+
+	params := map[string]interface{}{}
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: fmt.Sprintf("Params are not a JSON object: %s", err)})
+		return
+	}
+
+	id := r.URL.Query().Get("id")
+	if strings.TrimSpace(id) == "" {
+		id = fmt.Sprintf("%s-%d", action, time.Now().UnixNano())
+	}
+
+	err = q.AddByName(action, params, id)
+	if err != nil {
+		writeJSON(w, http.StatusTooManyRequests, errorResponse{Error: err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusAccepted, map[string]string{"id": id})
+}
+
+
+func (s *Server) viewTask(w http.ResponseWriter, r *http.Request) {
+	attempts := s.history.Attempts(r.PathValue("id"))
+	if len(attempts) == 0 {
+		writeJSON(w, http.StatusNotFound, errorResponse{Error: "Task has no attempts."})
+		return
+	}
+
+	response := []attemptResponse{}
+	for _, attempt := range attempts {
+		a := attemptResponse{
+			Number: attempt.Number,
+			Worker: attempt.Worker,
+			StartedAt: attempt.StartedAt,
+			Duration: attempt.Duration.String(),
+			Reason: attempt.Reason.String(),
+		}
+
+		if attempt.Err != nil {
+			a.Err = attempt.Err.Error()
+		}
+
+		response = append(response, a)
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+
+func (s *Server) viewStats(w http.ResponseWriter, r *http.Request) {
+	stats := statsResponse{Queues: map[string]fsq.Stats{}, View: s.mux.SnapshotView()}
+	for _, q := range s.mux.Queues() {
+		stats.Queues[q.QualifiedName()] = q.Stats()
+	}
+
+	writeJSON(w, http.StatusOK, stats)
+}
+
+
+// The result sink of every queue, appends @results to the results file.
+func (s *Server) writeResults(results []fsq.Event) error {
+	var lines strings.Builder
+	encoder := json.NewEncoder(&lines)
+
+	for _, event := range results {
+		record := Record{
+			Queue: event.Queue,
+			ExternalId: event.ExternalId,
+			Action: event.ActionName,
+			Type: event.Type.String(),
+			Reason: event.Reason.String(),
+			At: event.At,
+		}
+
+		if event.Err != nil {
+			record.Err = event.Err.Error()
+		}
+
+		err := encoder.Encode(record)
+		if err != nil {
+			return err
+		}
+	}
+
+	s.resultsMu.Lock()
+	defer s.resultsMu.Unlock()
+
+	if s.results == nil {
+		return errors.New("Results file is closed.")
+	}
+
+	_, err := s.results.WriteString(lines.String())
+	return err
+}
+
+
+// the demo's email action, needs a "to" param
+func sendEmail(work time.Duration) func(params map[string]interface{}) error {
+	return func(params map[string]interface{}) error {
+		to, _ := params["to"].(string)
+		if !strings.Contains(to, "@") {
+			return errors.New(fmt.Sprintf("Can't send an email to %q.", to))
+		}
+
+		time.Sleep(work)
+		return nil
+	}
+}
+
+
+// the demo's report action, needs a "month" param
+func buildReport(work time.Duration) func(params map[string]interface{}) error {
+	return func(params map[string]interface{}) error {
+		month, _ := params["month"].(string)
+		_, err := time.Parse("2006-01", month)
+		if err != nil {
+			return errors.New(fmt.Sprintf("Can't build a report for month %q.", month))
+		}
+
+		time.Sleep(work)
+		return nil
+	}
+}
+
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,111 @@
+package fsqdemo
+
+import "testing"
+import "bufio"
+import "context"
+import "encoding/json"
+import "net/http"
+import "net/http/httptest"
+import "os"
+import "path/filepath"
+import "strings"
+import "time"
+import "github.com/brybott/go_fsq"
+import "github.com/stretchr/testify/assert"
+
+
+func request(h http.Handler, method string, path string, token string, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer " + token)
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+
+func TestServer_EndToEnd(t *testing.T) {
+	assert := assert.New(t)
+
+	resultsPath := filepath.Join(t.TempDir(), "results.jsonl")
+	demo, err := New(Config{ResultsPath: resultsPath, AdminTokens: map[string]fsq.Role{"secret": fsq.RoleViewer}})
+	assert.NoError(err)
+	demo.Start()
+	h := demo.Handler()
+
+	w := request(h, "POST", "/tasks/email?id=welcome", "", `{"to": "ada@example.com", "subject": "Welcome"}`)
+	assert.Equal(http.StatusAccepted, w.Code)
+
+	w = request(h, "POST", "/tasks/email?id=broken", "", `{"to": "nobody"}`)
+	assert.Equal(http.StatusAccepted, w.Code)
+
+	w = request(h, "POST", "/tasks/report?id=june", "", `{"month": "2026-06"}`)
+	assert.Equal(http.StatusAccepted, w.Code)
+
+	assert.Equal(http.StatusNotFound, request(h, "POST", "/tasks/sms", "", `{}`).Code)
+	assert.Equal(http.StatusBadRequest, request(h, "POST", "/tasks/email", "", `[1]`).Code)
+
+	assert.Eventually(func() bool {
+		var stats statsResponse
+		json.NewDecoder(request(h, "GET", "/stats", "", "").Body).Decode(&stats)
+		return stats.Queues["email"].Completed + stats.Queues["email"].Failed + stats.Queues["report"].Completed == 3
+	}, time.Second, 10 * time.Millisecond)
+
+	var attempts []attemptResponse
+	w = request(h, "GET", "/tasks/broken", "", "")
+	assert.Equal(http.StatusOK, w.Code)
+	json.NewDecoder(w.Body).Decode(&attempts)
+	assert.Equal(1, len(attempts))
+	assert.Equal("email", attempts[0].Worker)
+	assert.Equal(`Can't send an email to "nobody".`, attempts[0].Err)
+	assert.Equal(http.StatusNotFound, request(h, "GET", "/tasks/unknown", "", "").Code)
+
+	assert.Equal(http.StatusOK, request(h, "GET", "/admin/queues", "secret", "").Code)
+	assert.Equal(http.StatusUnauthorized, request(h, "GET", "/admin/queues", "", "").Code)
+
+	reports := demo.Shutdown(context.Background())
+	assert.Equal(2, len(reports))
+	for _, report := range reports {
+		assert.True(report.Drained)
+	}
+
+	assert.Equal(http.StatusTooManyRequests, request(h, "POST", "/tasks/email", "", `{"to": "ada@example.com"}`).Code)
+
+	file, err := os.Open(resultsPath)
+	assert.NoError(err)
+	defer file.Close()
+
+	records := map[string]Record{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record Record
+		assert.NoError(json.Unmarshal(scanner.Bytes(), &record))
+		records[record.ExternalId] = record
+	}
+
+	assert.Equal(3, len(records))
+	assert.Equal("completed", records["welcome"].Type)
+	assert.Equal("failed", records["broken"].Type)
+	assert.Equal("ActionError", records["broken"].Reason)
+	assert.Equal("report", records["june"].Action)
+}
+
+
+func TestServer_RejectsDuplicates(t *testing.T) {
+	assert := assert.New(t)
+
+	demo, err := New(Config{QueueSize: 5, MaxProcessing: 1, Work: 50 * time.Millisecond})
+	assert.NoError(err)
+	demo.Start()
+	defer demo.Shutdown(context.Background())
+	h := demo.Handler()
+
+	// the first one starts processing, the second one waits
+	body := `{"to": "ada@example.com", "subject": "Invoice"}`
+	assert.Equal(http.StatusAccepted, request(h, "POST", "/tasks/email?id=1", "", body).Code)
+	assert.Eventually(func() bool { return demo.Mux().SnapshotView().Processing == 1 }, time.Second, time.Millisecond)
+	assert.Equal(http.StatusAccepted, request(h, "POST", "/tasks/email?id=2", "", body).Code)
+	assert.Equal(http.StatusTooManyRequests, request(h, "POST", "/tasks/email?id=3", "", body).Code)
+}
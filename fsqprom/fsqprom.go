@@ -0,0 +1,133 @@
+// - fsqprom exposes the metrics of fsq queues to Prometheus: how many tasks wait and process, the capacity,
+// counters of enqueued, completed, failed and rejected tasks and of failed runs by error class, and histograms of how long actions ran.
+// Every metric has a "queue" label with the queue's qualified name.
+//
+// - Create a Collector for the queues and register it, e.g. prometheus.MustRegister(fsqprom.NewCollector(q)).
+
+package fsqprom
+
+import "sync"
+
+import "github.com/brybott/go_fsq"
+import "github.com/prometheus/client_golang/prometheus"
+
+// events buffered per queue for the duration histograms, older ones are dropped when the collector falls behind
+const eventBuffer = 256
+
+// the classes fsq_errors_total is labelled with
+var errorClasses = []fsq.ErrorClass{fsq.ClassUnclassified, fsq.ClassRetryable, fsq.ClassPermanent, fsq.ClassRateLimited, fsq.ClassCancelled}
+
+// The buckets of the action duration histograms, in seconds.
+var DurationBuckets = []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5, 10, 30, 60}
+
+// - A prometheus.Collector of the metrics of fsq queues. Gauges and counters are read from the queues'
+// Stats when Prometheus scrapes, action durations are observed as tasks end.
+// - The counters follow the queue's stats epoch, so they start over when the queue's ResetStats is called.
+// Prometheus treats that as a counter reset.
+// - Close the collector once it is no longer registered.
+type Collector struct {
+	queues []*fsq.FixedSizeQueue
+	subs []*fsq.Subscription
+	done sync.WaitGroup
+
+	waiting *prometheus.Desc
+	processing *prometheus.Desc
+	capacity *prometheus.Desc
+	enqueued *prometheus.Desc
+	completed *prometheus.Desc
+	failed *prometheus.Desc
+	rejected *prometheus.Desc
+	errors *prometheus.Desc  //by queue and error class
+	durations *prometheus.HistogramVec  //by queue, action and outcome
+}
+
+
+// Returns a collector of the metrics of @queues. Their qualified names must be unique.
+func NewCollector(queues ...*fsq.FixedSizeQueue) *Collector {
+	labels := []string{"queue"}
+
+	c := &Collector{
+		queues: queues,
+		waiting: prometheus.NewDesc("fsq_waiting_tasks", "Tasks waiting to be dispatched.", labels, nil),
+		processing: prometheus.NewDesc("fsq_processing_tasks", "Tasks whose action is running.", labels, nil),
+		capacity: prometheus.NewDesc("fsq_capacity_tasks", "Max number of waiting tasks.", labels, nil),
+		enqueued: prometheus.NewDesc("fsq_enqueued_tasks_total", "Tasks added to the queue.", labels, nil),
+		completed: prometheus.NewDesc("fsq_completed_tasks_total", "Tasks whose action returned nil.", labels, nil),
+		failed: prometheus.NewDesc("fsq_failed_tasks_total", "Tasks whose action returned an error on their last run.", labels, nil),
+		rejected: prometheus.NewDesc("fsq_rejected_tasks_total", "Adds the queue turned away.", labels, nil),
+		errors: prometheus.NewDesc("fsq_errors_total", "Runs whose action returned an error, by the error's class.", []string{"queue", "class"}, nil),
+		durations: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name: "fsq_action_duration_seconds",
+			Help: "How long the actions of tasks ran.",
+			Buckets: DurationBuckets,
+		}, []string{"queue", "action", "outcome"}),
+	}
+
+	filter := fsq.EventFilter{Types: []fsq.EventType{fsq.EventCompleted, fsq.EventFailed, fsq.EventRetrying, fsq.EventAbandoned}}
+
+	for _, q := range queues {
+		sub := q.SubscribeFiltered(filter, eventBuffer, fsq.DropOldest)
+		c.subs = append(c.subs, sub)
+
+		c.done.Add(1)
+		go c.observe(sub)
+	}
+
+	return c
+}
+
+
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.waiting
+	ch <- c.processing
+	ch <- c.capacity
+	ch <- c.enqueued
+	ch <- c.completed
+	ch <- c.failed
+	ch <- c.rejected
+	ch <- c.errors
+	c.durations.Describe(ch)
+}
+
+
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	for _, q := range c.queues {
+		name := q.QualifiedName()
+		stats := q.Stats()
+
+		ch <- prometheus.MustNewConstMetric(c.waiting, prometheus.GaugeValue, float64(stats.Waiting), name)
+		ch <- prometheus.MustNewConstMetric(c.processing, prometheus.GaugeValue, float64(stats.Processing), name)
+		ch <- prometheus.MustNewConstMetric(c.capacity, prometheus.GaugeValue, float64(stats.Capacity), name)
+		ch <- prometheus.MustNewConstMetric(c.enqueued, prometheus.CounterValue, float64(stats.Enqueued), name)
+		ch <- prometheus.MustNewConstMetric(c.completed, prometheus.CounterValue, float64(stats.Completed), name)
+		ch <- prometheus.MustNewConstMetric(c.failed, prometheus.CounterValue, float64(stats.Failed), name)
+		ch <- prometheus.MustNewConstMetric(c.rejected, prometheus.CounterValue, float64(stats.Rejected), name)
+
+		for _, class := range errorClasses {
+			ch <- prometheus.MustNewConstMetric(c.errors, prometheus.CounterValue, float64(stats.Errors.Of(class)), name, class.String())
+		}
+	}
+
+	c.durations.Collect(ch)
+}
+
+
+// Stops observing action durations. The gauges and counters can still be collected.
+func (c *Collector) Close() {
+	for _, sub := range c.subs {
+		sub.Close()
+	}
+
+	c.done.Wait()
+}
+
+
+// observes the duration of every run ending on @sub, until it is closed
+func (c *Collector) observe(sub *fsq.Subscription) {
+	defer c.done.Done()
+
+	for event := range sub.Events() {
+		c.durations.WithLabelValues(event.Queue, event.ActionName, event.Type.String()).Observe(event.Duration.Seconds())
+	}
+}
+
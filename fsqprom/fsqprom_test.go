@@ -0,0 +1,82 @@
+package fsqprom
+
+import "testing"
+import "errors"
+import "time"
+import "github.com/brybott/go_fsq"
+import "github.com/prometheus/client_golang/prometheus"
+import "github.com/stretchr/testify/assert"
+
+
+// returns the values of the metrics gathered from @reg by metric name and queue, histograms by their sample count
+func gather(t *testing.T, reg *prometheus.Registry) map[string]float64 {
+	families, err := reg.Gather()
+	assert.NoError(t, err)
+
+	values := map[string]float64{}
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			key := family.GetName()
+			for _, label := range metric.GetLabel() {
+				key += "," + label.GetValue()
+			}
+
+			switch {
+			case metric.GetGauge() != nil:
+				values[key] = metric.GetGauge().GetValue()
+			case metric.GetCounter() != nil:
+				values[key] = metric.GetCounter().GetValue()
+			case metric.GetHistogram() != nil:
+				values[key] = float64(metric.GetHistogram().GetSampleCount())
+			}
+		}
+	}
+
+	return values
+}
+
+
+func TestCollector(t *testing.T) {
+	assert := assert.New(t)
+
+	emails := fsq.Init(5, "emails", 1)
+	reports := fsq.Init(3, "reports", 1)
+	emails.Start()
+	reports.Start()
+	defer emails.Stop()
+	defer reports.Stop()
+
+	c := NewCollector(emails, reports)
+	defer c.Close()
+	reg := prometheus.NewPedanticRegistry()
+	assert.NoError(reg.Register(c))
+
+	assert.NoError(emails.AddNamed("send", func(params map[string]interface{}) error { return nil }, map[string]interface{}{}, "1"))
+	assert.NoError(emails.AddNamed("send", func(params map[string]interface{}) error { return errors.New("boom") }, map[string]interface{}{}, "2"))
+	assert.Error(emails.Add(nil, nil, " "))
+
+	release := make(chan struct{})
+	defer close(release)
+	assert.NoError(reports.Add(func(params map[string]interface{}) error {
+		<-release
+		return nil
+	}, map[string]interface{}{}, "running"))
+	assert.NoError(reports.Add(func(params map[string]interface{}) error { return nil }, map[string]interface{}{}, "waiting"))
+
+	assert.Eventually(func() bool {
+		values := gather(t, reg)
+		return values["fsq_action_duration_seconds,send,completed,emails"] == 1 && values["fsq_action_duration_seconds,send,failed,emails"] == 1
+	}, time.Second, 10 * time.Millisecond)
+
+	values := gather(t, reg)
+	assert.Equal(2.0, values["fsq_enqueued_tasks_total,emails"])
+	assert.Equal(1.0, values["fsq_completed_tasks_total,emails"])
+	assert.Equal(1.0, values["fsq_failed_tasks_total,emails"])
+	assert.Equal(1.0, values["fsq_rejected_tasks_total,emails"])
+	assert.Equal(1.0, values["fsq_errors_total,unclassified,emails"])
+	assert.Equal(0.0, values["fsq_errors_total,permanent,emails"])
+	assert.Equal(5.0, values["fsq_capacity_tasks,emails"])
+	assert.Equal(3.0, values["fsq_capacity_tasks,reports"])
+	assert.Equal(1.0, values["fsq_waiting_tasks,reports"])
+	assert.Equal(1.0, values["fsq_processing_tasks,reports"])
+}
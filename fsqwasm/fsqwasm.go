@@ -0,0 +1,110 @@
+// - fsqwasm loads fsq task actions from WASM modules, so task handlers can be deployed or updated
+// without rebuilding the service that embeds the queue.
+//
+// - Modules are WASI commands (e.g. built with GOOS=wasip1 GOARCH=wasm). For every task a fresh
+// instance of the module is started, the task's params are passed as JSON over stdin, and the module
+// reports failure by exiting with a non-zero code. Anything written to stderr becomes the error message.
+//
+// - Since params are passed as JSON, they must be serializable, and numbers arrive as float64 values.
+//
+// - An instance is closed once its task's context is done, so task timeouts, abandon, CancelProcessing and
+// StopNow stop a module that runs too long.
+
+package fsqwasm
+
+import "fmt"
+import "errors"
+import "bytes"
+import "context"
+import "encoding/json"
+import "os"
+import "strings"
+import "sync"
+
+import "github.com/brybott/go_fsq"
+import "github.com/tetratelabs/wazero"
+import "github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+import "github.com/tetratelabs/wazero/sys"
+
+var runtimeOnce sync.Once
+var wasmRuntime wazero.Runtime
+
+// returns the runtime shared by every loaded module
+func sharedRuntime() wazero.Runtime {
+	runtimeOnce.Do(func() {
+		ctx := context.Background()
+		// modules that run past their task's context are closed, see run
+		wasmRuntime = wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().WithCloseOnContextDone(true))
+		wasi_snapshot_preview1.MustInstantiate(ctx, wasmRuntime)
+	})
+
+	return wasmRuntime
+}
+
+
+// - Compiles the WASM module in @wasm and registers it on @q as the action @name.
+// - Registering a name that is already registered replaces its action, so a module can be updated at runtime.
+func Register(q *fsq.FixedSizeQueue, name string, wasm []byte) error {
+	compiled, err := sharedRuntime().CompileModule(context.Background(), wasm)
+	if err != nil {
+		return errors.New(fmt.Sprintf("WASM module for action %s can't be compiled: %s", name, err))
+	}
+
+	return q.RegisterContextAction(name, func(ctx context.Context, params map[string]interface{}) error {
+		return run(ctx, name, compiled, params)
+	})
+}
+
+
+// Same as Register, but reads the module from the file at @path.
+func RegisterFile(q *fsq.FixedSizeQueue, name string, path string) error {
+	wasm, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	return Register(q, name, wasm)
+}
+
+
+// runs a fresh instance of @compiled with @params, the instance is closed once @ctx is done
+func run(ctx context.Context, name string, compiled wazero.CompiledModule, params map[string]interface{}) error {
+	input, err := json.Marshal(params)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Params for WASM action %s can't be serialized: %s", name, err))
+	}
+
+	var stderr bytes.Buffer
+
+	// modules are instantiated without a name so that several tasks can run the same module at once
+	config := wazero.NewModuleConfig().
+		WithName("").
+		WithStdin(bytes.NewReader(input)).
+		WithStderr(&stderr)
+
+	module, err := sharedRuntime().InstantiateModule(ctx, compiled, config)
+	if module != nil {
+		defer module.Close(context.Background())
+	}
+
+	if err == nil {
+		return nil
+	}
+
+	var exitErr *sys.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 0 {
+		return nil
+	}
+
+	// a closed instance failed because its task ran out of time or was stopped
+	if ctx.Err() != nil {
+		return errors.Join(ctx.Err(), errors.New(fmt.Sprintf("WASM action %s was stopped: %s", name, err)))
+	}
+
+	output := strings.TrimSpace(stderr.String())
+	if output != "" {
+		return errors.New(output)
+	}
+
+	return errors.New(fmt.Sprintf("WASM action %s failed: %s", name, err))
+}
@@ -0,0 +1,143 @@
+package fsqwasm
+
+import "testing"
+import "context"
+import "time"
+import "sync/atomic"
+import "github.com/brybott/go_fsq"
+import "github.com/stretchr/testify/assert"
+
+// a WASI command whose _start returns right away
+var succeedModule = []byte{
+	0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00,
+	0x01, 0x04, 0x01, 0x60, 0x00, 0x00,  // type section: () -> ()
+	0x03, 0x02, 0x01, 0x00,  // function section
+	0x07, 0x0a, 0x01, 0x06, '_', 's', 't', 'a', 'r', 't', 0x00, 0x00,  // export _start
+	0x0a, 0x04, 0x01, 0x02, 0x00, 0x0b,  // code section: empty body
+}
+
+// a WASI command whose _start calls proc_exit(1)
+var failModule = []byte{
+	0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00,
+	0x01, 0x08, 0x02, 0x60, 0x01, 0x7f, 0x00, 0x60, 0x00, 0x00,  // type section: (i32) -> (), () -> ()
+	0x02, 0x24, 0x01,  // import section
+	0x16, 'w', 'a', 's', 'i', '_', 's', 'n', 'a', 'p', 's', 'h', 'o', 't', '_', 'p', 'r', 'e', 'v', 'i', 'e', 'w', '1',
+	0x09, 'p', 'r', 'o', 'c', '_', 'e', 'x', 'i', 't', 0x00, 0x00,
+	0x03, 0x02, 0x01, 0x01,  // function section
+	0x07, 0x0a, 0x01, 0x06, '_', 's', 't', 'a', 'r', 't', 0x00, 0x01,  // export _start
+	0x0a, 0x08, 0x01, 0x06, 0x00, 0x41, 0x01, 0x10, 0x00, 0x0b,  // code section: i32.const 1, call proc_exit
+}
+
+// a WASI command whose _start loops forever
+var loopModule = []byte{
+	0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00,
+	0x01, 0x04, 0x01, 0x60, 0x00, 0x00,  // type section: () -> ()
+	0x03, 0x02, 0x01, 0x00,  // function section
+	0x07, 0x0a, 0x01, 0x06, '_', 's', 't', 'a', 'r', 't', 0x00, 0x00,  // export _start
+	0x0a, 0x09, 0x01, 0x07, 0x00, 0x03, 0x40, 0x0c, 0x00, 0x0b, 0x0b,  // code section: loop, br 0
+}
+
+
+func TestRegister_InvalidModule(t *testing.T) {
+	assert := assert.New(t)
+	q := fsq.Init(5, "TestQueue", 2)
+
+	err := Register(q, "broken", []byte("not wasm"))
+
+	assert.Error(err)
+	assert.Contains(err.Error(), "WASM module for action broken can't be compiled")
+	assert.False(q.IsRegistered("broken"))
+}
+
+
+func TestRegisterFile_MissingFile(t *testing.T) {
+	assert := assert.New(t)
+	q := fsq.Init(5, "TestQueue", 2)
+
+	err := RegisterFile(q, "missing", "does-not-exist.wasm")
+
+	assert.Error(err)
+	assert.False(q.IsRegistered("missing"))
+}
+
+
+func TestRun_ExitCodeIsResult(t *testing.T) {
+	assert := assert.New(t)
+
+	succeed, err := sharedRuntime().CompileModule(t.Context(), succeedModule)
+	assert.NoError(err)
+	fail, err := sharedRuntime().CompileModule(t.Context(), failModule)
+	assert.NoError(err)
+
+	params := map[string]interface{}{"key": "val"}
+	assert.NoError(run(t.Context(), "succeed", succeed, params))
+
+	err = run(t.Context(), "fail", fail, params)
+	assert.Error(err)
+	assert.Contains(err.Error(), "WASM action fail failed")
+
+	err = run(t.Context(), "succeed", succeed, map[string]interface{}{"ch": make(chan int)})
+	assert.Error(err)
+	assert.Contains(err.Error(), "Params for WASM action succeed can't be serialized")
+}
+
+
+func TestRun_ContextClosesInstance(t *testing.T) {
+	assert := assert.New(t)
+
+	loop, err := sharedRuntime().CompileModule(t.Context(), loopModule)
+	assert.NoError(err)
+
+	ctx, cancel := context.WithTimeout(t.Context(), 50 * time.Millisecond)
+	defer cancel()
+
+	err = run(ctx, "loop", loop, map[string]interface{}{})
+	assert.ErrorIs(err, context.DeadlineExceeded)
+	assert.Contains(err.Error(), "WASM action loop was stopped")
+}
+
+
+func TestRegister_CancelProcessingStopsModule(t *testing.T) {
+	assert := assert.New(t)
+	q := fsq.Init(5, "TestQueue", 1)
+	q.Start()
+	defer q.Stop()
+
+	assert.NoError(Register(q, "loop", loopModule))
+
+	sub := q.Subscribe(10, fsq.DropOldest)
+	defer sub.Close()
+
+	assert.NoError(q.AddByName("loop", map[string]interface{}{}, "id-1"))
+	assert.Eventually(func() bool {
+		return q.CancelProcessing("id-1") == nil
+	}, time.Second, 10 * time.Millisecond)
+
+	select {
+	case event := <-sub.Events():
+		assert.Equal(fsq.EventFailed, event.Type)
+		assert.Equal(fsq.ReasonCancelled, event.Reason)
+	case <-time.After(time.Second):
+		assert.Fail("the module should be stopped once its task is cancelled")
+	}
+}
+
+
+func TestRegister_RunsOnQueue(t *testing.T) {
+	assert := assert.New(t)
+	q := fsq.Init(5, "TestQueue", 2)
+	q.Start()
+
+	assert.NoError(Register(q, "succeed", succeedModule))
+	assert.True(q.IsRegistered("succeed"))
+
+	var done atomic.Bool
+	assert.NoError(q.RegisterAction("after", func(params map[string]interface{}) error {
+		done.Store(true)
+		return nil
+	}))
+
+	assert.NoError(q.AddByName("succeed", map[string]interface{}{}, "id-1"))
+	assert.NoError(q.AddByName("after", map[string]interface{}{}, "id-2"))
+	assert.Eventually(done.Load, time.Second, 10 * time.Millisecond)
+}
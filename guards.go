@@ -0,0 +1,120 @@
+package fsq
+
+import "fmt"
+import "errors"
+import "context"
+import "math"
+import "runtime"
+import "sync"
+
+// - Wraps the execution of a task's action to keep one heavy action from degrading others.
+// - A guard must call @run at most once, and should return its error. Returning an error without calling
+// @run skips the action.
+type ActionGuard func(params map[string]interface{}, run func() error) error
+
+
+// - Same as Add, but the task is labeled with @actionName so that the guard set for that name
+// (see SetActionGuard) wraps its execution.
+func (q *FixedSizeQueue) AddNamed(actionName string, action func(params map[string]interface{}) error, params map[string]interface{}, id string) error {
+	return q.add(context.Background(), action, params, id, addOptions{actionName: actionName})
+}
+
+
+// - Sets the guard that wraps the execution of tasks added with @actionName.
+// - An empty @actionName sets the default guard, used for every task whose name has no guard of its own.
+// - Passing a nil guard removes it.
+func (q *FixedSizeQueue) SetActionGuard(actionName string, guard ActionGuard) {
+	q.changeConfig("", 0, false, fmt.Sprintf("actionGuard[%s]", actionName), func() (string, string, error) {
+		oldValue := guardName(q.guards[actionName])
+
+		if guard == nil {
+			delete(q.guards, actionName)
+			return oldValue, "", nil
+		}
+
+		if q.guards == nil {
+			q.guards = map[string]ActionGuard{}
+		}
+
+		q.guards[actionName] = guard
+		return oldValue, guardName(guard), nil
+	})
+}
+
+
+// names @guard for the audit log, empty when there is none
+func guardName(guard ActionGuard) string {
+	if guard == nil {
+		return ""
+	}
+
+	return "custom"
+}
+
+
+// Calls the task's action through the guard for its action name, if any. Runs in the task's go routine,
+// the lock is only held to look up the guard.
+func (q *FixedSizeQueue) callGuarded(task *task) error {
+	q.mu.Lock()
+	guard, ok := q.guards[task.actionName]
+	if !ok {
+		guard, ok = q.guards[""]
+	}
+	q.mu.Unlock()
+
+	if !ok {
+		return task.CallAction()
+	}
+
+	return guard(task.paramsOfRun(), task.CallAction)
+}
+
+
+// - Returns a guard that lets at most a @share of GOMAXPROCS (at least 1) guarded actions run at once.
+// Tasks over the share wait inside their processing slot until another guarded action finishes.
+// - e.g. a share of 0.5 with GOMAXPROCS of 8 lets 4 guarded actions run at once.
+// - GOMAXPROCS is read when the guard is created.
+func ProcsShareGuard(share float64) ActionGuard {
+	limit := int(math.Ceil(share * float64(runtime.GOMAXPROCS(0))))
+	if limit < 1 {
+		limit = 1
+	}
+
+	slots := make(chan struct{}, limit)
+
+	return func(params map[string]interface{}, run func() error) error {
+		slots <- struct{}{}
+		defer func() { <-slots }()
+		return run()
+	}
+}
+
+
+// - Returns a guard that keeps a soft account of the memory used by guarded actions. Each action reserves
+// the bytes returned by @estimate for its params while it runs, and an action that would take the
+// total over @ceiling is not run and fails with an error instead.
+// - The accounting is "soft" because it relies on the estimate, actual allocations are not measured.
+func MemoryCeilingGuard(ceiling uint64, estimate func(params map[string]interface{}) uint64) ActionGuard {
+	var mu sync.Mutex
+	var reserved uint64
+
+	return func(params map[string]interface{}, run func() error) error {
+		need := estimate(params)
+
+		mu.Lock()
+		if reserved + need > ceiling {
+			mu.Unlock()
+			return errors.New(fmt.Sprintf("Memory ceiling of %d bytes reached, %d reserved and task needs %d.", ceiling, reserved, need))
+		}
+		reserved += need
+		mu.Unlock()
+
+		defer func() {
+			mu.Lock()
+			reserved -= need
+			mu.Unlock()
+		}()
+
+		return run()
+	}
+}
@@ -0,0 +1,76 @@
+package fsq
+
+import "context"
+
+// - A task added with WithHandle, to await its end instead of treating the queue as fire and forget.
+// - A handle is resolved exactly once: when the task's action returned, or when the task was abandoned
+// or dropped.
+type TaskHandle struct {
+	ExternalId string
+	done chan struct{}
+	result Event  //set before done is closed
+}
+
+
+// Returns a channel that is closed once the task ended.
+func (h *TaskHandle) Done() <-chan struct{} {
+	return h.done
+}
+
+
+// Returns the error the task ended with: its action's error, ErrTaskAbandoned or ErrTaskTooOld. Nil while
+// the task hasn't ended, or if it succeeded.
+func (h *TaskHandle) Err() error {
+	select {
+	case <-h.done:
+		return h.errOf()
+	default:
+		return nil
+	}
+}
+
+
+// Returns the event the task ended with (see Event), false while it hasn't ended.
+func (h *TaskHandle) Result() (Event, bool) {
+	select {
+	case <-h.done:
+		return h.result, true
+	default:
+		return Event{}, false
+	}
+}
+
+
+// Waits until the task ended or @ctx is done, returns the task's error (see Err) or the context's.
+func (h *TaskHandle) Wait(ctx context.Context) error {
+	select {
+	case <-h.done:
+		return h.errOf()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+
+func (h *TaskHandle) errOf() error {
+	switch {
+	case h.result.Err != nil:
+		return h.result.Err
+	case h.result.Type == EventAbandoned:
+		return ErrTaskAbandoned
+	}
+
+	return nil
+}
+
+
+// Resolves the handle of @task with the @event it ended with, if it has one. Doesn't block.
+func (t *task) resolve(event Event) {
+	if t.handle == nil {
+		return
+	}
+
+	t.handle.result = event
+	close(t.handle.done)
+	t.handle = nil
+}
@@ -0,0 +1,82 @@
+package fsq
+
+import "hash/fnv"
+import "sort"
+import "strconv"
+
+// points each queue gets on the ring, more points spread ids more evenly
+const hashRingReplicas = 128
+
+// A consistent hash ring over queue names, see RouteByHash.
+type hashRing struct {
+	points []uint32  //sorted
+	names map[uint32]string  //queue name by point
+}
+
+
+// - Returns a route func sending each task to one of the queues named @names by hashing its external id,
+// so the same id always goes to the same queue. Dedup of waiting ids and the order of tasks with the
+// same id then hold across the queues as they do within one.
+// - The hashing is consistent: adding or removing a queue name only moves about 1/n of the ids, the
+// others keep going where they went.
+func RouteByHash(names ...string) RouteFunc {
+	ring := newHashRing(names)
+
+	return func(r Route) string {
+		return ring.get(r.ExternalId)
+	}
+}
+
+
+// - Returns a mux that shards tasks across @queues by external id, see RouteByHash.
+func NewShardedMux(queues ...*FixedSizeQueue) (*Mux, error) {
+	names := make([]string, 0, len(queues))
+	for _, q := range queues {
+		names = append(names, q.QualifiedName())
+	}
+
+	return NewMux(RouteByHash(names...), queues...)
+}
+
+
+func newHashRing(names []string) *hashRing {
+	ring := &hashRing{names: map[uint32]string{}}
+
+	for _, name := range names {
+		for i := 0; i < hashRingReplicas; i++ {
+			point := hashKey(name + "#" + strconv.Itoa(i))
+			if _, ok := ring.names[point]; ok {
+				continue
+			}
+
+			ring.names[point] = name
+			ring.points = append(ring.points, point)
+		}
+	}
+
+	sort.Slice(ring.points, func(i, j int) bool { return ring.points[i] < ring.points[j] })
+	return ring
+}
+
+
+// returns the name owning @key, the first point at or after its hash. Empty if the ring has no names.
+func (r *hashRing) get(key string) string {
+	if len(r.points) == 0 {
+		return ""
+	}
+
+	hash := hashKey(key)
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= hash })
+	if i == len(r.points) {
+		i = 0
+	}
+
+	return r.names[r.points[i]]
+}
+
+
+func hashKey(key string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return h.Sum32()
+}
@@ -0,0 +1,239 @@
+package fsq
+
+import "sort"
+import "time"
+
+// used when a health probe is set with an interval <= 0
+const defaultProbeInterval = time.Second
+
+type healthProbe struct {
+	check func() bool
+	interval time.Duration
+	healthy bool
+	recovered func()  //called while holding the lock when the probe reports healthy again
+	stop chan struct{}  //closed to end the polling go routine, nil when not polling
+}
+
+
+// - Registers a func that reports whether the downstream the queue's tasks depend on is healthy.
+// - While the probe reports unhealthy, waiting tasks are held in the queue instead of being dispatched.
+// Tasks that are already processing are not affected.
+// - The probe is polled every @interval while the queue is running, and dispatching resumes
+// automatically as soon as it reports healthy again.
+// - Setting a new probe replaces the previous one.
+// - See SetActionHealthProbe for a downstream only some of the queue's actions depend on.
+func (q *FixedSizeQueue) SetHealthProbe(check func() bool, interval time.Duration) {
+	if check == nil {
+		q.ClearHealthProbe()
+		return
+	}
+
+	probe := newHealthProbe(check, interval)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.clearHealthProbe()
+	probe.recovered = q.dispatchWaiting
+	q.probe = probe
+
+	if q.isRunning {
+		q.startProbe(probe)
+	}
+}
+
+
+// - Same as SetHealthProbe, but for the downstream of the tasks added with @actionName only: while the probe
+// reports unhealthy, the action's waiting tasks are parked (see ParkedTasks) and tasks of other actions keep
+// being dispatched. The parked tasks are dispatched once the probe reports healthy again.
+// - Every action name has its own probe, setting a new one for @actionName replaces the previous one.
+func (q *FixedSizeQueue) SetActionHealthProbe(actionName string, check func() bool, interval time.Duration) {
+	if check == nil {
+		q.ClearActionHealthProbe(actionName)
+		return
+	}
+
+	probe := newHealthProbe(check, interval)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.clearActionHealthProbe(actionName)
+	probe.recovered = func() {
+		q.unpark(actionName)
+	}
+
+	if q.actionProbes == nil {
+		q.actionProbes = map[string]*healthProbe{}
+	}
+	q.actionProbes[actionName] = probe
+
+	if q.isRunning {
+		q.startProbe(probe)
+	}
+}
+
+
+// Removes the health probe (if any) and resumes dispatching of waiting tasks.
+func (q *FixedSizeQueue) ClearHealthProbe() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.clearHealthProbe()
+}
+
+
+// Removes the health probe of @actionName (if any) and dispatches the action's parked tasks.
+func (q *FixedSizeQueue) ClearActionHealthProbe(actionName string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.clearActionHealthProbe(actionName)
+}
+
+
+func (q *FixedSizeQueue) clearHealthProbe() {
+	if q.probe == nil {
+		return
+	}
+
+	q.stopProbe(q.probe)
+	q.probe = nil
+	q.dispatchWaiting()
+}
+
+
+func (q *FixedSizeQueue) clearActionHealthProbe(actionName string) {
+	probe, ok := q.actionProbes[actionName]
+	if !ok {
+		return
+	}
+
+	q.stopProbe(probe)
+	delete(q.actionProbes, actionName)
+	q.unpark(actionName)
+}
+
+
+// Returns the last result of the health probe. Always true when no probe is set.
+func (q *FixedSizeQueue) IsHealthy() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.isHealthy()
+}
+
+
+// Returns the last result of @actionName's health probe. Always true when the action has no probe, whatever the
+// queue's own probe reports.
+func (q *FixedSizeQueue) IsActionHealthy(actionName string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.isActionHealthy(actionName)
+}
+
+
+func (q *FixedSizeQueue) isHealthy() bool {
+	if q.probe == nil {
+		return true
+	}
+
+	return q.probe.healthy
+}
+
+
+func (q *FixedSizeQueue) isActionHealthy(actionName string) bool {
+	probe, ok := q.actionProbes[actionName]
+	return !ok || probe.healthy
+}
+
+
+// returns the names of the actions whose health probe reports unhealthy, sorted
+func (q *FixedSizeQueue) unhealthyActions() []string {
+	names := []string{}
+	for name, probe := range q.actionProbes {
+		if !probe.healthy {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+
+// the probe may be slow, so it is checked before taking the lock
+func newHealthProbe(check func() bool, interval time.Duration) *healthProbe {
+	if interval <= 0 {
+		interval = defaultProbeInterval
+	}
+
+	return &healthProbe{
+		check: check,
+		interval: interval,
+		healthy: check(),
+	}
+}
+
+
+// starts polling the queue's probe and the probes of its actions
+func (q *FixedSizeQueue) startHealthProbe() {
+	if q.probe != nil {
+		q.startProbe(q.probe)
+	}
+
+	for _, probe := range q.actionProbes {
+		q.startProbe(probe)
+	}
+}
+
+
+func (q *FixedSizeQueue) stopHealthProbe() {
+	if q.probe != nil {
+		q.stopProbe(q.probe)
+	}
+
+	for _, probe := range q.actionProbes {
+		q.stopProbe(probe)
+	}
+}
+
+
+func (q *FixedSizeQueue) startProbe(probe *healthProbe) {
+	if probe.stop != nil {
+		return
+	}
+
+	probe.stop = make(chan struct{})
+	go runTicker(probe.interval, probe.stop, func() {
+		q.pollHealthProbe(probe)
+	})
+}
+
+
+func (q *FixedSizeQueue) stopProbe(probe *healthProbe) {
+	if probe.stop == nil {
+		return
+	}
+
+	close(probe.stop)
+	probe.stop = nil
+}
+
+
+// Runs in the probe's go routine, takes the lock once the probe returned.
+func (q *FixedSizeQueue) pollHealthProbe(probe *healthProbe) {
+	healthy := probe.check()
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	wasHealthy := probe.healthy
+	probe.healthy = healthy
+
+	// when the probe recovers, start the waiting tasks that were held back
+	if probe.healthy && !wasHealthy {
+		probe.recovered()
+	}
+}
@@ -0,0 +1,124 @@
+package fsq
+
+import "sync"
+import "time"
+
+// the most attempts kept per external id, older ones are dropped
+const maxAttempts = 64
+
+// A run of a task's action, see History.Attempts.
+type Attempt struct {
+	Number int  //1 for the first run of the external id, counting up with every run after it
+	Worker string  //the qualified name of the queue that ran the attempt
+	ActionName string
+	StartedAt time.Time
+	Duration time.Duration  //until the action returned, or until the task was abandoned
+	Err error  //the action's error, nil when it succeeded or was abandoned
+	Reason OutcomeReason  //how the attempt ended
+	Result interface{}  //what the action reported with SetResult, nil when it reported nothing
+}
+
+// - Keeps the attempts of the tasks run by the queues it is set on, by external id, see SetHistory.
+// - A task added again with the same external id, e.g. after it failed, adds an attempt to the id's
+// timeline, so callers can see how a task got to its final outcome and not just the outcome.
+// - Safe for concurrent use, one history may be shared by several queues (e.g. the queues of a Mux), then
+// the Worker of an attempt tells which queue ran it.
+type History struct {
+	mu sync.Mutex
+	maxIds int
+	attempts map[string][]Attempt  //by external id, oldest first
+	order []string  //external ids, oldest first
+}
+
+
+// - Returns a history keeping the attempts of up to @maxIds external ids, the ids that were first run the
+// longest ago are dropped first. Defaults to 1000 if @maxIds <= 0 is passed in.
+// - Each id keeps at most its 64 most recent attempts, their numbers keep counting.
+func NewHistory(maxIds int) *History {
+	if maxIds <= 0 {
+		maxIds = 1000
+	}
+
+	return &History{
+		maxIds: maxIds,
+		attempts: map[string][]Attempt{},
+	}
+}
+
+
+// Returns the attempts of @externalId, oldest first. Empty if the id wasn't run (or was dropped).
+func (h *History) Attempts(externalId string) []Attempt {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return append([]Attempt{}, h.attempts[externalId]...)
+}
+
+
+// Returns the last attempt of @externalId, false if the id wasn't run (or was dropped).
+func (h *History) Last(externalId string) (Attempt, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	attempts := h.attempts[externalId]
+	if len(attempts) == 0 {
+		return Attempt{}, false
+	}
+
+	return attempts[len(attempts) - 1], true
+}
+
+
+// Records the attempts of the tasks the queue runs from now on in @history, nil stops recording.
+func (q *FixedSizeQueue) SetHistory(history *History) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.history = history
+}
+
+
+// Called with the event of @task ending, records the attempt if the queue has a history.
+func (q *FixedSizeQueue) recordAttempt(task *task, event Event) {
+	if q.history == nil {
+		return
+	}
+
+	q.history.record(Attempt{
+		Worker: event.Queue,
+		ActionName: event.ActionName,
+		StartedAt: task.startedAt,
+		Duration: event.At.Sub(task.startedAt),
+		Err: event.Err,
+		Reason: event.Reason,
+		Result: event.Result,
+	}, event.ExternalId)
+}
+
+
+// numbers @attempt and adds it to the timeline of @externalId
+func (h *History) record(attempt Attempt, externalId string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	attempts, ok := h.attempts[externalId]
+	if !ok {
+		h.order = append(h.order, externalId)
+		for len(h.order) > h.maxIds {
+			delete(h.attempts, h.order[0])
+			h.order = h.order[1:]
+		}
+	}
+
+	attempt.Number = 1
+	if n := len(attempts); n > 0 {
+		attempt.Number = attempts[n - 1].Number + 1
+	}
+
+	attempts = append(attempts, attempt)
+	if len(attempts) > maxAttempts {
+		attempts = attempts[1:]
+	}
+
+	h.attempts[externalId] = attempts
+}
@@ -0,0 +1,223 @@
+package fsq
+
+import "fmt"
+import "errors"
+import "context"
+import "io"
+import "net/http"
+import "net/url"
+import "strconv"
+import "strings"
+import "text/template"
+import "text/template/parse"
+import "time"
+
+// used when an HTTP action is registered without an http.Client
+const defaultHTTPActionTimeout = 30 * time.Second
+
+// how much of a failed response's body is kept in its HTTPError
+const maxHTTPErrorBody = 4096
+
+// how much of a successful response's body is kept in its HTTPResult
+const maxHTTPResultBody = 1 << 20
+
+// The result of an HTTP action whose response status is 2xx, see SetResult.
+type HTTPResult struct {
+	StatusCode int
+	Header http.Header
+	Body []byte  //the start of the response body, up to 1 MiB
+}
+
+// Returned by an HTTP action (see RegisterHTTPAction) when the response status isn't 2xx.
+type HTTPError struct {
+	Method string
+	URL string
+	StatusCode int
+	Body string  //the start of the response body
+}
+
+
+// - Registers an action under @name that makes the HTTP request described by the task's params:
+//	"method"   defaults to GET
+//	"url"      a text/template expanded with the params, e.g. "https://api.example.com/users/{{.id}}". What
+//	           an action outputs is escaped, path escaped before the "?" and query escaped after it, so a
+//	           param value can't change the URL's path or query
+//	"headers"  a map of header names to values
+//	"body"     the request body
+// - @client makes the requests. If nil, a client with a 30 second timeout is used. The request is made with the
+// context of the task's run, so it is cancelled when the task times out, passes its deadline, is abandoned (see
+// SetAbandonTimeout) or the queue is stopped.
+// - A 2xx response is the task's result as an HTTPResult (see SetResult). The task fails with an HTTPError when the response status isn't 2xx. 429 and 503 responses are
+// RateLimited (with the response's Retry-After, see SetRateLimitPause), other 5xx responses Retryable and
+// 4xx responses Permanent.
+func (q *FixedSizeQueue) RegisterHTTPAction(name string, client *http.Client) error {
+	if client == nil {
+		client = &http.Client{Timeout: defaultHTTPActionTimeout}
+	}
+
+	return q.RegisterContextAction(name, func(ctx context.Context, params map[string]interface{}) error {
+		return doRequest(ctx, client, params)
+	})
+}
+
+
+func doRequest(ctx context.Context, client *http.Client, params map[string]interface{}) error {
+	method, _ := params["method"].(string)
+	if method == "" {
+		method = http.MethodGet
+	}
+
+	urlTemplate, ok := params["url"].(string)
+	if !ok {
+		return Permanent(errors.New("Param url must be a string."))
+	}
+
+	url, err := expandURLTemplate(urlTemplate, params)
+	if err != nil {
+		return Permanent(err)
+	}
+
+	var body io.Reader
+	if b, ok := params["body"].(string); ok {
+		body = strings.NewReader(b)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return Permanent(err)
+	}
+
+	headers, _ := params["headers"].(map[string]interface{})
+	for key, value := range headers {
+		req.Header.Set(key, fmt.Sprint(value))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		// the task ran out of time or was stopped, running it again right away won't help
+		if ctx.Err() != nil {
+			return err
+		}
+
+		return Retryable(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		respBody, err := io.ReadAll(io.LimitReader(resp.Body, maxHTTPResultBody))
+		if err != nil {
+			return Retryable(err)
+		}
+
+		SetResult(ctx, HTTPResult{StatusCode: resp.StatusCode, Header: resp.Header, Body: respBody})
+		return nil
+	}
+
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, maxHTTPErrorBody))
+	httpErr := &HTTPError{Method: method, URL: url, StatusCode: resp.StatusCode, Body: string(respBody)}
+
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable:
+		return RateLimited(httpErr, retryAfterHeader(resp.Header.Get("Retry-After")))
+	case resp.StatusCode >= 500:
+		return Retryable(httpErr)
+	}
+
+	return Permanent(httpErr)
+}
+
+
+// expands @text like expandTemplate, but pipes what every action outputs through url.PathEscape, or through
+// url.QueryEscape once the template's text has reached the query or fragment
+func expandURLTemplate(text string, data interface{}) (string, error) {
+	tmpl, err := template.New("url").Option("missingkey=error").Funcs(template.FuncMap{
+		"pathEscape": func(value interface{}) string {
+			return url.PathEscape(fmt.Sprint(value))
+		},
+		"queryEscape": func(value interface{}) string {
+			return url.QueryEscape(fmt.Sprint(value))
+		},
+	}).Parse(text)
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("Template %q can't be parsed: %s", text, err))
+	}
+
+	if tmpl.Tree != nil {
+		escapeActions(tmpl.Tree, tmpl.Tree.Root, false)
+	}
+
+	var out strings.Builder
+	err = tmpl.Execute(&out, data)
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("Template %q can't be expanded: %s", text, err))
+	}
+
+	return out.String(), nil
+}
+
+
+// - Appends the escape func to the pipeline of every action of @list, in the order the template outputs them.
+// - @inQuery tells whether the text before @list already reached the query, the result whether the text up to
+// the end of @list did.
+func escapeActions(tree *parse.Tree, list *parse.ListNode, inQuery bool) bool {
+	if list == nil {
+		return inQuery
+	}
+
+	for _, node := range list.Nodes {
+		switch node := node.(type) {
+		case *parse.TextNode:
+			if strings.ContainsAny(string(node.Text), "?#") {
+				inQuery = true
+			}
+		case *parse.ActionNode:
+			// {{$x := ...}} declares a variable and outputs nothing
+			if len(node.Pipe.Decl) > 0 {
+				continue
+			}
+
+			escape := "pathEscape"
+			if inQuery {
+				escape = "queryEscape"
+			}
+			node.Pipe.Cmds = append(node.Pipe.Cmds, &parse.CommandNode{
+				NodeType: parse.NodeCommand,
+				Pos: node.Pos,
+				Args: []parse.Node{parse.NewIdentifier(escape).SetTree(tree).SetPos(node.Pos)},
+			})
+		case *parse.IfNode:
+			inQuery = escapeBranch(tree, &node.BranchNode, inQuery)
+		case *parse.RangeNode:
+			inQuery = escapeBranch(tree, &node.BranchNode, inQuery)
+		case *parse.WithNode:
+			inQuery = escapeBranch(tree, &node.BranchNode, inQuery)
+		}
+	}
+
+	return inQuery
+}
+
+
+// either branch may be the one that's output, the query is reached when either reaches it
+func escapeBranch(tree *parse.Tree, branch *parse.BranchNode, inQuery bool) bool {
+	inList := escapeActions(tree, branch.List, inQuery)
+	inElse := escapeActions(tree, branch.ElseList, inQuery)
+
+	return inList || inElse
+}
+
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("%s %s responded with status %d: %s", e.Method, e.URL, e.StatusCode, strings.TrimSpace(e.Body))
+}
+
+
+// parses a Retry-After header given in seconds, 0 if it is missing or a date
+func retryAfterHeader(value string) time.Duration {
+	seconds, err := strconv.Atoi(value)
+	if err != nil || seconds < 0 {
+		return 0
+	}
+
+	return time.Duration(seconds) * time.Second
+}
@@ -0,0 +1,156 @@
+package fsq
+
+import "fmt"
+import "errors"
+import "context"
+import "bytes"
+import "encoding/json"
+import "os"
+import "os/exec"
+import "strings"
+
+// How a registered action is executed.
+type ExecutionMode int
+
+const (
+	InProcess ExecutionMode = iota  //the action runs in a go routine of the queue's process (default)
+	Subprocess  //the action runs in a child process, see ServeIsolated
+)
+
+// environment variables telling a child process which queue and action to run
+const isolatedQueueEnv = "FSQ_ISOLATED_QUEUE"
+const isolatedActionEnv = "FSQ_ISOLATED_ACTION"
+
+// what a child process writes to stdout when its action returns
+type isolatedResult struct {
+	Error string `json:"error,omitempty"`
+}
+
+
+// - Sets how the action registered under @actionName is executed.
+// - In Subprocess mode the program re-executes itself as a child process for every task, passes the
+// params as JSON over stdin and reads the result from stdout. That way a crash, leak or cgo
+// instability in the action can't take down the host process. The program must call ServeIsolated.
+// - Params are passed as JSON, so they must be serializable, and numbers arrive in the action as float64.
+// - The child process is killed once the task times out, is abandoned or cancelled, or the queue is stopped with StopNow.
+func (q *FixedSizeQueue) SetExecutionMode(actionName string, mode ExecutionMode) {
+	q.changeConfig("", 0, false, fmt.Sprintf("executionMode[%s]", actionName), func() (string, string, error) {
+		oldValue := q.executionModes[actionName].String()
+
+		if q.executionModes == nil {
+			q.executionModes = map[string]ExecutionMode{}
+		}
+
+		q.executionModes[actionName] = mode
+		return oldValue, mode.String(), nil
+	})
+}
+
+
+func (m ExecutionMode) String() string {
+	if m == Subprocess {
+		return "subprocess"
+	}
+
+	return "inProcess"
+}
+
+
+// - Must be called early in main, after the queue's actions are registered, by a program that runs
+// actions in Subprocess mode.
+// - When the process was started by @q to run an isolated action, ServeIsolated runs the action and
+// exits the process. Otherwise it returns immediately.
+func ServeIsolated(q *FixedSizeQueue) {
+	if os.Getenv(isolatedQueueEnv) != q.QualifiedName() {
+		return
+	}
+
+	name := os.Getenv(isolatedActionEnv)
+	if name == "" {
+		return
+	}
+
+	os.Exit(serveIsolatedAction(q, name))
+}
+
+
+// runs the action in the child process, returns the exit code
+func serveIsolatedAction(q *FixedSizeQueue, name string) int {
+	result := isolatedResult{}
+
+	params := map[string]interface{}{}
+	err := json.NewDecoder(os.Stdin).Decode(&params)
+
+	if err == nil {
+		q.mu.Lock()
+		action, ok := q.actions[name]
+		q.mu.Unlock()
+
+		if !ok {
+			err = errors.New(fmt.Sprintf("Action %s is not registered.", name))
+		} else {
+			err = action(context.Background(), params)
+		}
+	}
+
+	if err != nil {
+		result.Error = err.Error()
+	}
+
+	json.NewEncoder(os.Stdout).Encode(result)
+	return 0
+}
+
+
+// - Runs the action registered under @name in a child process.
+// - The child is killed once @ctx is done, e.g. when the task times out, is abandoned or cancelled.
+func (q *FixedSizeQueue) runIsolated(ctx context.Context, name string, params map[string]interface{}) error {
+	input, err := json.Marshal(params)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Params for isolated action %s can't be serialized: %s", name, err))
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, executable)
+	cmd.WaitDelay = execWaitDelay
+	cmd.Env = append(os.Environ(), isolatedQueueEnv + "=" + q.QualifiedName(), isolatedActionEnv + "=" + name)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	runErr := cmd.Run()
+
+	result := isolatedResult{}
+	decodeErr := json.Unmarshal(stdout.Bytes(), &result)
+
+	if runErr != nil || decodeErr != nil {
+		// the child died before reporting a result
+		errMsg := fmt.Sprintf("Isolated action %s did not complete: %v", name, runErr)
+		if runErr == nil {
+			errMsg = fmt.Sprintf("Isolated action %s did not report a result: %v", name, decodeErr)
+		}
+
+		output := strings.TrimSpace(stderr.String())
+		if output != "" {
+			errMsg += "\n" + output
+		}
+
+		// a killed child failed because its task ran out of time or was stopped
+		if ctx.Err() != nil {
+			return errors.Join(ctx.Err(), errors.New(errMsg))
+		}
+
+		return errors.New(errMsg)
+	}
+
+	if result.Error != "" {
+		return errors.New(result.Error)
+	}
+
+	return nil
+}
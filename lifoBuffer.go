@@ -0,0 +1,55 @@
+package fsq
+
+// A bounded LIFO (stack) on top of a ring buffer: the newest item is taken out first. Used by
+// FixedSizeBuffer (see NewLIFOBuffer), and by FixedSizeQueue for the LIFO dispatch order (see SetDispatchOrder).
+type lifoBuffer[T any] struct {
+	*ringBuffer[T]
+}
+
+
+func newLIFOBuffer[T any](size int) *lifoBuffer[T] {
+	return &lifoBuffer[T]{newRingBuffer[T](size)}
+}
+
+
+// Returns the newest item and removes it, the zero value of T if the buffer is empty.
+func (lb *lifoBuffer[T]) Dequeue() T {
+	return lb.DequeueTail()
+}
+
+
+// Returns the newest item without removing it, the zero value of T if the buffer is empty.
+func (lb *lifoBuffer[T]) Peek() T {
+	return lb.ringBuffer.PeekTail()
+}
+
+
+// Returns the oldest item, the one taken out last, without removing it.
+func (lb *lifoBuffer[T]) PeekTail() T {
+	return lb.ringBuffer.Peek()
+}
+
+
+// Removes the first item, from newest to oldest, that @match returns true for, and returns it.
+func (lb *lifoBuffer[T]) Remove(match func(item T) bool) (T, bool) {
+	for i := lb.CurrentSize - 1; i >= 0; i-- {
+		if match((*lb.items)[lb.index(i)]) {
+			return lb.removeAt(i), true
+		}
+	}
+
+	var zero T
+	return zero, false
+}
+
+
+// Returns the items in the order they would be dequeued, newest first.
+func (lb *lifoBuffer[T]) Tasks() []T {
+	items := lb.ringBuffer.Tasks()
+
+	for i, j := 0, len(items) - 1; i < j; i, j = i + 1, j - 1 {
+		items[i], items[j] = items[j], items[i]
+	}
+
+	return items
+}
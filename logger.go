@@ -0,0 +1,61 @@
+package fsq
+
+import "context"
+import "log/slog"
+
+// the logger of queues that weren't given one, see SetLogger
+var discardLogger = slog.New(slog.DiscardHandler)
+
+
+// - Logs the queue's task lifecycle to @logger: when a task starts (debug), completes (info), fails and is
+// retried (warn) or fails for good (error), with the queue's qualified name, the task's external id, action
+// name, attempt, run duration and error. Errors the queue can't return to anyone, e.g. of triggers, warm
+// hooks, result sinks and schedule stores, are logged as errors.
+// - A nil @logger turns logging off, the default. See WithLogger to set the logger when creating the queue.
+func (q *FixedSizeQueue) SetLogger(logger *slog.Logger) {
+	if logger == nil {
+		logger = discardLogger
+	}
+
+	q.logger.Store(logger)
+}
+
+
+// Returns the queue's logger. Safe to call without the lock.
+func (q *FixedSizeQueue) log() *slog.Logger {
+	logger := q.logger.Load()
+	if logger == nil {
+		return discardLogger
+	}
+
+	return logger
+}
+
+
+// Logs @msg about @task at @level, with @err if it isn't nil and @attrs.
+func (q *FixedSizeQueue) logTask(level slog.Level, msg string, task *task, err error, attrs ...slog.Attr) {
+	logger := q.log()
+	if !logger.Enabled(context.Background(), level) {
+		return
+	}
+
+	attrs = append([]slog.Attr{
+		slog.String("queue", q.QualifiedName()),
+		slog.String("id", task.externalId),
+		slog.String("action", task.actionName),
+		slog.Int("attempt", task.attempt),
+	}, attrs...)
+
+	if err != nil {
+		attrs = append(attrs, slog.Any("error", err))
+	}
+
+	logger.LogAttrs(context.Background(), level, msg, attrs...)
+}
+
+
+// Logs an error the queue can't return, @msg says what failed. Safe to call without the lock.
+func (q *FixedSizeQueue) logError(msg string, err error, attrs ...slog.Attr) {
+	attrs = append([]slog.Attr{slog.String("queue", q.QualifiedName()), slog.Any("error", err)}, attrs...)
+	q.log().LogAttrs(context.Background(), slog.LevelError, msg, attrs...)
+}
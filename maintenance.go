@@ -0,0 +1,182 @@
+package fsq
+
+import "fmt"
+import "errors"
+import "strconv"
+import "time"
+
+// how often a running queue with maintenance windows re-checks whether a window has started or ended
+var maintenanceCheckInterval = time.Second
+
+const oneDay = 24 * time.Hour
+
+// A recurring, cron-like window during which a queue stands down, for example during known downstream
+// maintenance. While the window is active, at most MaxProcessing tasks are dispatched at a time.
+type MaintenanceWindow struct {
+	Days []time.Weekday  //days on which the window starts. Empty means every day.
+	Start time.Duration  //offset from midnight at which the window starts, e.g. 2 * time.Hour for 02:00
+	Duration time.Duration  //how long the window lasts. May extend past midnight.
+	MaxProcessing int  //max number of concurrent tasks during the window. 0 pauses dispatching.
+	Location *time.Location  //time zone the window is defined in. Defaults to time.Local.
+}
+
+
+// Returns true if @t falls inside an occurrence of the window.
+func (w MaintenanceWindow) IsActive(t time.Time) bool {
+	loc := w.Location
+	if loc == nil {
+		loc = time.Local
+	}
+
+	t = t.In(loc)
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+
+	// a window that started on a previous day may still be running, so check back as many days as it can span
+	daysBack := int(w.Duration / oneDay) + 1
+
+	for back := 0; back <= daysBack; back++ {
+		startDay := midnight.AddDate(0, 0, -back)
+
+		if !w.startsOn(startDay.Weekday()) {
+			continue
+		}
+
+		// the wall clock time, adding w.Start to midnight is off by an hour on the days DST changes
+		start := wallClock(startDay, w.Start)
+		if !t.Before(start) && t.Before(start.Add(w.Duration)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+
+func (w MaintenanceWindow) startsOn(weekday time.Weekday) bool {
+	if len(w.Days) == 0 {
+		return true
+	}
+
+	for _, d := range w.Days {
+		if d == weekday {
+			return true
+		}
+	}
+
+	return false
+}
+
+
+func (w MaintenanceWindow) validate() error {
+	if w.Duration <= 0 {
+		return errors.New("Maintenance window duration must be greater than 0.")
+	}
+
+	if w.Start < 0 || w.Start >= oneDay {
+		return errors.New(fmt.Sprintf("Maintenance window start %s must be within a single day.", w.Start))
+	}
+
+	if w.MaxProcessing < 0 {
+		return errors.New("Maintenance window MaxProcessing can't be negative.")
+	}
+
+	return nil
+}
+
+
+// Adds a maintenance window to the queue. Windows are checked while the queue is running, when a window
+// ends the waiting tasks held back by it are dispatched automatically.
+func (q *FixedSizeQueue) AddMaintenanceWindow(w MaintenanceWindow) error {
+	_, err := q.changeConfig("", 0, false, "maintenanceWindows", func() (string, string, error) {
+		err := w.validate()
+		if err != nil {
+			return "", "", err
+		}
+
+		oldValue := strconv.Itoa(len(q.maintenanceWindows))
+		q.maintenanceWindows = append(q.maintenanceWindows, w)
+
+		if q.isRunning {
+			q.startMaintenanceCheck()
+		}
+
+		return oldValue, strconv.Itoa(len(q.maintenanceWindows)), nil
+	})
+
+	return err
+}
+
+
+// Removes all maintenance windows and resumes normal dispatching.
+func (q *FixedSizeQueue) ClearMaintenanceWindows() {
+	q.changeConfig("", 0, false, "maintenanceWindows", func() (string, string, error) {
+		oldValue := strconv.Itoa(len(q.maintenanceWindows))
+		q.stopMaintenanceCheck()
+		q.maintenanceWindows = nil
+		q.dispatchWaiting()
+		return oldValue, "0", nil
+	})
+}
+
+
+// Returns true if any maintenance window is currently active.
+func (q *FixedSizeQueue) InMaintenance() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.inMaintenance()
+}
+
+
+func (q *FixedSizeQueue) inMaintenance() bool {
+	now := q.now()
+
+	for _, w := range q.maintenanceWindows {
+		if w.IsActive(now) {
+			return true
+		}
+	}
+
+	return false
+}
+
+
+// Returns the number of tasks allowed to process at once right now, taking active maintenance windows into account.
+func (q *FixedSizeQueue) effectiveMaxProcessing() int {
+	max := q.currentMaxProcessing()
+
+	if len(q.maintenanceWindows) == 0 {
+		return max
+	}
+
+	now := q.now()
+
+	for _, w := range q.maintenanceWindows {
+		if w.MaxProcessing < max && w.IsActive(now) {
+			max = w.MaxProcessing
+		}
+	}
+
+	return max
+}
+
+
+func (q *FixedSizeQueue) startMaintenanceCheck() {
+	if len(q.maintenanceWindows) == 0 || q.maintenanceStop != nil {
+		return
+	}
+
+	q.maintenanceStop = make(chan struct{})
+	// a window may have ended (or allow more processes) since the last check
+	go runTicker(maintenanceCheckInterval, q.maintenanceStop, q.wake)
+}
+
+
+func (q *FixedSizeQueue) stopMaintenanceCheck() {
+	if q.maintenanceStop == nil {
+		return
+	}
+
+	close(q.maintenanceStop)
+	q.maintenanceStop = nil
+}
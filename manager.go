@@ -0,0 +1,96 @@
+package fsq
+
+import "fmt"
+import "errors"
+import "context"
+
+// - Owns several queues and runs their lifecycle together, e.g. the queues of a service that starts them at
+// startup and drains them at shutdown. Unlike a Mux, a Manager doesn't route submissions, tasks are added
+// to its queues directly.
+type Manager struct {
+	queues []*FixedSizeQueue  //in the order they were given
+	byName map[string]*FixedSizeQueue
+}
+
+// The activity counters of every queue of a Manager, with totals.
+type ManagerStats struct {
+	Queues map[string]Stats  //by qualified name
+	Total Stats  //the sums of the queues' counters and load, Epoch and Since are zero
+}
+
+
+// - Returns a manager of @queues.
+// - Queues are identified by their qualified name, which must be unique.
+func NewManager(queues ...*FixedSizeQueue) (*Manager, error) {
+	m := &Manager{byName: map[string]*FixedSizeQueue{}}
+
+	for _, q := range queues {
+		name := q.QualifiedName()
+		if _, ok := m.byName[name]; ok {
+			return nil, errors.New(fmt.Sprintf("Manager already has a FixedSizeQueue named %s.", name))
+		}
+
+		m.byName[name] = q
+		m.queues = append(m.queues, q)
+	}
+
+	return m, nil
+}
+
+
+// Returns the queue with the qualified name @name, false if the manager doesn't have it.
+func (m *Manager) Queue(name string) (*FixedSizeQueue, bool) {
+	q, ok := m.byName[name]
+	return q, ok
+}
+
+
+// Returns the manager's queues, in the order they were given.
+func (m *Manager) Queues() []*FixedSizeQueue {
+	return append([]*FixedSizeQueue{}, m.queues...)
+}
+
+
+// Starts every queue of the manager.
+func (m *Manager) StartAll() {
+	for _, q := range m.queues {
+		q.Start()
+	}
+}
+
+
+// Stops every queue of the manager, see FixedSizeQueue.Stop.
+func (m *Manager) StopAll() {
+	for _, q := range m.queues {
+		q.Stop()
+	}
+}
+
+
+// - Stops every queue of the manager gracefully, see FixedSizeQueue.StopAndDrain. Adds to all of them are
+// rejected right away, then the queues drain at the same time until @ctx is done.
+// - Returns nil if every queue drained, otherwise the *DrainError of each queue that didn't, joined (see errors.Join).
+func (m *Manager) DrainAll(ctx context.Context) error {
+	errs := []error{}
+	for _, report := range shutdownAll(ctx, m.queues) {
+		errs = append(errs, report.Err)
+	}
+
+	return errors.Join(errs...)
+}
+
+
+// - Returns the activity counters of every queue of the manager, with totals.
+// - Each queue's counters are read on their own, so those of different queues may be from slightly different times.
+func (m *Manager) Stats() ManagerStats {
+	stats := ManagerStats{Queues: map[string]Stats{}}
+
+	for _, q := range m.queues {
+		s := q.Stats()
+		stats.Queues[q.QualifiedName()] = s
+		stats.Total.add(s)
+	}
+
+	return stats
+}
+
@@ -0,0 +1,74 @@
+package fsq
+
+import "errors"
+import "time"
+
+// The error of the EventFailed event of a task that was dropped because it was older than the max task age.
+var ErrTaskTooOld = errors.New("Task is older than the queue's max task age.")
+
+
+// - Limits how long a task may live from being added to finishing, waiting and processing together, so
+// work that stopped being relevant isn't run long after it was added. Tasks past @age end with ReasonMaxAge.
+// - A waiting task past @age is dropped when its turn comes instead of being dispatched, with an EventFailed
+// event whose error is ErrTaskTooOld. A processing task past @age has its context cancelled and is
+// abandoned once the abandon grace period passed (see SetAbandonGrace), with an EventAbandoned event.
+// - An @age <= 0 removes the limit (the default). Processing tasks keep the limit they were dispatched with.
+func (q *FixedSizeQueue) SetMaxTaskAge(age time.Duration) {
+	if age < 0 {
+		age = 0
+	}
+
+	q.changeConfig("", 0, false, "maxTaskAge", func() (string, string, error) {
+		oldValue := q.maxTaskAge.String()
+		q.maxTaskAge = age
+		return oldValue, q.maxTaskAge.String(), nil
+	})
+}
+
+
+// Called with the next waiting task, drops it if it is older than the max task age. Returns true if the task was dropped.
+func (q *FixedSizeQueue) dropIfTooOld(next *task) bool {
+	if q.maxTaskAge <= 0 {
+		return false
+	}
+
+	age := q.now().Sub(next.enqueuedAt)
+	if age <= q.maxTaskAge {
+		return false
+	}
+
+	q.removeNextWaiting()
+	q.forgetWaiting(next)
+	q.stats.Dropped++
+	q.trace(next.externalId, "dropped", "waited %s, past the max task age of %s", age, q.maxTaskAge)
+
+	event := q.taskEvent(next, ErrTaskTooOld)
+	event.Reason = ReasonMaxAge
+	q.publish(event)
+	next.resolve(event)
+
+	if q.results != nil {
+		q.results.push(event)
+	}
+
+	// the task's action is never called, and the lock is held
+	if done := next.completion(ErrTaskTooOld); done != nil {
+		go done()
+	}
+
+	next.releasePayload()
+	next.Clean()
+	*q.readyTaskPool = append(*q.readyTaskPool, next)
+	q.epoch++
+	return true
+}
+
+
+// Returns how long the dispatched @task has left before it is past the max task age, false if there is no limit.
+func (q *FixedSizeQueue) ageLeft(task *task) (time.Duration, bool) {
+	if q.maxTaskAge <= 0 {
+		return 0, false
+	}
+
+	return q.maxTaskAge - q.now().Sub(task.enqueuedAt), true
+}
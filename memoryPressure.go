@@ -0,0 +1,177 @@
+package fsq
+
+import "fmt"
+import "errors"
+import "runtime"
+import "strconv"
+import "time"
+
+// used when a memory limit is set with an interval <= 0
+const defaultMemoryCheckInterval = time.Second
+
+type memoryGuard struct {
+	threshold uint64
+	gauge func() uint64
+	interval time.Duration
+	underPressure bool
+	rejected int
+	stop chan struct{}  //closed to end the polling go routine, nil when not polling
+}
+
+
+// - Throttles the queue while memory use is above @threshold bytes. While under pressure, Add rejects new
+// tasks and waiting tasks are held back, both resume once memory use drops back under the threshold.
+// - @gauge: returns the current memory use in bytes. If nil, the heap size from runtime.ReadMemStats is used.
+// - @interval: how often the gauge is read while the queue is running.
+func (q *FixedSizeQueue) SetMemoryLimit(threshold uint64, gauge func() uint64, interval time.Duration) {
+	if gauge == nil {
+		gauge = heapAlloc
+	}
+
+	if interval <= 0 {
+		interval = defaultMemoryCheckInterval
+	}
+
+	// the gauge may be slow, so it isn't read while holding the lock
+	underPressure := gauge() > threshold
+
+	q.changeConfig("", 0, false, "memoryLimit", func() (string, string, error) {
+		oldValue := q.memoryLimit()
+		q.clearMemoryLimit()
+
+		q.memory = &memoryGuard{
+			threshold: threshold,
+			gauge: gauge,
+			interval: interval,
+			underPressure: underPressure,
+		}
+
+		if q.isRunning {
+			q.startMemoryCheck()
+		}
+
+		return oldValue, q.memoryLimit(), nil
+	})
+}
+
+
+// Removes the memory limit (if any) and resumes dispatching of waiting tasks.
+func (q *FixedSizeQueue) ClearMemoryLimit() {
+	q.changeConfig("", 0, false, "memoryLimit", func() (string, string, error) {
+		if q.memory == nil {
+			// nothing to record in the audit log
+			return "", "", errors.New("No memory limit is set.")
+		}
+
+		oldValue := q.memoryLimit()
+		q.clearMemoryLimit()
+		return oldValue, "", nil
+	})
+}
+
+
+func (q *FixedSizeQueue) clearMemoryLimit() {
+	if q.memory == nil {
+		return
+	}
+
+	q.stopMemoryCheck()
+	q.memory = nil
+	q.dispatchWaiting()
+}
+
+
+// formats the memory limit for the audit log, empty when there is none
+func (q *FixedSizeQueue) memoryLimit() string {
+	if q.memory == nil {
+		return ""
+	}
+
+	return strconv.FormatUint(q.memory.threshold, 10)
+}
+
+
+// Returns true if memory use was above the limit when it was last checked.
+func (q *FixedSizeQueue) UnderMemoryPressure() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.underMemoryPressure()
+}
+
+
+func (q *FixedSizeQueue) underMemoryPressure() bool {
+	return q.memory != nil && q.memory.underPressure
+}
+
+
+// Returns the number of Adds rejected because of memory pressure since the limit was set.
+func (q *FixedSizeQueue) MemoryRejections() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.memory == nil {
+		return 0
+	}
+
+	return q.memory.rejected
+}
+
+
+func heapAlloc() uint64 {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	return stats.HeapAlloc
+}
+
+
+// Called by Add, returns an error if new tasks can't be admitted because of memory pressure.
+func (q *FixedSizeQueue) admitMemory() error {
+	if !q.underMemoryPressure() {
+		return nil
+	}
+
+	q.memory.rejected++
+	errMsg := fmt.Sprintf("FixedSizeQueue %s is under memory pressure. Try later.", q.QualifiedName())
+	return errors.New(errMsg)
+}
+
+
+func (q *FixedSizeQueue) startMemoryCheck() {
+	if q.memory == nil || q.memory.stop != nil {
+		return
+	}
+
+	guard := q.memory
+	guard.stop = make(chan struct{})
+	go runTicker(guard.interval, guard.stop, func() {
+		q.checkMemory(guard)
+	})
+}
+
+
+func (q *FixedSizeQueue) stopMemoryCheck() {
+	if q.memory == nil || q.memory.stop == nil {
+		return
+	}
+
+	close(q.memory.stop)
+	q.memory.stop = nil
+}
+
+
+// Runs in the guard's go routine, takes the lock once the gauge was read.
+func (q *FixedSizeQueue) checkMemory(guard *memoryGuard) {
+	underPressure := guard.gauge() > guard.threshold
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	wasUnderPressure := guard.underPressure
+	guard.underPressure = underPressure
+
+	// when the pressure subsides, start the waiting tasks that were held back
+	if wasUnderPressure && !guard.underPressure {
+		q.dispatchWaiting()
+	}
+}
@@ -0,0 +1,120 @@
+package fsq
+
+import "fmt"
+import "errors"
+
+
+// - Moves the waiting task with @externalId from the queue named @from to the queue named @to, e.g. from
+// a backlogged queue to a spare one. Both are qualified names of queues of the mux.
+// - The task keeps its action, params, cost, tenant, action name, deadline and enqueue time. It goes to the
+// back of @to, as if it had been added there, and is rejected the same way: if @to isn't running, is
+// frozen or full, already has a waiting task with the id, or the cost doesn't fit its budgets.
+// - Both queues are locked for the move, so the task is in exactly one of them at any time and the id
+// can't be added to either in between. Tasks that are processing can't be moved.
+func (m *Mux) Move(externalId string, from string, to string) error {
+	source, ok := m.byName[from]
+	if !ok {
+		return errors.New(fmt.Sprintf("Mux has no FixedSizeQueue named %s.", from))
+	}
+
+	target, ok := m.byName[to]
+	if !ok {
+		return errors.New(fmt.Sprintf("Mux has no FixedSizeQueue named %s.", to))
+	}
+
+	if source == target {
+		return nil
+	}
+
+	// always lock in name order, so concurrent moves between the same queues can't deadlock
+	first, second := source, target
+	if to < from {
+		first, second = target, source
+	}
+
+	first.mu.Lock()
+	defer first.mu.Unlock()
+	second.mu.Lock()
+	defer second.mu.Unlock()
+
+	err := source.admitFrozen()
+	if err != nil {
+		return err
+	}
+
+	task, ok := source.waitingTasksByExternalId[externalId]
+	if !ok {
+		return errors.New(fmt.Sprintf("FixedSizeQueue %s has no waiting task with id %s.", from, externalId))
+	}
+
+	err = target.enqueue(task.action, task.params, task.externalId, addOptions{
+		cost: task.cost,
+		tenant: task.tenant,
+		actionName: task.actionName,
+		byName: task.byName,
+		ctxAction: task.ctxAction,
+		deadline: task.deadline,
+		enqueuedAt: task.enqueuedAt,
+		release: task.release,
+		onSuccess: task.onSuccess,
+		onFailure: task.onFailure,
+		handle: task.handle,
+		priority: task.priority,
+		notBefore: task.notBefore,
+		timeout: task.timeout,
+		maxAttempts: task.maxAttempts,
+		traceParent: task.traceParent,
+	})
+	if err != nil {
+		return err
+	}
+
+	source.trace(externalId, "moved", "to %s", to)
+	source.removeWaiting(task)
+	return nil
+}
+
+
+// Takes @waitingTask out of the queue, wherever it waits, and returns it to the pool. Expects the lock to be held.
+// Its payload isn't released, see task.releasePayload.
+func (q *FixedSizeQueue) removeWaiting(waitingTask *task) {
+	q.forgetWaiting(waitingTask)
+
+	_, found := q.items.Remove(func(item *task) bool { return item == waitingTask })
+	if found {
+		q.refillFromOverflow()
+	} else {
+		found = q.removeOverflow(waitingTask) || q.removeDelayed(waitingTask)
+	}
+
+	for i := 0; !found && i < len(q.unparked); i++ {
+		if q.unparked[i] == waitingTask {
+			q.unparked = append(q.unparked[:i], q.unparked[i + 1:]...)
+			found = true
+		}
+	}
+
+	for _, byKey := range []map[string][]*task{q.parked, q.overBudget} {
+		for key, tasks := range byKey {
+			for i := 0; !found && i < len(tasks); i++ {
+				if tasks[i] == waitingTask {
+					byKey[key] = append(tasks[:i], tasks[i + 1:]...)
+					found = true
+
+					if len(byKey[key]) == 0 {
+						delete(byKey, key)
+					}
+				}
+			}
+		}
+	}
+
+	waitingTask.Clean()
+	*q.readyTaskPool = append(*q.readyTaskPool, waitingTask)
+	q.epoch++
+	q.notifyRoom()
+
+	// a slot opened in the buffer
+	q.dispatchWaiting()
+	q.notifyIdle()
+}
@@ -0,0 +1,191 @@
+package fsq
+
+import "fmt"
+import "errors"
+import "context"
+
+// What a Mux knows about a submission when routing it.
+type Route struct {
+	ExternalId string
+	ActionName string  //empty for tasks added with Add
+	Tenant string
+	Cost int  //the task's size in budget units, 0 when added without a cost
+	Params map[string]interface{}
+}
+
+// Returns the qualified name (see FixedSizeQueue.QualifiedName) of the queue a submission goes to.
+type RouteFunc func(r Route) string
+
+// - Presents several queues behind one Add, e.g. a queue per action or per tenant, or shards of one
+// workload. Every submission is routed to one of the queues by a RouteFunc.
+// - The queues keep working on their own, a Mux only routes submissions and offers lifecycle control and
+// views over all of them.
+type Mux struct {
+	queues []*FixedSizeQueue  //in the order they were given
+	byName map[string]*FixedSizeQueue
+	route RouteFunc
+}
+
+// A point in time view of every queue of a Mux, with totals.
+type MuxView struct {
+	Queues []QueueView  //in the order the queues were given to the mux
+	Capacity int
+	Waiting int
+	Processing int
+	Parked int
+}
+
+
+// - Returns a mux routing submissions to @queues with @route.
+// - Queues are identified by their qualified name, which must be unique.
+func NewMux(route RouteFunc, queues ...*FixedSizeQueue) (*Mux, error) {
+	if route == nil {
+		return nil, errors.New("Mux route func can't be nil.")
+	}
+
+	if len(queues) == 0 {
+		return nil, errors.New("Mux needs at least one queue.")
+	}
+
+	m := &Mux{
+		byName: map[string]*FixedSizeQueue{},
+		route: route,
+	}
+
+	for _, q := range queues {
+		name := q.QualifiedName()
+		if _, ok := m.byName[name]; ok {
+			return nil, errors.New(fmt.Sprintf("Mux already has a FixedSizeQueue named %s.", name))
+		}
+
+		m.byName[name] = q
+		m.queues = append(m.queues, q)
+	}
+
+	return m, nil
+}
+
+
+// Returns a route func sending tasks to the queue named after their action name, and tasks added without
+// one to @fallback.
+func RouteByActionName(fallback string) RouteFunc {
+	return func(r Route) string {
+		if r.ActionName == "" {
+			return fallback
+		}
+
+		return r.ActionName
+	}
+}
+
+
+// Returns a route func sending tasks to the queue named after their tenant, and tasks added without one
+// to @fallback.
+func RouteByTenant(fallback string) RouteFunc {
+	return func(r Route) string {
+		if r.Tenant == "" {
+			return fallback
+		}
+
+		return r.Tenant
+	}
+}
+
+
+// Returns the queue with the qualified name @name, false if the mux doesn't have it.
+func (m *Mux) Queue(name string) (*FixedSizeQueue, bool) {
+	q, ok := m.byName[name]
+	return q, ok
+}
+
+
+// Returns the mux's queues, in the order they were given.
+func (m *Mux) Queues() []*FixedSizeQueue {
+	return append([]*FixedSizeQueue{}, m.queues...)
+}
+
+
+// Same as FixedSizeQueue.Add, on the queue the task is routed to.
+func (m *Mux) Add(action func(params map[string]interface{}) error, params map[string]interface{}, id string, opts ...AddOption) error {
+	options, err := applyAddOptions(opts)
+	if err != nil {
+		return err
+	}
+
+	return m.add(action, params, id, options)
+}
+
+
+// Same as FixedSizeQueue.AddNamed, on the queue the task is routed to.
+func (m *Mux) AddNamed(actionName string, action func(params map[string]interface{}) error, params map[string]interface{}, id string) error {
+	return m.add(action, params, id, addOptions{actionName: actionName})
+}
+
+
+// Same as FixedSizeQueue.AddWithCost, on the queue the task is routed to.
+func (m *Mux) AddWithCost(action func(params map[string]interface{}) error, params map[string]interface{}, id string, cost int, tenant string) error {
+	return m.Add(action, params, id, WithCost(cost), WithTenant(tenant))
+}
+
+
+func (m *Mux) add(action func(params map[string]interface{}) error, params map[string]interface{}, id string, opts addOptions) error {
+	q, err := m.routeTo(Route{
+		ExternalId: id,
+		ActionName: opts.actionName,
+		Tenant: opts.tenant,
+		Cost: opts.cost,
+		Params: params,
+	})
+	if err != nil {
+		return err
+	}
+
+	return q.add(context.Background(), action, params, id, opts)
+}
+
+
+// returns the queue @r is routed to
+func (m *Mux) routeTo(r Route) (*FixedSizeQueue, error) {
+	name := m.route(r)
+
+	q, ok := m.byName[name]
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("Task %s is routed to FixedSizeQueue %s, which the mux doesn't have.", r.ExternalId, name))
+	}
+
+	return q, nil
+}
+
+
+// Starts every queue of the mux.
+func (m *Mux) Start() {
+	for _, q := range m.queues {
+		q.Start()
+	}
+}
+
+
+// Stops every queue of the mux.
+func (m *Mux) Stop() {
+	for _, q := range m.queues {
+		q.Stop()
+	}
+}
+
+
+// - Returns a view of every queue of the mux, with totals.
+// - Each queue's view is taken on its own, so views of different queues may be from slightly different times.
+func (m *Mux) SnapshotView() MuxView {
+	view := MuxView{Queues: []QueueView{}}
+
+	for _, q := range m.queues {
+		qv := q.SnapshotView()
+		view.Queues = append(view.Queues, qv)
+		view.Capacity += qv.Capacity
+		view.Waiting += len(qv.Waiting)
+		view.Processing += len(qv.Processing)
+		view.Parked += len(qv.Parked)
+	}
+
+	return view
+}
@@ -0,0 +1,74 @@
+package fsq
+
+import "fmt"
+import "errors"
+import "strings"
+
+// separates the namespace from the queue name in a qualified name
+const namespaceSeparator = ":"
+
+
+// - Sets a namespace for the queue, e.g. the name of the service or team that owns it. When many queues run
+// in one process (or many processes report to the same backend), the namespace keeps their names from
+// colliding in errors, the admin API, metrics, logs and events.
+// - Set the namespace before the queue is shared, e.g. right after Init, since it changes the queue's
+// qualified name.
+// - Returns an error if @namespace is empty or contains the separator ":".
+func (q *FixedSizeQueue) SetNamespace(namespace string) error {
+	err := validateNamespace(namespace)
+	if err != nil {
+		return err
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.namespace.Store(&namespace)
+	return nil
+}
+
+
+// Returns the namespace of the queue, empty if none was set.
+func (q *FixedSizeQueue) Namespace() string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.currentNamespace()
+}
+
+
+// - Returns the name the queue is identified by in errors, the admin API, metrics, logs and events:
+// "namespace:name", or just the name when no namespace is set.
+// - Safe to call with or without the lock, so that errors and logs can name the queue wherever they happen.
+func (q *FixedSizeQueue) QualifiedName() string {
+	namespace := q.currentNamespace()
+	if namespace == "" {
+		return q.Name
+	}
+
+	return namespace + namespaceSeparator + q.Name
+}
+
+
+// Returns the namespace of the queue, empty if none was set. Safe to call without the lock.
+func (q *FixedSizeQueue) currentNamespace() string {
+	namespace := q.namespace.Load()
+	if namespace == nil {
+		return ""
+	}
+
+	return *namespace
+}
+
+
+func validateNamespace(namespace string) error {
+	if strings.TrimSpace(namespace) == "" {
+		return errors.New("Namespace is not valid, only uses space characters.")
+	}
+
+	if strings.Contains(namespace, namespaceSeparator) {
+		return errors.New(fmt.Sprintf("Namespace %s can't contain %q.", namespace, namespaceSeparator))
+	}
+
+	return nil
+}
@@ -0,0 +1,14 @@
+package fsq
+
+
+// - Sets a handler called whenever a task's action returns an error, with the task's external id, so failures
+// can be alerted on or recorded in one place instead of in every action. Runs that are retried (see
+// SetRetryPolicy) call it too, as do actions of abandoned tasks returning late.
+// - The handler is called from the task's go routine once the task was settled, without holding the queue's
+// lock, so it may use the queue. A nil @handler removes it.
+func (q *FixedSizeQueue) OnError(handler func(taskID string, err error)) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.onError = handler
+}
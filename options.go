@@ -0,0 +1,144 @@
+package fsq
+
+import "fmt"
+import "errors"
+import "log/slog"
+import "strings"
+
+// The size of a queue created by New without WithSize.
+const DefaultSize = 100
+
+// The max processing of a queue created by New without WithMaxProcessing.
+const DefaultMaxProcessing = 1
+
+// Configures a queue created by New.
+type Option func(o *options) error
+
+// what New builds the queue from
+type options struct {
+	size int
+	maxProcessing int
+	namespace string
+	clock Clock
+	logger *slog.Logger
+	preallocate bool
+}
+
+
+// - Creates a queue named @name, configured by @opts, e.g. New("emails", WithSize(500), WithMaxProcessing(8)).
+// Without options the queue holds DefaultSize waiting tasks and runs DefaultMaxProcessing at a time.
+// - Unlike Init, invalid settings aren't replaced by defaults: the first invalid option is returned as an error.
+// - As with Init, the queue has to be started before tasks are added.
+func New(name string, opts ...Option) (*FixedSizeQueue, error) {
+	if strings.TrimSpace(name) == "" {
+		return nil, errors.New("Queue name is not valid, only uses space characters.")
+	}
+
+	// the separator would make qualified names ambiguous
+	if strings.Contains(name, namespaceSeparator) {
+		return nil, errors.New(fmt.Sprintf("Queue name %s can't contain %q.", name, namespaceSeparator))
+	}
+
+	o := options{
+		size: DefaultSize,
+		maxProcessing: DefaultMaxProcessing,
+		clock: realClock{},
+	}
+
+	for _, opt := range opts {
+		err := opt(&o)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("FixedSizeQueue %s can't be created: %s", name, err))
+		}
+	}
+
+	q := newQueue(o.size, name, o.maxProcessing, o.clock)
+	if o.namespace != "" {
+		q.namespace.Store(&o.namespace)
+	}
+
+	if o.logger != nil {
+		q.SetLogger(o.logger)
+	}
+
+	if o.preallocate {
+		q.preallocate()
+	}
+
+	return q, nil
+}
+
+
+// Sets the max number of waiting tasks, see Resize. @size must be greater than 0.
+func WithSize(size int) Option {
+	return func(o *options) error {
+		if size <= 0 {
+			return errors.New("Queue size must be greater than 0.")
+		}
+
+		o.size = size
+		return nil
+	}
+}
+
+
+// Sets the max number of tasks processing at once, see SetMaxProcessing. @maxProcessing must be greater than 0.
+func WithMaxProcessing(maxProcessing int) Option {
+	return func(o *options) error {
+		if maxProcessing <= 0 {
+			return errors.New("Max processing must be greater than 0.")
+		}
+
+		o.maxProcessing = maxProcessing
+		return nil
+	}
+}
+
+
+// Sets the queue's namespace, see SetNamespace.
+func WithNamespace(namespace string) Option {
+	return func(o *options) error {
+		err := validateNamespace(namespace)
+		if err != nil {
+			return err
+		}
+
+		o.namespace = namespace
+		return nil
+	}
+}
+
+
+// Sets the time source of the queue, see SetClock. @clock must not be nil.
+func WithClock(clock Clock) Option {
+	return func(o *options) error {
+		if clock == nil {
+			return errors.New("Clock can't be nil.")
+		}
+
+		o.clock = clock
+		return nil
+	}
+}
+
+
+// Logs the queue's task lifecycle and errors to @logger, see SetLogger. @logger must not be nil.
+func WithLogger(logger *slog.Logger) Option {
+	return func(o *options) error {
+		if logger == nil {
+			return errors.New("Logger can't be nil.")
+		}
+
+		o.logger = logger
+		return nil
+	}
+}
+
+
+// Creates every task up front, see InitPreallocated.
+func WithPreallocation() Option {
+	return func(o *options) error {
+		o.preallocate = true
+		return nil
+	}
+}
@@ -0,0 +1,212 @@
+package fsq
+
+import "fmt"
+import "errors"
+import "strconv"
+
+// The order in which a queue dispatches its waiting tasks, see SetDispatchOrder.
+type DispatchOrder int
+
+const (
+	FIFO DispatchOrder = iota  //oldest task first, the default
+	LIFO  //newest task first
+	Random  //a randomly picked task
+)
+
+// - Sets strict FIFO mode, for users who need tasks started in the order they were added even at the
+// cost of throughput.
+// - By default, waiting tasks are dispatched in the order they were added with these exceptions:
+//   - A task that can't be dispatched because its registered action was removed (see UnregisterAction),
+//     is paused (see SetRateLimitPause) or its budget is spent (see SetBudget) is parked, and later tasks
+//     overtake it. Parked tasks go ahead of all other waiting tasks once they are released.
+//   - Dispatched tasks run in their own go routines, so with MaxProcessing > 1 their actions may be
+//     called in a different order than they were dispatched in.
+// - In strict FIFO mode:
+//   - A task that can't be dispatched holds back every task added after it, nothing is parked.
+//   - A task's action is only called after the action of the task dispatched before it was called, so
+//     actions are called in the order the tasks were added. Actions still run concurrently, they only
+//     start in order. Completion order is only guaranteed with MaxProcessing of 1.
+func (q *FixedSizeQueue) SetStrictFIFO(strict bool) {
+	q.changeConfig("", 0, false, "strictFIFO", func() (string, string, error) {
+		oldValue := strconv.FormatBool(q.strictFIFO)
+		q.strictFIFO = strict
+		if !strict {
+			q.lastStarted = nil
+		}
+		q.relevel()
+
+		// tasks held at the head of the queue may be parked now
+		q.dispatchWaiting()
+		return oldValue, strconv.FormatBool(strict), nil
+	})
+}
+
+
+// Returns true if the queue is in strict FIFO mode, see SetStrictFIFO.
+func (q *FixedSizeQueue) IsStrictFIFO() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.strictFIFO
+}
+
+
+// Called when @task is dispatched, makes its action wait for the action of the task dispatched before it
+// in strict FIFO mode.
+func (q *FixedSizeQueue) orderStart(task *task) {
+	if !q.strictFIFO {
+		return
+	}
+
+	started := make(chan struct{})
+	task.SetTurn(q.lastStarted, started)
+	q.lastStarted = started
+}
+
+
+func (o DispatchOrder) String() string {
+	switch o {
+	case FIFO:
+		return "FIFO"
+	case LIFO:
+		return "LIFO"
+	case Random:
+		return "Random"
+	}
+
+	return fmt.Sprintf("DispatchOrder(%d)", int(o))
+}
+
+
+// - Sets the order in which waiting tasks are dispatched. LIFO dispatches the newest task first, for
+// workloads where the newest work is the most valuable, e.g. refreshing the latest state. Under steady
+// overload, LIFO may leave old tasks waiting indefinitely.
+// - Random dispatches a randomly picked waiting task, so a burst of related tasks from a producer (e.g.
+// for the same cache key) is spread out instead of hitting the downstream in lockstep. Queue views list
+// the waiting tasks by priority, in FIFO order within a priority then.
+// - Tasks released from parking still go first, and strict FIFO mode (see SetStrictFIFO) dispatches in
+// FIFO order whatever the order is set to.
+func (q *FixedSizeQueue) SetDispatchOrder(order DispatchOrder) error {
+	_, err := q.changeConfig("", 0, false, "dispatchOrder", func() (string, string, error) {
+		if order != FIFO && order != LIFO && order != Random {
+			return "", "", errors.New(fmt.Sprintf("Dispatch order %s is not valid.", order))
+		}
+
+		oldValue := q.dispatchOrder
+		q.dispatchOrder = order
+		q.orderItems()
+		return oldValue.String(), order.String(), nil
+	})
+
+	return err
+}
+
+
+// Sets the buffer the queue dispatches through: a priority buffer over the levels (see levelOf), taking from
+// each level in the dispatch order. A dispatch strategy picks from the PriorityNormal level instead, see pick.
+func (q *FixedSizeQueue) orderItems() {
+	levels := make([]buffer[*task], 0, len(q.levels))
+	for _, level := range q.levels {
+		switch {
+		case q.strictFIFO || q.dispatchOrder == FIFO:
+			levels = append(levels, level)
+		case q.dispatchOrder == LIFO:
+			levels = append(levels, &lifoBuffer[*task]{level})
+		default:
+			levels = append(levels, newRandomBuffer(level))
+		}
+	}
+
+	q.items = priorityBufferOf(levels, q.levelOf)
+}
+
+
+// returns true if the waiting task dispatched next is picked by a dispatch strategy
+func (q *FixedSizeQueue) picksByStrategy() bool {
+	return q.strategy != nil && !q.strictFIFO
+}
+
+
+// Picks the waiting task that is dispatched next with the queue's strategy, removeNextWaiting removes it
+// unless another one is picked first.
+func (q *FixedSizeQueue) pick() *task {
+	if q.items.Len() == 0 {
+		return nil
+	}
+
+	// without priorities, every task waits in the PriorityNormal level
+	waiting := q.levels[normalLevel].Tasks()
+	views := make([]TaskView, 0, len(waiting))
+	for _, task := range waiting {
+		views = append(views, q.taskView(task))
+	}
+
+	q.picked = q.strategy.Next(views)
+	if q.picked < 0 || q.picked >= len(waiting) {
+		q.picked = 0
+	}
+
+	return waiting[q.picked]
+}
+
+
+// removes the task picked by pick
+func (q *FixedSizeQueue) removePicked() *task {
+	if q.picked >= q.items.Len() {
+		q.pick()
+	}
+
+	return q.levels[normalLevel].removeAt(q.picked)
+}
+
+
+// - Picks which waiting task a queue dispatches next, for custom orders such as business priority scores
+// (see SetDispatchStrategy).
+// - Next is given the waiting tasks oldest first, and returns the index of the one to dispatch. An index
+// out of range dispatches the oldest task.
+// - Next is called while holding the queue's lock, once for every dispatch, and must not call the queue.
+type DispatchStrategy interface {
+	Next(waiting []TaskView) int
+}
+
+// Lets an ordinary function be used as a DispatchStrategy.
+type DispatchStrategyFunc func(waiting []TaskView) int
+
+
+func (f DispatchStrategyFunc) Next(waiting []TaskView) int {
+	return f(waiting)
+}
+
+
+// - Sets a custom strategy picking the waiting task that is dispatched next, which overrides the dispatch
+// order (see SetDispatchOrder). A nil @strategy goes back to the dispatch order.
+// - Tasks released from parking still go first, and strict FIFO mode (see SetStrictFIFO) dispatches in
+// FIFO order whatever the strategy.
+// - Building the views the strategy picks from costs O(n) in the number of waiting tasks per dispatch.
+// Queue views list the waiting tasks in FIFO order while a strategy is set.
+func (q *FixedSizeQueue) SetDispatchStrategy(strategy DispatchStrategy) {
+	q.changeConfig("", 0, false, "dispatchStrategy", func() (string, string, error) {
+		oldValue := strategyName(q.strategy)
+		q.strategy = strategy
+		q.relevel()
+		return oldValue, strategyName(strategy), nil
+	})
+}
+
+
+// names @strategy for the audit log, empty when there is none
+func strategyName(strategy DispatchStrategy) string {
+	if strategy == nil {
+		return ""
+	}
+
+	return fmt.Sprintf("%T", strategy)
+}
+
+
+// Returns the waiting tasks in the order they would be dispatched, parked tasks aside. Delayed tasks come
+// last, soonest first.
+func (q *FixedSizeQueue) waitingInOrder() []*task {
+	waiting := append([]*task{}, q.unparked...)
+	return append(append(append(waiting, q.items.Tasks()...), q.overflow...), q.delayed...)
+}
@@ -0,0 +1,37 @@
+package fsq
+
+import "fmt"
+import "errors"
+
+// - The error of a task whose action panicked, the task ends with ReasonPanicked. The queue recovers the panic
+// so it doesn't take the process down, and frees the task's processing slot like for any other failure.
+// - The task's error wraps this one with the value the action panicked with. Panics are Permanent (see ClassOf),
+// the task isn't retried.
+var ErrActionPanicked = errors.New("Task's action panicked.")
+
+// the error of an action that panicked with value
+type panicError struct {
+	value interface{}
+}
+
+
+func (e *panicError) Error() string {
+	return fmt.Sprintf("Task's action panicked: %v", e.value)
+}
+
+
+func (e *panicError) Is(target error) bool {
+	return target == ErrActionPanicked
+}
+
+
+// Runs in the task's go routine, calls @call and turns a panic into the task's error.
+func recoverPanic(call func() error) (err error) {
+	defer func() {
+		if value := recover(); value != nil {
+			err = Permanent(&panicError{value: value})
+		}
+	}()
+
+	return call()
+}
@@ -0,0 +1,127 @@
+package fsq
+
+import "fmt"
+import "errors"
+import "os"
+import "strconv"
+import "strings"
+import "text/template"
+import "time"
+
+// What a param template can refer to as .Task, next to the task's params.
+type TemplateTask struct {
+	ExternalId string
+	ActionName string
+	Queue string  //the qualified name of the queue
+	Now time.Time  //when the task was dispatched
+}
+
+
+// - When enabled, string params are expanded as text/templates when the task is dispatched, so tasks that
+// are added ahead of time can carry dynamic values, e.g. "report-{{.Task.Now.Format \"2006-01-02\"}}.csv".
+// - A template can refer to the task's params by name ({{.id}}), to .Task (see TemplateTask) and to the
+// environment variables allowed by SetTemplateEnv as .Env ({{.Env.REGION}}). "Task" and "Env" hide params of
+// the same name.
+// - Only top level string params are expanded. A template that can't be expanded, e.g. because it refers
+// to a missing key, fails the task with a Permanent error.
+// - Neither the caller's params map nor the task's params are changed, every run of the action gets a copy with
+// the values expanded afresh. Retries (see SetRetryPolicy) and dead letters keep the templates.
+func (q *FixedSizeQueue) SetParamTemplates(enabled bool) {
+	q.changeConfig("", 0, false, "paramTemplates", func() (string, string, error) {
+		oldValue := strconv.FormatBool(q.paramTemplates)
+		q.paramTemplates = enabled
+		return oldValue, strconv.FormatBool(enabled), nil
+	})
+}
+
+
+// - Allows param templates to read the environment variables @names as .Env, see SetParamTemplates. Templates
+// can't read any other variable, since params may come from producers that shouldn't see the process' secrets.
+// - Replaces the names allowed before, no names (the default) leaves .Env empty.
+func (q *FixedSizeQueue) SetTemplateEnv(names ...string) {
+	q.changeConfig("", 0, false, "templateEnv", func() (string, string, error) {
+		oldValue := strings.Join(q.templateEnv, ",")
+		q.templateEnv = append([]string{}, names...)
+		return oldValue, strings.Join(q.templateEnv, ","), nil
+	})
+}
+
+
+// Runs in the task's go routine. Sets the params of the task's run to a copy where templates are expanded.
+func (q *FixedSizeQueue) expandParams(task *task) error {
+	q.mu.Lock()
+	enabled := q.paramTemplates
+	meta := TemplateTask{
+		ExternalId: task.externalId,
+		ActionName: task.actionName,
+		Queue: q.QualifiedName(),
+		Now: q.now(),
+	}
+	params := make(map[string]interface{}, len(task.params))
+	for key, value := range task.params {
+		params[key] = value
+	}
+	names := q.templateEnv
+	q.mu.Unlock()
+
+	if !enabled {
+		return nil
+	}
+
+	data := make(map[string]interface{}, len(params) + 2)
+	for key, value := range params {
+		data[key] = value
+	}
+	data["Task"] = meta
+	data["Env"] = environ(names)
+
+	for key, value := range params {
+		text, ok := value.(string)
+		if !ok || !strings.Contains(text, "{{") {
+			continue
+		}
+
+		result, err := expandTemplate(text, data)
+		if err != nil {
+			return Permanent(errors.New(fmt.Sprintf("Param %s of task %s: %s", key, meta.ExternalId, err)))
+		}
+		params[key] = result
+	}
+
+	q.mu.Lock()
+	task.SetRunParams(params)
+	q.mu.Unlock()
+
+	return nil
+}
+
+
+// returns the environment variables @names that are set, as a map
+func environ(names []string) map[string]string {
+	env := map[string]string{}
+
+	for _, name := range names {
+		if value, ok := os.LookupEnv(name); ok {
+			env[name] = value
+		}
+	}
+
+	return env
+}
+
+
+// expands @text as a text/template against @data
+func expandTemplate(text string, data interface{}) (string, error) {
+	tmpl, err := template.New("param").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("Template %q can't be parsed: %s", text, err))
+	}
+
+	var out strings.Builder
+	err = tmpl.Execute(&out, data)
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("Template %q can't be expanded: %s", text, err))
+	}
+
+	return out.String(), nil
+}
@@ -0,0 +1,54 @@
+package fsq
+
+import "strconv"
+
+
+// - Stops dispatching: waiting tasks stay in the queue until Resume is called, while Add keeps taking new
+// tasks up to the queue's capacity. Tasks that are already processing run to completion.
+// - Unlike Stop, which stops taking work, and Freeze, which stops both, a paused queue only stops doing work,
+// e.g. while a downstream dependency is down for maintenance. Pausing a paused queue does nothing.
+func (q *FixedSizeQueue) Pause() {
+	q.As("").Pause()
+}
+
+
+// Resumes a paused queue, dispatching the tasks that waited while it was paused. Resuming a queue that
+// isn't paused does nothing.
+func (q *FixedSizeQueue) Resume() {
+	q.As("").Resume()
+}
+
+
+// See FixedSizeQueue.Pause
+func (e ConfigEditor) Pause() {
+	e.q.changeConfig(e.principal, 0, false, "paused", func() (string, string, error) {
+		oldValue := strconv.FormatBool(e.q.paused)
+		e.q.paused = true
+		e.q.epoch++
+		return oldValue, "true", nil
+	})
+}
+
+
+// See FixedSizeQueue.Resume
+func (e ConfigEditor) Resume() {
+	e.q.changeConfig(e.principal, 0, false, "paused", func() (string, string, error) {
+		oldValue := strconv.FormatBool(e.q.paused)
+		if e.q.paused {
+			e.q.paused = false
+			e.q.epoch++
+			e.q.dispatchWaiting()
+		}
+
+		return oldValue, "false", nil
+	})
+}
+
+
+// Returns true if the queue is paused, see Pause.
+func (q *FixedSizeQueue) IsPaused() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.paused
+}
@@ -0,0 +1,40 @@
+package fsq
+
+import "fmt"
+import "errors"
+import "plugin"
+
+// the symbol a Go plugin must export for LoadPlugin
+const pluginActionsSymbol = "Actions"
+
+
+// - Opens the Go plugin at @path and registers every action in its exported Actions variable, so task
+// handlers can be deployed or updated without rebuilding the program that embeds the queue.
+// - The plugin must export: var Actions = map[string]func(params map[string]interface{}) error{...}
+// - Actions already registered under the same names are replaced.
+// - Go plugins are only supported on some platforms and need cgo, see the plugin package.
+func (q *FixedSizeQueue) LoadPlugin(path string) error {
+	p, err := plugin.Open(path)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Plugin %s can't be opened: %s", path, err))
+	}
+
+	symbol, err := p.Lookup(pluginActionsSymbol)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Plugin %s doesn't export %s.", path, pluginActionsSymbol))
+	}
+
+	actions, ok := symbol.(*map[string]func(params map[string]interface{}) error)
+	if !ok {
+		return errors.New(fmt.Sprintf("Plugin %s exports %s with the wrong type %T.", path, pluginActionsSymbol, symbol))
+	}
+
+	for name, action := range *actions {
+		err = q.RegisterAction(name, action)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
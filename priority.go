@@ -0,0 +1,142 @@
+package fsq
+
+import "fmt"
+import "errors"
+import "context"
+import "sort"
+
+// How urgent a task is, see AddWithPriority.
+type Priority int
+
+const (
+	PriorityLow Priority = iota - 1  //dispatched once no normal or high priority task waits
+	PriorityNormal  //the priority of tasks added without one
+	PriorityHigh  //dispatched ahead of every normal and low priority task
+)
+
+// the waiting tasks of each priority wait in their own level, PriorityHigh's first (see levelOf)
+const priorityLevels = int(PriorityHigh - PriorityLow) + 1
+const normalLevel = int(PriorityHigh - PriorityNormal)
+
+
+// - Same as Add, but waiting tasks of a higher @priority are dispatched first, so urgent work can go ahead
+// of a backlog. Tasks of the same priority are dispatched in the queue's dispatch order (see SetDispatchOrder).
+// - Tasks released from parking still go first, and strict FIFO mode (see SetStrictFIFO) dispatches in FIFO
+// order whatever the priorities. With a dispatch strategy (see SetDispatchStrategy) the strategy decides,
+// the priority is in its TaskView.
+// - Tasks in the overflow (see SetSoftCapacity) only overtake others once they moved into the queue.
+// - Waiting tasks are kept in a ring buffer per priority, so picking the next task stays O(1). Turning strict
+// FIFO mode or a dispatch strategy on or off sorts the waiting tasks into their levels again, which is O(n log n).
+func (q *FixedSizeQueue) AddWithPriority(action func(params map[string]interface{}) error, params map[string]interface{}, id string, priority Priority) error {
+	if priority < PriorityLow || priority > PriorityHigh {
+		return errors.New(fmt.Sprintf("Priority %s is not valid.", priority))
+	}
+
+	return q.add(context.Background(), action, params, id, addOptions{priority: priority})
+}
+
+
+// - Returns the ring buffers of the priority levels of a queue of @size, highest priority first.
+// - Only the PriorityNormal level is allocated up front, the others are grown to @size once a task of
+// their priority waits (see enqueueWaiting).
+func newLevels(size int) [priorityLevels]*ringBuffer[*task] {
+	var levels [priorityLevels]*ringBuffer[*task]
+	for i := range levels {
+		levels[i] = newRingBuffer[*task](0)
+	}
+	levels[normalLevel] = newRingBuffer[*task](size)
+
+	return levels
+}
+
+
+// Returns the level @task waits in. Without priorities (in strict FIFO mode or with a dispatch strategy),
+// every task waits in the PriorityNormal level, in the order it was added.
+func (q *FixedSizeQueue) levelOf(task *task) int {
+	if !q.byPriority() {
+		return normalLevel
+	}
+
+	return int(PriorityHigh - task.priority)
+}
+
+
+// returns true if the priorities of the waiting tasks decide which one is dispatched next
+func (q *FixedSizeQueue) byPriority() bool {
+	return q.strategy == nil && !q.strictFIFO
+}
+
+
+// Adds @task to the waiting tasks, in the level of its priority. Fails if the queue is full.
+func (q *FixedSizeQueue) enqueueWaiting(task *task) error {
+	if q.items.Len() >= q.capacity() {
+		return errors.New("Can't enqueue, ring buffer is full.")
+	}
+
+	if level := q.levelOf(task); q.levels[level].MaxSize < q.capacity() {
+		q.levels[level] = q.levels[level].resized(q.capacity())
+		q.orderItems()
+	}
+
+	q.seq++
+	task.seq = q.seq
+	return q.items.Enqueue(task)
+}
+
+
+// - Called when priorities were turned on or off (see byPriority), moves the waiting tasks into the levels
+// they wait in now, keeping the order they were added in. O(n log n) in the number of waiting tasks.
+func (q *FixedSizeQueue) relevel() {
+	waiting := []*task{}
+	for _, level := range q.levels {
+		for level.Len() > 0 {
+			waiting = append(waiting, level.Dequeue())
+		}
+	}
+
+	sort.Slice(waiting, func(i, j int) bool { return waiting[i].seq < waiting[j].seq })
+
+	for _, task := range waiting {
+		level := q.levelOf(task)
+		if q.levels[level].MaxSize < q.capacity() {
+			q.levels[level] = q.levels[level].resized(q.capacity())
+		}
+
+		q.levels[level].Enqueue(task)
+	}
+
+	q.orderItems()
+}
+
+
+func (p Priority) String() string {
+	switch p {
+	case PriorityLow:
+		return "low"
+	case PriorityNormal:
+		return "normal"
+	case PriorityHigh:
+		return "high"
+	}
+
+	return fmt.Sprintf("Priority(%d)", int(p))
+}
+
+
+// Priorities are written as their name in JSON.
+func (p Priority) MarshalText() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+
+// Reads a priority written by MarshalText, e.g. in a peer's QueueView.
+func (p *Priority) UnmarshalText(text []byte) error {
+	for priority := PriorityLow; priority <= PriorityHigh; priority++ {
+		if priority.String() == string(text) {
+			*p = priority
+			return nil
+		}
+	}
+
+	return errors.New(fmt.Sprintf("Unknown priority %q.", text))
+}
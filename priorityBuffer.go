@@ -0,0 +1,216 @@
+package fsq
+
+import "fmt"
+import "errors"
+
+// A composite buffer with one buffer per priority level, level 0 being the highest priority. For
+// workloads with few priority levels it keeps Enqueue and Dequeue O(1), where a heap would be O(log n).
+// Items are drained either strictly by level, or by weighted round robin across the levels. Within a
+// level, items are drained in the order of the level's buffer (FIFO for a ring buffer).
+type priorityBuffer[T any] struct {
+	levels []buffer[T]
+	level func(item T) int  //returns the priority level of an item
+	weights []int  //nil for strict draining, otherwise how many items each level gets per round
+	credits []int  //what is left of each level's weight in the current round
+}
+
+
+// - Returns a priority buffer with @levels levels of @sizePerLevel items each.
+// - @level returns the priority level of an item, levels out of range are clamped.
+// - @weights: when empty, items are drained strictly by level, lower levels only get a turn once the higher
+// levels are empty. Otherwise there must be one weight per level, and per round each level drains up to its
+// weight in items, so lower levels are never starved.
+func newPriorityBuffer[T any](levels int, sizePerLevel int, level func(item T) int, weights ...int) (*priorityBuffer[T], error) {
+	if levels <= 0 {
+		return nil, errors.New("Priority buffer must have at least 1 level.")
+	}
+
+	if len(weights) > 0 && len(weights) != levels {
+		return nil, errors.New(fmt.Sprintf("Priority buffer has %d levels but %d weights.", levels, len(weights)))
+	}
+
+	for _, w := range weights {
+		if w <= 0 {
+			return nil, errors.New("Priority buffer weights must be greater than 0.")
+		}
+	}
+
+	rings := make([]buffer[T], 0, levels)
+	for i := 0; i < levels; i++ {
+		rings = append(rings, newRingBuffer[T](sizePerLevel))
+	}
+
+	pb := priorityBufferOf(rings, level)
+	if len(weights) > 0 {
+		pb.weights = weights
+		pb.credits = make([]int, levels)
+		copy(pb.credits, weights)
+	}
+
+	return pb, nil
+}
+
+
+// Returns a priority buffer over the buffers of @levels that drains strictly by level, e.g. LIFO buffers
+// so that the newest item of the highest level is taken out first.
+func priorityBufferOf[T any](levels []buffer[T], level func(item T) int) *priorityBuffer[T] {
+	return &priorityBuffer[T]{
+		levels: levels,
+		level: level,
+	}
+}
+
+
+// Adds @item to the buffer of its level, fails if that ring is full even when other levels have space.
+func (pb *priorityBuffer[T]) Enqueue(item T) error {
+	return pb.levels[pb.levelOf(item)].Enqueue(item)
+}
+
+
+func (pb *priorityBuffer[T]) Dequeue() T {
+	i, newRound := pb.next()
+	if i < 0 {
+		var zero T
+		return zero
+	}
+
+	if newRound {
+		copy(pb.credits, pb.weights)
+	}
+
+	if pb.weights != nil {
+		pb.credits[i]--
+	}
+
+	return pb.levels[i].Dequeue()
+}
+
+
+// Returns the item Dequeue would return next, without removing it.
+func (pb *priorityBuffer[T]) Peek() T {
+	i, _ := pb.next()
+	if i < 0 {
+		var zero T
+		return zero
+	}
+
+	return pb.levels[i].Peek()
+}
+
+
+// Returns the newest item of the lowest priority level that has items, without removing it. With strict
+// draining that is the item Dequeue would return last.
+func (pb *priorityBuffer[T]) PeekTail() T {
+	for i := len(pb.levels) - 1; i >= 0; i-- {
+		if pb.levels[i].Len() > 0 {
+			return pb.levels[i].PeekTail()
+		}
+	}
+
+	var zero T
+	return zero
+}
+
+
+// Removes the first item that @match returns true for, searching from the highest priority level down.
+func (pb *priorityBuffer[T]) Remove(match func(item T) bool) (T, bool) {
+	for _, b := range pb.levels {
+		item, ok := b.Remove(match)
+		if ok {
+			return item, true
+		}
+	}
+
+	var zero T
+	return zero, false
+}
+
+
+func (pb *priorityBuffer[T]) Len() int {
+	size := 0
+	for _, b := range pb.levels {
+		size += b.Len()
+	}
+
+	return size
+}
+
+
+func (pb *priorityBuffer[T]) Cap() int {
+	size := 0
+	for _, b := range pb.levels {
+		size += b.Cap()
+	}
+
+	return size
+}
+
+
+// Returns the items in the order they would be drained if nothing else was added.
+func (pb *priorityBuffer[T]) Tasks() []T {
+	items := make([]T, 0, pb.Len())
+	levels := make([][]T, len(pb.levels))
+	for l, b := range pb.levels {
+		levels[l] = b.Tasks()
+	}
+	credits := append([]int(nil), pb.credits...)
+
+	for len(items) < cap(items) {
+		i := -1
+		for l := range levels {
+			if len(levels[l]) > 0 && (pb.weights == nil || credits[l] > 0) {
+				i = l
+				break
+			}
+		}
+
+		if i < 0 {
+			// every level with items left used its weight, start a new round
+			copy(credits, pb.weights)
+			continue
+		}
+
+		if pb.weights != nil {
+			credits[i]--
+		}
+
+		items = append(items, levels[i][0])
+		levels[i] = levels[i][1:]
+	}
+
+	return items
+}
+
+
+// Returns the level the next item is taken from, -1 if the buffer is empty. Returns true if the weights
+// have to be refilled (a new round starts) before taking it.
+func (pb *priorityBuffer[T]) next() (int, bool) {
+	for i, b := range pb.levels {
+		if b.Len() > 0 && (pb.weights == nil || pb.credits[i] > 0) {
+			return i, false
+		}
+	}
+
+	for i, b := range pb.levels {
+		if b.Len() > 0 {
+			return i, true
+		}
+	}
+
+	return -1, false
+}
+
+
+func (pb *priorityBuffer[T]) levelOf(item T) int {
+	l := pb.level(item)
+
+	if l < 0 {
+		return 0
+	}
+
+	if l >= len(pb.levels) {
+		return len(pb.levels) - 1
+	}
+
+	return l
+}
@@ -0,0 +1,55 @@
+package fsq
+
+import "runtime"
+import "strconv"
+
+
+// - Same as Init, but maxProcessing is derived from runtime.GOMAXPROCS instead of being hardcoded, so
+// containers get a sensible concurrency for the CPU they are given.
+// - @procsMultiplier: maxProcessing is procsMultiplier * GOMAXPROCS (e.g. 1 for N, 2 for 2N). Defaults to 1 if <= 0 is passed in.
+// - GOMAXPROCS is re-read every time a task is dispatched, so if it is changed at runtime (for example by
+// automaxprocs when the container's CPU quota changes) the queue follows.
+func InitWithProcs(size int, name string, procsMultiplier int) *FixedSizeQueue {
+	queue := Init(size, name, 0)
+	queue.SetProcsMultiplier(procsMultiplier)
+	return queue
+}
+
+
+// Derives maxProcessing from procsMultiplier * GOMAXPROCS from now on. Passing <= 0 defaults to 1.
+func (q *FixedSizeQueue) SetProcsMultiplier(procsMultiplier int) {
+	if procsMultiplier <= 0 {
+		procsMultiplier = 1
+	}
+
+	q.changeConfig("", 0, false, "procsMultiplier", func() (string, string, error) {
+		oldValue := strconv.Itoa(q.procsMultiplier)
+		q.procsMultiplier = procsMultiplier
+		q.maxProcessing = q.procsMaxProcessing()
+		q.dispatchWaiting()
+		return oldValue, strconv.Itoa(procsMultiplier), nil
+	})
+}
+
+
+// Returns the max number of tasks the queue processes at once, not counting maintenance windows.
+func (q *FixedSizeQueue) MaxProcessing() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.currentMaxProcessing()
+}
+
+
+func (q *FixedSizeQueue) currentMaxProcessing() int {
+	if q.procsMultiplier > 0 {
+		q.maxProcessing = q.procsMaxProcessing()
+	}
+
+	return q.maxProcessing
+}
+
+
+func (q *FixedSizeQueue) procsMaxProcessing() int {
+	return q.procsMultiplier * runtime.GOMAXPROCS(0)
+}
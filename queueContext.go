@@ -0,0 +1,57 @@
+package fsq
+
+import "errors"
+import "context"
+
+// The cause of the cancellation of the contexts of a stopped queue's actions, see context.Cause.
+var ErrQueueStopped = errors.New("Queue was stopped.")
+
+
+// - Same as Add, for an action that is handed a context. The context is cancelled when the queue is
+// stopped, so long running actions can give up instead of running to completion after shutdown.
+// - Its cause (see context.Cause) is then ErrQueueStopped, and the task ends with ReasonQueueShutdown.
+// Tasks dispatched after Stop, while the queue drains, get a context that is already cancelled.
+// - @opts change how the task is handled, as for Add.
+func (q *FixedSizeQueue) AddContextAction(action ContextAction, params map[string]interface{}, id string, opts ...AddOption) error {
+	options, err := applyAddOptions(opts)
+	if err != nil {
+		return err
+	}
+
+	options.ctxAction = action
+	return q.add(context.Background(), nil, params, id, options)
+}
+
+
+// Called by Start, every action dispatched from now on gets a context derived from a fresh base context.
+func (q *FixedSizeQueue) startBaseContext() {
+	if q.baseCtx != nil && q.baseCtx.Err() == nil {
+		return
+	}
+
+	q.baseCtx, q.cancelBase = context.WithCancelCause(context.Background())
+}
+
+
+// Called by Stop, cancels the contexts of the processing actions and of the running warm hooks.
+func (q *FixedSizeQueue) stopBaseContext() {
+	if q.cancelBase != nil {
+		q.cancelBase(ErrQueueStopped)
+	}
+}
+
+
+// Returns the context the contexts of actions and warm hooks derive from, not cancelled if the queue was never started.
+func (q *FixedSizeQueue) baseContext() context.Context {
+	if q.baseCtx == nil {
+		return context.Background()
+	}
+
+	return q.baseCtx
+}
+
+
+// Returns true if the context of @task was cancelled because the queue was stopped.
+func stoppedWhileRunning(task *task) bool {
+	return task.ctx != nil && errors.Is(context.Cause(task.ctx), ErrQueueStopped)
+}
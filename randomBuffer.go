@@ -0,0 +1,60 @@
+package fsq
+
+import "math/rand/v2"
+
+// A ring buffer that takes out a randomly picked item instead of the oldest one. Used by FixedSizeQueue
+// for the Random dispatch order (see SetDispatchOrder).
+type randomBuffer[T any] struct {
+	*ringBuffer[T]
+	picked int  //the position from the head of the item Peek picked, -1 until one is picked
+}
+
+
+func newRandomBuffer[T any](rb *ringBuffer[T]) *randomBuffer[T] {
+	return &randomBuffer[T]{ringBuffer: rb, picked: -1}
+}
+
+
+// Picks the item Dequeue takes out next and returns it without removing it, the zero value of T if the
+// buffer is empty. The item stays picked until it is taken out or another item is removed.
+func (b *randomBuffer[T]) Peek() T {
+	if b.CurrentSize == 0 {
+		var zero T
+		return zero
+	}
+
+	if b.picked < 0 || b.picked >= b.CurrentSize {
+		b.picked = rand.IntN(b.CurrentSize)
+	}
+
+	return b.at(b.picked)
+}
+
+
+// Returns the picked item (see Peek) and removes it in O(1), the zero value of T if the buffer is empty. The
+// newest item takes the place of the removed one.
+func (b *randomBuffer[T]) Dequeue() T {
+	if b.CurrentSize == 0 {
+		var zero T
+		return zero
+	}
+
+	b.Peek()
+
+	// the order of the items doesn't matter here, so the tail takes the picked item's place instead of
+	// shifting the others
+	items := *b.items
+	last := b.index(b.CurrentSize - 1)
+	picked := b.index(b.picked)
+	items[picked], items[last] = items[last], items[picked]
+
+	b.picked = -1
+	return b.DequeueTail()
+}
+
+
+// Removes the first item (from oldest to newest) that @match returns true for, and returns it.
+func (b *randomBuffer[T]) Remove(match func(item T) bool) (T, bool) {
+	b.picked = -1
+	return b.ringBuffer.Remove(match)
+}
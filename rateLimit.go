@@ -0,0 +1,93 @@
+package fsq
+
+import "fmt"
+import "strconv"
+import "time"
+
+
+// - When @pause is true, a RateLimited error (see RateLimited) returned by an action added with @actionName
+// pauses the dispatching of that action's tasks for its retry-after, so the queue backs off the downstream as
+// a whole instead of hitting it with the rest of its waiting tasks. Without a retry-after, the pause is as long
+// as the retry policy's backoff (see SetRetryPolicy), and at least a second.
+// - Waiting tasks of a paused action are parked (see ParkedTasks) and dispatched once the pause ends,
+// tasks of other actions keep being dispatched. Tasks that are already processing are not affected.
+// - A later rate limit that asks for a longer wait extends the pause.
+func (q *FixedSizeQueue) SetRateLimitPause(actionName string, pause bool) {
+	q.changeConfig("", 0, false, fmt.Sprintf("rateLimitPause[%s]", actionName), func() (string, string, error) {
+		oldValue := strconv.FormatBool(q.rateLimitPause[actionName])
+
+		if !pause {
+			delete(q.rateLimitPause, actionName)
+			return oldValue, "false", nil
+		}
+
+		if q.rateLimitPause == nil {
+			q.rateLimitPause = map[string]bool{}
+		}
+
+		q.rateLimitPause[actionName] = true
+		return oldValue, "true", nil
+	})
+}
+
+
+// Returns when dispatching of @actionName's tasks resumes, false if the action isn't paused.
+func (q *FixedSizeQueue) RateLimitedUntil(actionName string) (time.Time, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	until, ok := q.rateLimitedUntil[actionName]
+	return until, ok
+}
+
+
+func (q *FixedSizeQueue) isRateLimited(actionName string) bool {
+	_, ok := q.rateLimitedUntil[actionName]
+	return ok
+}
+
+
+// Called when @task's action returned @err, pauses the action if it was rate limited.
+func (q *FixedSizeQueue) noteRateLimit(task *task, err error) {
+	if !q.rateLimitPause[task.actionName] {
+		return
+	}
+
+	if ClassOf(err) != ClassRateLimited {
+		return
+	}
+
+	retryAfter := q.rateLimitWait(task, err)
+	name := task.actionName
+	until := q.now().Add(retryAfter)
+
+	if current, ok := q.rateLimitedUntil[name]; ok && !until.After(current) {
+		return
+	}
+
+	if q.rateLimitedUntil == nil {
+		q.rateLimitedUntil = map[string]time.Time{}
+	}
+
+	q.rateLimitedUntil[name] = until
+	q.epoch++
+
+	time.AfterFunc(retryAfter, func() {
+		q.endRateLimit(name, until)
+	})
+}
+
+
+// Runs in a timer's go routine, resumes @name unless its pause was extended past @until since.
+func (q *FixedSizeQueue) endRateLimit(name string, until time.Time) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if current, ok := q.rateLimitedUntil[name]; !ok || !current.Equal(until) {
+		return
+	}
+
+	delete(q.rateLimitedUntil, name)
+	q.epoch++
+	q.unpark(name)
+}
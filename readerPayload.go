@@ -0,0 +1,39 @@
+package fsq
+
+import "context"
+import "io"
+import "log/slog"
+
+// An action that streams its input from a reader, e.g. a large upload, see AddReader.
+type ReaderAction func(ctx context.Context, body io.Reader) error
+
+
+// - Same as AddBytes, but the task's payload is @body, which @action reads from when the task runs. The
+// producer doesn't have to buffer a large body into memory or params.
+// - The queue owns @body from then on and closes it once the task is done with it: when the action
+// returned, when the task is dispatched but the action isn't called (e.g. skipped by a guard), or right
+// away if adding the task fails.
+func (q *FixedSizeQueue) AddReader(action ReaderAction, body io.ReadCloser, id string) error {
+	release := func() {
+		if body != nil {
+			err := body.Close()
+			if err != nil {
+				q.logError("closing the payload failed", err, slog.String("id", id))
+			}
+		}
+	}
+
+	var ctxAction ContextAction
+	if action != nil {
+		ctxAction = func(ctx context.Context, params map[string]interface{}) error {
+			return action(ctx, body)
+		}
+	}
+
+	err := q.add(context.Background(), nil, noParams, id, addOptions{ctxAction: ctxAction, release: release})
+	if err != nil {
+		release()
+	}
+
+	return err
+}
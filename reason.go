@@ -0,0 +1,77 @@
+package fsq
+
+import "errors"
+import "context"
+import "time"
+
+// - How a task ended, machine readable, so automation downstream of events and result sinks can branch on
+// it rather than on whether there was an error.
+// - Set on the EventCompleted, EventFailed and EventAbandoned events, see Event.Reason.
+type OutcomeReason int
+
+const (
+	ReasonSuccess OutcomeReason = iota  //the action returned nil
+	ReasonActionError  //the action returned an error that none of the reasons below explain
+	ReasonTimeout  //the action ran out of time: its deadline or timeout passed while it ran, or it was abandoned
+	ReasonCancelled  //the action returned because its context was cancelled, or the waiting task was withdrawn by Cancel
+	ReasonExpired  //the task was dispatched after its deadline, and its action gave up on the expired context
+	ReasonPanicked  //the action panicked, see ErrActionPanicked
+	ReasonQueueShutdown  //the action returned because its context was cancelled when the queue was stopped, or the waiting task was discarded by StopNow
+	ReasonDLQ  //the task failed and was kept as a dead letter, see SetDeadLetters
+	ReasonMaxAge  //the task lived longer than the max task age, see SetMaxTaskAge
+)
+
+
+func (r OutcomeReason) String() string {
+	switch r {
+	case ReasonSuccess:
+		return "Success"
+	case ReasonActionError:
+		return "ActionError"
+	case ReasonTimeout:
+		return "Timeout"
+	case ReasonCancelled:
+		return "Cancelled"
+	case ReasonExpired:
+		return "Expired"
+	case ReasonPanicked:
+		return "Panicked"
+	case ReasonQueueShutdown:
+		return "QueueShutdown"
+	case ReasonDLQ:
+		return "DLQ"
+	case ReasonMaxAge:
+		return "MaxAge"
+	}
+
+	return "Unknown"
+}
+
+
+// Reasons are written as their name in JSON.
+func (r OutcomeReason) MarshalText() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+
+// returns why a task dispatched at @startedAt, with @deadline (zero for none), ended with @err
+func reasonOf(err error, startedAt time.Time, deadline time.Time) OutcomeReason {
+	switch {
+	case err == nil:
+		return ReasonSuccess
+	case errors.Is(err, ErrActionPanicked):
+		return ReasonPanicked
+	case errors.Is(err, ErrTaskTimeout):
+		return ReasonTimeout
+	case errors.Is(err, context.DeadlineExceeded):
+		if !deadline.IsZero() && !startedAt.Before(deadline) {
+			return ReasonExpired
+		}
+
+		return ReasonTimeout
+	case ClassOf(err) == ClassCancelled:
+		return ReasonCancelled
+	}
+
+	return ReasonActionError
+}
@@ -0,0 +1,144 @@
+package fsq
+
+import "fmt"
+import "errors"
+import "path"
+import "sort"
+import "strings"
+
+// what a redacted param's value is replaced with
+const Redacted = "[REDACTED]"
+
+
+// - Marks the params matching @keys as sensitive for tasks added with @actionName, or for every task if
+// @actionName is empty. Wherever the queue hands params out for inspection (see Freeze and DeadLetters) their
+// values are replaced with Redacted, and RedactParams does the same for the user's own logs and dumps.
+// - @keys are case insensitive patterns as in path.Match, e.g. "password" or "*token*". They also apply
+// to the keys of nested param maps.
+// - Rules only ever add up, use ClearRedactions to start over.
+func (q *FixedSizeQueue) AddRedaction(actionName string, keys ...string) error {
+	for _, key := range keys {
+		_, err := path.Match(strings.ToLower(key), "")
+		if err != nil {
+			return errors.New(fmt.Sprintf("Redaction pattern %q is not valid: %s", key, err))
+		}
+	}
+
+	_, err := q.changeConfig("", 0, false, "redaction", func() (string, string, error) {
+		oldValue := q.redactionRules()
+
+		if q.redactions == nil {
+			q.redactions = map[string][]string{}
+		}
+
+		for _, key := range keys {
+			q.redactions[actionName] = append(q.redactions[actionName], strings.ToLower(key))
+		}
+
+		return oldValue, q.redactionRules(), nil
+	})
+
+	return err
+}
+
+
+// Removes every redaction rule.
+func (q *FixedSizeQueue) ClearRedactions() {
+	q.changeConfig("", 0, false, "redaction", func() (string, string, error) {
+		oldValue := q.redactionRules()
+		q.redactions = nil
+		return oldValue, q.redactionRules(), nil
+	})
+}
+
+
+// - Returns a copy of @params with the values of the sensitive params of @actionName replaced, see AddRedaction.
+// - Nested param maps and slices are copied too, so the copy can be handed out without sharing the task's params.
+func (q *FixedSizeQueue) RedactParams(actionName string, params map[string]interface{}) map[string]interface{} {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.redact(actionName, params)
+}
+
+
+// Same as RedactParams, with the lock held.
+func (q *FixedSizeQueue) redact(actionName string, params map[string]interface{}) map[string]interface{} {
+	patterns := append(append([]string{}, q.redactions[""]...), q.redactions[actionName]...)
+	if actionName == "" {
+		patterns = q.redactions[""]
+	}
+
+	return redactMap(params, patterns)
+}
+
+
+func redactMap(params map[string]interface{}, patterns []string) map[string]interface{} {
+	if params == nil {
+		return nil
+	}
+
+	redacted := make(map[string]interface{}, len(params))
+	for key, value := range params {
+		if matchesAny(key, patterns) {
+			redacted[key] = Redacted
+		} else {
+			redacted[key] = redactValue(value, patterns)
+		}
+	}
+
+	return redacted
+}
+
+
+// copies the param maps and slices in @value, redacting their keys matching @patterns
+func redactValue(value interface{}, patterns []string) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return redactMap(v, patterns)
+	case []interface{}:
+		redacted := make([]interface{}, len(v))
+		for i, item := range v {
+			redacted[i] = redactValue(item, patterns)
+		}
+		return redacted
+	}
+
+	return value
+}
+
+
+// Returns a deep copy of @params' maps and slices, e.g. to keep them past the task without sharing them with the caller.
+func copyParams(params map[string]interface{}) map[string]interface{} {
+	return redactMap(params, nil)
+}
+
+
+func matchesAny(key string, patterns []string) bool {
+	key = strings.ToLower(key)
+
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, key); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+
+// formats the rules for the audit log, by action name
+func (q *FixedSizeQueue) redactionRules() string {
+	names := make([]string, 0, len(q.redactions))
+	for name := range q.redactions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	rules := make([]string, 0, len(names))
+	for _, name := range names {
+		rules = append(rules, fmt.Sprintf("%s=%s", name, strings.Join(q.redactions[name], ",")))
+	}
+
+	return strings.Join(rules, ";")
+}
@@ -0,0 +1,102 @@
+package fsq
+
+import "fmt"
+import "errors"
+import "sort"
+import "sync"
+
+// - Keeps queues by their qualified name (see FixedSizeQueue.QualifiedName), so queues created in one place
+// can be looked up in another. Safe for concurrent use, the zero value is an empty registry.
+// - The package level Register, Get, List and Deregister use a registry shared by the whole process.
+type Registry struct {
+	mu sync.Mutex
+	queues map[string]*FixedSizeQueue
+}
+
+var defaultRegistry Registry
+
+
+// - Adds @q to the registry under its qualified name. Set the queue's namespace before registering it.
+// - Returns an error if another queue is registered under the name, registering the same queue twice is a no-op.
+func (r *Registry) Register(q *FixedSizeQueue) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	name := q.QualifiedName()
+	registered, ok := r.queues[name]
+	if ok && registered != q {
+		return errors.New(fmt.Sprintf("Registry already has a FixedSizeQueue named %s.", name))
+	}
+
+	if r.queues == nil {
+		r.queues = map[string]*FixedSizeQueue{}
+	}
+
+	r.queues[name] = q
+	return nil
+}
+
+
+// Returns the queue registered under the qualified @name, false if there is none.
+func (r *Registry) Get(name string) (*FixedSizeQueue, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	q, ok := r.queues[name]
+	return q, ok
+}
+
+
+// Returns the registered queues, ordered by qualified name.
+func (r *Registry) List() []*FixedSizeQueue {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	names := make([]string, 0, len(r.queues))
+	for name := range r.queues {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	queues := make([]*FixedSizeQueue, 0, len(names))
+	for _, name := range names {
+		queues = append(queues, r.queues[name])
+	}
+
+	return queues
+}
+
+
+// Removes the queue registered under the qualified @name, returns false if there is none. The queue keeps running.
+func (r *Registry) Deregister(name string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, ok := r.queues[name]
+	delete(r.queues, name)
+	return ok
+}
+
+
+// Same as Registry.Register, on the registry shared by the process.
+func Register(q *FixedSizeQueue) error {
+	return defaultRegistry.Register(q)
+}
+
+
+// Same as Registry.Get, on the registry shared by the process.
+func Get(name string) (*FixedSizeQueue, bool) {
+	return defaultRegistry.Get(name)
+}
+
+
+// Same as Registry.List, on the registry shared by the process.
+func List() []*FixedSizeQueue {
+	return defaultRegistry.List()
+}
+
+
+// Same as Registry.Deregister, on the registry shared by the process.
+func Deregister(name string) bool {
+	return defaultRegistry.Deregister(name)
+}
@@ -0,0 +1,297 @@
+package fsq
+
+import "context"
+import "log/slog"
+import "sync"
+import "time"
+
+// defaults for the zero fields of ResultSinkOptions
+const defaultResultBatchSize = 100
+const defaultResultFlushInterval = time.Second
+const defaultResultRetries = 3
+const defaultResultRetryDelay = 100 * time.Millisecond
+const defaultMaxBufferedResults = 10000
+
+// - Records the outcomes of tasks somewhere outside the queue, e.g. a database table, a topic or a file,
+// see SetResultSink. Write gets the EventCompleted and EventFailed events of finished tasks, oldest first.
+// - Write is only called by one go routine at a time. A returned error has the whole batch retried.
+type ResultSink interface {
+	Write(results []Event) error
+}
+
+// Lets a plain function be used as a ResultSink.
+type ResultSinkFunc func(results []Event) error
+
+// How a result sink is fed. Zero fields use the defaults.
+type ResultSinkOptions struct {
+	BatchSize int  //the most results per Write, 100 by default. A full batch is written right away.
+	FlushInterval time.Duration  //how often a partial batch is written, 1s by default
+	Retries int  //how often a failed Write is retried before the batch is given up, 3 by default and none if < 0
+	RetryDelay time.Duration  //the wait before the first retry, doubling with every retry. 100ms by default.
+	MaxBuffered int  //results waiting to be written beyond this drop the oldest, 10000 by default
+}
+
+// Counts of the results handed to a result sink, see ResultSinkStats.
+type ResultSinkStats struct {
+	Pending int  //waiting to be written
+	Written int
+	Dropped int  //dropped because MaxBuffered was reached
+	Failed int  //given up after the retries were used up
+}
+
+// feeds a ResultSink from its own go routine
+type resultWriter struct {
+	mu sync.Mutex  //guards pending and the counts
+	writeMu sync.Mutex  //held while writing, so batches are written one at a time and in order
+	sink ResultSink
+	opts ResultSinkOptions
+	pending []Event
+	stats ResultSinkStats
+	kick chan struct{}  //signals a full batch
+	stop chan struct{}  //closed to end the go routine, which writes what is left first
+	done chan struct{}  //closed once the go routine ended
+	logError func(msg string, err error, attrs ...slog.Attr)  //the queue's, see SetLogger
+}
+
+
+// the context key of the result of a task's run, see SetResult
+type resultKey struct{}
+
+// the result an action reported for a run of its task
+type runResult struct {
+	mu sync.Mutex
+	value interface{}
+}
+
+
+// - Reports @result as the result of the task whose action @ctx was passed to (see ContextAction), e.g. the
+// output of a command or the body of a response. It is handed on as Event.Result of the run's EventCompleted
+// or EventFailed event, so result sinks (see SetResultSink) and histories (see SetHistory) record it.
+// - A later call replaces the result. Returns false if @ctx isn't the context of a task's action.
+func SetResult(ctx context.Context, result interface{}) bool {
+	r, ok := ctx.Value(resultKey{}).(*runResult)
+	if !ok {
+		return false
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.value = result
+	return true
+}
+
+
+// returns what the action reported with SetResult, nil for a nil @r
+func (r *runResult) get() interface{} {
+	if r == nil {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.value
+}
+
+
+func (f ResultSinkFunc) Write(results []Event) error {
+	return f(results)
+}
+
+
+// - Hands the outcome of every task finishing from now on to @sink, in batches written by a go routine of
+// its own, so recording outcomes doesn't live inside each action and a slow sink doesn't hold up the queue.
+// - Failed writes are retried with a growing delay. Results that can't be written, or don't fit the buffer, are counted in ResultSinkStats.
+// - Replacing the sink, or removing it with a nil @sink, writes what the previous one has left first and
+// waits for it. Stopping the queue doesn't stop the sink, tasks still processing finish after Stop.
+func (q *FixedSizeQueue) SetResultSink(sink ResultSink, opts ResultSinkOptions) {
+	var w *resultWriter
+	if sink != nil {
+		w = newResultWriter(sink, opts, q.logError)
+	}
+
+	q.mu.Lock()
+	previous := q.results
+	q.results = w
+	q.mu.Unlock()
+
+	// the last write may be slow
+	if previous != nil {
+		previous.close()
+	}
+
+	if w != nil {
+		go w.run()
+	}
+}
+
+
+// Writes the results waiting for the result sink right away, e.g. before the process exits. Returns the
+// error of the last failed write, nil if there is no sink.
+func (q *FixedSizeQueue) FlushResults() error {
+	q.mu.Lock()
+	w := q.results
+	q.mu.Unlock()
+
+	if w == nil {
+		return nil
+	}
+
+	return w.flush()
+}
+
+
+// Returns the counts of the current result sink, zero if there is none.
+func (q *FixedSizeQueue) ResultSinkStats() ResultSinkStats {
+	q.mu.Lock()
+	w := q.results
+	q.mu.Unlock()
+
+	if w == nil {
+		return ResultSinkStats{}
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	stats := w.stats
+	stats.Pending = len(w.pending)
+	return stats
+}
+
+
+func newResultWriter(sink ResultSink, opts ResultSinkOptions, logError func(msg string, err error, attrs ...slog.Attr)) *resultWriter {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = defaultResultBatchSize
+	}
+
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = defaultResultFlushInterval
+	}
+
+	if opts.Retries < 0 {
+		opts.Retries = 0
+	} else if opts.Retries == 0 {
+		opts.Retries = defaultResultRetries
+	}
+
+	if opts.RetryDelay <= 0 {
+		opts.RetryDelay = defaultResultRetryDelay
+	}
+
+	if opts.MaxBuffered <= 0 {
+		opts.MaxBuffered = defaultMaxBufferedResults
+	}
+
+	return &resultWriter{
+		sink: sink,
+		opts: opts,
+		kick: make(chan struct{}, 1),
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+		logError: logError,
+	}
+}
+
+
+// Called with the queue's lock held, never blocks.
+func (w *resultWriter) push(event Event) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.pending = append(w.pending, event)
+	if len(w.pending) > w.opts.MaxBuffered {
+		w.pending = w.pending[1:]
+		w.stats.Dropped++
+	}
+
+	if len(w.pending) >= w.opts.BatchSize {
+		select {
+		case w.kick <- struct{}{}:
+		default:
+		}
+	}
+}
+
+
+func (w *resultWriter) run() {
+	defer close(w.done)
+
+	ticker := time.NewTicker(w.opts.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.stop:
+			w.report(w.flush())
+			return
+		case <-w.kick:
+			w.report(w.flush())
+		case <-ticker.C:
+			w.report(w.flush())
+		}
+	}
+}
+
+
+// logs the error of a flush, if any
+func (w *resultWriter) report(err error) {
+	if err != nil {
+		w.logError("writing results failed", err)
+	}
+}
+
+
+// writes the pending results batch by batch, returns the error of the last batch given up on
+func (w *resultWriter) flush() error {
+	w.writeMu.Lock()
+	defer w.writeMu.Unlock()
+
+	var lastErr error
+
+	for {
+		w.mu.Lock()
+		n := min(len(w.pending), w.opts.BatchSize)
+		batch := append([]Event{}, w.pending[:n]...)
+		w.pending = w.pending[n:]
+		w.mu.Unlock()
+
+		if len(batch) == 0 {
+			return lastErr
+		}
+
+		err := w.write(batch)
+
+		w.mu.Lock()
+		if err != nil {
+			w.stats.Failed += len(batch)
+			lastErr = err
+		} else {
+			w.stats.Written += len(batch)
+		}
+		w.mu.Unlock()
+	}
+}
+
+
+// writes @batch, retrying with a doubling delay
+func (w *resultWriter) write(batch []Event) error {
+	delay := w.opts.RetryDelay
+	err := w.sink.Write(batch)
+
+	for retry := 0; err != nil && retry < w.opts.Retries; retry++ {
+		time.Sleep(delay)
+		delay *= 2
+		err = w.sink.Write(batch)
+	}
+
+	return err
+}
+
+
+// ends the go routine once it wrote what is left
+func (w *resultWriter) close() {
+	close(w.stop)
+	<-w.done
+}
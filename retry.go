@@ -0,0 +1,224 @@
+package fsq
+
+import "fmt"
+import "log/slog"
+import "math"
+import "time"
+
+// How the queue retries tasks whose action returned an error, see SetRetryPolicy.
+type RetryPolicy struct {
+	MaxAttempts int  //runs of a task's action, the first one included. Retries are off if <= 1.
+	BaseDelay time.Duration  //the wait before the first retry, doubling with every retry after it
+	MaxDelay time.Duration  //the longest wait before a retry, no limit if <= 0
+	ClassifiedOnly bool  //only errors marked Retryable or RateLimited are retried, unclassified ones end the task
+}
+
+// how long a rate limited action without a retry-after backs off at least, see RateLimited
+const minRateLimitWait = time.Second
+
+
+// - Retries tasks whose action returned an error instead of ending them, up to @policy.MaxAttempts runs in
+// total, waiting an exponential backoff before each retry (see RetryPolicy.Backoff).
+// - What is retried follows the class of the error (see ClassOf): Retryable errors are retried, unclassified
+// ones too unless the policy is ClassifiedOnly. A RateLimited error waits at least its retry-after, or without
+// one backs off as far as the policy allows (MaxDelay, and at least a second), since running the task again
+// right away would only be turned away too.
+// - A task waiting for a retry is held like a task added with AddAfter: it keeps its slot, dedup key, callbacks
+// and handle, and an EventRetrying event is published in place of EventFailed. Only its last run ends it.
+// - Errors marked Permanent or Cancelled aren't retried, nor are tasks whose deadline would pass before the retry,
+// tasks added with AddReader (their payload was read), and tasks of a stopped queue. A task that can't be put
+// back, e.g. because the queue filled up meanwhile, fails with the error of its last run.
+// - WithRetries overrides MaxAttempts for a single task.
+// - TaskView.Attempts and TaskView.LastError show the retry state of a waiting task. Negative values are treated as 0.
+func (q *FixedSizeQueue) SetRetryPolicy(policy RetryPolicy) {
+	policy.MaxAttempts = max(policy.MaxAttempts, 0)
+	policy.BaseDelay = max(policy.BaseDelay, 0)
+	policy.MaxDelay = max(policy.MaxDelay, 0)
+
+	q.changeConfig("", 0, false, "retryPolicy", func() (string, string, error) {
+		oldValue := q.retryPolicy.String()
+		q.retryPolicy = policy
+		return oldValue, q.retryPolicy.String(), nil
+	})
+}
+
+
+// Returns the wait before the run after the @attempt-th one failed: BaseDelay doubled for every earlier retry, capped at MaxDelay.
+func (p RetryPolicy) Backoff(attempt int) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < attempt && delay > 0; i++ {
+		// stops doubling before it overflows
+		if delay > math.MaxInt64 / 2 || (p.MaxDelay > 0 && delay >= p.MaxDelay) {
+			break
+		}
+
+		delay *= 2
+	}
+
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		return p.MaxDelay
+	}
+
+	return delay
+}
+
+
+// formats the policy for the audit log
+func (p RetryPolicy) String() string {
+	if p.MaxAttempts <= 1 {
+		return "off"
+	}
+
+	policy := fmt.Sprintf("%d attempts, backoff %s to %s", p.MaxAttempts, p.BaseDelay, p.MaxDelay)
+	if p.ClassifiedOnly {
+		policy += ", classified errors only"
+	}
+
+	return policy
+}
+
+
+// Returns true if the policy allows retrying a run that failed with an error of @class.
+func (p RetryPolicy) retries(class ErrorClass) bool {
+	switch class {
+	case ClassPermanent, ClassCancelled:
+		return false
+	case ClassUnclassified:
+		return !p.ClassifiedOnly
+	}
+
+	return true
+}
+
+
+// Returns how long @task's action should back off after being rate limited with @err.
+func (q *FixedSizeQueue) rateLimitWait(task *task, err error) time.Duration {
+	if retryAfter, ok := RetryAfter(err); ok {
+		return retryAfter
+	}
+
+	return max(q.retryPolicy.Backoff(task.attempt), q.retryPolicy.MaxDelay, minRateLimitWait)
+}
+
+
+// Returns true if the retry policy (or the task's WithRetries) allows @task another run, whatever its error.
+func (q *FixedSizeQueue) attemptsLeft(task *task) bool {
+	maxAttempts := q.retryPolicy.MaxAttempts
+	if task.maxAttempts > 0 {
+		maxAttempts = task.maxAttempts
+	}
+
+	return task.attempt < maxAttempts && !task.oneShot && q.isRunning
+}
+
+
+// - Called by finish with the error of @task's action. When @err is a PartialFailure and the task's items are in
+// its BatchItemsParam, takes the items that didn't fail out of the task's params. If the task has attempts left,
+// the failed items the retry policy won't run again are dead lettered on their own and taken out too.
+// - Returns the error the task goes on with: @err, or a PartialFailure of the items that are retried.
+func (q *FixedSizeQueue) narrowPartialFailure(task *task, err error) error {
+	failed, ok := FailedItems(err)
+	if !ok {
+		return err
+	}
+
+	params, ok := withItems(task.params, func(key string) bool {
+		_, failed := failed[key]
+		return failed
+	})
+	if !ok {
+		return err
+	}
+	task.SetParams(params)
+
+	retried := map[string]error{}
+	givenUp := map[string]error{}
+	for key, itemErr := range failed {
+		if q.retryPolicy.retries(ClassOf(itemErr)) {
+			retried[key] = itemErr
+		} else {
+			givenUp[key] = itemErr
+		}
+	}
+
+	// the task either is retried with every failed item or ends with them
+	if len(retried) == 0 || len(givenUp) == 0 || !q.attemptsLeft(task) {
+		return err
+	}
+
+	for key, itemErr := range givenUp {
+		itemParams, _ := withItems(task.params, func(k string) bool { return k == key })
+		q.keepDeadLetter(task, task.externalId + "/" + key, itemParams, q.taskEvent(task, itemErr))
+	}
+
+	params, _ = withItems(task.params, func(key string) bool {
+		_, ok := retried[key]
+		return ok
+	})
+	task.SetParams(params)
+
+	return &PartialFailure{Failed: retried}
+}
+
+
+// - Called by finish with the error of @task's action. Puts the task back as a delayed task if the retry policy
+// allows another run, returns false if the task ends instead.
+func (q *FixedSizeQueue) retry(task *task, err error) bool {
+	if err == nil || !q.attemptsLeft(task) {
+		return false
+	}
+
+	class := ClassOf(err)
+	if !q.retryPolicy.retries(class) {
+		return false
+	}
+
+	delay := q.retryPolicy.Backoff(task.attempt)
+	if class == ClassRateLimited {
+		delay = max(delay, q.rateLimitWait(task, err))
+	}
+
+	retryAt := q.now().Add(delay)
+	if !task.deadline.IsZero() && retryAt.After(task.deadline) {
+		return false
+	}
+
+	// the task's slot and id were given up when it was dispatched
+	if q.isFull() {
+		return false
+	}
+
+	if _, ok := q.waitingTasksByExternalId[task.externalId]; ok {
+		return false
+	}
+
+	dedupKey, dedupErr := q.admitDedupKey(task.externalId, task.actionName, task.params)
+	if dedupErr != nil {
+		return false
+	}
+
+	event := q.taskEvent(task, err)
+	event.Type = EventRetrying
+	event.RetryAt = retryAt
+	event.Duration = event.At.Sub(task.startedAt)
+	q.publish(event)
+	q.recordAttempt(task, event)
+	q.endTaskSpan(task, event)
+	q.logTask(slog.LevelWarn, "task failed, retrying", task, err, slog.Duration("duration", event.Duration), slog.Time("retryAt", retryAt))
+	q.stats.Retried++
+	q.trace(task.externalId, "retrying", "run %d failed after %s, retrying in %s: %s", task.attempt, q.now().Sub(task.startedAt), delay, err)
+
+	task.SetStateWaiting()
+	task.SetLastErr(err)
+	task.SetDedupKey(dedupKey)
+	task.SetNotBefore(retryAt)
+	task.SetWaitingSince(event.At)
+	task.starving = false
+	q.enqueueDelayed(task)
+	q.rememberWaiting(task)
+
+	q.countProcessing--
+	q.epoch++
+	q.dispatchAfterCompletion()
+	return true
+}
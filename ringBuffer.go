@@ -2,24 +2,36 @@ package fsq
 
 import "errors"
 
-type ringBuffer struct {
+// A bounded FIFO with O(1) enqueue and dequeue. Not safe for concurrent use on its own, the
+// FixedSizeQueue and FixedSizeBuffer that hold it guard it with their lock.
+type ringBuffer[T any] struct {
 	MaxSize int
 	CurrentSize int
 	IsFull bool
-	items *[]*task
+	items *[]T
 	head int
 	tail int
 }
 
 
-func (rb *ringBuffer) Enqueue(task *task) error {
+func newRingBuffer[T any](size int) *ringBuffer[T] {
+	items := make([]T, size)
+
+	return &ringBuffer[T]{
+		MaxSize: size,
+		items: &items,
+	}
+}
+
+
+func (rb *ringBuffer[T]) Enqueue(item T) error {
 	if rb.CurrentSize == rb.MaxSize {
 		return errors.New("Can't enqueue, ring buffer is full.")
 	}
 
 	rb.tail = (rb.head + rb.CurrentSize) % rb.MaxSize
 	rb.CurrentSize++
-	(*rb.items)[rb.tail] = task
+	(*rb.items)[rb.tail] = item
 
 	if rb.CurrentSize == rb.MaxSize {
 		rb.IsFull = true
@@ -29,17 +41,166 @@ func (rb *ringBuffer) Enqueue(task *task) error {
 }
 
 
-func (rb *ringBuffer) Dequeue() *task {
+// Returns the item at the head of the buffer and removes it, the zero value of T if the buffer is empty.
+func (rb *ringBuffer[T]) Dequeue() T {
+	var zero T
+
 	if rb.CurrentSize == 0 {
-		return nil
+		return zero
 	}
 
-	task := (*rb.items)[rb.head]
+	item := (*rb.items)[rb.head]
 
-	(*rb.items)[rb.head] = nil
+	// don't keep the item reachable from the buffer
+	(*rb.items)[rb.head] = zero
 	rb.head = (rb.head + 1) % rb.MaxSize
 	rb.CurrentSize--
 	rb.IsFull = false
 
-	return task
-}
\ No newline at end of file
+	return item
+}
+
+
+// Returns the item at the head of the buffer without removing it, the zero value of T if the buffer is empty.
+func (rb *ringBuffer[T]) Peek() T {
+	if rb.CurrentSize == 0 {
+		var zero T
+		return zero
+	}
+
+	return (*rb.items)[rb.head]
+}
+
+
+// Returns the item at the tail of the buffer (the newest one) without removing it, the zero value of T if
+// the buffer is empty.
+func (rb *ringBuffer[T]) PeekTail() T {
+	if rb.CurrentSize == 0 {
+		var zero T
+		return zero
+	}
+
+	return (*rb.items)[(rb.head + rb.CurrentSize - 1) % rb.MaxSize]
+}
+
+
+// - Removes the first item (from head to tail) that @match returns true for, and returns it. Returns false
+// if no item matches.
+// - The order of the other items is kept. Whichever side of the removed item is shorter is shifted to
+// close the gap, so removing near the head or the tail is cheap.
+func (rb *ringBuffer[T]) Remove(match func(item T) bool) (T, bool) {
+	for i := 0; i < rb.CurrentSize; i++ {
+		if match((*rb.items)[rb.index(i)]) {
+			return rb.removeAt(i), true
+		}
+	}
+
+	var zero T
+	return zero, false
+}
+
+
+// Returns the item at the tail of the buffer (the newest one) and removes it, the zero value of T if the
+// buffer is empty.
+func (rb *ringBuffer[T]) DequeueTail() T {
+	if rb.CurrentSize == 0 {
+		var zero T
+		return zero
+	}
+
+	return rb.removeAt(rb.CurrentSize - 1)
+}
+
+
+// removes and returns the @at-th item from the head, which must exist, keeping the order of the others
+func (rb *ringBuffer[T]) removeAt(at int) T {
+	var zero T
+
+	items := *rb.items
+	removed := items[rb.index(at)]
+
+	if at < rb.CurrentSize / 2 {
+		// shift the items before it one towards the tail
+		for i := at; i > 0; i-- {
+			items[rb.index(i)] = items[rb.index(i - 1)]
+		}
+
+		items[rb.head] = zero
+		rb.head = (rb.head + 1) % rb.MaxSize
+	} else {
+		// shift the items after it one towards the head
+		for i := at; i < rb.CurrentSize - 1; i++ {
+			items[rb.index(i)] = items[rb.index(i + 1)]
+		}
+
+		items[rb.index(rb.CurrentSize - 1)] = zero
+	}
+
+	rb.CurrentSize--
+	rb.IsFull = false
+	rb.tail = rb.index(rb.CurrentSize - 1)
+
+	return removed
+}
+
+
+// returns the @i-th item from the head, which must exist
+func (rb *ringBuffer[T]) at(i int) T {
+	return (*rb.items)[rb.index(i)]
+}
+
+
+// returns the position in items of the @i-th item from the head
+func (rb *ringBuffer[T]) index(i int) int {
+	return (rb.head + i + rb.MaxSize) % rb.MaxSize
+}
+
+
+func (rb *ringBuffer[T]) Len() int {
+	return rb.CurrentSize
+}
+
+
+func (rb *ringBuffer[T]) Cap() int {
+	return rb.MaxSize
+}
+
+
+// Returns the items in the buffer in FIFO order, without removing them.
+func (rb *ringBuffer[T]) Tasks() []T {
+	first, second := rb.Segments()
+
+	items := make([]T, 0, rb.CurrentSize)
+	items = append(items, first...)
+	return append(items, second...)
+}
+
+
+// - Returns the items in the buffer in FIFO order as the (at most) two contiguous segments of the underlying
+// array: from the head to the end of the array, and from the start of the array when the items wrap around.
+// - The segments are views, not copies, so bulk operations (snapshots, persistence writes) don't have to
+// iterate the items one by one. They are only valid until the buffer is changed, and must not be modified.
+func (rb *ringBuffer[T]) Segments() ([]T, []T) {
+	items := *rb.items
+	end := rb.head + rb.CurrentSize
+
+	if end <= rb.MaxSize {
+		return items[rb.head:end], items[:0]
+	}
+
+	return items[rb.head:], items[:end - rb.MaxSize]
+}
+
+// Returns a ring buffer of @size holding the items of this one in the same order, @size must fit them.
+func (rb *ringBuffer[T]) resized(size int) *ringBuffer[T] {
+	items := make([]T, size)
+	first, second := rb.Segments()
+	copy(items[copy(items, first):], second)
+
+	return &ringBuffer[T]{
+		MaxSize: size,
+		CurrentSize: rb.CurrentSize,
+		IsFull: rb.CurrentSize == size,
+		items: &items,
+	}
+}
@@ -0,0 +1,436 @@
+package fsq
+
+import "fmt"
+import "errors"
+import "math/rand/v2"
+import "slices"
+import "sort"
+import "time"
+
+// - A recurring schedule that fires at each of Times on each of Days, read on the wall clock of Location.
+// - Fire times are computed per calendar day in Location, so a schedule keeps firing at the same local time
+// across daylight saving changes. A time that doesn't exist on a day (skipped by a DST change) fires at the
+// equivalent time after the change, e.g. 02:30 fires at 03:30. A time that exists twice fires once.
+// - A schedule with Every fires on an interval instead of at Times, Days, MonthDays and Months still limit
+// the days it fires on. See ParseSchedule for schedules written as cron expressions.
+type Schedule struct {
+	Days []time.Weekday  //days on which the schedule fires. Empty means every day.
+	Times []time.Duration  //offsets from midnight at which the schedule fires, e.g. 9*time.Hour + 30*time.Minute for 09:30
+	Every time.Duration  //fires every Every instead of at Times, on multiples of Every since the zero time, e.g. every 15 minutes on the quarter hour (UTC)
+	MonthDays []int  //days of the month on which the schedule fires, from 1 to 31. Empty means every day. With Days set too, a day matching either fires, as in cron.
+	Months []time.Month  //months in which the schedule fires. Empty means every month.
+	Location *time.Location  //time zone the schedule is defined in, e.g. from time.LoadLocation("Europe/Berlin"). Defaults to time.Local.
+	CatchUp CatchUpPolicy  //what happens to fire times missed while the queue wasn't running, checked on Start
+	Blackouts []BlackoutCalendar  //periods in which the schedule doesn't fire, e.g. holidays or change freezes
+	Blackout BlackoutPolicy  //what happens to fire times inside a blackout
+	Jitter time.Duration  //each occurrence fires after a random delay below Jitter, so instances sharing a schedule don't fire in lockstep
+}
+
+// The state of a schedule registered with a queue, see ScheduleStatus.
+type ScheduleStatus struct {
+	Name string
+	Paused bool  //see PauseSchedule
+	Next time.Time  //when the schedule fires next (before jitter), zero while the queue isn't running or the schedule is paused
+	LastFired time.Time  //the latest occurrence that was added, zero before the first one
+	Deferred time.Time  //the occurrence held back by a blackout until Next, zero if none
+	LastBlackout *BlackoutDecision  //what happened to the latest occurrence inside a blackout, nil if none
+}
+
+// Returned for a schedule name that isn't registered with the queue.
+var ErrNoSchedule = errors.New("No schedule is registered under that name.")
+
+// - How many days Next looks ahead for a day the schedule fires on. A schedule limited to weekdays fires
+// within a week, one limited to days of the month may wait for a leap day, which can be 8 years away.
+const scheduleSearchDays = 8 * 366 + 7
+
+// a schedule registered with a queue, see AddSchedule
+type scheduledJob struct {
+	name string
+	schedule Schedule
+	action func(params map[string]interface{}) error
+	params map[string]interface{}
+	next time.Time  //the next fire time, zero when the schedule isn't armed
+	timer *time.Timer  //fires at next, nil when the schedule isn't armed
+	lastFired time.Time  //the latest occurrence that was added, zero before the first one
+	deferred time.Time  //the occurrence held back by a blackout until next, zero if none
+	lastBlackout *BlackoutDecision  //what happened to the latest occurrence inside a blackout
+	paused bool  //see PauseSchedule
+}
+
+
+// Returns the first fire time strictly after @after.
+func (s Schedule) Next(after time.Time) time.Time {
+	if s.Every > 0 {
+		return s.nextInterval(after)
+	}
+
+	loc := s.location()
+	local := after.In(loc)
+	times := s.sortedTimes()
+
+	for day := 0; day <= s.searchDays(); day++ {
+		date := time.Date(local.Year(), local.Month(), local.Day() + day, 0, 0, 0, 0, loc)
+
+		if !s.firesOn(date) {
+			continue
+		}
+
+		for _, offset := range times {
+			fire := wallClock(date, offset)
+			if fire.After(after) {
+				return fire
+			}
+		}
+	}
+
+	return time.Time{}
+}
+
+
+// Returns the first multiple of Every strictly after @after that falls on a day the schedule fires on.
+func (s Schedule) nextInterval(after time.Time) time.Time {
+	loc := s.location()
+	fire := after.Truncate(s.Every).Add(s.Every)
+
+	for day := 0; day <= s.searchDays(); day++ {
+		local := fire.In(loc)
+		if s.firesOn(local) {
+			return fire
+		}
+
+		// the first multiple of Every on the next day
+		midnight := time.Date(local.Year(), local.Month(), local.Day() + 1, 0, 0, 0, 0, loc)
+		fire = midnight.Add(-time.Nanosecond).Truncate(s.Every).Add(s.Every)
+	}
+
+	return time.Time{}
+}
+
+
+// Returns the next @n fire times after @after, e.g. to preview a schedule.
+func (s Schedule) NextRuns(after time.Time, n int) []time.Time {
+	runs := []time.Time{}
+
+	for len(runs) < n {
+		after = s.Next(after)
+		if after.IsZero() {
+			break
+		}
+
+		runs = append(runs, after)
+	}
+
+	return runs
+}
+
+
+func (s Schedule) location() *time.Location {
+	if s.Location == nil {
+		return time.Local
+	}
+
+	return s.Location
+}
+
+
+func (s Schedule) sortedTimes() []time.Duration {
+	times := append([]time.Duration{}, s.Times...)
+	sort.Slice(times, func(i, j int) bool { return times[i] < times[j] })
+	return times
+}
+
+
+// Returns a random delay in [0, Jitter).
+func (s Schedule) jitter() time.Duration {
+	if s.Jitter <= 0 {
+		return 0
+	}
+
+	return rand.N(s.Jitter)
+}
+
+
+func (s Schedule) searchDays() int {
+	if len(s.MonthDays) == 0 && len(s.Months) == 0 {
+		return 7
+	}
+
+	return scheduleSearchDays
+}
+
+
+// Returns true if the schedule fires on the day of @date, read in its own time zone.
+func (s Schedule) firesOn(date time.Time) bool {
+	if len(s.Months) > 0 && !slices.Contains(s.Months, date.Month()) {
+		return false
+	}
+
+	onWeekday := len(s.Days) == 0 || slices.Contains(s.Days, date.Weekday())
+	onMonthDay := len(s.MonthDays) == 0 || slices.Contains(s.MonthDays, date.Day())
+
+	// as in cron, a day matching either list fires when both are set
+	if len(s.Days) > 0 && len(s.MonthDays) > 0 {
+		return onWeekday || onMonthDay
+	}
+
+	return onWeekday && onMonthDay
+}
+
+
+func (s Schedule) validate() error {
+	if s.Every < 0 {
+		return errors.New("Schedule interval can't be negative.")
+	}
+
+	if s.Every > 0 && len(s.Times) > 0 {
+		return errors.New("Schedule can have times or an interval, not both.")
+	}
+
+	// occurrences are told apart by their fire time in seconds, see addOccurrence
+	if s.Every > 0 && s.Every < time.Second {
+		return errors.New(fmt.Sprintf("Schedule interval %s must be at least a second.", s.Every))
+	}
+
+	if len(s.Times) == 0 && s.Every == 0 {
+		return errors.New("Schedule needs at least one time or an interval.")
+	}
+
+	if s.Jitter < 0 {
+		return errors.New("Schedule jitter can't be negative.")
+	}
+
+	for _, offset := range s.Times {
+		if offset < 0 || offset >= oneDay {
+			return errors.New(fmt.Sprintf("Schedule time %s must be within a single day.", offset))
+		}
+	}
+
+	for _, day := range s.MonthDays {
+		if day < 1 || day > 31 {
+			return errors.New(fmt.Sprintf("Schedule day of the month %d must be from 1 to 31.", day))
+		}
+	}
+
+	for _, month := range s.Months {
+		if month < time.January || month > time.December {
+			return errors.New(fmt.Sprintf("Schedule month %d is not valid.", month))
+		}
+	}
+
+	return nil
+}
+
+
+// Returns the wall clock time @offset after midnight on @date, built from its fields rather than by adding
+// to midnight, so a day that is 23 or 25 hours long (DST) doesn't shift it.
+func wallClock(date time.Time, offset time.Duration) time.Time {
+	hours := int(offset / time.Hour)
+	minutes := int(offset % time.Hour / time.Minute)
+	seconds := int(offset % time.Minute / time.Second)
+	nanos := int(offset % time.Second)
+
+	fire := time.Date(date.Year(), date.Month(), date.Day(), hours, minutes, seconds, nanos, date.Location())
+	if fire.Hour() == hours && fire.Minute() == minutes {
+		return fire
+	}
+
+	// the time was skipped by a DST change, read it with the offset from before the change instead
+	_, before := date.Zone()
+	zone := time.FixedZone("", before)
+	fire = time.Date(date.Year(), date.Month(), date.Day(), hours, minutes, seconds, nanos, zone)
+	return fire.In(date.Location())
+}
+
+
+// - Registers a recurring task under @name. While the queue is running, a task for @action is added at
+// every fire time of @schedule, with the external id "<name>@<fire time>" and @name as its action name
+// (see AddNamed). An occurrence that can't be added, e.g. because the queue is full, is skipped.
+// - Returns an error if a schedule is already registered under @name.
+func (q *FixedSizeQueue) AddSchedule(name string, schedule Schedule, action func(params map[string]interface{}) error, params map[string]interface{}) error {
+	err := schedule.validate()
+	if err != nil {
+		return err
+	}
+
+	if action == nil {
+		return errors.New(fmt.Sprintf("Action for schedule %s can't be nil.", name))
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if _, ok := q.schedules[name]; ok {
+		return errors.New(fmt.Sprintf("FixedSizeQueue %s already has a schedule named %s.", q.QualifiedName(), name))
+	}
+
+	if q.schedules == nil {
+		q.schedules = map[string]*scheduledJob{}
+	}
+
+	// tasks need params, even empty ones
+	if params == nil {
+		params = map[string]interface{}{}
+	}
+
+	job := &scheduledJob{name: name, schedule: schedule, action: action, params: params}
+	q.schedules[name] = job
+
+	if q.isRunning {
+		q.armSchedule(job, q.now())
+	}
+
+	return nil
+}
+
+
+// Removes the schedule registered under @name, tasks it already added are not affected. Returns false if
+// no schedule is registered under @name.
+func (q *FixedSizeQueue) RemoveSchedule(name string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	job, ok := q.schedules[name]
+	if !ok {
+		return false
+	}
+
+	q.disarmSchedule(job)
+	delete(q.schedules, name)
+	return true
+}
+
+
+// Returns the next @n fire times of the schedule registered under @name, in the schedule's time zone.
+func (q *FixedSizeQueue) NextRuns(name string, n int) ([]time.Time, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	job, ok := q.schedules[name]
+	if !ok {
+		return nil, ErrNoSchedule
+	}
+
+	return job.schedule.NextRuns(q.now(), n), nil
+}
+
+
+// Returns the state of the schedule registered under @name.
+func (q *FixedSizeQueue) ScheduleStatus(name string) (ScheduleStatus, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	job, ok := q.schedules[name]
+	if !ok {
+		return ScheduleStatus{}, ErrNoSchedule
+	}
+
+	return job.status(), nil
+}
+
+
+func (job *scheduledJob) status() ScheduleStatus {
+	status := ScheduleStatus{
+		Name: job.name,
+		Paused: job.paused,
+		Next: job.next,
+		LastFired: job.lastFired,
+		Deferred: job.deferred,
+	}
+
+	if job.lastBlackout != nil {
+		decision := *job.lastBlackout
+		status.LastBlackout = &decision
+	}
+
+	return status
+}
+
+
+func (q *FixedSizeQueue) startSchedules() {
+	now := q.now()
+
+	for _, job := range q.schedules {
+		if !job.paused {
+			q.armSchedule(job, now)
+		}
+	}
+}
+
+
+func (q *FixedSizeQueue) stopSchedules() {
+	for _, job := range q.schedules {
+		q.disarmSchedule(job)
+	}
+}
+
+
+// sets a timer for the first fire time of @job after @after
+func (q *FixedSizeQueue) armSchedule(job *scheduledJob, after time.Time) {
+	q.disarmSchedule(job)
+	q.setScheduleTimer(job, job.schedule.Next(after))
+}
+
+
+// sets a timer that fires @job at @at, nothing when @at is zero
+func (q *FixedSizeQueue) setScheduleTimer(job *scheduledJob, at time.Time) {
+	job.next = at
+	if at.IsZero() {
+		return
+	}
+
+	job.timer = time.AfterFunc(at.Sub(q.now()) + job.schedule.jitter(), func() {
+		q.fireSchedule(job, at)
+	})
+}
+
+
+func (q *FixedSizeQueue) disarmSchedule(job *scheduledJob) {
+	if job.timer != nil {
+		job.timer.Stop()
+	}
+
+	job.timer = nil
+	job.next = time.Time{}
+	job.deferred = time.Time{}
+}
+
+
+// - Runs in the timer's go routine. Adds the occurrence at @fireAt, then arms the schedule for the one after.
+// - An occurrence inside a blackout is skipped or deferred to the end of the blackout, see Schedule.Blackout.
+func (q *FixedSizeQueue) fireSchedule(job *scheduledJob, fireAt time.Time) {
+	q.mu.Lock()
+	// the schedule may have been removed, disarmed or re-armed since the timer was set
+	if q.schedules[job.name] != job || !job.next.Equal(fireAt) {
+		q.mu.Unlock()
+		return
+	}
+
+	// a deferred occurrence keeps the fire time it was deferred from
+	occurrence := fireAt
+	if !job.deferred.IsZero() {
+		occurrence = job.deferred
+	}
+
+	if q.holdForBlackout(job, occurrence, fireAt) {
+		q.mu.Unlock()
+		return
+	}
+	q.mu.Unlock()
+
+	if q.claimOccurrence(job, occurrence) {
+		q.addOccurrence(job, occurrence)
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.isRunning && q.schedules[job.name] == job && job.next.Equal(fireAt) {
+		// a deferred occurrence may end exactly on a fire time, which isn't blacked out
+		after := fireAt
+		if !occurrence.Equal(fireAt) {
+			after = fireAt.Add(-time.Nanosecond)
+		}
+
+		q.armSchedule(job, after)
+	}
+}
@@ -0,0 +1,207 @@
+package fsq
+
+import "fmt"
+import "errors"
+import "context"
+import "encoding/json"
+import "log/slog"
+import "os"
+import "path/filepath"
+import "sync"
+import "time"
+
+// the most missed occurrences RunAllMissed adds for a schedule when the queue starts
+const maxCatchUpRuns = 100
+
+// What a schedule does with the fire times it missed while the queue wasn't running (or the process was down).
+type CatchUpPolicy int
+
+const (
+	SkipMissed CatchUpPolicy = iota  //missed fire times are dropped
+	RunOnceMissed  //a single task is added for the latest missed fire time
+	RunAllMissed  //a task is added for every missed fire time, up to maxCatchUpRuns
+)
+
+// - Keeps the last fire time of each schedule, so fire times missed while the process was down can be
+// caught up when the queue starts again (see CatchUpPolicy and SetScheduleStore).
+// - Calls for different schedules may happen at once.
+type ScheduleStore interface {
+	LastFired(name string) (time.Time, bool, error)
+	SetLastFired(name string, at time.Time) error
+}
+
+// A ScheduleStore that keeps last fire times in a JSON file.
+type FileScheduleStore struct {
+	mu sync.Mutex
+	path string
+}
+
+
+// Returns a store that keeps last fire times in the file at @path, which is created on the first write.
+func NewFileScheduleStore(path string) *FileScheduleStore {
+	return &FileScheduleStore{path: path}
+}
+
+
+func (s *FileScheduleStore) LastFired(name string) (time.Time, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fired, err := s.read()
+	if err != nil {
+		return time.Time{}, false, err
+	}
+
+	at, ok := fired[name]
+	return at, ok, nil
+}
+
+
+// Replaces the file as a whole, so a crash during a write leaves the previous version.
+func (s *FileScheduleStore) SetLastFired(name string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fired, err := s.read()
+	if err != nil {
+		return err
+	}
+
+	fired[name] = at
+
+	data, err := json.Marshal(fired)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path) + ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	closeErr := tmp.Close()
+	if err != nil {
+		return err
+	}
+
+	if closeErr != nil {
+		return closeErr
+	}
+
+	return os.Rename(tmp.Name(), s.path)
+}
+
+
+func (s *FileScheduleStore) read() (map[string]time.Time, error) {
+	fired := map[string]time.Time{}
+
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return fired, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(data, &fired)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Schedule store %s can't be read: %s", s.path, err))
+	}
+
+	return fired, nil
+}
+
+
+// - Sets the store that keeps the last fire time of each schedule across restarts. Without a store, fire
+// times are only remembered while the process runs, so only those missed while the queue was stopped are caught up.
+// - A nil @store removes it.
+func (q *FixedSizeQueue) SetScheduleStore(store ScheduleStore) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.scheduleStore = store
+}
+
+
+// an occurrence of a schedule, added outside the lock
+type occurrence struct {
+	job *scheduledJob
+	at time.Time
+}
+
+
+// Called by Start with the lock held, returns the occurrences missed since each schedule last fired, as
+// its CatchUpPolicy asks for.
+func (q *FixedSizeQueue) missedOccurrences(now time.Time) []occurrence {
+	missed := []occurrence{}
+
+	for _, job := range q.schedules {
+		if job.schedule.CatchUp == SkipMissed || job.paused {
+			continue
+		}
+
+		last := job.lastFired
+		if last.IsZero() && q.scheduleStore != nil {
+			var err error
+			last, _, err = q.scheduleStore.LastFired(job.name)
+			if err != nil {
+				q.logError("reading the last fire time failed", err, slog.String("schedule", job.name))
+			}
+		}
+
+		if last.IsZero() {
+			continue
+		}
+
+		runs := []time.Time{}
+		for at := job.schedule.Next(last); !at.IsZero() && !at.After(now); at = job.schedule.Next(at) {
+			if _, _, ok := job.schedule.blackedOut(at); ok && job.schedule.Blackout == SkipBlackedOut {
+				continue
+			}
+
+			runs = append(runs, at)
+
+			// keep the latest ones
+			if len(runs) > maxCatchUpRuns {
+				runs = runs[1:]
+			}
+		}
+
+		if len(runs) > 0 && job.schedule.CatchUp == RunOnceMissed {
+			runs = runs[len(runs) - 1:]
+		}
+
+		for _, at := range runs {
+			missed = append(missed, occurrence{job: job, at: at})
+		}
+	}
+
+	return missed
+}
+
+
+// - Adds the task for @job's occurrence at @at and records it as the last fire time. Takes the lock.
+// - Returns the error of adding the task, the fire time is recorded either way.
+func (q *FixedSizeQueue) addOccurrence(job *scheduledJob, at time.Time) error {
+	id := fmt.Sprintf("%s@%s", job.name, at.Format(time.RFC3339))
+	err := q.add(context.Background(), job.action, job.params, id, addOptions{actionName: job.name})
+
+	q.mu.Lock()
+	if at.After(job.lastFired) {
+		job.lastFired = at
+	}
+	store := q.scheduleStore
+	q.mu.Unlock()
+
+	if store != nil {
+		storeErr := store.SetLastFired(job.name, at)
+		if storeErr != nil {
+			q.logError("storing the last fire time failed", storeErr, slog.String("schedule", job.name))
+		}
+	}
+
+	return err
+}
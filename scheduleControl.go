@@ -0,0 +1,76 @@
+package fsq
+
+import "sort"
+
+
+// Returns the state of every schedule registered with the queue, sorted by name.
+func (q *FixedSizeQueue) Schedules() []ScheduleStatus {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	statuses := make([]ScheduleStatus, 0, len(q.schedules))
+	for _, job := range q.schedules {
+		statuses = append(statuses, job.status())
+	}
+
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	return statuses
+}
+
+
+// - Stops the schedule registered under @name from firing until ResumeSchedule is called, tasks it already
+// added are not affected. Pausing a paused schedule does nothing.
+// - Fire times passed while paused are not caught up.
+func (q *FixedSizeQueue) PauseSchedule(name string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	job, ok := q.schedules[name]
+	if !ok {
+		return ErrNoSchedule
+	}
+
+	job.paused = true
+	q.disarmSchedule(job)
+	return nil
+}
+
+
+// Lets a paused schedule fire again, from its next fire time on. Resuming a schedule that isn't paused does nothing.
+func (q *FixedSizeQueue) ResumeSchedule(name string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	job, ok := q.schedules[name]
+	if !ok {
+		return ErrNoSchedule
+	}
+
+	if !job.paused {
+		return nil
+	}
+
+	job.paused = false
+	if q.isRunning {
+		q.armSchedule(job, q.now())
+	}
+
+	return nil
+}
+
+
+// - Adds a task for the schedule registered under @name right away, outside of its fire times and blackouts.
+// Works for paused schedules too, and doesn't change when the schedule fires next.
+// - Returns the error of adding the task, e.g. if the queue is full.
+func (q *FixedSizeQueue) TriggerSchedule(name string) error {
+	q.mu.Lock()
+	job, ok := q.schedules[name]
+	now := q.now()
+	q.mu.Unlock()
+
+	if !ok {
+		return ErrNoSchedule
+	}
+
+	return q.addOccurrence(job, now)
+}
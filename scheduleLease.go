@@ -0,0 +1,146 @@
+package fsq
+
+import "errors"
+import "log/slog"
+import "net/url"
+import "os"
+import "path/filepath"
+import "strconv"
+import "strings"
+import "sync"
+import "time"
+
+// how long a DirScheduleLeaser keeps the claim files of past occurrences
+const dirLeaseRetention = 24 * time.Hour
+
+// - Decides which of many instances fires an occurrence of a schedule they share, so each occurrence runs
+// on exactly one of them (see SetScheduleLeaser). Backed by something all instances can reach.
+// - Claim returns true for exactly one caller per schedule @name and fire time @at. @holder identifies the
+// calling instance, for diagnostics.
+type ScheduleLeaser interface {
+	Claim(name string, at time.Time, holder string) (bool, error)
+}
+
+// A ScheduleLeaser for instances in a single process, e.g. several queues sharing a schedule.
+type MemoryScheduleLeaser struct {
+	mu sync.Mutex
+	claimed map[string]time.Time  //latest claimed fire time by schedule name
+}
+
+// A ScheduleLeaser for instances sharing a directory, e.g. on a network file system. Each occurrence is
+// claimed by exclusively creating a file for it, which holds the holder's name.
+type DirScheduleLeaser struct {
+	dir string
+}
+
+
+func NewMemoryScheduleLeaser() *MemoryScheduleLeaser {
+	return &MemoryScheduleLeaser{claimed: map[string]time.Time{}}
+}
+
+
+// Claims succeed in fire time order, an occurrence at or before the latest claimed one can't be claimed.
+func (l *MemoryScheduleLeaser) Claim(name string, at time.Time, holder string) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !at.After(l.claimed[name]) {
+		return false, nil
+	}
+
+	l.claimed[name] = at
+	return true, nil
+}
+
+
+// Returns a leaser that keeps its claims in @dir, which is created if needed.
+func NewDirScheduleLeaser(dir string) *DirScheduleLeaser {
+	return &DirScheduleLeaser{dir: dir}
+}
+
+
+// Claim files older than a day before @at are removed once the claim is made.
+func (l *DirScheduleLeaser) Claim(name string, at time.Time, holder string) (bool, error) {
+	err := os.MkdirAll(l.dir, 0755)
+	if err != nil {
+		return false, err
+	}
+
+	prefix := url.PathEscape(name) + "@"
+	path := filepath.Join(l.dir, prefix + strconv.FormatInt(at.UnixNano(), 10))
+
+	file, err := os.OpenFile(path, os.O_WRONLY | os.O_CREATE | os.O_EXCL, 0644)
+	if errors.Is(err, os.ErrExist) {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	_, err = file.WriteString(holder)
+	file.Close()
+	if err != nil {
+		return true, err
+	}
+
+	l.prune(prefix, at.Add(-dirLeaseRetention))
+	return true, nil
+}
+
+
+// removes the claim files starting with @prefix for fire times before @before
+func (l *DirScheduleLeaser) prune(prefix string, before time.Time) {
+	entries, err := os.ReadDir(l.dir)
+	if err != nil {
+		return
+	}
+
+	for _, entry := range entries {
+		nanos, ok := strings.CutPrefix(entry.Name(), prefix)
+		if !ok {
+			continue
+		}
+
+		at, err := strconv.ParseInt(nanos, 10, 64)
+		if err == nil && at < before.UnixNano() {
+			os.Remove(filepath.Join(l.dir, entry.Name()))
+		}
+	}
+}
+
+
+// - Makes every schedule of the queue fire each occurrence only if it can claim it from @leaser, so
+// instances sharing the schedules (and the leaser) don't all run it. @holder names this instance.
+// - Applies to fire times and catch-up, not to TriggerSchedule. An occurrence that can't be claimed, or
+// whose claim fails, is left to the other instances.
+// - A nil @leaser removes it.
+func (q *FixedSizeQueue) SetScheduleLeaser(leaser ScheduleLeaser, holder string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.scheduleLeaser = leaser
+	q.scheduleHolder = holder
+}
+
+
+// Returns true if this instance may fire @job's occurrence at @at. Takes the lock to read the leaser,
+// which is called without it.
+func (q *FixedSizeQueue) claimOccurrence(job *scheduledJob, at time.Time) bool {
+	q.mu.Lock()
+	leaser := q.scheduleLeaser
+	holder := q.scheduleHolder
+	q.mu.Unlock()
+
+	if leaser == nil {
+		return true
+	}
+
+	claimed, err := leaser.Claim(job.name, at, holder)
+	if err != nil {
+		q.logError("claiming the occurrence failed", err, slog.String("schedule", job.name), slog.Time("at", at))
+		return false
+	}
+
+	return claimed
+}
@@ -0,0 +1,245 @@
+package fsq
+
+import "fmt"
+import "errors"
+import "context"
+import "sort"
+import "strings"
+import "sync"
+import "time"
+
+// How the shutdown of a single queue went, see Registry.ShutdownAll.
+type ShutdownReport struct {
+	Queue string  //qualified name
+	Drained bool  //every waiting and processing task finished before the deadline
+	Waiting int  //tasks still waiting (or parked) when the deadline passed, 0 when drained
+	Processing int  //tasks still processing when the deadline passed, 0 when drained
+	Took time.Duration
+	Err error  //a *DrainError listing the unfinished tasks (it wraps the context's error) when the deadline passed, nil when drained
+}
+
+
+// The error of the EventFailed event of a waiting task discarded by StopNow.
+var ErrTaskDiscarded = errors.New("Task was discarded when the queue was stopped.")
+
+// A waiting task discarded by StopNow, with its params so it can be added again (e.g. to another queue).
+type DiscardedTask struct {
+	TaskView
+	Params map[string]interface{}
+}
+
+// The error of StopAndDrain when tasks were left unfinished.
+type DrainError struct {
+	Queue string  //qualified name
+	Waiting []string  //external ids of the tasks still waiting (or parked or delayed), in dispatch order
+	Processing []string  //external ids of the tasks still processing, sorted
+	Err error  //the context's error
+}
+
+
+func (e *DrainError) Error() string {
+	unfinished := append(append([]string{}, e.Processing...), e.Waiting...)
+	return fmt.Sprintf("FixedSizeQueue %s stopped with %d unfinished tasks (%s): %s", e.Queue, len(unfinished), strings.Join(unfinished, ", "), e.Err)
+}
+
+
+func (e *DrainError) Unwrap() error {
+	return e.Err
+}
+
+
+// - Stops the queue gracefully: Adds are rejected right away, then the waiting and processing tasks are given
+// until @ctx is done to finish. Delayed tasks and tasks waiting for a retry are waited for too.
+// - The contexts of context actions (see AddContextAction) are only cancelled once the tasks finished or @ctx is
+// done, so in-flight actions aren't told to give up while there is time left.
+// - Returns nil if every task finished, a *DrainError listing the unfinished tasks otherwise. Those are left
+// to run, see StopNow to discard them.
+func (q *FixedSizeQueue) StopAndDrain(ctx context.Context) error {
+	q.mu.Lock()
+	q.stopIntake()
+	q.mu.Unlock()
+
+	err := q.waitIdle(ctx)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.stopBaseContext()
+	if err == nil {
+		return nil
+	}
+
+	return q.drainError(err)
+}
+
+
+// Returns the *DrainError listing the queue's unfinished tasks after draining failed with @err.
+func (q *FixedSizeQueue) drainError(err error) *DrainError {
+	drainErr := &DrainError{Queue: q.QualifiedName(), Waiting: []string{}, Processing: []string{}, Err: err}
+
+	waiting := append(q.waitingInOrder(), q.parkedTasks()...)
+
+	for _, task := range waiting {
+		drainErr.Waiting = append(drainErr.Waiting, task.externalId)
+	}
+
+	for _, task := range q.tasksById {
+		if task.state == processing {
+			drainErr.Processing = append(drainErr.Processing, task.externalId)
+		}
+	}
+	sort.Strings(drainErr.Processing)
+
+	return drainErr
+}
+
+
+// - Stops the queue right away: Adds are rejected, the contexts of processing tasks are cancelled (see
+// AddContextAction) and the waiting tasks are discarded, parked and delayed ones included. Returns the
+// discarded tasks in dispatch order, parked ones last.
+// - Discarded tasks end with an EventFailed event whose error is ErrTaskDiscarded (ReasonQueueShutdown),
+// their failure callbacks are called before StopNow returns.
+// - No task is dispatched after StopNow, so no new go routine runs an action until the queue is started again.
+// Processing tasks end as their action returns, plain actions can't be stopped.
+func (q *FixedSizeQueue) StopNow() []DiscardedTask {
+	q.mu.Lock()
+
+	q.stopIntake()
+	q.stopBaseContext()
+	q.halted = true
+
+	waiting := append(q.waitingInOrder(), q.parkedTasks()...)
+
+	discarded := make([]DiscardedTask, 0, len(waiting))
+	callbacks := []func(){}
+
+	for _, task := range waiting {
+		discarded = append(discarded, DiscardedTask{TaskView: q.taskView(task), Params: task.params})
+		q.stats.Discarded++
+		q.trace(task.externalId, "discarded", "the queue was stopped")
+
+		if done := q.withdraw(task, ErrTaskDiscarded, ReasonQueueShutdown); done != nil {
+			callbacks = append(callbacks, done)
+		}
+	}
+
+	q.mu.Unlock()
+
+	for _, done := range callbacks {
+		done()
+	}
+
+	return discarded
+}
+
+
+// - Shuts every registered queue down in one call, e.g. when the process exits: stops intake on every
+// queue right away, then waits for all of them to drain in parallel, until @ctx is done.
+// - As with StopAndDrain, the contexts of context actions are only cancelled once a queue drained or @ctx is done.
+// - Returns a report per queue, ordered by qualified name. Queues that didn't drain in time report what was
+// left, their tasks keep running. The queues stay registered.
+func (r *Registry) ShutdownAll(ctx context.Context) []ShutdownReport {
+	return shutdownAll(ctx, r.List())
+}
+
+
+// Same as Registry.ShutdownAll, on the registry shared by the process.
+func ShutdownAll(ctx context.Context) []ShutdownReport {
+	return defaultRegistry.ShutdownAll(ctx)
+}
+
+
+// Same as Registry.ShutdownAll, for the queues of the mux. The reports are in the order the queues were
+// given to the mux.
+func (m *Mux) ShutdownAll(ctx context.Context) []ShutdownReport {
+	return shutdownAll(ctx, m.queues)
+}
+
+
+// stops intake on @queues, then drains them in parallel until @ctx is done. Takes the queues' locks.
+func shutdownAll(ctx context.Context, queues []*FixedSizeQueue) []ShutdownReport {
+	for _, q := range queues {
+		q.mu.Lock()
+		q.stopIntake()
+		q.mu.Unlock()
+	}
+
+	reports := make([]ShutdownReport, len(queues))
+	start := time.Now()
+
+	var wg sync.WaitGroup
+	for i, q := range queues {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			err := q.waitIdle(ctx)
+			report := ShutdownReport{
+				Queue: q.QualifiedName(),
+				Drained: err == nil,
+				Took: time.Since(start),
+				Err: err,
+			}
+
+			q.mu.Lock()
+			if err != nil {
+				report.Waiting = q.countWaiting()
+				report.Processing = q.countProcessing
+				report.Err = q.drainError(err)
+			}
+			q.stopBaseContext()
+			q.mu.Unlock()
+
+			reports[i] = report
+		}()
+	}
+
+	wg.Wait()
+	return reports
+}
+
+
+// Waits until the queue has no waiting or processing tasks, or @ctx is done. Takes the lock.
+func (q *FixedSizeQueue) waitIdle(ctx context.Context) error {
+	q.mu.Lock()
+	if q.isIdle() {
+		q.mu.Unlock()
+		return nil
+	}
+
+	idle := make(chan struct{})
+	q.idleWaiters = append(q.idleWaiters, idle)
+	q.mu.Unlock()
+
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+
+// Called once a task completed, releases the idleWaiters if nothing is left.
+func (q *FixedSizeQueue) notifyIdle() {
+	if len(q.idleWaiters) == 0 || !q.isIdle() {
+		return
+	}
+
+	for _, idle := range q.idleWaiters {
+		close(idle)
+	}
+	q.idleWaiters = nil
+}
+
+
+func (q *FixedSizeQueue) isIdle() bool {
+	return q.countProcessing == 0 && q.countWaiting() == 0
+}
+
+
+// returns the number of waiting tasks, parked and delayed ones included
+func (q *FixedSizeQueue) countWaiting() int {
+	return q.items.Len() + len(q.overflow) + len(q.delayed) + q.countParked()
+}
@@ -0,0 +1,181 @@
+package fsq
+
+import "fmt"
+import "errors"
+import "strconv"
+
+// How close a queue with soft capacity is to rejecting tasks, see SetSoftCapacity.
+type PressureLevel int
+
+const (
+	PressureNone PressureLevel = iota  //the overflow is empty
+	PressureElevated  //tasks are waiting in the overflow
+	PressureHigh  //the overflow is at least half full
+	PressureCritical  //the overflow is at least 90% full, Adds are about to be rejected
+)
+
+// Overflow accounting of a queue with soft capacity, see OverflowStats.
+type OverflowStats struct {
+	Limit int  //0 when the capacity is hard
+	InUse int  //tasks waiting in the overflow
+	Peak int  //the most tasks that waited in the overflow at once
+	Accepted int  //Adds accepted into the overflow
+	Rejected int  //Adds rejected because the overflow was full too
+	Pressure PressureLevel
+}
+
+
+// - Makes the queue's capacity soft: once the queue is full, up to @overflow more tasks are accepted into an
+// overflow instead of being rejected, so brief bursts don't fail. Adds are only rejected once the overflow is full
+// too, so sustained overload still does.
+// - Overflow tasks keep their order and move into the queue as it frees up, they are dispatched after
+// the tasks that were in the queue before them.
+// - The fuller the overflow, the higher the queue's pressure level. Every change is published as an
+// EventPressure event, so producers can slow down before they get rejected. See also OverflowStats.
+// - An @overflow <= 0 makes the capacity hard again (the default). Tasks already in the overflow stay.
+func (q *FixedSizeQueue) SetSoftCapacity(overflow int) {
+	if overflow < 0 {
+		overflow = 0
+	}
+
+	q.changeConfig("", 0, false, "softCapacity", func() (string, string, error) {
+		oldValue := strconv.Itoa(q.overflowLimit)
+		q.overflowLimit = overflow
+		q.notePressure("")
+		q.notifyRoom()
+		return oldValue, strconv.Itoa(q.overflowLimit), nil
+	})
+}
+
+
+// Returns the overflow accounting of the queue, see SetSoftCapacity.
+func (q *FixedSizeQueue) OverflowStats() OverflowStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	stats := q.overflowStats
+	stats.Limit = q.overflowLimit
+	stats.InUse = len(q.overflow)
+	stats.Pressure = q.pressure
+	return stats
+}
+
+
+// Called by Add when the queue is full, returns true if the task may go to the overflow. Counts rejections.
+func (q *FixedSizeQueue) admitOverflow() bool {
+	if len(q.overflow) < q.overflowLimit {
+		return true
+	}
+
+	if q.overflowLimit > 0 {
+		q.overflowStats.Rejected++
+	}
+
+	return false
+}
+
+
+// puts @task in the overflow, called by Add once the task was set up
+func (q *FixedSizeQueue) enqueueOverflow(task *task) {
+	q.overflow = append(q.overflow, task)
+	q.overflowStats.Accepted++
+	q.overflowStats.Peak = max(q.overflowStats.Peak, len(q.overflow))
+	q.notePressure(task.externalId)
+}
+
+
+// Moves tasks from the overflow into the queue while it has room. Called whenever a waiting task left the queue.
+func (q *FixedSizeQueue) refillFromOverflow() {
+	moved := false
+
+	for len(q.overflow) > 0 && !q.isFull() {
+		// Don't expect this to fail since isFull was checked.
+		q.enqueueWaiting(q.overflow[0])
+		q.overflow = q.overflow[1:]
+		moved = true
+	}
+
+	if moved {
+		q.notePressure("")
+	}
+}
+
+
+// Takes @waitingTask out of the overflow, returns false if it isn't there.
+func (q *FixedSizeQueue) removeOverflow(waitingTask *task) bool {
+	for i, task := range q.overflow {
+		if task == waitingTask {
+			q.overflow = append(q.overflow[:i:i], q.overflow[i + 1:]...)
+			q.notePressure("")
+			return true
+		}
+	}
+
+	return false
+}
+
+
+// Updates the pressure level and publishes an EventPressure event if it changed. @externalId is the task
+// that caused the change, empty if none did.
+func (q *FixedSizeQueue) notePressure(externalId string) {
+	level := PressureNone
+	inUse := len(q.overflow)
+
+	switch {
+	case inUse == 0:
+	case q.overflowLimit > 0 && inUse * 10 >= q.overflowLimit * 9:
+		level = PressureCritical
+	case q.overflowLimit > 0 && inUse * 2 >= q.overflowLimit:
+		level = PressureHigh
+	default:
+		level = PressureElevated
+	}
+
+	if level == q.pressure {
+		return
+	}
+
+	q.pressure = level
+	q.publish(Event{
+		Type: EventPressure,
+		Queue: q.QualifiedName(),
+		ExternalId: externalId,
+		Pressure: level,
+		At: q.now(),
+	})
+}
+
+
+func (l PressureLevel) String() string {
+	switch l {
+	case PressureNone:
+		return "none"
+	case PressureElevated:
+		return "elevated"
+	case PressureHigh:
+		return "high"
+	case PressureCritical:
+		return "critical"
+	}
+
+	return "unknown"
+}
+
+
+// Pressure levels are written as their name in JSON.
+func (l PressureLevel) MarshalText() ([]byte, error) {
+	return []byte(l.String()), nil
+}
+
+
+// Reads a pressure level written by MarshalText, e.g. in a peer's QueueView.
+func (l *PressureLevel) UnmarshalText(text []byte) error {
+	for level := PressureNone; level <= PressureCritical; level++ {
+		if level.String() == string(text) {
+			*l = level
+			return nil
+		}
+	}
+
+	return errors.New(fmt.Sprintf("Unknown pressure level %q.", text))
+}
@@ -0,0 +1,89 @@
+package fsq
+
+import "time"
+
+// Activity counters of a queue over its current stats epoch, and its current load. See Stats and ResetStats.
+type Stats struct {
+	Epoch uint64  //0 until ResetStats is first called, increased by every call
+	Since time.Time  //when the epoch began: when the queue was created, or ResetStats was last called
+	Enqueued int  //tasks added to the queue, moved ones included
+	Rejected int  //Adds that failed
+	Completed int  //tasks whose action returned nil
+	Failed int  //tasks whose action returned an error on their last run
+	Retried int  //runs that failed and were retried, see SetRetryPolicy
+	Errors ClassCounts  //runs whose action returned an error by the error's class, retried ones included. See ClassOf.
+	DeadLettered int  //failed tasks kept as dead letters, see SetDeadLetters
+	Abandoned int  //tasks abandoned while processing, see SetAbandonTimeout
+	Dropped int  //waiting tasks dropped past the max task age, see SetMaxTaskAge
+	Discarded int  //waiting tasks discarded by StopNow
+	Cancelled int  //waiting tasks withdrawn by Cancel
+	Waiting int  //tasks waiting now, parked, delayed and overflowing ones included
+	Processing int  //tasks processing now
+	Capacity int  //max number of waiting tasks, not counting the overflow
+	ReadyPool int  //tasks ready to be reused by the next Adds
+	Preallocated int  //tasks created up front, see InitPreallocated
+	Budgets []BudgetSpend  //the spend of every budget by tenant, see BudgetSpends
+}
+
+
+// - Returns the activity counters of the queue since the current stats epoch began, and its current load.
+// - The counters and the load are read under the queue's lock, so they are consistent with each other.
+func (q *FixedSizeQueue) Stats() Stats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.currentStats()
+}
+
+
+// - Starts a new stats epoch: the counters of Stats, Fairness and OverflowStats start over from zero, so
+// dashboards and tests can measure activity over a bounded interval instead of the queue's whole lifetime.
+// - Returns the counters of the epoch that ended. The overflow's peak starts over at its current use.
+func (q *FixedSizeQueue) ResetStats() Stats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	ended := q.currentStats()
+	q.stats = Stats{Epoch: ended.Epoch + 1, Since: q.now()}
+	q.fairness = nil
+	q.overflowStats = OverflowStats{Peak: len(q.overflow)}
+	return ended
+}
+
+
+func (q *FixedSizeQueue) currentStats() Stats {
+	stats := q.stats
+	if stats.Since.IsZero() {
+		stats.Since = q.createdAt
+	}
+
+	stats.Waiting = q.countWaiting()
+	stats.Processing = q.countProcessing
+	stats.Capacity = q.capacity()
+	stats.ReadyPool = len(*q.readyTaskPool)
+	stats.Preallocated = q.preallocated
+	stats.Budgets = q.budgetSpends()
+	return stats
+}
+
+
+// adds the counters and the load of @other to the stats
+func (s *Stats) add(other Stats) {
+	s.Enqueued += other.Enqueued
+	s.Rejected += other.Rejected
+	s.Completed += other.Completed
+	s.Failed += other.Failed
+	s.Retried += other.Retried
+	s.Errors.add(other.Errors)
+	s.DeadLettered += other.DeadLettered
+	s.Abandoned += other.Abandoned
+	s.Dropped += other.Dropped
+	s.Discarded += other.Discarded
+	s.Cancelled += other.Cancelled
+	s.Waiting += other.Waiting
+	s.Processing += other.Processing
+	s.Capacity += other.Capacity
+	s.ReadyPool += other.ReadyPool
+	s.Preallocated += other.Preallocated
+	s.Budgets = append(s.Budgets, other.Budgets...)
+}
@@ -0,0 +1,70 @@
+package fsq
+
+import "context"
+
+
+// - Same as Add, but @ctx bounds the submission: if it is done before the task is in the queue (including
+// while waiting for the queue's lock), the task isn't added and the context's error is returned.
+// - @ctx only concerns adding the task. It is not the context the task's action runs with, cancelling it
+// after Add returned doesn't affect the task.
+// - @opts change how the task is handled, as for Add.
+func (q *FixedSizeQueue) AddContext(ctx context.Context, action func(params map[string]interface{}) error, params map[string]interface{}, id string, opts ...AddOption) error {
+	options, err := applyAddOptions(opts)
+	if err != nil {
+		return err
+	}
+
+	return q.add(ctx, action, params, id, options)
+}
+
+
+// - Same as AddContext, but while the queue has no room for the task, AddWait blocks until a slot frees up (or
+// there is room in the overflow, see SetSoftCapacity) instead of failing, so callers don't need retry loops.
+// - Returns the context's error if @ctx is done first. Other errors (e.g. a duplicate id, or the queue being
+// stopped while waiting) are returned right away.
+func (q *FixedSizeQueue) AddWait(ctx context.Context, action func(params map[string]interface{}) error, params map[string]interface{}, id string, opts ...AddOption) error {
+	options, err := applyAddOptions(opts)
+	if err != nil {
+		return err
+	}
+
+	options.wait = true
+	return q.add(ctx, action, params, id, options)
+}
+
+
+// Returns true if a task that isn't delayed can be added without exceeding the capacity, see enqueue.
+func (q *FixedSizeQueue) hasRoom() bool {
+	if !q.isFull() && len(q.overflow) == 0 {
+		return true
+	}
+
+	return len(q.overflow) < q.overflowLimit
+}
+
+
+// Waits until room may have opened in the queue or @ctx is done. Called and returns with the lock held,
+// releases it while waiting.
+func (q *FixedSizeQueue) waitForRoom(ctx context.Context) error {
+	room := make(chan struct{})
+	q.roomWaiters = append(q.roomWaiters, room)
+
+	q.mu.Unlock()
+	defer q.mu.Lock()
+
+	select {
+	case <-room:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+
+// Called whenever a slot may have freed up, wakes the Adds waiting for room. They check again.
+func (q *FixedSizeQueue) notifyRoom() {
+	for _, room := range q.roomWaiters {
+		close(room)
+	}
+	q.roomWaiters = nil
+}
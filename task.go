@@ -1,26 +1,88 @@
 package fsq
 
 import "errors"
+import "context"
+import "sync/atomic"
+import "time"
+
+import "go.opentelemetry.io/otel/trace"
 
 // valid task state values
 const ready string = "r"
 const waiting string = "w"
 const processing string = "p"
+const abandoned string = "a"
 
 type task struct {
 	state string
 	action func(params map[string]interface{}) error
+	ctxAction ContextAction  //used instead of action when set
+	deadline time.Time  //zero when the task has no deadline
+	ctx context.Context  //the context ctxAction runs with, set when the task is dispatched
+	cancel context.CancelFunc  //releases ctx once the action returned
+	result *runResult  //what the action reported with SetResult in the current run, nil until the task is dispatched
 	params map[string]interface{}  //should be passed to the "action" func
+	runParams map[string]interface{}  //the params of the current run with templates expanded, nil to pass params. See SetParamTemplates.
 	id int  //a non mutable (by convention) id that is constant as tasks are re-used
 	externalId string  //a mutable "id" that allows users of this package to give an id to the task. The idea is to prevent duplication of tasks, such that a task will not be created if it shares the same id with a task that has a waiting state.
+	cost int  //units charged against the queue's budgets, 0 when the task was added without a cost
+	tenant string  //the tenant whose budget the cost is charged against
+	actionName string  //optional name of the action, used to look up per action settings such as guards
+	byName bool  //true if the action is the one registered under actionName
+	enqueuedAt time.Time  //when the task was added, read from the queue's clock
+	startedAt time.Time  //when the task was last dispatched
+	run uint64  //increased on every dispatch and kept when the task is re-used, tells a timer's run from a later one
+	settled atomic.Bool  //set once a dispatched task either completed or was abandoned, whichever happened first
+	turn <-chan struct{}  //closed once the task dispatched before it called its action, nil outside strict FIFO mode
+	started chan struct{}  //closed once this task called its action (or gave up its turn), nil outside strict FIFO mode
+	starving bool  //an EventStarving event was published for the task while it waited
+	release func()  //releases the task's payload once the action returned (or wasn't called), nil without one
+	dedupKey string  //the key the task is a duplicate under while it waits, empty without one. See SetDedupKey.
+	onSuccess func()  //see AddWithCallbacks
+	onFailure func(err error)
+	handle *TaskHandle  //resolved once the task ended, nil without one. See WithHandle.
+	priority Priority
+	seq uint64  //when the task joined the waiting tasks, see FixedSizeQueue.relevel
+	notBefore time.Time  //the task isn't dispatched before, zero when it was added without a delay. See AddAfter.
+	attempt int  //runs of the task's action so far, see SetRetryPolicy
+	lastErr error  //the error of the task's latest run, while it waits for a retry
+	oneShot bool  //the task can't be retried, e.g. because its payload was read
+	timeout time.Duration  //the task fails once its action ran this long, 0 for never. See AddWithTimeout.
+	maxAttempts int  //overrides the retry policy's MaxAttempts when > 0, see WithRetries
+	traceParent trace.SpanContext  //parent of the task's wait and execute spans, invalid when not traced. See SetTracerProvider.
+	span trace.Span  //execute span of the current run, nil when not traced
+	waitingSince time.Time  //when the task began waiting for its next run, for its wait span
 }
 
 
 func (t *task) Clean() {
 	t.SetStateReady()
 	t.SetAction(nil)
+	t.SetContextAction(nil, time.Time{})
+	t.SetContext(nil, nil)
+	t.SetResult(nil)
 	t.SetParams(nil)
+	t.SetRunParams(nil)
 	t.SetExternalId("")
+	t.SetCost(0, "")
+	t.SetActionName("")
+	t.SetByName(false)
+	t.SetTurn(nil, nil)
+	t.SetRelease(nil)
+	t.SetDedupKey("")
+	t.SetCallbacks(nil, nil)
+	t.SetHandle(nil)
+	t.SetPriority(PriorityNormal)
+	t.SetNotBefore(time.Time{})
+	t.SetAttempt(0)
+	t.SetLastErr(nil)
+	t.SetOneShot(false)
+	t.SetTimeout(0)
+	t.SetMaxAttempts(0)
+	t.SetTraceParent(trace.SpanContext{})
+	t.SetSpan(nil)
+	t.SetWaitingSince(time.Time{})
+	t.starving = false
 }
 
 
@@ -39,12 +101,24 @@ func (t *task) SetStateProcessing() {
 }
 
 
+func (t *task) SetStateAbandoned() {
+	t.state = abandoned
+}
+
+
 func (t *task) CallAction() error {
-	if t.action == nil || t.params == nil {
+	t.takeTurn()
+
+	if (t.action == nil && t.ctxAction == nil) || t.params == nil {
 		// Don't expect this to happen, adding for safety.
 		return errors.New("Task action and/or params are nil, cannot make call.")
 	}
-	return t.action(t.params)
+
+	if t.ctxAction != nil {
+		return t.ctxAction(t.ctx, t.paramsOfRun())
+	}
+
+	return t.action(t.paramsOfRun())
 }
 
 
@@ -53,11 +127,57 @@ func (t *task) SetAction(action func(params map[string]interface{}) error) {
 }
 
 
+func (t *task) SetContextAction(ctxAction ContextAction, deadline time.Time) {
+	t.ctxAction = ctxAction
+	t.deadline = deadline
+}
+
+
+func (t *task) SetRelease(release func()) {
+	t.release = release
+}
+
+
+// Releases the task's payload, if it has one. Only the first call does anything.
+func (t *task) releasePayload() {
+	if t.release != nil {
+		t.release()
+		t.release = nil
+	}
+}
+
+
+func (t *task) SetContext(ctx context.Context, cancel context.CancelFunc) {
+	t.ctx = ctx
+	t.cancel = cancel
+}
+
+
+func (t *task) SetResult(result *runResult) {
+	t.result = result
+}
+
+
 func (t *task) SetParams(params map[string]interface{}) {
 	t.params = params
 }
 
 
+func (t *task) SetRunParams(params map[string]interface{}) {
+	t.runParams = params
+}
+
+
+// Returns the params the task's action is called with in its current run.
+func (t *task) paramsOfRun() map[string]interface{} {
+	if t.runParams != nil {
+		return t.runParams
+	}
+
+	return t.params
+}
+
+
 func (t *task) SetExternalId(externalId string) {
 	t.externalId = externalId
 }
@@ -65,4 +185,141 @@ func (t *task) SetExternalId(externalId string) {
 
 func (t *task) SetId(id int) {
 	t.id = id
-}
\ No newline at end of file
+}
+
+
+func (t *task) SetCost(cost int, tenant string) {
+	t.cost = cost
+	t.tenant = tenant
+}
+
+
+func (t *task) SetActionName(actionName string) {
+	t.actionName = actionName
+}
+
+
+func (t *task) SetByName(byName bool) {
+	t.byName = byName
+}
+
+
+func (t *task) SetCallbacks(onSuccess func(), onFailure func(err error)) {
+	t.onSuccess = onSuccess
+	t.onFailure = onFailure
+}
+
+
+func (t *task) SetNotBefore(notBefore time.Time) {
+	t.notBefore = notBefore
+}
+
+
+// Returns when the task could first be dispatched: when it was added, or when its delay ended.
+func (t *task) eligibleAt() time.Time {
+	if t.notBefore.After(t.enqueuedAt) {
+		return t.notBefore
+	}
+
+	return t.enqueuedAt
+}
+
+
+func (t *task) SetAttempt(attempt int) {
+	t.attempt = attempt
+}
+
+
+func (t *task) SetLastErr(lastErr error) {
+	t.lastErr = lastErr
+}
+
+
+func (t *task) SetOneShot(oneShot bool) {
+	t.oneShot = oneShot
+}
+
+
+func (t *task) SetTimeout(timeout time.Duration) {
+	t.timeout = timeout
+}
+
+
+func (t *task) SetMaxAttempts(maxAttempts int) {
+	t.maxAttempts = maxAttempts
+}
+
+
+// Marks @task as waiting for its next run from @since, for its wait span.
+func (t *task) SetWaitingSince(since time.Time) {
+	t.waitingSince = since
+}
+
+
+func (t *task) SetTraceParent(parent trace.SpanContext) {
+	t.traceParent = parent
+}
+
+
+func (t *task) SetSpan(span trace.Span) {
+	t.span = span
+}
+
+
+func (t *task) SetPriority(priority Priority) {
+	t.priority = priority
+}
+
+
+func (t *task) SetHandle(handle *TaskHandle) {
+	t.handle = handle
+}
+
+
+func (t *task) SetDedupKey(dedupKey string) {
+	t.dedupKey = dedupKey
+}
+
+
+func (t *task) SetEnqueuedAt(enqueuedAt time.Time) {
+	t.enqueuedAt = enqueuedAt
+}
+
+
+// Marks the task as dispatched at @startedAt, starting a new run.
+func (t *task) SetStartedAt(startedAt time.Time) {
+	t.startedAt = startedAt
+	t.run++
+	t.settled.Store(false)
+}
+
+
+// Returns true for the first caller after the task was dispatched, so that only one of completing and
+// abandoning the task takes effect.
+func (t *task) settle() bool {
+	return t.settled.CompareAndSwap(false, true)
+}
+
+
+// Sets the channels that order the start of the task's action in strict FIFO mode, see SetStrictFIFO.
+func (t *task) SetTurn(turn <-chan struct{}, started chan struct{}) {
+	t.turn = turn
+	t.started = started
+}
+
+
+// Waits for the task's turn to call its action, then hands the turn on to the next task. Does nothing outside
+// strict FIFO mode, or once the turn was taken. Only called from the task's go routine.
+func (t *task) takeTurn() {
+	if t.started == nil {
+		return
+	}
+
+	if t.turn != nil {
+		<-t.turn
+	}
+
+	close(t.started)
+	t.turn = nil
+	t.started = nil
+}
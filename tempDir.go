@@ -0,0 +1,84 @@
+package fsq
+
+import "fmt"
+import "errors"
+import "context"
+import "os"
+
+// the context key a task's scratch directory is stored under
+type tempDirKey struct{}
+
+
+// - Gives every task execution its own scratch directory, created in @parent before the action runs and
+// removed with everything in it once the action returns. Context actions get its path with TempDir, e.g.
+// for actions that shell out or write intermediate files.
+// - An empty @parent uses the default directory for temporary files, see os.TempDir.
+// - If the directory can't be created, the task fails without running its action.
+func (q *FixedSizeQueue) SetTempDirs(parent string) {
+	q.changeConfig("", 0, false, "tempDirs", func() (string, string, error) {
+		oldValue := q.tempDirSetting()
+		q.tempDirs = true
+		q.tempDirParent = parent
+		return oldValue, q.tempDirSetting(), nil
+	})
+}
+
+
+// Stops creating scratch directories for tasks.
+func (q *FixedSizeQueue) ClearTempDirs() {
+	q.changeConfig("", 0, false, "tempDirs", func() (string, string, error) {
+		oldValue := q.tempDirSetting()
+		q.tempDirs = false
+		q.tempDirParent = ""
+		return oldValue, q.tempDirSetting(), nil
+	})
+}
+
+
+// formats the scratch directory setting for the audit log: empty when off, the parent directory otherwise
+func (q *FixedSizeQueue) tempDirSetting() string {
+	if !q.tempDirs {
+		return ""
+	}
+
+	if q.tempDirParent == "" {
+		return os.TempDir()
+	}
+
+	return q.tempDirParent
+}
+
+
+// Returns the scratch directory of the task whose action @ctx was passed to, false if the queue doesn't
+// create them (see SetTempDirs).
+func TempDir(ctx context.Context) (string, bool) {
+	dir, ok := ctx.Value(tempDirKey{}).(string)
+	return dir, ok
+}
+
+
+// Runs in the task's go routine. Calls the task's action, with a fresh scratch directory in its context
+// when the queue creates them.
+func (q *FixedSizeQueue) callWithTempDir(task *task) error {
+	q.mu.Lock()
+	enabled := q.tempDirs
+	parent := q.tempDirParent
+	q.mu.Unlock()
+
+	if !enabled {
+		return q.callGuarded(task)
+	}
+
+	dir, err := os.MkdirTemp(parent, "fsq-task-")
+	if err != nil {
+		return errors.New(fmt.Sprintf("Temp dir for task %s can't be created: %s", task.externalId, err))
+	}
+	defer os.RemoveAll(dir)
+
+	// timers read the task's context under the lock, see abandon
+	q.mu.Lock()
+	task.ctx = context.WithValue(task.ctx, tempDirKey{}, dir)
+	q.mu.Unlock()
+
+	return q.callGuarded(task)
+}
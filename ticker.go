@@ -0,0 +1,19 @@
+package fsq
+
+import "time"
+
+
+// Calls @tick every @interval until @stop is closed. Meant to be run in its own go routine.
+func runTicker(interval time.Duration, stop chan struct{}, tick func()) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			tick()
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package fsq
+
+import "errors"
+import "context"
+import "time"
+
+// The error of a task whose action ran past its timeout, see AddWithTimeout.
+var ErrTaskTimeout = errors.New("Task ran past its timeout.")
+
+
+// - Same as Add, but the task fails once its action ran longer than @timeout: the task ends with ErrTaskTimeout
+// (ReasonTimeout) and its processing slot is freed for the next waiting task, so a hung action can't hold it forever.
+// - A plain action can't be stopped, its go routine is left running like an abandoned task's (see AbandonedTasks)
+// and its result is ignored. For an action that can give up, use AddContextWithTimeout.
+// - Timed out tasks aren't retried (see SetRetryPolicy), they may be kept as dead letters (see SetDeadLetters).
+// - A @timeout <= 0 is the same as Add. The queue's abandon timeout and max task age still apply if they come first.
+func (q *FixedSizeQueue) AddWithTimeout(action func(params map[string]interface{}) error, params map[string]interface{}, id string, timeout time.Duration) error {
+	return q.add(context.Background(), action, params, id, addOptions{timeout: timeout})
+}
+
+
+// Same as AddWithTimeout, but the context passed to @action expires at the timeout, so the action can give up and
+// return. An action that returns with the context's error by itself is retried like any other failure.
+func (q *FixedSizeQueue) AddContextWithTimeout(action ContextAction, params map[string]interface{}, id string, timeout time.Duration) error {
+	return q.add(context.Background(), nil, params, id, addOptions{ctxAction: action, timeout: timeout})
+}
+
+
+// Runs in the task's timer go routine once @run of its action ran past its timeout, ends the task as failed. Takes the lock.
+func (q *FixedSizeQueue) timeOut(task *task, run uint64) {
+	q.mu.Lock()
+
+	// the action may have returned at the same time the timer fired, and the task may run again since
+	if task.run != run || !task.settle() {
+		q.mu.Unlock()
+		return
+	}
+
+	q.forgetProcessing(task)
+
+	// context actions are told to give up, their context may have expired already
+	task.cancel()
+	triggered, done := q.end(task, ErrTaskTimeout, false)
+	q.mu.Unlock()
+
+	q.fireTriggers(triggered)
+
+	if done != nil {
+		done()
+	}
+}
+
+
+// Returns @ctx limited to the timeout of @task, if it has one.
+func withTaskTimeout(ctx context.Context, task *task) (context.Context, context.CancelFunc) {
+	if task.timeout <= 0 {
+		return ctx, func() {}
+	}
+
+	return context.WithTimeout(ctx, task.timeout)
+}
@@ -0,0 +1,95 @@
+package fsq
+
+import "fmt"
+import "time"
+
+// the most entries kept per traced id, older ones are dropped
+const maxTraceEntries = 64
+
+// A decision the queue made about a task, see SetTracing.
+type TraceEntry struct {
+	At time.Time
+	Step string  //e.g. "admitted", "rejected", "parked", "held", "dispatched", "completed"
+	Detail string
+}
+
+// the entries of a traced id
+type taskTrace struct {
+	entries []TraceEntry  //oldest first
+}
+
+
+// - Turns per task tracing on for the @maxTasks most recently traced external ids, or off when <= 0.
+// - While on, the queue records every decision it makes about a task: when it was admitted or rejected and
+// why, when it was parked or held back, how long it waited, which dispatch order or strategy picked it
+// and over how many others, how long it ran and how it ended. Trace returns them by external id.
+// - Meant for debugging, e.g. answering why a task took 30s. It costs a little on every decision while on.
+func (q *FixedSizeQueue) SetTracing(maxTasks int) {
+	q.changeConfig("", 0, false, "tracing", func() (string, string, error) {
+		oldValue := fmt.Sprint(q.maxTraced)
+
+		if maxTasks <= 0 {
+			maxTasks = 0
+			q.traces = nil
+			q.traceOrder = nil
+		} else if q.traces == nil {
+			q.traces = map[string]*taskTrace{}
+		}
+
+		q.maxTraced = maxTasks
+		q.evictTraces()
+		return oldValue, fmt.Sprint(q.maxTraced), nil
+	})
+}
+
+
+// Returns the trace of the tasks added with @externalId, oldest entry first. Empty if tracing is off or the
+// id wasn't traced (or was evicted).
+func (q *FixedSizeQueue) Trace(externalId string) []TraceEntry {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	trace, ok := q.traces[externalId]
+	if !ok {
+		return []TraceEntry{}
+	}
+
+	return append([]TraceEntry{}, trace.entries...)
+}
+
+
+// Records a decision about @externalId if tracing is on. A step repeating the previous one with the same
+// detail (e.g. a task held back on every dispatch attempt) is only recorded once.
+func (q *FixedSizeQueue) trace(externalId string, step string, format string, args ...interface{}) {
+	if q.maxTraced == 0 {
+		return
+	}
+
+	trace, ok := q.traces[externalId]
+	if !ok {
+		trace = &taskTrace{}
+		q.traces[externalId] = trace
+		q.traceOrder = append(q.traceOrder, externalId)
+		q.evictTraces()
+	}
+
+	detail := fmt.Sprintf(format, args...)
+
+	if n := len(trace.entries); n > 0 && trace.entries[n - 1].Step == step && trace.entries[n - 1].Detail == detail {
+		return
+	}
+
+	trace.entries = append(trace.entries, TraceEntry{At: q.now(), Step: step, Detail: detail})
+	if len(trace.entries) > maxTraceEntries {
+		trace.entries = trace.entries[1:]
+	}
+}
+
+
+// drops the oldest traced ids beyond maxTraced
+func (q *FixedSizeQueue) evictTraces() {
+	for len(q.traceOrder) > q.maxTraced {
+		delete(q.traces, q.traceOrder[0])
+		q.traceOrder = q.traceOrder[1:]
+	}
+}
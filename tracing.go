@@ -0,0 +1,115 @@
+package fsq
+
+import "context"
+
+import "go.opentelemetry.io/otel/attribute"
+import "go.opentelemetry.io/otel/codes"
+import "go.opentelemetry.io/otel/trace"
+
+// the instrumentation scope of the queue's spans
+const tracerName = "github.com/brybott/go_fsq"
+
+
+// - Traces the lifecycle of tasks with @provider, so a task added while handling a request can be followed
+// to its asynchronous run. Every task gets an "fsq.enqueue" span for its Add, an "fsq.wait" span from the
+// Add (or its last failed run) until it was dispatched, and an "fsq.execute" span per run of its action.
+// - The enqueue span is a child of the span in the context given to AddContext (or AddWait), the wait and
+// execute spans are children of the enqueue span. Context actions (see AddContextAction) get a context with
+// the execute span, so their own spans are children of it.
+// - Spans carry the queue's qualified name, the task's external id, action name and attempt. They are timed
+// with the queue's clock, see SetClock.
+// - A nil @provider turns tracing off, the default. Tasks already added are traced by the provider they were added with.
+func (q *FixedSizeQueue) SetTracerProvider(provider trace.TracerProvider) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if provider == nil {
+		q.tracer = nil
+		return
+	}
+
+	q.tracer = provider.Tracer(tracerName)
+}
+
+
+// Starts the enqueue span of the task with @id if the queue is traced, as a child of the span in @ctx.
+// Returns nil otherwise.
+func (q *FixedSizeQueue) startEnqueueSpan(ctx context.Context, id string) trace.Span {
+	if q.tracer == nil {
+		return nil
+	}
+
+	_, span := q.tracer.Start(ctx, "fsq.enqueue",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithTimestamp(q.now()),
+		trace.WithAttributes(
+			attribute.String("fsq.queue", q.QualifiedName()),
+			attribute.String("fsq.task.id", id),
+		),
+	)
+
+	return span
+}
+
+
+// Ends the enqueue @span with the error of the Add, if any.
+func (q *FixedSizeQueue) endEnqueueSpan(span trace.Span, err error) {
+	if span == nil {
+		return
+	}
+
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	span.End(trace.WithTimestamp(q.now()))
+}
+
+
+// Called when @task is dispatched: records its wait span and starts its execute span, returns @ctx with the
+// execute span. Returns @ctx as is if the task isn't traced.
+func (q *FixedSizeQueue) startTaskSpans(ctx context.Context, task *task) context.Context {
+	if q.tracer == nil || !task.traceParent.IsValid() {
+		return ctx
+	}
+
+	parent := trace.ContextWithSpanContext(ctx, task.traceParent)
+	attributes := trace.WithAttributes(
+		attribute.String("fsq.queue", q.QualifiedName()),
+		attribute.String("fsq.task.id", task.externalId),
+		attribute.String("fsq.action", task.actionName),
+		attribute.Int("fsq.attempt", task.attempt),
+	)
+
+	_, wait := q.tracer.Start(parent, "fsq.wait", trace.WithTimestamp(task.waitingSince), attributes)
+	wait.End(trace.WithTimestamp(task.startedAt))
+
+	ctx, span := q.tracer.Start(parent, "fsq.execute", trace.WithSpanKind(trace.SpanKindConsumer), trace.WithTimestamp(task.startedAt), attributes)
+	task.SetSpan(span)
+	return ctx
+}
+
+
+// Called with the event of @task's run ending (completed, failed, retrying or abandoned), ends its execute span.
+func (q *FixedSizeQueue) endTaskSpan(task *task, event Event) {
+	if task.span == nil {
+		return
+	}
+
+	task.span.SetAttributes(
+		attribute.String("fsq.event", event.Type.String()),
+		attribute.String("fsq.reason", event.Reason.String()),
+	)
+
+	if event.Err != nil {
+		task.span.RecordError(event.Err)
+		task.span.SetStatus(codes.Error, event.Err.Error())
+	} else if event.Type == EventAbandoned {
+		task.span.SetStatus(codes.Error, "abandoned")
+	}
+
+	task.span.End(trace.WithTimestamp(event.At))
+	task.SetSpan(nil)
+}
+
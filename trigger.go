@@ -0,0 +1,114 @@
+package fsq
+
+import "fmt"
+import "errors"
+import "log/slog"
+
+// - A rule that adds a task to a queue when a task of this queue completes or fails, see AddTrigger.
+// - @When picks the events that fire the trigger, e.g. Types: []EventType{EventCompleted} and an action
+// name. @Match optionally narrows them down further by the finished task's params.
+// - The added task runs the action registered under @ActionName on @Target (see RegisterAction), with the
+// params returned by @Params, or a copy of the finished task's params when @Params is nil. Its id is the
+// trigger's name and the finished task's id, joined by ":".
+type Trigger struct {
+	Name string
+	When EventFilter
+	Match func(event Event, params map[string]interface{}) bool  //optional
+	Target *FixedSizeQueue  //may be this queue
+	ActionName string
+	Params func(event Event, params map[string]interface{}) map[string]interface{}  //optional
+}
+
+// a task a trigger adds once the lock is released
+type triggered struct {
+	trigger Trigger
+	id string
+	params map[string]interface{}
+}
+
+
+// - Adds @trigger to the queue, it fires for tasks finishing from now on. Names must be unique per queue.
+// - Adding the triggered task happens outside the queue's lock, after the finished task's slot was freed.
+// Failing to add it (e.g. because the target is full or the action isn't registered there) doesn't affect the finished task.
+func (q *FixedSizeQueue) AddTrigger(trigger Trigger) error {
+	if trigger.Name == "" {
+		return errors.New("Trigger name can't be empty.")
+	}
+
+	if trigger.Target == nil || trigger.ActionName == "" {
+		return errors.New(fmt.Sprintf("Trigger %s needs a target queue and an action name.", trigger.Name))
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, t := range q.triggers {
+		if t.Name == trigger.Name {
+			return errors.New(fmt.Sprintf("FixedSizeQueue %s already has a trigger named %s.", q.QualifiedName(), trigger.Name))
+		}
+	}
+
+	q.triggers = append(q.triggers, trigger)
+	return nil
+}
+
+
+// Removes the trigger named @name, returns false if the queue has no such trigger.
+func (q *FixedSizeQueue) RemoveTrigger(name string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for i, t := range q.triggers {
+		if t.Name == name {
+			q.triggers = append(q.triggers[:i:i], q.triggers[i + 1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
+
+// Returns the tasks the triggers matching @event add, @params are the finished task's params.
+func (q *FixedSizeQueue) matchTriggers(event Event, params map[string]interface{}) []triggered {
+	var fired []triggered
+
+	for _, t := range q.triggers {
+		if !t.When.matches(event) {
+			continue
+		}
+
+		if t.Match != nil && !t.Match(event, params) {
+			continue
+		}
+
+		var next map[string]interface{}
+		if t.Params != nil {
+			next = t.Params(event, params)
+		} else {
+			next = make(map[string]interface{}, len(params))
+			for key, value := range params {
+				next[key] = value
+			}
+		}
+
+		if next == nil {
+			next = map[string]interface{}{}
+		}
+
+		fired = append(fired, triggered{trigger: t, id: t.Name + ":" + event.ExternalId, params: next})
+	}
+
+	return fired
+}
+
+
+// Adds the triggered tasks to their targets, called without the lock.
+func (q *FixedSizeQueue) fireTriggers(fired []triggered) {
+	for _, f := range fired {
+		err := f.trigger.Target.AddByName(f.trigger.ActionName, f.params, f.id)
+		if err != nil {
+			q.logError("adding the triggered task failed", err, slog.String("id", f.id), slog.String("target", f.trigger.Target.QualifiedName()))
+		}
+	}
+}
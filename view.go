@@ -0,0 +1,144 @@
+package fsq
+
+import "sort"
+import "time"
+
+// A read-only, point in time view of a queue.
+type QueueView struct {
+	Name string
+	Namespace string
+	QualifiedName string  //see FixedSizeQueue.QualifiedName
+	Epoch uint64  //increases with every change to the queue's tasks, views with the same epoch show the same state
+	TakenAt time.Time
+	Running bool
+	Frozen bool  //see FixedSizeQueue.Freeze
+	Draining bool  //see FixedSizeQueue.BeginDrain
+	Paused bool  //see FixedSizeQueue.Pause
+	Healthy bool
+	UnhealthyActions []string  //actions whose health probe reports unhealthy, sorted. See FixedSizeQueue.SetActionHealthProbe.
+	InMaintenance bool
+	UnderMemoryPressure bool
+	Capacity int  //max number of waiting tasks, not counting the overflow
+	OverflowLimit int  //see FixedSizeQueue.SetSoftCapacity, 0 when the capacity is hard
+	Pressure PressureLevel
+	MaxProcessing int
+	EffectiveMaxProcessing int  //max processing after maintenance windows
+	PreallocatedTasks int  //tasks created up front by InitPreallocated
+	Waiting []TaskView  //in dispatch order
+	Processing []TaskView  //ordered by external id
+	Parked []TaskView  //ordered by action name, then in the order they were parked. Tasks parked over budget follow by tenant.
+	Abandoned []TaskView  //abandoned tasks whose action is still running, ordered by external id
+	Budgets []BudgetSpend
+}
+
+// A read-only view of a task.
+type TaskView struct {
+	ExternalId string
+	ActionName string
+	Tenant string
+	Cost int
+	EnqueuedAt time.Time
+	Deadline time.Time  //zero when the task has no deadline
+	WaitTime time.Duration  //how long the task waited to be dispatched, so far if it is still waiting
+	Priority Priority  //see AddWithPriority
+	NotBefore time.Time  //zero unless the task was added with a delay, see AddAfter
+	Attempts int  //runs of the task's action so far, see SetRetryPolicy
+	LastError string  //the error of the task's latest failed run once it was retried, empty before
+}
+
+
+// - Returns a point in time view of the queue's waiting, processing and parked tasks, its budgets and
+// configuration, so that dashboards don't show contradictory numbers.
+// - The view is taken while holding the queue's lock, so all of its parts are from the same epoch.
+func (q *FixedSizeQueue) SnapshotView() QueueView {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.buildView()
+}
+
+
+func (q *FixedSizeQueue) buildView() QueueView {
+	view := QueueView{
+		Name: q.Name,
+		Namespace: q.currentNamespace(),
+		QualifiedName: q.QualifiedName(),
+		Epoch: q.epoch,
+		TakenAt: q.now(),
+		Running: q.isRunning,
+		Frozen: q.frozen,
+		Draining: q.draining,
+		Paused: q.paused,
+		Healthy: q.isHealthy(),
+		UnhealthyActions: q.unhealthyActions(),
+		InMaintenance: q.inMaintenance(),
+		UnderMemoryPressure: q.underMemoryPressure(),
+		Capacity: q.capacity(),
+		OverflowLimit: q.overflowLimit,
+		Pressure: q.pressure,
+		MaxProcessing: q.currentMaxProcessing(),
+		EffectiveMaxProcessing: q.effectiveMaxProcessing(),
+		PreallocatedTasks: q.preallocated,
+		Waiting: []TaskView{},
+		Processing: []TaskView{},
+		Parked: []TaskView{},
+		Abandoned: []TaskView{},
+		Budgets: q.budgetSpends(),
+	}
+
+	for _, task := range q.waitingInOrder() {
+		view.Waiting = append(view.Waiting, q.taskView(task))
+	}
+
+	for _, task := range q.tasksById {
+		if task.state == processing {
+			view.Processing = append(view.Processing, q.taskView(task))
+		}
+
+		if task.state == abandoned {
+			view.Abandoned = append(view.Abandoned, q.taskView(task))
+		}
+	}
+
+	sort.Slice(view.Processing, func(i, j int) bool {
+		return view.Processing[i].ExternalId < view.Processing[j].ExternalId
+	})
+
+	sort.Slice(view.Abandoned, func(i, j int) bool {
+		return view.Abandoned[i].ExternalId < view.Abandoned[j].ExternalId
+	})
+
+	for _, task := range q.parkedTasks() {
+		view.Parked = append(view.Parked, q.taskView(task))
+	}
+
+	return view
+}
+
+
+func (q *FixedSizeQueue) taskView(t *task) TaskView {
+	view := TaskView{
+		ExternalId: t.externalId,
+		ActionName: t.actionName,
+		Tenant: t.tenant,
+		Cost: t.cost,
+		EnqueuedAt: t.enqueuedAt,
+		Deadline: t.deadline,
+		Priority: t.priority,
+		NotBefore: t.notBefore,
+		Attempts: t.attempt,
+	}
+
+	if t.lastErr != nil {
+		view.LastError = t.lastErr.Error()
+	}
+
+	if t.state == waiting {
+		view.WaitTime = q.since(t.enqueuedAt)
+	} else {
+		// both readings are from the queue's clock, so the difference can only be negative if the clock was replaced
+		view.WaitTime = max(t.startedAt.Sub(t.enqueuedAt), 0)
+	}
+
+	return view
+}
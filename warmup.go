@@ -0,0 +1,180 @@
+package fsq
+
+import "fmt"
+import "errors"
+import "context"
+import "log/slog"
+import "strings"
+
+// the warm hook of a registered action, see SetWarmup
+type warmup struct {
+	warm func(ctx context.Context) error
+	warming bool  //the hook is running, tasks for the action are parked until it returns
+	warmed bool  //the hook returned nil, it isn't called again
+	err error  //what the hook returned the last time it failed
+	done chan struct{}  //closed once the hook returned, nil until it was first called
+}
+
+
+// - Same as Init, but the queue is warmed up: a task is preallocated for every slot of the queue and the
+// internal maps are sized for them, so the first burst after startup doesn't pay allocation and rehash costs.
+// - The number of preallocated tasks is shown in the queue's view and stats, see SnapshotView and Stats.
+func InitPreallocated(size int, name string, maxProcessCount int) *FixedSizeQueue {
+	queue := Init(size, name, maxProcessCount)
+	queue.preallocate()
+	return queue
+}
+
+
+func (q *FixedSizeQueue) preallocate() {
+	size := q.capacity()
+	pool := make([]*task, 0, size)
+
+	q.tasksById = make(map[int]*task, size)
+	q.waitingTasksByExternalId = make(map[string]*task, size)
+
+	for i := 0; i < size; i++ {
+		t := &task{}
+		q.taskCount++
+		t.SetId(q.taskCount)
+		t.SetStateReady()
+		q.tasksById[t.id] = t
+		pool = append(pool, t)
+	}
+
+	q.readyTaskPool = &pool
+
+	// the footprint shown in the view and stats, it outlasts ResetStats
+	q.preallocated = size
+}
+
+
+// - Sets a hook warming up the action registered (or to be registered) under @name, e.g. opening its
+// connections or loading its model, so the first task dispatched for it doesn't absorb the cold start.
+// - Start calls every hook in a go routine of its own, with a context that carries the queue's environment
+// (see Env) and is cancelled by Stop. Tasks for the action are parked until its hook returned. If the
+// queue is already running, the hook is called right away.
+// - A hook that returned nil isn't called again. One that returned an error is called again at the next
+// Start, its tasks are dispatched meanwhile. See WaitWarm for the errors.
+// - A nil @warm removes the hook of @name.
+func (q *FixedSizeQueue) SetWarmup(name string, warm func(ctx context.Context) error) error {
+	if len(strings.TrimSpace(name)) == 0 {
+		return errors.New("Action name is not valid, only uses space characters.")
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.warmups == nil {
+		q.warmups = map[string]*warmup{}
+	}
+
+	previous, ok := q.warmups[name]
+	if ok && previous.warming {
+		return errors.New(fmt.Sprintf("Action %s is warming up, try again once it is warm.", name))
+	}
+
+	if warm == nil {
+		delete(q.warmups, name)
+		return nil
+	}
+
+	w := &warmup{warm: warm}
+	q.warmups[name] = w
+
+	if q.isRunning {
+		q.startWarmup(name, w)
+	}
+
+	return nil
+}
+
+
+// - Waits until the warm hooks running since the queue's Start returned, or @ctx is done.
+// - Returns the errors of the hooks that failed, by action name, nil if none did. Returns the
+// context's error if it was done first.
+func (q *FixedSizeQueue) WaitWarm(ctx context.Context) (map[string]error, error) {
+	q.mu.Lock()
+	running := []chan struct{}{}
+	for _, w := range q.warmups {
+		if w.warming {
+			running = append(running, w.done)
+		}
+	}
+	q.mu.Unlock()
+
+	for _, done := range running {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var failed map[string]error
+	for name, w := range q.warmups {
+		if w.err == nil {
+			continue
+		}
+
+		if failed == nil {
+			failed = map[string]error{}
+		}
+
+		failed[name] = w.err
+	}
+
+	return failed, nil
+}
+
+
+// Called by Start, calls the warm hooks that didn't succeed yet.
+func (q *FixedSizeQueue) startWarmups() {
+	for name, w := range q.warmups {
+		if !w.warmed && !w.warming {
+			q.startWarmup(name, w)
+		}
+	}
+}
+
+
+func (q *FixedSizeQueue) startWarmup(name string, w *warmup) {
+	ctx := q.baseContext()
+	if q.env != nil {
+		ctx = context.WithValue(ctx, envKey{}, q.env)
+	}
+
+	w.warming = true
+	w.done = make(chan struct{})
+	go q.runWarmup(ctx, name, w)
+}
+
+
+// Runs in the hook's own go routine, takes the lock once the hook returned.
+func (q *FixedSizeQueue) runWarmup(ctx context.Context, name string, w *warmup) {
+	err := w.warm(ctx)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	w.warming = false
+	w.warmed = err == nil
+	w.err = err
+	close(w.done)
+
+	if err != nil {
+		q.logError("warming up the action failed", err, slog.String("action", name))
+	}
+
+	q.unpark(name)
+}
+
+
+// Returns true while the warm hook of the action named @name is running.
+func (q *FixedSizeQueue) isWarming(name string) bool {
+	w, ok := q.warmups[name]
+	return ok && w.warming
+}